@@ -0,0 +1,286 @@
+package sundaecli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+)
+
+var AdminOpts struct {
+	Port int
+}
+
+var AdminPortFlag = IntFlag("admin-port", "the port to serve /healthz, /readyz, /version, /metrics and /debug/pprof on, in console mode (0 disables)", &AdminOpts.Port)
+
+const (
+	// readinessTimeout bounds how long a single /readyz request waits on checks
+	readinessTimeout = 5 * time.Second
+	// adminShutdownGrace bounds how long Close waits on in-flight requests
+	adminShutdownGrace = 5 * time.Second
+)
+
+// ReadinessCheck reports whether a dependency of the process is usable; a nil
+// error means ready
+type ReadinessCheck func(ctx context.Context) error
+
+// AdminServer is an operational HTTP listener for long-running console-mode
+// processes. It serves:
+//
+//	/healthz       200 while the process is up, 503 once it begins shutting down
+//	/readyz        200 if every readiness check passes, 503 otherwise
+//	/version       the Service name and version, as JSON
+//	/metrics       Prometheus metrics
+//	/debug/pprof/  the standard Go profiling endpoints
+type AdminServer struct {
+	service Service
+	logger  zerolog.Logger
+	started time.Time
+
+	mu      sync.Mutex
+	checks  map[string]ReadinessCheck
+	metrics http.Handler
+
+	stopping atomic.Bool
+	server   *http.Server
+}
+
+func NewAdminServer(service Service) *AdminServer {
+	return &AdminServer{
+		service: service,
+		logger:  Logger(service).With().Str("component", "admin").Logger(),
+		started: time.Now(),
+		checks:  map[string]ReadinessCheck{},
+	}
+}
+
+// StartAdmin builds an AdminServer for service and, when running in console
+// mode with --admin-port set, starts serving it in the background. If metrics
+// can serve itself over HTTP (as PrometheusMetrics can), it is exposed on
+// /metrics. The returned server is always usable for registering checks, and
+// should be closed when the process exits.
+func StartAdmin(service Service, metrics Metrics) (*AdminServer, error) {
+	a := NewAdminServer(service)
+	if h, ok := metrics.(interface{ Handler() http.Handler }); ok {
+		a.SetMetricsHandler(h.Handler())
+	}
+	if DetectRuntime() != ConsoleRuntime || AdminOpts.Port == 0 {
+		return a, nil
+	}
+	if err := a.ListenAndServe(fmt.Sprintf(":%v", AdminOpts.Port)); err != nil {
+		return nil, fmt.Errorf("unable to start admin server: %w", err)
+	}
+	// Report unhealthy as soon as shutdown begins, so traffic drains away
+	// while in-flight work finishes
+	context.AfterFunc(DefaultLifecycle().Context(), func() { a.stopping.Store(true) })
+	return a, nil
+}
+
+// AddReadinessCheck registers check under name, replacing any existing check
+// with that name
+func (a *AdminServer) AddReadinessCheck(name string, check ReadinessCheck) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.checks[name] = check
+}
+
+// SetMetricsHandler replaces the handler served on /metrics; by default the
+// Prometheus default registry (Go runtime and process stats) is served
+func (a *AdminServer) SetMetricsHandler(h http.Handler) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.metrics = h
+}
+
+// Handler returns the admin routes, for mounting on an existing listener
+func (a *AdminServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", a.handleHealthz)
+	mux.HandleFunc("/readyz", a.handleReadyz)
+	mux.HandleFunc("/version", a.handleVersion)
+	mux.HandleFunc("/metrics", a.handleMetrics)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// ListenAndServe serves Handler at addr in the background until Shutdown
+func (a *AdminServer) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.server = &http.Server{Handler: a.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	server := a.server
+	a.mu.Unlock()
+
+	a.logger.Info().Str("addr", listener.Addr().String()).Msg("serving admin endpoints")
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			a.logger.Warn().Err(err).Msg("admin server stopped")
+		}
+	}()
+	return nil
+}
+
+// Shutdown marks the process unhealthy and unready, then stops the listener
+// started by ListenAndServe, if any
+func (a *AdminServer) Shutdown(ctx context.Context) error {
+	a.stopping.Store(true)
+	a.mu.Lock()
+	server := a.server
+	a.mu.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
+
+// Close is Shutdown with a short grace period for in-flight requests
+func (a *AdminServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), adminShutdownGrace)
+	defer cancel()
+	return a.Shutdown(ctx)
+}
+
+func (a *AdminServer) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	if a.stopping.Load() {
+		writeAdminJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "stopping"})
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+type readinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func (a *AdminServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if a.stopping.Load() {
+		writeAdminJSON(w, http.StatusServiceUnavailable, readinessResponse{Status: "stopping"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	results := a.Check(ctx)
+	response := readinessResponse{Status: "ok", Checks: map[string]string{}}
+	status := http.StatusOK
+	for name, err := range results {
+		if err != nil {
+			response.Checks[name] = err.Error()
+			response.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		} else {
+			response.Checks[name] = "ok"
+		}
+	}
+	writeAdminJSON(w, status, response)
+}
+
+// Check runs every readiness check concurrently and returns each result by
+// name
+func (a *AdminServer) Check(ctx context.Context) map[string]error {
+	a.mu.Lock()
+	names := make([]string, 0, len(a.checks))
+	for name := range a.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	checks := make([]ReadinessCheck, len(names))
+	for i, name := range names {
+		checks[i] = a.checks[name]
+	}
+	a.mu.Unlock()
+
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check ReadinessCheck) {
+			defer wg.Done()
+			errs[i] = check(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+
+	results := make(map[string]error, len(names))
+	for i, name := range names {
+		results[name] = errs[i]
+		if errs[i] != nil {
+			a.logger.Debug().Err(errs[i]).Str("check", name).Msg("readiness check failed")
+		}
+	}
+	return results
+}
+
+func (a *AdminServer) handleVersion(w http.ResponseWriter, _ *http.Request) {
+	writeAdminJSON(w, http.StatusOK, struct {
+		VersionInfo
+		Uptime string `json:"uptime"`
+	}{
+		VersionInfo: Version(a.service),
+		Uptime:      time.Since(a.started).Round(time.Second).String(),
+	})
+}
+
+func (a *AdminServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	h := a.metrics
+	a.mu.Unlock()
+	if h == nil {
+		h = promhttp.Handler()
+	}
+	h.ServeHTTP(w, r)
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// DynamoDBCheck is ready when tableName can be described and is ACTIVE
+func DynamoDBCheck(api dynamodbiface.DynamoDBAPI, tableName string) ReadinessCheck {
+	return func(ctx context.Context) error {
+		out, err := api.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+			TableName: aws.String(tableName),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to describe table %v: %w", tableName, err)
+		}
+		if status := aws.StringValue(out.Table.TableStatus); status != dynamodb.TableStatusActive {
+			return fmt.Errorf("table %v is %v", tableName, status)
+		}
+		return nil
+	}
+}
+
+// LagCheck is ready while lag() is at most maxLag; consumers typically report
+// how far behind the head of their stream the last processed record was
+func LagCheck(lag func() time.Duration, maxLag time.Duration) ReadinessCheck {
+	return func(context.Context) error {
+		if l := lag(); l > maxLag {
+			return fmt.Errorf("lag of %v exceeds %v", l.Round(time.Second), maxLag)
+		}
+		return nil
+	}
+}
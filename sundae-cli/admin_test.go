@@ -0,0 +1,68 @@
+package sundaecli
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+)
+
+func TestAdminServer(t *testing.T) {
+	a := NewAdminServer(testService)
+	h := a.Handler()
+
+	get := func(path string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		var body map[string]interface{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body
+	}
+
+	code, body := get("/version")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "test-service", body["name"])
+	assert.Equal(t, "abc123", body["version"])
+
+	code, _ = get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", body["status"])
+
+	a.AddReadinessCheck("db", func(context.Context) error { return nil })
+	a.AddReadinessCheck("lag", LagCheck(func() time.Duration { return time.Minute }, time.Second))
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", body["status"])
+	checks := body["checks"].(map[string]interface{})
+	assert.Equal(t, "ok", checks["db"])
+	assert.Contains(t, checks["lag"], "exceeds")
+
+	a.AddReadinessCheck("lag", func(context.Context) error { return nil })
+	code, _ = get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = get("/metrics")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = get("/debug/pprof/")
+	assert.Equal(t, http.StatusOK, code)
+
+	assert.NoError(t, a.Shutdown(context.Background()))
+	code, _ = get("/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	code, _ = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
+
+func TestAdminServerListen(t *testing.T) {
+	a := NewAdminServer(testService)
+	assert.NoError(t, a.ListenAndServe("127.0.0.1:0"))
+	assert.NoError(t, a.Close())
+	assert.NoError(t, a.Close())
+}
@@ -0,0 +1,35 @@
+package sundaecli
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+var awsInstrumenters struct {
+	sync.Mutex
+	fns []func(*request.Handlers)
+}
+
+// InstrumentAWS has every session created with AWSSession pass its handlers
+// to fn. sundaetrace registers its tracing this way, so clients created in
+// this package, which can't import it, are traced as well.
+func InstrumentAWS(fn func(*request.Handlers)) {
+	awsInstrumenters.Lock()
+	defer awsInstrumenters.Unlock()
+	awsInstrumenters.fns = append(awsInstrumenters.fns, fn)
+}
+
+// AWSSession creates an AWS session instrumented by everything registered
+// with InstrumentAWS
+func AWSSession(cfgs ...*aws.Config) *session.Session {
+	s := session.Must(session.NewSession(cfgs...))
+	awsInstrumenters.Lock()
+	defer awsInstrumenters.Unlock()
+	for _, fn := range awsInstrumenters.fns {
+		fn(&s.Handlers)
+	}
+	return s
+}
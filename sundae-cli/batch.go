@@ -0,0 +1,349 @@
+package sundaecli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+)
+
+var BatchOpts struct {
+	ReportItemFailures bool
+	BisectOnError      bool
+	MaxAttempts        int
+	Retries            int
+	RetryBackoff       time.Duration
+}
+
+var ReportBatchItemFailuresFlag = BoolFlag("report-batch-item-failures", "return a partial batch response naming the first failed record, rather than failing the whole batch; the event source mapping must enable ReportBatchItemFailures", &BatchOpts.ReportItemFailures)
+var BisectOnErrorFlag = BoolFlag("bisect-batch-on-error", "when a batch handler fails, split the batch in halves to find the record that failed", &BatchOpts.BisectOnError)
+var MaxAttemptsFlag = IntFlag("max-record-attempts", "skip a record after it has failed this many times in a row (0 retries forever, or quarantines it straight away if there's a dead letter sink)", &BatchOpts.MaxAttempts)
+var RetriesFlag = IntFlag("record-retries", "how many times to retry a failed record in process before giving up on it", &BatchOpts.Retries)
+var RetryBackoffFlag = DurationFlag("record-retry-backoff", "how long to wait before the first in-process retry; doubles with each retry", &BatchOpts.RetryBackoff, DefaultRetryBackoff)
+
+var BatchFlags = []cli.Flag{
+	ReportBatchItemFailuresFlag,
+	BisectOnErrorFlag,
+	MaxAttemptsFlag,
+	RetriesFlag,
+	RetryBackoffFlag,
+}
+
+const DefaultRetryBackoff = 200 * time.Millisecond
+
+const (
+	RecordDiscardedMetric MetricName = "RecordDiscarded"
+
+	// maxTrackedAttempts bounds the memory used to count attempts
+	maxTrackedAttempts = 10000
+)
+
+// DiscardFunc is told about a record that was skipped after failing
+// MaxAttempts times
+type DiscardFunc func(ctx context.Context, id string, err error)
+
+// DeadLetter is a record a consumer gave up on, with enough context to
+// inspect it and later redrive it through the same handler
+type DeadLetter struct {
+	// ID identifies the dead letter within its sink
+	ID string `json:"id"`
+	// Source names the consumer, e.g. sundae-ddb
+	Source   string          `json:"source"`
+	RecordID string          `json:"recordId"`
+	Error    string          `json:"error"`
+	Attempts int             `json:"attempts"`
+	Time     time.Time       `json:"time"`
+	Service  string          `json:"service,omitempty"`
+	Version  string          `json:"version,omitempty"`
+	Commit   string          `json:"commit,omitempty"`
+	Record   json.RawMessage `json:"record"`
+}
+
+// DeadLetterSink quarantines records that keep failing
+type DeadLetterSink interface {
+	Send(ctx context.Context, letter DeadLetter) error
+}
+
+var deadLetters struct {
+	sync.Mutex
+	sink DeadLetterSink
+}
+
+// SetDeadLetterSink makes sink part of DefaultBatchPolicy
+func SetDeadLetterSink(sink DeadLetterSink) {
+	deadLetters.Lock()
+	defer deadLetters.Unlock()
+	deadLetters.sink = sink
+}
+
+// BatchPolicy is how a stream consumer handles a record that fails
+type BatchPolicy struct {
+	// ReportItemFailures returns the first failed record to Lambda, so it
+	// retries from there instead of from the start of the batch
+	ReportItemFailures bool
+	// BisectOnError splits a failed batch in halves, recursively, to find the
+	// record that failed; only batch handlers need it
+	BisectOnError bool
+	// MaxAttempts is how many times in a row a record may fail before it is
+	// skipped; 0 never skips. Attempts are counted in memory, so in Lambda they
+	// are per container.
+	MaxAttempts int
+	// Retries is how many more times a failed record is tried in process,
+	// waiting RetryBackoff before the first retry and twice as long before
+	// each one after, before it counts as a failed attempt
+	Retries      int
+	RetryBackoff time.Duration
+	// DeadLetter, if set, receives every record that is given up on; a
+	// record that can't be sent there is reported as failed rather than
+	// skipped
+	DeadLetter DeadLetterSink
+	// Source names the consumer in dead letters
+	Source string
+	// Service, if set, stamps dead letters with the build that gave up on
+	// them
+	Service Service
+	// OnDiscard, if set, is called for every skipped record
+	OnDiscard DiscardFunc
+}
+
+// DefaultBatchPolicy is the BatchPolicy set by BatchFlags, with the sink set
+// by SetDeadLetterSink
+func DefaultBatchPolicy(source string) BatchPolicy {
+	deadLetters.Lock()
+	defer deadLetters.Unlock()
+	return BatchPolicy{
+		ReportItemFailures: BatchOpts.ReportItemFailures,
+		BisectOnError:      BatchOpts.BisectOnError,
+		MaxAttempts:        BatchOpts.MaxAttempts,
+		Retries:            BatchOpts.Retries,
+		RetryBackoff:       BatchOpts.RetryBackoff,
+		DeadLetter:         deadLetters.sink,
+		Source:             source,
+	}
+}
+
+// Quarantines reports whether records that keep failing are skipped rather
+// than retried forever, in which case a consumer must keep going after one
+func (p BatchPolicy) Quarantines() bool {
+	return p.MaxAttempts > 0 || p.DeadLetter != nil
+}
+
+// BatchResult is the outcome of a batch. If a record failed, FailedID
+// identifies it and Err is why; records before it were handled (or discarded),
+// and none after it were attempted.
+type BatchResult struct {
+	FailedID string
+	Err      error
+}
+
+// BatchProcessor applies a BatchPolicy to the batches a consumer receives. It
+// remembers failed attempts across batches, so one should be kept for the
+// life of the process.
+type BatchProcessor struct {
+	policy  BatchPolicy
+	logger  zerolog.Logger
+	metrics Metrics
+
+	mu       sync.Mutex
+	attempts map[string]int
+}
+
+func NewBatchProcessor(policy BatchPolicy, logger zerolog.Logger, metrics Metrics) *BatchProcessor {
+	if metrics == nil {
+		metrics = NopMetrics{}
+	}
+	return &BatchProcessor{
+		policy:   policy,
+		logger:   logger,
+		metrics:  metrics,
+		attempts: map[string]int{},
+	}
+}
+
+func (p *BatchProcessor) Policy() BatchPolicy {
+	return p.policy
+}
+
+// SetMetrics replaces the metrics discarded records are counted in, for a
+// processor built before its consumer's metrics were
+func (p *BatchProcessor) SetMetrics(metrics Metrics) {
+	if metrics == nil {
+		metrics = NopMetrics{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.metrics = metrics
+}
+
+// giveUp records a failed attempt at record, and reports whether it has now
+// used up its attempts and was skipped
+func (p *BatchProcessor) giveUp(ctx context.Context, id string, record interface{}, err error) bool {
+	maxAttempts := p.policy.MaxAttempts
+	if maxAttempts <= 0 && p.policy.DeadLetter != nil {
+		maxAttempts = 1
+	}
+	if maxAttempts <= 0 {
+		return false
+	}
+	p.mu.Lock()
+	if len(p.attempts) >= maxTrackedAttempts {
+		p.attempts = map[string]int{}
+	}
+	p.attempts[id]++
+	n := p.attempts[id]
+	if n >= maxAttempts {
+		delete(p.attempts, id)
+	}
+	metrics := p.metrics
+	p.mu.Unlock()
+
+	if n < maxAttempts {
+		return false
+	}
+
+	if p.policy.DeadLetter != nil {
+		if sendErr := p.quarantine(ctx, id, record, n*(p.policy.Retries+1), err); sendErr != nil {
+			p.logger.Error().Err(sendErr).Str("record", id).Msg("unable to quarantine record")
+			return false
+		}
+		p.logger.Error().Err(err).Str("record", id).Int("attempts", n).Msg("quarantined record after too many failed attempts")
+	} else {
+		p.logger.Error().Err(err).Str("record", id).Int("attempts", n).Msg("discarding record after too many failed attempts")
+	}
+	metrics.Event(ctx, RecordDiscardedMetric)
+	if p.policy.OnDiscard != nil {
+		p.policy.OnDiscard(ctx, id, err)
+	}
+	return true
+}
+
+func (p *BatchProcessor) quarantine(ctx context.Context, id string, record interface{}, attempts int, err error) error {
+	raw, marshalErr := json.Marshal(record)
+	if marshalErr != nil {
+		return fmt.Errorf("unable to marshal record %v: %w", id, marshalErr)
+	}
+	letter := DeadLetter{
+		Source:   p.policy.Source,
+		RecordID: id,
+		Error:    err.Error(),
+		Attempts: attempts,
+		Time:     time.Now(),
+		Record:   raw,
+	}
+	if p.policy.Service.Name != "" {
+		version := Version(p.policy.Service)
+		letter.Service = version.Name
+		letter.Version = version.Version
+		letter.Commit = version.Commit
+	}
+	return p.policy.DeadLetter.Send(ctx, letter)
+}
+
+// retry calls fn until it succeeds or the policy's retries are used up
+func (p *BatchProcessor) retry(ctx context.Context, id string, fn func() error) error {
+	err := fn()
+	backoff := p.policy.RetryBackoff
+	for i := 0; err != nil && i < p.policy.Retries; i++ {
+		p.logger.Warn().Err(err).Str("record", id).Int("retry", i+1).Msg("retrying failed record")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		err = fn()
+	}
+	return err
+}
+
+func (p *BatchProcessor) succeeded(id string) {
+	if p.policy.MaxAttempts <= 0 {
+		return
+	}
+	p.mu.Lock()
+	delete(p.attempts, id)
+	p.mu.Unlock()
+}
+
+// ProcessRecords handles records one at a time, in order, stopping at the
+// first that fails and isn't discarded
+func ProcessRecords[R any](ctx context.Context, p *BatchProcessor, records []R, id func(R) string, handle func(context.Context, R) error) BatchResult {
+	for _, record := range records {
+		if err := p.retry(ctx, id(record), func() error { return handle(ctx, record) }); err != nil {
+			if p.giveUp(ctx, id(record), record, err) {
+				continue
+			}
+			return BatchResult{FailedID: id(record), Err: err}
+		}
+		p.succeeded(id(record))
+	}
+	return BatchResult{}
+}
+
+// ProcessBatch hands records to handle all at once. If that fails, the first
+// record is reported as failed, unless BisectOnError is set, in which case the
+// batch is split to find the record responsible. Before a record is given up
+// on it is always isolated this way, so the records around it aren't skipped
+// too.
+//
+// Bisecting hands records to handle again, in ever smaller batches, so a
+// record may be handled more than once: before the batch it was in failed,
+// within a half that succeeded, and with the records after the culprit once
+// that has been dealt with. Handlers that aren't idempotent must tolerate
+// this. If every half of a failed batch succeeds, the failure was transient
+// and the batch counts as handled.
+func ProcessBatch[R any](ctx context.Context, p *BatchProcessor, records []R, id func(R) string, handle func(context.Context, []R) error) BatchResult {
+	for len(records) > 0 {
+		index, err := bisectBatch(ctx, records, handle, p.policy.BisectOnError)
+		if err == nil {
+			return BatchResult{}
+		}
+		if p.policy.Quarantines() && !p.policy.BisectOnError && len(records) > 1 {
+			// Find the culprit before counting, so a bad record can't cause
+			// the good ones around it to be discarded
+			if index, err = bisectHalves(ctx, records, handle); err == nil {
+				return BatchResult{}
+			}
+		}
+		if p.policy.Retries > 0 {
+			// Retry the culprit by itself; if it then goes through, carry on
+			// with the rest of the batch
+			culprit := records[index : index+1]
+			err = p.retry(ctx, id(records[index]), func() error { return handle(ctx, culprit) })
+		}
+		if err != nil && !p.giveUp(ctx, id(records[index]), records[index], err) {
+			return BatchResult{FailedID: id(records[index]), Err: err}
+		}
+		records = records[index+1:]
+	}
+	return BatchResult{}
+}
+
+// bisectBatch returns the index of the record that failed and the error, or a
+// nil error if the batch succeeded. Without bisect, the failure is blamed on
+// the first record.
+func bisectBatch[R any](ctx context.Context, records []R, handle func(context.Context, []R) error, bisect bool) (int, error) {
+	err := handle(ctx, records)
+	if err == nil || !bisect || len(records) == 1 {
+		return 0, err
+	}
+	return bisectHalves(ctx, records, handle)
+}
+
+// bisectHalves looks for the record that failed in each half of a batch that
+// failed as a whole. If both halves succeed, every record has been handled,
+// and the error is nil.
+func bisectHalves[R any](ctx context.Context, records []R, handle func(context.Context, []R) error) (int, error) {
+	mid := len(records) / 2
+	if i, err := bisectBatch(ctx, records[:mid], handle, true); err != nil {
+		return i, err
+	}
+	if i, err := bisectBatch(ctx, records[mid:], handle, true); err != nil {
+		return mid + i, err
+	}
+	return 0, nil
+}
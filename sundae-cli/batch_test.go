@@ -0,0 +1,160 @@
+package sundaecli
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/tj/assert"
+)
+
+func TestProcessRecords(t *testing.T) {
+	ctx := context.Background()
+	id := strconv.Itoa
+	bad := errors.New("bad record")
+	var handled []int
+	handle := func(_ context.Context, r int) error {
+		if r == 3 {
+			return bad
+		}
+		handled = append(handled, r)
+		return nil
+	}
+
+	t.Run("stops at the first failure", func(t *testing.T) {
+		handled = nil
+		p := NewBatchProcessor(BatchPolicy{}, zerolog.Nop(), nil)
+		result := ProcessRecords(ctx, p, []int{1, 2, 3, 4}, id, handle)
+		assert.Equal(t, "3", result.FailedID)
+		assert.Equal(t, bad, result.Err)
+		assert.Equal(t, []int{1, 2}, handled)
+	})
+
+	t.Run("discards after max attempts", func(t *testing.T) {
+		handled = nil
+		var discarded []string
+		metrics := NewMemoryMetrics(testService)
+		p := NewBatchProcessor(BatchPolicy{
+			MaxAttempts: 2,
+			OnDiscard:   func(_ context.Context, id string, _ error) { discarded = append(discarded, id) },
+		}, zerolog.Nop(), metrics)
+
+		assert.Equal(t, "3", ProcessRecords(ctx, p, []int{3, 4}, id, handle).FailedID)
+		assert.Nil(t, ProcessRecords(ctx, p, []int{3, 4}, id, handle).Err)
+		assert.Equal(t, []int{4}, handled)
+		assert.Equal(t, []string{"3"}, discarded)
+		assert.Len(t, metrics.Named(RecordDiscardedMetric), 1)
+	})
+
+	t.Run("metrics set later", func(t *testing.T) {
+		p := NewBatchProcessor(BatchPolicy{MaxAttempts: 1}, zerolog.Nop(), nil)
+		metrics := NewMemoryMetrics(testService)
+		p.SetMetrics(metrics)
+		assert.Nil(t, ProcessRecords(ctx, p, []int{3}, id, handle).Err)
+		assert.Len(t, metrics.Named(RecordDiscardedMetric), 1)
+	})
+
+	t.Run("retries before giving up", func(t *testing.T) {
+		var tries int
+		flaky := func(_ context.Context, r int) error {
+			if tries++; tries < 3 {
+				return bad
+			}
+			return nil
+		}
+		p := NewBatchProcessor(BatchPolicy{Retries: 2, RetryBackoff: time.Millisecond}, zerolog.Nop(), nil)
+		assert.Nil(t, ProcessRecords(ctx, p, []int{1}, id, flaky).Err)
+		assert.Equal(t, 3, tries)
+	})
+
+	t.Run("quarantines to the dead letter sink", func(t *testing.T) {
+		handled = nil
+		sink := &memorySink{}
+		p := NewBatchProcessor(BatchPolicy{Retries: 1, DeadLetter: sink, Source: "test", Service: testService}, zerolog.Nop(), nil)
+		assert.Nil(t, ProcessRecords(ctx, p, []int{3, 4}, id, handle).Err)
+		assert.Equal(t, []int{4}, handled)
+		assert.Len(t, sink.letters, 1)
+		assert.Equal(t, "test", sink.letters[0].Source)
+		assert.Equal(t, "3", sink.letters[0].RecordID)
+		assert.Equal(t, "bad record", sink.letters[0].Error)
+		assert.Equal(t, 2, sink.letters[0].Attempts)
+		assert.Equal(t, "3", string(sink.letters[0].Record))
+		assert.Equal(t, testService.Name, sink.letters[0].Service)
+		assert.Equal(t, testService.Version, sink.letters[0].Version)
+	})
+
+	t.Run("fails records the sink rejects", func(t *testing.T) {
+		handled = nil
+		p := NewBatchProcessor(BatchPolicy{DeadLetter: &memorySink{err: errors.New("unavailable")}}, zerolog.Nop(), nil)
+		assert.Equal(t, "3", ProcessRecords(ctx, p, []int{3, 4}, id, handle).FailedID)
+		assert.Nil(t, handled)
+	})
+}
+
+type memorySink struct {
+	letters []DeadLetter
+	err     error
+}
+
+func (s *memorySink) Send(_ context.Context, letter DeadLetter) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.letters = append(s.letters, letter)
+	return nil
+}
+
+func TestProcessBatch(t *testing.T) {
+	ctx := context.Background()
+	id := strconv.Itoa
+	var calls [][]int
+	handle := func(_ context.Context, rs []int) error {
+		calls = append(calls, rs)
+		for _, r := range rs {
+			if r == 6 {
+				return errors.New("bad record")
+			}
+		}
+		return nil
+	}
+	batch := []int{1, 2, 3, 4, 5, 6, 7, 8}
+
+	p := NewBatchProcessor(BatchPolicy{}, zerolog.Nop(), nil)
+	assert.Equal(t, "1", ProcessBatch(ctx, p, batch, id, handle).FailedID)
+
+	calls = nil
+	p = NewBatchProcessor(BatchPolicy{BisectOnError: true}, zerolog.Nop(), nil)
+	assert.Equal(t, "6", ProcessBatch(ctx, p, batch, id, handle).FailedID)
+	assert.Equal(t, [][]int{batch, {1, 2, 3, 4}, {5, 6, 7, 8}, {5, 6}, {5}, {6}}, calls)
+
+	calls = nil
+	p = NewBatchProcessor(BatchPolicy{MaxAttempts: 1}, zerolog.Nop(), nil)
+	assert.Nil(t, ProcessBatch(ctx, p, batch, id, handle).Err)
+	assert.Equal(t, [][]int{batch, {1, 2, 3, 4}, {5, 6, 7, 8}, {5, 6}, {5}, {6}, {7, 8}}, calls, "the whole batch isn't run twice")
+
+	calls = nil
+	sink := &memorySink{}
+	p = NewBatchProcessor(BatchPolicy{DeadLetter: sink}, zerolog.Nop(), nil)
+	assert.Nil(t, ProcessBatch(ctx, p, batch, id, handle).Err)
+	assert.Len(t, sink.letters, 1)
+	assert.Equal(t, "6", sink.letters[0].RecordID)
+
+	t.Run("transient failure", func(t *testing.T) {
+		var calls [][]int
+		flaky := func(_ context.Context, rs []int) error {
+			calls = append(calls, rs)
+			if len(calls) == 1 {
+				return errors.New("throttled")
+			}
+			return nil
+		}
+		sink := &memorySink{}
+		p := NewBatchProcessor(BatchPolicy{BisectOnError: true, DeadLetter: sink}, zerolog.Nop(), nil)
+		assert.Nil(t, ProcessBatch(ctx, p, batch, id, flaky).Err)
+		assert.Equal(t, [][]int{batch, {1, 2, 3, 4}, {5, 6, 7, 8}}, calls, "no record is blamed or run again")
+		assert.Empty(t, sink.letters)
+	})
+}
@@ -0,0 +1,259 @@
+package sundaecli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// VersionInfo describes the running build
+type VersionInfo struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Version describes the build of service
+func Version(service Service) VersionInfo {
+	return VersionInfo{
+		Name:      service.Name,
+		Version:   service.Version,
+		Commit:    CommitHash(),
+		GoVersion: runtime.Version(),
+	}
+}
+
+// Command is a convenience for declaring a subcommand for CommandApp
+func Command(name, usage string, action cli.ActionFunc, flags ...cli.Flag) *cli.Command {
+	return &cli.Command{
+		Name:   name,
+		Usage:  usage,
+		Action: action,
+		Flags:  flags,
+	}
+}
+
+// CommandApp builds a cli.App made of named subcommands (run, replay,
+// backfill, ...), where App builds one with a single action. flags apply to
+// every command.
+//
+// The first command is also run when no subcommand is given, which is how
+// Lambda invokes the binary; any flags it needs in that case must be in flags
+// rather than its own.
+//
+// Every app also gets these commands, unless one of commands has the same
+// name:
+//
+//	version  print the build information
+//	config   print the resolved value of every flag in flags, as JSON
+//	flags    print a reference of every flag, as markdown or JSON
+//	tables   print the DynamoDB tables registered with RegisterTable, for --env
+func CommandApp(service Service, flags []cli.Flag, commands ...*cli.Command) *cli.App {
+	var action cli.ActionFunc
+	if len(commands) > 0 {
+		action = commands[0].Action
+	}
+	app := App(service, action, flags...)
+	app.Commands = commands
+
+	taken := map[string]bool{}
+	for _, c := range commands {
+		for _, name := range c.Names() {
+			taken[name] = true
+		}
+	}
+	for _, c := range builtinCommands(service) {
+		if !taken[c.Name] {
+			app.Commands = append(app.Commands, c)
+		}
+	}
+	return app
+}
+
+func builtinCommands(service Service) []*cli.Command {
+	return []*cli.Command{
+		{
+			Name:  "version",
+			Usage: "print the build information",
+			Flags: []cli.Flag{jsonFlag()},
+			Action: func(c *cli.Context) error {
+				info := Version(service)
+				if c.Bool("json") {
+					return writeJSON(c.App.Writer, info)
+				}
+				fmt.Fprintf(c.App.Writer, "%v %v (commit %v, %v)\n", info.Name, info.Version, info.Commit, info.GoVersion)
+				return nil
+			},
+		},
+		{
+			Name:  "config",
+			Usage: "print the resolved value of every flag, as JSON",
+			Action: func(c *cli.Context) error {
+				return writeJSON(c.App.Writer, ResolvedConfig(c))
+			},
+		},
+		{
+			Name:  "flags",
+			Usage: "print a reference of every flag",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "format", Usage: "markdown or json", Value: "markdown"},
+			},
+			Action: func(c *cli.Context) error {
+				return WriteFlagReference(c.App.Writer, c.App, c.String("format"))
+			},
+		},
+		{
+			Name:      "tables",
+			Usage:     "print the DynamoDB tables this service uses",
+			ArgsUsage: "[env]",
+			Flags:     []cli.Flag{jsonFlag()},
+			Action: func(c *cli.Context) error {
+				env := CommonOpts.Env
+				if c.Args().Present() {
+					env = c.Args().First()
+				}
+				if env == "" {
+					return fmt.Errorf("an environment is required: pass --%v or an argument", EnvFlag.Name)
+				}
+				names := map[string]string{}
+				for _, t := range Tables() {
+					names[t.Component] = t.TableName(env)
+				}
+				if c.Bool("json") {
+					return writeJSON(c.App.Writer, names)
+				}
+				for _, t := range Tables() {
+					if t.Optional {
+						fmt.Fprintf(c.App.Writer, "%v\t%v\toptional\n", t.Component, names[t.Component])
+						continue
+					}
+					fmt.Fprintf(c.App.Writer, "%v\t%v\n", t.Component, names[t.Component])
+				}
+				return nil
+			},
+		},
+	}
+}
+
+func jsonFlag() cli.Flag {
+	return &cli.BoolFlag{Name: "json", Usage: "print as JSON"}
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+const redacted = "<redacted>"
+
+// secretFlagWords mark flags whose values shouldn't be printed
+var secretFlagWords = []string{"secret", "password", "token", "credential"}
+
+func isSecretFlag(name string) bool {
+	for _, word := range secretFlagWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolvedConfig returns the value of every app level flag after defaults,
+// environment variables and the command line have been applied, with
+// secret-looking values redacted
+func ResolvedConfig(c *cli.Context) map[string]interface{} {
+	config := map[string]interface{}{}
+	for _, f := range c.App.Flags {
+		name := f.Names()[0]
+		value := c.Value(name)
+		if isSecretFlag(name) && fmt.Sprint(value) != "" {
+			value = redacted
+		}
+		config[name] = value
+	}
+	return config
+}
+
+// FlagDoc describes a single flag for WriteFlagReference
+type FlagDoc struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases,omitempty"`
+	EnvVars []string `json:"envVars,omitempty"`
+	Default string   `json:"default,omitempty"`
+	Usage   string   `json:"usage"`
+}
+
+// FlagDocs describes flags, sorted by name. The help and version flags cli
+// adds to every app are left out, and secret-looking defaults are redacted.
+func FlagDocs(flags []cli.Flag) []FlagDoc {
+	docs := make([]FlagDoc, 0, len(flags))
+	for _, f := range flags {
+		if f == cli.HelpFlag || f == cli.VersionFlag {
+			continue
+		}
+		names := f.Names()
+		doc := FlagDoc{Name: names[0], Aliases: names[1:]}
+		if d, ok := f.(cli.DocGenerationFlag); ok {
+			doc.Usage = d.GetUsage()
+			doc.EnvVars = d.GetEnvVars()
+			doc.Default = d.GetDefaultText()
+			if doc.Default == "" && d.TakesValue() {
+				doc.Default = d.GetValue()
+			}
+			doc.Default = strings.Trim(doc.Default, `"`)
+			if isSecretFlag(doc.Name) && doc.Default != "" {
+				doc.Default = redacted
+			}
+		}
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Name < docs[j].Name })
+	return docs
+}
+
+// WriteFlagReference documents the global flags of app and the flags of each
+// of its commands, as markdown or json
+func WriteFlagReference(w io.Writer, app *cli.App, format string) error {
+	reference := map[string][]FlagDoc{"global": FlagDocs(app.Flags)}
+	var commands []string
+	for _, c := range app.Commands {
+		if len(c.Flags) > 0 {
+			reference[c.Name] = FlagDocs(c.Flags)
+			commands = append(commands, c.Name)
+		}
+	}
+
+	switch format {
+	case "json":
+		return writeJSON(w, reference)
+	case "", "markdown", "md":
+	default:
+		return fmt.Errorf("unknown format %q: expected markdown or json", format)
+	}
+
+	fmt.Fprintf(w, "# %v flags\n", app.Name)
+	for _, section := range append([]string{"global"}, commands...) {
+		fmt.Fprintf(w, "\n## %v\n\n", section)
+		fmt.Fprintln(w, "| Flag | Environment | Default | Description |")
+		fmt.Fprintln(w, "| --- | --- | --- | --- |")
+		for _, doc := range reference[section] {
+			name := "`--" + doc.Name + "`"
+			for _, alias := range doc.Aliases {
+				name += ", `-" + alias + "`"
+			}
+			env := ""
+			if len(doc.EnvVars) > 0 {
+				env = "`" + strings.Join(doc.EnvVars, "`, `") + "`"
+			}
+			fmt.Fprintf(w, "| %v | %v | %v | %v |\n", name, env, doc.Default, strings.ReplaceAll(doc.Usage, "|", "\\|"))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package sundaecli
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/tj/assert"
+	"github.com/urfave/cli/v2"
+)
+
+func TestCommandApp(t *testing.T) {
+	RegisterTable("testdao", func(env string) string { return env + "-test--table" })
+
+	var ran string
+	run := Command("run", "run the service", func(*cli.Context) error { ran = "run"; return nil })
+	check := Command("check", "check the service", func(*cli.Context) error { ran = "check"; return nil },
+		&cli.IntFlag{Name: "limit", Usage: "max items", Value: 10})
+	flags := []cli.Flag{
+		&cli.StringFlag{Name: "env", Value: "local", EnvVars: []string{"ENV"}},
+		&cli.StringFlag{Name: "api-token", Value: "hunter2"},
+	}
+
+	exec := func(args ...string) string {
+		var out bytes.Buffer
+		app := CommandApp(testService, flags, run, check)
+		app.Writer = &out
+		assert.Nil(t, app.Run(append([]string{"svc"}, args...)))
+		return out.String()
+	}
+
+	exec()
+	assert.Equal(t, "run", ran)
+	exec("check")
+	assert.Equal(t, "check", ran)
+
+	t.Run("version", func(t *testing.T) {
+		var info VersionInfo
+		assert.Nil(t, json.Unmarshal([]byte(exec("version", "--json")), &info))
+		assert.Equal(t, "test-service", info.Name)
+		assert.Equal(t, "abc123", info.Version)
+	})
+
+	t.Run("config", func(t *testing.T) {
+		var config map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(exec("--env", "dev", "config")), &config))
+		assert.Equal(t, "dev", config["env"])
+		assert.Equal(t, "<redacted>", config["api-token"])
+	})
+
+	t.Run("flags", func(t *testing.T) {
+		out := exec("flags")
+		assert.Contains(t, out, "| `--env` | `ENV` | local |")
+		assert.Contains(t, out, "## check")
+
+		var reference map[string][]FlagDoc
+		assert.Nil(t, json.Unmarshal([]byte(exec("flags", "--format", "json")), &reference))
+		assert.Len(t, reference["global"], 2)
+		assert.Equal(t, "limit", reference["check"][0].Name)
+		assert.Equal(t, redacted, reference["global"][0].Default)
+		assert.Equal(t, "10", reference["check"][0].Default)
+	})
+
+	t.Run("tables", func(t *testing.T) {
+		out := exec("tables", "prod")
+		assert.True(t, strings.Contains(out, "testdao\tprod-test--table"), out)
+		assert.True(t, strings.Contains(out, "features\t"+FeatureTableName("prod")+"\toptional"), out)
+	})
+}
@@ -0,0 +1,325 @@
+package sundaecli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+)
+
+// DefaultFeatureTTL is how long feature flag values are cached before the
+// store is read again
+const DefaultFeatureTTL = 30 * time.Second
+
+var FeatureOpts struct {
+	Source string
+	TTL    time.Duration
+}
+
+var FeaturesFlag = StringFlag("features", "where to read feature flags from: ddb, the path to a JSON file, or none to use the defaults", &FeatureOpts.Source, "none")
+var FeaturesTTLFlag = DurationFlag("features-ttl", "how long feature flag values are cached before being reloaded", &FeatureOpts.TTL, DefaultFeatureTTL)
+
+var FeatureFlags = []cli.Flag{
+	FeaturesFlag,
+	FeaturesTTLFlag,
+}
+
+const (
+	FeatureEnabledMetric      MetricName = "FeatureEnabled"
+	FeatureRefreshErrorMetric MetricName = "FeatureRefreshError"
+
+	FeatureDimension DimensionName = "Feature"
+)
+
+// Feature is a switch declared in code, with the value it takes when no
+// override is stored for it
+type Feature struct {
+	Name    string
+	Usage   string
+	Default bool
+}
+
+var features struct {
+	sync.Mutex
+	declared map[string]*Feature
+	current  *Features
+}
+
+// NewFeature declares a feature; features are usually package level vars, e.g.
+//
+//	var PoolWrites = sundaecli.NewFeature("pool-writes", "write pool snapshots", true)
+//
+// Declaring the same name twice panics.
+func NewFeature(name, usage string, value bool) *Feature {
+	features.Lock()
+	defer features.Unlock()
+	if _, ok := features.declared[name]; ok {
+		panic(fmt.Sprintf("feature %v declared twice", name))
+	}
+	if features.declared == nil {
+		features.declared = map[string]*Feature{}
+	}
+	f := &Feature{Name: name, Usage: usage, Default: value}
+	features.declared[name] = f
+	return f
+}
+
+// DeclaredFeatures returns every feature declared with NewFeature, sorted by
+// name
+func DeclaredFeatures() []*Feature {
+	features.Lock()
+	defer features.Unlock()
+	list := make([]*Feature, 0, len(features.declared))
+	for _, f := range features.declared {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// SetFeatures makes f the Features consulted by Feature.Enabled; BuildFeatures
+// calls it
+func SetFeatures(f *Features) {
+	features.Lock()
+	defer features.Unlock()
+	features.current = f
+}
+
+// Enabled evaluates the feature against the Features set by BuildFeatures (or
+// SetFeatures), falling back to its default if there are none. See
+// Features.Enabled for how key is used.
+func (f *Feature) Enabled(ctx context.Context, key ...string) bool {
+	features.Lock()
+	current := features.current
+	features.Unlock()
+	if current == nil {
+		return f.Default
+	}
+	return current.Enabled(ctx, f, key...)
+}
+
+// Features evaluates features against the overrides in a FeatureStore. The
+// overrides are cached for a TTL and reloaded by the first evaluation after it
+// expires, so changes to the store take effect without a redeploy; other
+// evaluations meanwhile use the previous overrides rather than wait for the
+// store. If a reload fails, the previous overrides stay in use until the next
+// attempt.
+type Features struct {
+	store   FeatureStore
+	env     string
+	ttl     time.Duration
+	metrics Metrics
+	logger  zerolog.Logger
+	now     func() time.Time
+
+	mu         sync.Mutex
+	overrides  map[featureScope]bool
+	loadedAt   time.Time
+	refreshing chan struct{} // closed when the reload in flight is done
+}
+
+type featureScope struct {
+	name string
+	env  string
+	key  string
+}
+
+type FeaturesOption func(*Features)
+
+// WithFeatureTTL sets how long overrides are cached; DefaultFeatureTTL if
+// unset
+func WithFeatureTTL(ttl time.Duration) FeaturesOption {
+	return func(f *Features) {
+		if ttl > 0 {
+			f.ttl = ttl
+		}
+	}
+}
+
+// WithFeatureMetrics reports the value of every declared feature after each
+// reload as the FeatureEnabled gauge, and failed reloads as
+// FeatureRefreshError events
+func WithFeatureMetrics(metrics Metrics) FeaturesOption {
+	return func(f *Features) {
+		if metrics != nil {
+			f.metrics = metrics
+		}
+	}
+}
+
+func WithFeatureLogger(logger zerolog.Logger) FeaturesOption {
+	return func(f *Features) {
+		f.logger = logger
+	}
+}
+
+// NewFeatures evaluates features for env against the overrides in store
+func NewFeatures(store FeatureStore, env string, opts ...FeaturesOption) *Features {
+	f := &Features{
+		store:   store,
+		env:     env,
+		ttl:     DefaultFeatureTTL,
+		metrics: NopMetrics{},
+		logger:  zerolog.Nop(),
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// BuildFeatures constructs the Features selected by FeatureOpts for
+// CommonOpts.Env and makes it the one Feature.Enabled consults
+func BuildFeatures(service Service, metrics Metrics) (*Features, error) {
+	var store FeatureStore
+	switch FeatureOpts.Source {
+	case "", "none":
+		store = FeatureRecords(nil)
+	case "ddb":
+		api := dynamodb.New(AWSSession(aws.NewConfig()))
+		store = NewDDBFeatureStore(api, FeatureTableName(CommonOpts.Env))
+	default:
+		store = NewFileFeatureStore(FeatureOpts.Source)
+	}
+
+	f := NewFeatures(store, CommonOpts.Env,
+		WithFeatureTTL(FeatureOpts.TTL),
+		WithFeatureMetrics(metrics),
+		WithFeatureLogger(Logger(service).With().Str("component", "features").Logger()),
+	)
+	SetFeatures(f)
+	return f, nil
+}
+
+// Enabled reports whether feature is on. With a key (a pool ident, an API
+// client, ...), an override for that key wins over one for the whole
+// environment; overrides scoped to this environment win over unscoped ones;
+// and the feature's default applies when nothing is stored. Only the first key
+// is used.
+func (f *Features) Enabled(ctx context.Context, feature *Feature, key ...string) bool {
+	var k string
+	if len(key) > 0 {
+		k = key[0]
+	}
+	return f.evaluate(f.load(ctx), feature, k)
+}
+
+func (f *Features) evaluate(overrides map[featureScope]bool, feature *Feature, key string) bool {
+	var scopes []featureScope
+	if key != "" {
+		scopes = append(scopes,
+			featureScope{name: feature.Name, env: f.env, key: key},
+			featureScope{name: feature.Name, key: key},
+		)
+	}
+	scopes = append(scopes,
+		featureScope{name: feature.Name, env: f.env},
+		featureScope{name: feature.Name},
+	)
+	for _, scope := range scopes {
+		if enabled, ok := overrides[scope]; ok {
+			return enabled
+		}
+	}
+	return feature.Default
+}
+
+// Refresh reloads the overrides from the store now
+func (f *Features) Refresh(ctx context.Context) error {
+	f.mu.Lock()
+	done := f.startRefresh()
+	f.mu.Unlock()
+	return f.refresh(ctx, done)
+}
+
+func (f *Features) load(ctx context.Context) map[featureScope]bool {
+	f.mu.Lock()
+	overrides, done := f.overrides, f.refreshing
+	switch {
+	case overrides != nil && (done != nil || f.now().Sub(f.loadedAt) < f.ttl):
+		// Fresh, or being reloaded by another evaluation
+		f.mu.Unlock()
+		return overrides
+	case done != nil:
+		// The first load is in flight; there's nothing older to use
+		f.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.overrides
+	}
+	done = f.startRefresh()
+	f.mu.Unlock()
+
+	if err := f.refresh(ctx, done); err != nil {
+		f.logger.Warn().Err(err).Msg("unable to reload feature flags; using previous values")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.overrides
+}
+
+// startRefresh must be called with f.mu held
+func (f *Features) startRefresh() chan struct{} {
+	done := make(chan struct{})
+	f.refreshing = done
+	return done
+}
+
+// refresh reads the store without holding f.mu, then swaps the overrides in
+// and closes done
+func (f *Features) refresh(ctx context.Context, done chan struct{}) error {
+	records, err := f.store.LoadFeatures(ctx)
+	var overrides map[featureScope]bool
+	if err == nil {
+		overrides = make(map[featureScope]bool, len(records))
+		for _, r := range records {
+			if r.Env != "" && r.Env != f.env {
+				continue
+			}
+			key := r.Key
+			if key == AllKeys {
+				key = ""
+			}
+			overrides[featureScope{name: r.Name, env: r.Env, key: key}] = r.Enabled
+		}
+	}
+
+	f.mu.Lock()
+	// Whatever the outcome, don't try again until the TTL has passed
+	f.loadedAt = f.now()
+	if f.refreshing == done {
+		f.refreshing = nil
+	}
+	close(done)
+	switch {
+	case err == nil:
+		f.overrides = overrides
+	case f.overrides == nil:
+		f.overrides = map[featureScope]bool{}
+	}
+	f.mu.Unlock()
+
+	if err != nil {
+		f.metrics.Event(ctx, FeatureRefreshErrorMetric)
+		return fmt.Errorf("unable to load feature flags: %w", err)
+	}
+	for _, feature := range DeclaredFeatures() {
+		var value float64
+		if f.evaluate(overrides, feature, "") {
+			value = 1
+		}
+		f.metrics.Gauge(ctx, FeatureEnabledMetric, value, map[DimensionName]string{FeatureDimension: feature.Name})
+	}
+	return nil
+}
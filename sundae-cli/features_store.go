@@ -0,0 +1,104 @@
+package sundaecli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/savaki/ddb"
+)
+
+// AllKeys is the Key of an override that applies to every key; DynamoDB
+// doesn't allow an empty range key
+const AllKeys = "*"
+
+func init() {
+	RegisterTable("features", FeatureTableName, WithTableModel(FeatureRecord{}), WithTableOptional())
+}
+
+func FeatureTableName(env string) string {
+	return env + "-sundae--features"
+}
+
+// FeatureRecord overrides the default of a feature. An empty Env applies to
+// every environment, and an empty Key (or AllKeys) to every key.
+type FeatureRecord struct {
+	Name    string `json:"name" dynamodbav:"name" ddb:"hash"`
+	Key     string `json:"key,omitempty" dynamodbav:"key" ddb:"range"`
+	Env     string `json:"env,omitempty" dynamodbav:"env,omitempty"`
+	Enabled bool   `json:"enabled" dynamodbav:"enabled"`
+	Note    string `json:"note,omitempty" dynamodbav:"note,omitempty"`
+}
+
+// FeatureStore loads every stored override
+type FeatureStore interface {
+	LoadFeatures(ctx context.Context) ([]FeatureRecord, error)
+}
+
+// FeatureRecords is a fixed set of overrides, for tests and for running with
+// the defaults
+type FeatureRecords []FeatureRecord
+
+func (r FeatureRecords) LoadFeatures(context.Context) ([]FeatureRecord, error) {
+	return r, nil
+}
+
+// DDBFeatureStore reads overrides from a DynamoDB table keyed by feature name
+// and key
+type DDBFeatureStore struct {
+	table *ddb.Table
+}
+
+func NewDDBFeatureStore(api dynamodbiface.DynamoDBAPI, tableName string) *DDBFeatureStore {
+	return &DDBFeatureStore{
+		table: ddb.New(api).MustTable(tableName, FeatureRecord{}),
+	}
+}
+
+func (s *DDBFeatureStore) LoadFeatures(ctx context.Context) ([]FeatureRecord, error) {
+	var records []FeatureRecord
+	err := s.table.Scan().ConsistentRead(true).EachWithContext(ctx, func(item ddb.Item) (bool, error) {
+		var r FeatureRecord
+		if err := item.Unmarshal(&r); err != nil {
+			return false, err
+		}
+		records = append(records, r)
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to scan feature table: %w", err)
+	}
+	return records, nil
+}
+
+// Put stores an override
+func (s *DDBFeatureStore) Put(ctx context.Context, r FeatureRecord) error {
+	if r.Key == "" {
+		r.Key = AllKeys
+	}
+	return s.table.Put(r).RunWithContext(ctx)
+}
+
+// FileFeatureStore reads overrides from a JSON array of FeatureRecord. The file
+// is read on every load, so edits are picked up once the cache expires.
+type FileFeatureStore struct {
+	path string
+}
+
+func NewFileFeatureStore(path string) *FileFeatureStore {
+	return &FileFeatureStore{path: path}
+}
+
+func (s *FileFeatureStore) LoadFeatures(context.Context) ([]FeatureRecord, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read feature file %v: %w", s.path, err)
+	}
+	var records []FeatureRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("unable to parse feature file %v: %w", s.path, err)
+	}
+	return records, nil
+}
@@ -0,0 +1,110 @@
+package sundaecli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+)
+
+type failingFeatureStore struct{}
+
+func (failingFeatureStore) LoadFeatures(context.Context) ([]FeatureRecord, error) {
+	return nil, errors.New("boom")
+}
+
+// blockingFeatureStore loads its records once release is closed
+type blockingFeatureStore struct {
+	records FeatureRecords
+	loads   chan struct{}
+	release chan struct{}
+}
+
+func (s blockingFeatureStore) LoadFeatures(ctx context.Context) ([]FeatureRecord, error) {
+	s.loads <- struct{}{}
+	<-s.release
+	return s.records, nil
+}
+
+func TestFeatures(t *testing.T) {
+	ctx := context.Background()
+	writes := NewFeature("test-writes", "write things", true)
+	beta := NewFeature("test-beta", "beta path", false)
+
+	assert.True(t, writes.Enabled(ctx))
+	assert.Panics(t, func() { NewFeature("test-writes", "again", false) })
+
+	path := filepath.Join(t.TempDir(), "features.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`[
+		{"name": "test-writes", "enabled": false},
+		{"name": "test-writes", "key": "pool-a", "enabled": true},
+		{"name": "test-beta", "env": "preview", "enabled": true},
+		{"name": "test-beta", "env": "mainnet", "key": "pool-b", "enabled": true}
+	]`), 0o644))
+
+	metrics := NewMemoryMetrics(testService)
+	now := time.Unix(0, 0)
+	f := NewFeatures(NewFileFeatureStore(path), "mainnet", WithFeatureTTL(time.Minute), WithFeatureMetrics(metrics))
+	f.now = func() time.Time { return now }
+
+	assert.False(t, f.Enabled(ctx, writes))
+	assert.True(t, f.Enabled(ctx, writes, "pool-a"))
+	assert.False(t, f.Enabled(ctx, writes, "pool-b"))
+	assert.False(t, f.Enabled(ctx, beta))
+	assert.True(t, f.Enabled(ctx, beta, "pool-b"))
+	assert.Len(t, metrics.Named(FeatureEnabledMetric), 2)
+	assert.Equal(t, float64(0), metrics.Sum(FeatureEnabledMetric))
+
+	t.Run("hot reload", func(t *testing.T) {
+		assert.Nil(t, os.WriteFile(path, []byte(`[{"name": "test-writes", "enabled": true}]`), 0o644))
+		assert.False(t, f.Enabled(ctx, writes)) // cached
+		now = now.Add(time.Minute)
+		assert.True(t, f.Enabled(ctx, writes))
+	})
+
+	t.Run("failed reload keeps previous values", func(t *testing.T) {
+		f.store = failingFeatureStore{}
+		now = now.Add(time.Minute)
+		assert.True(t, f.Enabled(ctx, writes))
+		assert.Len(t, metrics.Named(FeatureRefreshErrorMetric), 1)
+	})
+
+	t.Run("default features", func(t *testing.T) {
+		SetFeatures(NewFeatures(FeatureRecords{{Name: "test-beta", Key: AllKeys, Enabled: true}}, "mainnet"))
+		defer SetFeatures(nil)
+		assert.True(t, beta.Enabled(ctx))
+	})
+
+	t.Run("reloads don't block evaluations", func(t *testing.T) {
+		store := blockingFeatureStore{
+			records: FeatureRecords{{Name: "test-beta", Enabled: true}},
+			loads:   make(chan struct{}, 2),
+			release: make(chan struct{}),
+		}
+		f.store = store
+		now = now.Add(time.Minute)
+		reloaded := make(chan bool)
+		go func() { reloaded <- f.Enabled(ctx, beta) }()
+		<-store.loads
+
+		// Meanwhile, the previous values are used, and the store isn't read twice
+		assert.False(t, f.Enabled(ctx, beta))
+		assert.Len(t, store.loads, 0)
+		close(store.release)
+		assert.True(t, <-reloaded)
+		assert.True(t, f.Enabled(ctx, beta))
+	})
+
+	t.Run("nil metrics", func(t *testing.T) {
+		defer func() { FeatureOpts.Source = "" }()
+		FeatureOpts.Source = path
+		built, err := BuildFeatures(testService, nil)
+		defer SetFeatures(nil)
+		assert.Nil(t, err)
+		assert.True(t, built.Enabled(ctx, writes))
+	})
+}
@@ -2,6 +2,7 @@ package sundaecli
 
 import (
 	"strings"
+	"time"
 
 	"github.com/urfave/cli/v2"
 )
@@ -33,6 +34,34 @@ var CommonFlags = []cli.Flag{
 	DryFlag,
 	EnvFlag,
 	SlotOffset,
+	EventsFlag,
+	AdminPortFlag,
+	DrainTimeoutFlag,
+	MetricsBackendFlag,
+	MetricsNamespaceFlag,
+	MetricsFlushIntervalFlag,
+	MetricsPortFlag,
+	FeaturesFlag,
+	FeaturesTTLFlag,
+}
+
+var MetricsOpts struct {
+	Backend       string
+	Namespace     string
+	FlushInterval time.Duration
+	Port          int
+}
+
+var MetricsBackendFlag = StringFlag("metrics", "where to publish metrics: cloudwatch, emf, prometheus, memory or none", &MetricsOpts.Backend, "cloudwatch")
+var MetricsNamespaceFlag = StringFlag("metrics-namespace", "the CloudWatch namespace to publish metrics under", &MetricsOpts.Namespace, DefaultNamespace)
+var MetricsFlushIntervalFlag = DurationFlag("metrics-flush-interval", "how often buffered CloudWatch metrics are published", &MetricsOpts.FlushInterval, DefaultFlushInterval)
+var MetricsPortFlag = IntFlag("metrics-port", "the port to serve prometheus metrics on, in console mode", &MetricsOpts.Port)
+
+var MetricsFlags = []cli.Flag{
+	MetricsBackendFlag,
+	MetricsNamespaceFlag,
+	MetricsFlushIntervalFlag,
+	MetricsPortFlag,
 }
 
 func ToUNDER_CAPS(s string) string {
@@ -114,3 +143,17 @@ func Uint64Flag(name, usage string, dest *uint64, value ...uint64) *cli.Uint64Fl
 		Destination: dest,
 	}
 }
+
+func DurationFlag(name, usage string, dest *time.Duration, value ...time.Duration) *cli.DurationFlag {
+	var v time.Duration
+	if len(value) > 0 {
+		v = value[0]
+	}
+	return &cli.DurationFlag{
+		Name:        name,
+		Usage:       usage,
+		Value:       v,
+		EnvVars:     []string{ToUNDER_CAPS(name)},
+		Destination: dest,
+	}
+}
@@ -0,0 +1,22 @@
+package sundaecli
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// Lambda sends SIGKILL roughly 500ms after SIGTERM, so shutdown flushes must
+// finish well inside that
+const lambdaShutdownGrace = 400 * time.Millisecond
+
+// StartLambda starts handler as a Lambda function, flushing every registered
+// Flusher (see RegisterFlusher) when the execution environment shuts down
+func StartLambda(handler interface{}) {
+	lambda.StartWithOptions(handler, lambda.WithEnableSIGTERM(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), lambdaShutdownGrace)
+		defer cancel()
+		_ = FlushAll(ctx)
+	}))
+}
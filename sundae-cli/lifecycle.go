@@ -0,0 +1,241 @@
+package sundaecli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultDrainTimeout leaves headroom inside ECS's default 30s stop timeout
+const DefaultDrainTimeout = 25 * time.Second
+
+var LifecycleOpts struct {
+	DrainTimeout time.Duration
+}
+
+var DrainTimeoutFlag = DurationFlag("drain-timeout", "how long in-flight work may take to finish after SIGINT/SIGTERM, before shutdown hooks run", &LifecycleOpts.DrainTimeout, DefaultDrainTimeout)
+
+// ShutdownHook is run once the process has been asked to stop, with a context
+// bounded by the drain deadline
+type ShutdownHook func(ctx context.Context) error
+
+type shutdownHook struct {
+	name string
+	hook ShutdownHook
+}
+
+// Lifecycle owns the root context of a long-running process.
+//
+// The root context (Context) is cancelled on SIGINT or SIGTERM, and signals
+// that no new work should be started. Work already in flight should run under
+// a Drain context instead, which stays alive until the drain timeout has
+// elapsed after the signal, so the current batch can be finished and its
+// cursor persisted. Shutdown then runs every hook registered with OnShutdown,
+// in registration order, and finally flushes every registered Flusher. A
+// second signal exits immediately.
+type Lifecycle struct {
+	logger  zerolog.Logger
+	timeout time.Duration
+
+	ctx         context.Context
+	cancel      context.CancelFunc
+	drainCtx    context.Context
+	drainCancel context.CancelFunc
+
+	mu       sync.Mutex
+	hooks    []shutdownHook
+	signals  chan os.Signal
+	shutdown sync.Once
+	err      error
+}
+
+// NewLifecycle starts watching for SIGINT and SIGTERM. A non-positive timeout
+// uses DefaultDrainTimeout.
+func NewLifecycle(logger zerolog.Logger, timeout time.Duration) *Lifecycle {
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+	l := &Lifecycle{
+		logger:  logger,
+		timeout: timeout,
+		signals: make(chan os.Signal, 2),
+	}
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	l.drainCtx, l.drainCancel = context.WithCancel(context.Background())
+	context.AfterFunc(l.ctx, func() {
+		time.AfterFunc(l.timeout, l.drainCancel)
+	})
+
+	signal.Notify(l.signals, os.Interrupt, syscall.SIGTERM)
+	go l.watch()
+	return l
+}
+
+func (l *Lifecycle) watch() {
+	sig, ok := <-l.signals
+	if !ok {
+		return
+	}
+	l.logger.Info().Str("signal", sig.String()).Dur("drainTimeout", l.timeout).Msg("shutting down; draining in-flight work")
+	l.cancel()
+
+	if sig, ok := <-l.signals; ok {
+		l.logger.Warn().Str("signal", sig.String()).Msg("caught second signal, exiting immediately")
+		os.Exit(1)
+	}
+}
+
+// Context is cancelled as soon as the process is asked to stop
+func (l *Lifecycle) Context() context.Context {
+	return l.ctx
+}
+
+// Stopping reports whether the process has been asked to stop
+func (l *Lifecycle) Stopping() bool {
+	return l.ctx.Err() != nil
+}
+
+// Stop cancels the root context as though a signal had been received
+func (l *Lifecycle) Stop() {
+	l.cancel()
+}
+
+// Drain returns a context carrying ctx's values that is not cancelled when the
+// process is asked to stop, but only once the drain timeout has elapsed after
+// that, or when cancel is called
+func (l *Lifecycle) Drain(ctx context.Context) (context.Context, context.CancelFunc) {
+	drain, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(l.drainCtx, cancel)
+	return drain, func() {
+		stop()
+		cancel()
+	}
+}
+
+// OnShutdown registers hook to be run by Shutdown, after every hook registered
+// before it
+func (l *Lifecycle) OnShutdown(name string, hook ShutdownHook) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, shutdownHook{name: name, hook: hook})
+}
+
+// Shutdown cancels the root context if it hasn't been already, runs each
+// shutdown hook in order, then flushes every registered Flusher. Hooks share
+// the drain deadline, and a failing hook doesn't stop later ones from running.
+// Only the first call does anything; later calls return the same error.
+func (l *Lifecycle) Shutdown() error {
+	l.shutdown.Do(func() {
+		l.cancel()
+
+		l.mu.Lock()
+		hooks := append([]shutdownHook(nil), l.hooks...)
+		l.mu.Unlock()
+
+		var errs []error
+		for _, h := range hooks {
+			l.logger.Debug().Str("hook", h.name).Msg("running shutdown hook")
+			if err := h.hook(l.drainCtx); err != nil {
+				l.logger.Warn().Err(err).Str("hook", h.name).Msg("shutdown hook failed")
+				errs = append(errs, fmt.Errorf("shutdown hook %v: %w", h.name, err))
+			}
+		}
+		if err := FlushAll(l.drainCtx); err != nil {
+			errs = append(errs, fmt.Errorf("unable to flush: %w", err))
+		}
+		l.err = errors.Join(errs...)
+
+		signal.Stop(l.signals)
+		close(l.signals)
+		l.drainCancel()
+	})
+	return l.err
+}
+
+// Run calls fn with a context that is cancelled when either ctx is, or the
+// process is asked to stop, then runs Shutdown. An error from fn caused purely
+// by that cancellation is not reported.
+func (l *Lifecycle) Run(ctx context.Context, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(l.ctx, cancel)
+	defer stop()
+
+	err := fn(ctx)
+	if err != nil && l.Stopping() && errors.Is(err, context.Canceled) {
+		err = nil
+	}
+	shutdownErr := l.Shutdown()
+	if err == nil {
+		return shutdownErr
+	}
+	return errors.Join(err, shutdownErr)
+}
+
+var defaultLifecycle struct {
+	sync.Mutex
+	lifecycle *Lifecycle
+}
+
+// DefaultLifecycle returns the process-wide Lifecycle used by Run, creating it
+// on first use with the --drain-timeout flag
+func DefaultLifecycle() *Lifecycle {
+	defaultLifecycle.Lock()
+	defer defaultLifecycle.Unlock()
+	if defaultLifecycle.lifecycle == nil {
+		defaultLifecycle.lifecycle = NewLifecycle(zerolog.New(os.Stdout).With().Str("component", "lifecycle").Logger(), LifecycleOpts.DrainTimeout)
+	}
+	return defaultLifecycle.lifecycle
+}
+
+// OnShutdown registers hook with the DefaultLifecycle
+func OnShutdown(name string, hook ShutdownHook) {
+	DefaultLifecycle().OnShutdown(name, hook)
+}
+
+// Drain derives a drain context from the DefaultLifecycle; see Lifecycle.Drain
+func Drain(ctx context.Context) (context.Context, context.CancelFunc) {
+	return DefaultLifecycle().Drain(ctx)
+}
+
+// ListenAndServe serves handler on addr until ctx is cancelled, then stops
+// accepting connections and waits for in-flight requests to complete, within
+// the drain deadline
+func ListenAndServe(ctx context.Context, addr string, handler http.Handler) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return context.WithoutCancel(ctx) },
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- server.Serve(listener) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	drain, cancel := Drain(ctx)
+	defer cancel()
+	if err := server.Shutdown(drain); err != nil {
+		return fmt.Errorf("unable to shut down http server cleanly: %w", err)
+	}
+	if err := <-errc; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package sundaecli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/tj/assert"
+)
+
+func TestLifecycle(t *testing.T) {
+	t.Run("runs hooks in order after the drain", func(t *testing.T) {
+		l := NewLifecycle(zerolog.Nop(), 50*time.Millisecond)
+		var order []string
+		for _, name := range []string{"cursor", "metrics", "broken", "last"} {
+			name := name
+			l.OnShutdown(name, func(ctx context.Context) error {
+				order = append(order, name)
+				if name == "broken" {
+					return fmt.Errorf("boom")
+				}
+				return nil
+			})
+		}
+
+		err := l.Run(context.Background(), func(ctx context.Context) error {
+			drain, cancel := l.Drain(ctx)
+			defer cancel()
+
+			l.Stop()
+			<-ctx.Done()
+			assert.NoError(t, drain.Err())
+			select {
+			case <-drain.Done():
+			case <-time.After(time.Second):
+				t.Fatal("drain context outlived the drain timeout")
+			}
+			return ctx.Err()
+		})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "shutdown hook broken: boom")
+		assert.Equal(t, []string{"cursor", "metrics", "broken", "last"}, order)
+		assert.Equal(t, err, l.Shutdown())
+	})
+
+	t.Run("cancels the root context on SIGTERM", func(t *testing.T) {
+		l := NewLifecycle(zerolog.Nop(), time.Second)
+		defer l.Shutdown()
+
+		assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+		select {
+		case <-l.Context().Done():
+		case <-time.After(time.Second):
+			t.Fatal("root context was not cancelled")
+		}
+		assert.True(t, l.Stopping())
+	})
+}
+
+func TestListenAndServe(t *testing.T) {
+	// ListenAndServe drains through the default lifecycle
+	defaultLifecycle.lifecycle = NewLifecycle(zerolog.Nop(), time.Second)
+	defer func() {
+		defaultLifecycle.lifecycle.Shutdown()
+		defaultLifecycle.lifecycle = nil
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- ListenAndServe(ctx, addr, handler) }()
+
+	responses := make(chan string, 1)
+	go func() {
+		var resp *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			if resp, err = http.Get("http://" + addr); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+
+	<-started
+	cancel()
+	close(release)
+	assert.Equal(t, "done", <-responses)
+	err = <-served
+	assert.True(t, err == nil || errors.Is(err, http.ErrServerClosed))
+}
@@ -3,23 +3,39 @@ package sundaecli
 import (
 	"context"
 	"fmt"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
 )
 
-type Metrics struct {
-	service    Service
-	cloudwatch cloudwatchiface.CloudWatchAPI
+// Metrics records application metrics. Implementations may buffer, so callers
+// should Flush (or Close) before the process exits; handlers started through
+// StartLambda flush every registered Metrics on shutdown.
+type Metrics interface {
+	// Event records a single occurrence of name
+	Event(ctx context.Context, name MetricName, dimensions ...map[DimensionName]string)
+	// Timing records the milliseconds elapsed since start
+	Timing(ctx context.Context, name MetricName, start time.Time, dimensions ...map[DimensionName]string)
+	// Gauge records a point-in-time value
+	Gauge(ctx context.Context, name MetricName, value float64, dimensions ...map[DimensionName]string)
+	// Counter adds value to a monotonically increasing count
+	Counter(ctx context.Context, name MetricName, value float64, unit Unit, dimensions ...map[DimensionName]string)
+	// Histogram records one observation of a distribution
+	Histogram(ctx context.Context, name MetricName, value float64, unit Unit, dimensions ...map[DimensionName]string)
+
+	Flush(ctx context.Context) error
+	Close() error
 }
 
+// NewMetrics returns a buffered CloudWatch Metrics publishing to the default
+// namespace; see NewCloudWatchMetrics for the available options.
 func NewMetrics(service Service, cloudwatch cloudwatchiface.CloudWatchAPI) Metrics {
-	return Metrics{
-		service,
-		cloudwatch,
-	}
+	return NewCloudWatchMetrics(service, cloudwatch)
 }
 
 type MetricName string
@@ -36,6 +52,58 @@ const (
 	OperationNameDimension  DimensionName = "OperationName"
 )
 
+// Unit is the unit of a metric value. The predefined units are the ones
+// CloudWatch understands; other values are allowed, but the CloudWatch and EMF
+// backends will publish them as UnitNone.
+type Unit string
+
+const (
+	UnitNone         Unit = cloudwatch.StandardUnitNone
+	UnitCount        Unit = cloudwatch.StandardUnitCount
+	UnitPercent      Unit = cloudwatch.StandardUnitPercent
+	UnitSeconds      Unit = cloudwatch.StandardUnitSeconds
+	UnitMilliseconds Unit = cloudwatch.StandardUnitMilliseconds
+	UnitMicroseconds Unit = cloudwatch.StandardUnitMicroseconds
+	UnitBytes        Unit = cloudwatch.StandardUnitBytes
+	UnitKilobytes    Unit = cloudwatch.StandardUnitKilobytes
+	UnitMegabytes    Unit = cloudwatch.StandardUnitMegabytes
+	UnitCountSecond  Unit = cloudwatch.StandardUnitCountSecond
+	UnitBytesSecond  Unit = cloudwatch.StandardUnitBytesSecond
+)
+
+// cloudwatchUnit maps u onto a unit CloudWatch will accept
+func cloudwatchUnit(u Unit) string {
+	for _, v := range cloudwatch.StandardUnit_Values() {
+		if string(u) == v {
+			return v
+		}
+	}
+	return cloudwatch.StandardUnitNone
+}
+
+// MetricKind distinguishes how a Datum should be aggregated
+type MetricKind string
+
+const (
+	CounterKind   MetricKind = "counter"
+	GaugeKind     MetricKind = "gauge"
+	HistogramKind MetricKind = "histogram"
+)
+
+// Datum is a single recorded metric value, with the service's default
+// dimensions already merged in
+type Datum struct {
+	Name       MetricName
+	Kind       MetricKind
+	Unit       Unit
+	Value      float64
+	Dimensions map[DimensionName]string
+	Timestamp  time.Time
+}
+
+// DefaultNamespace is the CloudWatch namespace used when none is configured
+const DefaultNamespace = "sundae-services"
+
 func defaultDimensions(service Service) map[DimensionName]string {
 	return map[DimensionName]string{
 		ServiceNameDimension:    service.Name,
@@ -43,75 +111,136 @@ func defaultDimensions(service Service) map[DimensionName]string {
 	}
 }
 
-func mapToDimensions(ms ...map[DimensionName]string) []*cloudwatch.Dimension {
-	var dimensions []*cloudwatch.Dimension
+func mergeDimensions(ms ...map[DimensionName]string) map[DimensionName]string {
+	merged := map[DimensionName]string{}
 	for _, ds := range ms {
 		for k, v := range ds {
 			if v == "" {
 				continue
 			}
-			dimensions = append(dimensions, &cloudwatch.Dimension{
-				Name:  aws.String(string(k)),
-				Value: aws.String(v),
-			})
+			merged[k] = v
 		}
 	}
-	return dimensions
-}
-
-func (m Metrics) Event(ctx context.Context, name MetricName, dimensions ...map[DimensionName]string) {
-	awsDimensions := mapToDimensions(append(dimensions, defaultDimensions(m.service))...)
-	_, err := m.cloudwatch.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
-		Namespace: aws.String("sundae-services"),
-		MetricData: []*cloudwatch.MetricDatum{
-			{
-				MetricName: aws.String(string(name)),
-				Timestamp:  aws.Time(time.Now()),
-				Unit:       aws.String("Count"),
-				Value:      aws.Float64(1),
-				Dimensions: awsDimensions,
-			},
-		},
-	})
-	if err != nil {
-		fmt.Printf("Warning: couldn't publish event for %v: %+v\n", name, err)
-	}
+	return merged
 }
 
-func (m Metrics) Timing(ctx context.Context, name MetricName, start time.Time, dimensions ...map[DimensionName]string) {
-	awsDimensions := mapToDimensions(append(dimensions, defaultDimensions(m.service))...)
-	_, err := m.cloudwatch.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
-		Namespace: aws.String("sundae-services"),
-		MetricData: []*cloudwatch.MetricDatum{
-			{
-				MetricName: aws.String(string(name)),
-				Timestamp:  aws.Time(time.Now()),
-				Unit:       aws.String("Milliseconds"),
-				Value:      aws.Float64(float64(time.Since(start).Milliseconds())),
-				Dimensions: awsDimensions,
-			},
-		},
+// baseMetrics implements the Metrics recording methods on top of a single
+// record function, so each backend only has to deal with Datums
+type baseMetrics struct {
+	service Service
+	record  func(ctx context.Context, datum Datum)
+}
+
+func (m baseMetrics) emit(ctx context.Context, name MetricName, kind MetricKind, unit Unit, value float64, dimensions []map[DimensionName]string) {
+	m.record(ctx, Datum{
+		Name:       name,
+		Kind:       kind,
+		Unit:       unit,
+		Value:      value,
+		Dimensions: mergeDimensions(append(dimensions, defaultDimensions(m.service))...),
+		Timestamp:  time.Now(),
 	})
-	if err != nil {
-		fmt.Printf("Warning: couldn't publish timing for %v: %+v\n", name, err)
+}
+
+func (m baseMetrics) Event(ctx context.Context, name MetricName, dimensions ...map[DimensionName]string) {
+	m.emit(ctx, name, CounterKind, UnitCount, 1, dimensions)
+}
+
+func (m baseMetrics) Timing(ctx context.Context, name MetricName, start time.Time, dimensions ...map[DimensionName]string) {
+	m.emit(ctx, name, HistogramKind, UnitMilliseconds, float64(time.Since(start).Milliseconds()), dimensions)
+}
+
+func (m baseMetrics) Gauge(ctx context.Context, name MetricName, value float64, dimensions ...map[DimensionName]string) {
+	m.emit(ctx, name, GaugeKind, UnitNone, value, dimensions)
+}
+
+func (m baseMetrics) Counter(ctx context.Context, name MetricName, value float64, unit Unit, dimensions ...map[DimensionName]string) {
+	m.emit(ctx, name, CounterKind, unit, value, dimensions)
+}
+
+func (m baseMetrics) Histogram(ctx context.Context, name MetricName, value float64, unit Unit, dimensions ...map[DimensionName]string) {
+	m.emit(ctx, name, HistogramKind, unit, value, dimensions)
+}
+
+// BuildMetrics constructs the Metrics backend selected by MetricsOpts, and
+// registers it to be flushed when a Lambda started via StartLambda shuts down
+func BuildMetrics(service Service) (Metrics, error) {
+	namespace := MetricsOpts.Namespace
+	if namespace == "" {
+		namespace = DefaultNamespace
 	}
+
+	var metrics Metrics
+	switch MetricsOpts.Backend {
+	case "", "cloudwatch":
+		metrics = NewCloudWatchMetrics(service, cloudwatch.New(session.Must(session.NewSession(aws.NewConfig()))),
+			WithNamespace(namespace),
+			WithFlushInterval(MetricsOpts.FlushInterval),
+		)
+	case "emf":
+		metrics = NewEMFMetrics(service, os.Stdout, namespace)
+	case "prometheus":
+		p := NewPrometheusMetrics(service, nil)
+		if CommonOpts.Console && MetricsOpts.Port != 0 {
+			if err := p.ListenAndServe(fmt.Sprintf(":%v", MetricsOpts.Port)); err != nil {
+				return nil, err
+			}
+		}
+		metrics = p
+	case "memory":
+		metrics = NewMemoryMetrics(service)
+	case "none":
+		metrics = NopMetrics{}
+	default:
+		return nil, fmt.Errorf("unknown metrics backend %q: expected cloudwatch, emf, prometheus, memory or none", MetricsOpts.Backend)
+	}
+
+	RegisterFlusher(metrics)
+	return metrics, nil
 }
 
-func (m Metrics) Gauge(ctx context.Context, name MetricName, value float64, dimensions ...map[DimensionName]string) {
-	awsDimensions := mapToDimensions(append(dimensions, defaultDimensions(m.service))...)
-	_, err := m.cloudwatch.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
-		Namespace: aws.String("sundae-services"),
-		MetricData: []*cloudwatch.MetricDatum{
-			{
-				MetricName: aws.String(string(name)),
-				Timestamp:  aws.Time(time.Now()),
-				Unit:       aws.String("None"),
-				Value:      aws.Float64(value),
-				Dimensions: awsDimensions,
-			},
-		},
-	})
-	if err != nil {
-		fmt.Printf("Warning: couldn't publish gauge for %v: %+v\n", name, err)
+// Flusher is anything that holds buffered state that must be written out
+// before the process exits
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+var flushers struct {
+	sync.Mutex
+	list []Flusher
+}
+
+// RegisterFlusher adds f to the set flushed by FlushAll
+func RegisterFlusher(f Flusher) {
+	flushers.Lock()
+	defer flushers.Unlock()
+	flushers.list = append(flushers.list, f)
+}
+
+// FlushAll flushes every registered Flusher, returning the first error
+func FlushAll(ctx context.Context) error {
+	flushers.Lock()
+	list := append([]Flusher(nil), flushers.list...)
+	flushers.Unlock()
+
+	var firstErr error
+	for _, f := range list {
+		if err := f.Flush(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
+	return firstErr
+}
+
+// NopMetrics discards everything
+type NopMetrics struct{}
+
+func (NopMetrics) Event(context.Context, MetricName, ...map[DimensionName]string)             {}
+func (NopMetrics) Timing(context.Context, MetricName, time.Time, ...map[DimensionName]string) {}
+func (NopMetrics) Gauge(context.Context, MetricName, float64, ...map[DimensionName]string)    {}
+func (NopMetrics) Counter(context.Context, MetricName, float64, Unit, ...map[DimensionName]string) {
+}
+func (NopMetrics) Histogram(context.Context, MetricName, float64, Unit, ...map[DimensionName]string) {
 }
+func (NopMetrics) Flush(context.Context) error { return nil }
+func (NopMetrics) Close() error                { return nil }
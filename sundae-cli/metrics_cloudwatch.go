@@ -0,0 +1,190 @@
+package sundaecli
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/rs/zerolog"
+)
+
+// MaxDatumsPerRequest is the most MetricDatum entries PutMetricData accepts in
+// a single call
+const MaxDatumsPerRequest = 1000
+
+// DefaultFlushInterval is how often buffered CloudWatch metrics are published
+// when no interval is configured
+const DefaultFlushInterval = 30 * time.Second
+
+// CloudWatchMetrics buffers metrics in memory and publishes them to CloudWatch
+// in batches, either every flush interval, when the buffer reaches the
+// per-request limit, or when Flush / Close is called.
+type CloudWatchMetrics struct {
+	baseMetrics
+	cloudwatch cloudwatchiface.CloudWatchAPI
+	logger     zerolog.Logger
+	namespace  string
+	interval   time.Duration
+
+	mu     sync.Mutex
+	buffer []*cloudwatch.MetricDatum
+
+	// flushMu serializes publishes, so concurrent flushes don't interleave
+	flushMu sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+type CloudWatchOption func(*CloudWatchMetrics)
+
+// WithNamespace overrides the CloudWatch namespace (default DefaultNamespace)
+func WithNamespace(namespace string) CloudWatchOption {
+	return func(m *CloudWatchMetrics) {
+		if namespace != "" {
+			m.namespace = namespace
+		}
+	}
+}
+
+// WithFlushInterval overrides how often the buffer is published (default
+// DefaultFlushInterval). A negative interval disables background flushing.
+func WithFlushInterval(interval time.Duration) CloudWatchOption {
+	return func(m *CloudWatchMetrics) {
+		if interval != 0 {
+			m.interval = interval
+		}
+	}
+}
+
+// WithMetricsLogger overrides the logger used to report publish failures
+func WithMetricsLogger(logger zerolog.Logger) CloudWatchOption {
+	return func(m *CloudWatchMetrics) {
+		m.logger = logger
+	}
+}
+
+func NewCloudWatchMetrics(service Service, api cloudwatchiface.CloudWatchAPI, opts ...CloudWatchOption) *CloudWatchMetrics {
+	m := &CloudWatchMetrics{
+		cloudwatch: api,
+		logger:     Logger(service),
+		namespace:  DefaultNamespace,
+		interval:   DefaultFlushInterval,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	m.baseMetrics = baseMetrics{service: service, record: m.record}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.interval > 0 {
+		go m.loop()
+	} else {
+		close(m.done)
+	}
+	return m
+}
+
+func (m *CloudWatchMetrics) loop() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = m.Flush(context.Background())
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *CloudWatchMetrics) record(ctx context.Context, datum Datum) {
+	m.mu.Lock()
+	m.buffer = append(m.buffer, toCloudWatchDatum(datum))
+	full := len(m.buffer) >= MaxDatumsPerRequest
+	m.mu.Unlock()
+
+	if full {
+		go func() { _ = m.Flush(context.Background()) }()
+	}
+}
+
+// Flush publishes everything buffered so far, in batches of at most
+// MaxDatumsPerRequest. Batches that fail to publish are logged and dropped.
+func (m *CloudWatchMetrics) Flush(ctx context.Context) error {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.mu.Lock()
+	pending := m.buffer
+	m.buffer = nil
+	m.mu.Unlock()
+
+	var firstErr error
+	for len(pending) > 0 {
+		n := len(pending)
+		if n > MaxDatumsPerRequest {
+			n = MaxDatumsPerRequest
+		}
+		batch := pending[:n]
+		pending = pending[n:]
+
+		_, err := m.cloudwatch.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(m.namespace),
+			MetricData: batch,
+		})
+		if err != nil {
+			m.logger.Warn().Err(err).Int("count", len(batch)).Msg("couldn't publish metrics")
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// Close stops the background flush and publishes anything still buffered
+func (m *CloudWatchMetrics) Close() error {
+	m.once.Do(func() {
+		close(m.stop)
+	})
+	<-m.done
+	return m.Flush(context.Background())
+}
+
+func toCloudWatchDatum(datum Datum) *cloudwatch.MetricDatum {
+	return &cloudwatch.MetricDatum{
+		MetricName: aws.String(string(datum.Name)),
+		Timestamp:  aws.Time(datum.Timestamp),
+		Unit:       aws.String(cloudwatchUnit(datum.Unit)),
+		Value:      aws.Float64(datum.Value),
+		Dimensions: mapToDimensions(datum.Dimensions),
+	}
+}
+
+func mapToDimensions(ds map[DimensionName]string) []*cloudwatch.Dimension {
+	names := sortedDimensionNames(ds)
+	dimensions := make([]*cloudwatch.Dimension, 0, len(names))
+	for _, k := range names {
+		dimensions = append(dimensions, &cloudwatch.Dimension{
+			Name:  aws.String(string(k)),
+			Value: aws.String(ds[k]),
+		})
+	}
+	return dimensions
+}
+
+func sortedDimensionNames(ds map[DimensionName]string) []DimensionName {
+	names := make([]DimensionName, 0, len(ds))
+	for k := range ds {
+		names = append(names, k)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
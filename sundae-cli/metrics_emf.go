@@ -0,0 +1,102 @@
+package sundaecli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"syscall"
+)
+
+// EMFMetrics writes each metric as a CloudWatch Embedded Metric Format log
+// line. In Lambda, stdout is shipped to CloudWatch Logs, which extracts the
+// metrics asynchronously, so recording never makes a network call.
+//
+// See https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+type EMFMetrics struct {
+	baseMetrics
+	namespace string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewEMFMetrics(service Service, w io.Writer, namespace string) *EMFMetrics {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	m := &EMFMetrics{
+		namespace: namespace,
+		w:         w,
+	}
+	m.baseMetrics = baseMetrics{service: service, record: m.record}
+	return m
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+// encodeEMF renders datum as a single EMF document
+func encodeEMF(namespace string, datum Datum) ([]byte, error) {
+	names := sortedDimensionNames(datum.Dimensions)
+	dimensionSet := make([]string, 0, len(names))
+	doc := make(map[string]interface{}, len(names)+2)
+	for _, name := range names {
+		dimensionSet = append(dimensionSet, string(name))
+		doc[string(name)] = datum.Dimensions[name]
+	}
+	doc["_aws"] = emfMetadata{
+		Timestamp: datum.Timestamp.UnixMilli(),
+		CloudWatchMetrics: []emfDirective{
+			{
+				Namespace:  namespace,
+				Dimensions: [][]string{dimensionSet},
+				Metrics:    []emfMetric{{Name: string(datum.Name), Unit: cloudwatchUnit(datum.Unit)}},
+			},
+		},
+	}
+	doc[string(datum.Name)] = datum.Value
+	return json.Marshal(doc)
+}
+
+func (m *EMFMetrics) record(_ context.Context, datum Datum) {
+	line, err := encodeEMF(m.namespace, datum)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, _ = m.w.Write(line)
+}
+
+func (m *EMFMetrics) Flush(context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.w.(interface{ Sync() error }); ok {
+		// Pipes and terminals, such as stdout in Lambda, can't be synced
+		if err := s.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOTSUP) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *EMFMetrics) Close() error {
+	return m.Flush(context.Background())
+}
@@ -0,0 +1,83 @@
+package sundaecli
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryMetrics records every metric in memory; intended for tests that want
+// to assert on what a handler emitted
+type MemoryMetrics struct {
+	baseMetrics
+
+	mu    sync.Mutex
+	data  []Datum
+	flush int
+}
+
+func NewMemoryMetrics(service Service) *MemoryMetrics {
+	m := &MemoryMetrics{}
+	m.baseMetrics = baseMetrics{service: service, record: m.record}
+	return m
+}
+
+func (m *MemoryMetrics) record(_ context.Context, datum Datum) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = append(m.data, datum)
+}
+
+// Data returns a copy of everything recorded so far, in order
+func (m *MemoryMetrics) Data() []Datum {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Datum(nil), m.data...)
+}
+
+// Named returns the recorded data points with the given name
+func (m *MemoryMetrics) Named(name MetricName) []Datum {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var matches []Datum
+	for _, d := range m.data {
+		if d.Name == name {
+			matches = append(matches, d)
+		}
+	}
+	return matches
+}
+
+// Sum adds up the values recorded under name
+func (m *MemoryMetrics) Sum(name MetricName) float64 {
+	var total float64
+	for _, d := range m.Named(name) {
+		total += d.Value
+	}
+	return total
+}
+
+// Flushes reports how many times Flush has been called
+func (m *MemoryMetrics) Flushes() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.flush
+}
+
+// Reset discards everything recorded so far
+func (m *MemoryMetrics) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = nil
+	m.flush = 0
+}
+
+func (m *MemoryMetrics) Flush(context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flush++
+	return nil
+}
+
+func (m *MemoryMetrics) Close() error {
+	return nil
+}
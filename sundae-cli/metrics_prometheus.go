@@ -0,0 +1,210 @@
+package sundaecli
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+)
+
+// PrometheusMetrics exposes metrics through a Prometheus registry, intended
+// for long-running console-mode processes that get scraped rather than push.
+//
+// Metric and dimension names are converted to Prometheus conventions:
+// ResponseTime timed in milliseconds becomes response_time_milliseconds, and
+// the Service dimension becomes the service label. Counters get a _total
+// suffix. A metric must always be recorded with the same set of dimensions;
+// mismatched recordings are logged and dropped.
+type PrometheusMetrics struct {
+	baseMetrics
+	logger   zerolog.Logger
+	registry *prometheus.Registry
+
+	mu         sync.Mutex
+	counters   map[string]*prometheus.CounterVec
+	gauges     map[string]*prometheus.GaugeVec
+	histograms map[string]*prometheus.HistogramVec
+	labels     map[string][]string
+
+	server *http.Server
+}
+
+// NewPrometheusMetrics registers metrics in registry, or in a fresh registry
+// if registry is nil
+func NewPrometheusMetrics(service Service, registry *prometheus.Registry) *PrometheusMetrics {
+	if registry == nil {
+		registry = prometheus.NewRegistry()
+	}
+	m := &PrometheusMetrics{
+		logger:     Logger(service),
+		registry:   registry,
+		counters:   map[string]*prometheus.CounterVec{},
+		gauges:     map[string]*prometheus.GaugeVec{},
+		histograms: map[string]*prometheus.HistogramVec{},
+		labels:     map[string][]string{},
+	}
+	m.baseMetrics = baseMetrics{service: service, record: m.record}
+	return m
+}
+
+// Registry returns the underlying registry, so callers can register their own
+// collectors alongside
+func (m *PrometheusMetrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func (m *PrometheusMetrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// ListenAndServe serves Handler on /metrics at addr in the background; the
+// listener is closed by Close
+func (m *PrometheusMetrics) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	m.server = &http.Server{Handler: mux}
+	m.logger.Info().Str("addr", listener.Addr().String()).Msg("serving prometheus metrics")
+	go func() {
+		if err := m.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			m.logger.Warn().Err(err).Msg("prometheus metrics server stopped")
+		}
+	}()
+	return nil
+}
+
+var invalidPrometheusChars = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// prometheusName converts a CamelCase CloudWatch-style name to snake_case
+func prometheusName(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	name := invalidPrometheusChars.ReplaceAllString(b.String(), "_")
+	name = strings.Trim(name, "_")
+	if name != "" && unicode.IsDigit(rune(name[0])) {
+		name = "_" + name
+	}
+	return name
+}
+
+func prometheusMetricName(datum Datum) string {
+	name := prometheusName(string(datum.Name))
+	switch datum.Unit {
+	case "", UnitNone, UnitCount:
+	default:
+		name += "_" + prometheusName(strings.ReplaceAll(string(datum.Unit), "/", "_per_"))
+	}
+	if datum.Kind == CounterKind {
+		name += "_total"
+	}
+	return name
+}
+
+func (m *PrometheusMetrics) record(_ context.Context, datum Datum) {
+	name := prometheusMetricName(datum)
+	names := sortedDimensionNames(datum.Dimensions)
+	labelNames := make([]string, 0, len(names))
+	labelValues := make([]string, 0, len(names))
+	for _, n := range names {
+		labelNames = append(labelNames, prometheusName(string(n)))
+		labelValues = append(labelValues, datum.Dimensions[n])
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.labels[name]; ok && strings.Join(existing, ",") != strings.Join(labelNames, ",") {
+		m.logger.Warn().Str("metric", name).Strs("labels", labelNames).Strs("expected", existing).Msg("dropping metric recorded with inconsistent dimensions")
+		return
+	}
+
+	var err error
+	switch datum.Kind {
+	case CounterKind:
+		vec, ok := m.counters[name]
+		if !ok {
+			vec = prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: string(datum.Name)}, labelNames)
+			if err = m.registry.Register(vec); err == nil {
+				m.counters[name] = vec
+			}
+		}
+		if err == nil && datum.Value >= 0 {
+			vec.WithLabelValues(labelValues...).Add(datum.Value)
+		}
+	case GaugeKind:
+		vec, ok := m.gauges[name]
+		if !ok {
+			vec = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: string(datum.Name)}, labelNames)
+			if err = m.registry.Register(vec); err == nil {
+				m.gauges[name] = vec
+			}
+		}
+		if err == nil {
+			vec.WithLabelValues(labelValues...).Set(datum.Value)
+		}
+	default:
+		vec, ok := m.histograms[name]
+		if !ok {
+			vec = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: string(datum.Name), Buckets: histogramBuckets(datum.Unit)}, labelNames)
+			if err = m.registry.Register(vec); err == nil {
+				m.histograms[name] = vec
+			}
+		}
+		if err == nil {
+			vec.WithLabelValues(labelValues...).Observe(datum.Value)
+		}
+	}
+	if err != nil {
+		m.logger.Warn().Err(err).Str("metric", name).Msg("couldn't register prometheus metric")
+		return
+	}
+	m.labels[name] = labelNames
+}
+
+// histogramBuckets picks buckets that suit the unit; latencies in
+// milliseconds are by far the most common histogram we record
+func histogramBuckets(unit Unit) []float64 {
+	switch unit {
+	case UnitMilliseconds:
+		return []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000}
+	case UnitMicroseconds:
+		return prometheus.ExponentialBuckets(10, 4, 10)
+	case UnitBytes:
+		return prometheus.ExponentialBuckets(64, 4, 10)
+	default:
+		return prometheus.DefBuckets
+	}
+}
+
+// Flush is a no-op; Prometheus pulls
+func (m *PrometheusMetrics) Flush(context.Context) error {
+	return nil
+}
+
+// Close shuts down the server started by ListenAndServe, if any
+func (m *PrometheusMetrics) Close() error {
+	if m.server == nil {
+		return nil
+	}
+	return m.server.Shutdown(context.Background())
+}
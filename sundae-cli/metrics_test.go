@@ -0,0 +1,152 @@
+package sundaecli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/tj/assert"
+)
+
+var testService = Service{Name: "test-service", Version: "abc123"}
+
+type fakeCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+
+	mu    sync.Mutex
+	calls []*cloudwatch.PutMetricDataInput
+}
+
+func (f *fakeCloudWatch) PutMetricDataWithContext(_ aws.Context, input *cloudwatch.PutMetricDataInput, _ ...request.Option) (*cloudwatch.PutMetricDataOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, input)
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func TestCloudWatchMetrics(t *testing.T) {
+	t.Run("buffers until flushed", func(t *testing.T) {
+		api := &fakeCloudWatch{}
+		m := NewCloudWatchMetrics(testService, api, WithFlushInterval(-1), WithNamespace("test"))
+		ctx := context.Background()
+
+		m.Event(ctx, "Hit", map[DimensionName]string{OperationNameDimension: "query"})
+		m.Histogram(ctx, "Size", 42, UnitBytes)
+		m.Counter(ctx, "Weird", 1, Unit("Furlongs"))
+		assert.Len(t, api.calls, 0)
+
+		assert.NoError(t, m.Flush(ctx))
+		assert.Len(t, api.calls, 1)
+		input := api.calls[0]
+		assert.Equal(t, "test", aws.StringValue(input.Namespace))
+		assert.Len(t, input.MetricData, 3)
+		assert.Equal(t, "Count", aws.StringValue(input.MetricData[0].Unit))
+		assert.Len(t, input.MetricData[0].Dimensions, 3)
+		assert.Equal(t, "Bytes", aws.StringValue(input.MetricData[1].Unit))
+		assert.Equal(t, "None", aws.StringValue(input.MetricData[2].Unit))
+
+		assert.NoError(t, m.Flush(ctx))
+		assert.Len(t, api.calls, 1)
+	})
+
+	t.Run("splits batches at the request limit", func(t *testing.T) {
+		api := &fakeCloudWatch{}
+		m := NewCloudWatchMetrics(testService, api, WithFlushInterval(-1))
+
+		m.mu.Lock()
+		for i := 0; i < MaxDatumsPerRequest+5; i++ {
+			m.buffer = append(m.buffer, toCloudWatchDatum(Datum{Name: "Hit", Unit: UnitCount, Value: 1}))
+		}
+		m.mu.Unlock()
+
+		assert.NoError(t, m.Close())
+		assert.Len(t, api.calls, 2)
+		assert.Len(t, api.calls[0].MetricData, MaxDatumsPerRequest)
+		assert.Len(t, api.calls[1].MetricData, 5)
+	})
+
+	t.Run("flushes on interval", func(t *testing.T) {
+		api := &fakeCloudWatch{}
+		m := NewCloudWatchMetrics(testService, api, WithFlushInterval(10*time.Millisecond))
+		defer m.Close()
+
+		m.Gauge(context.Background(), "Lag", 3)
+		assert.Eventually(t, func() bool {
+			api.mu.Lock()
+			defer api.mu.Unlock()
+			return len(api.calls) == 1
+		}, time.Second, 5*time.Millisecond)
+	})
+}
+
+func TestEMFMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewEMFMetrics(testService, &buf, "")
+	m.Timing(context.Background(), ResponseTimeMetric, time.Now(), map[DimensionName]string{OperationNameDimension: "pools"})
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, "test-service", doc["Service"])
+	assert.Equal(t, "pools", doc["OperationName"])
+	assert.Contains(t, doc, "ResponseTime")
+
+	directive := doc["_aws"].(map[string]interface{})["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, DefaultNamespace, directive["Namespace"])
+	assert.Equal(t, []interface{}{[]interface{}{"OperationName", "Service", "Version"}}, directive["Dimensions"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"Name": "ResponseTime", "Unit": "Milliseconds"}}, directive["Metrics"])
+
+	// stdout is usually a pipe, which can't be synced
+	r, w, err := os.Pipe()
+	assert.NoError(t, err)
+	defer r.Close()
+	defer w.Close()
+	assert.NoError(t, NewEMFMetrics(testService, w, "").Flush(context.Background()))
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	m := NewPrometheusMetrics(testService, nil)
+	ctx := context.Background()
+
+	m.Event(ctx, "RecordsProcessed")
+	m.Event(ctx, "RecordsProcessed")
+	m.Timing(ctx, ResponseTimeMetric, time.Now())
+	m.Gauge(ctx, "ShardLag", 7)
+	// Inconsistent dimensions are dropped rather than panicking
+	m.Gauge(ctx, "ShardLag", 8, map[DimensionName]string{"Shard": "1"})
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	assert.Contains(t, body, `records_processed_total{service="test-service",version="abc123"} 2`)
+	assert.Contains(t, body, `response_time_milliseconds_count{service="test-service",version="abc123"} 1`)
+	assert.Contains(t, body, `shard_lag{service="test-service",version="abc123"} 7`)
+	assert.False(t, strings.Contains(body, `shard="1"`))
+}
+
+func TestMemoryMetrics(t *testing.T) {
+	m := NewMemoryMetrics(testService)
+	ctx := context.Background()
+
+	m.Counter(ctx, "Bytes", 10, UnitBytes)
+	m.Counter(ctx, "Bytes", 5, UnitBytes)
+	m.Event(ctx, "Other")
+
+	assert.Equal(t, 15.0, m.Sum("Bytes"))
+	assert.Len(t, m.Named("Other"), 1)
+	assert.Equal(t, "test-service", m.Data()[0].Dimensions[ServiceNameDimension])
+
+	RegisterFlusher(m)
+	assert.NoError(t, FlushAll(ctx))
+	assert.Equal(t, 1, m.Flushes())
+}
@@ -0,0 +1,197 @@
+package sundaecli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/urfave/cli/v2"
+)
+
+// Runtime is the environment a handler is being run in
+type Runtime string
+
+const (
+	// LambdaRuntime means the process was started by the Lambda service
+	LambdaRuntime Runtime = "lambda"
+	// ReplayRuntime means the process is running locally and feeding fixture
+	// events (see EventsFlag) to the same handler Lambda would invoke
+	ReplayRuntime Runtime = "replay"
+	// ConsoleRuntime means the process is running locally as a long-lived
+	// service (webserver, stream consumer, etc)
+	ConsoleRuntime Runtime = "console"
+)
+
+// lambdaRuntimeAPIEnv is set by the Lambda service in every execution
+// environment, and is what the aws-lambda-go runtime client connects to
+const lambdaRuntimeAPIEnv = "AWS_LAMBDA_RUNTIME_API"
+
+var RuntimeOpts struct {
+	Events cli.StringSlice
+}
+
+var EventsFlag = StringSliceFlag("events", "JSON fixture files (or directories of them, or - for stdin) to feed to the lambda handler locally", nil, &RuntimeOpts.Events)
+
+// InLambda reports whether the process was started by the Lambda service
+func InLambda() bool {
+	return os.Getenv(lambdaRuntimeAPIEnv) != ""
+}
+
+// DetectRuntime works out how the current process should run. --console
+// always wins; otherwise the Lambda runtime API is used when present, and
+// anything else is treated as a local run.
+func DetectRuntime() Runtime {
+	switch {
+	case !CommonOpts.Console && InLambda():
+		return LambdaRuntime
+	case len(RuntimeOpts.Events.Value()) > 0:
+		return ReplayRuntime
+	default:
+		return ConsoleRuntime
+	}
+}
+
+// Run starts handler according to DetectRuntime. In Lambda, handler is started
+// with StartLambda. Locally with --events, every fixture event is decoded and
+// passed to handler in turn, exactly as Lambda would. Otherwise console is
+// called to run the service in its long-lived local form; a nil console
+// means the service has no local mode.
+//
+// Locally, the context passed along is cancelled on SIGINT/SIGTERM and the
+// DefaultLifecycle is shut down once the run ends; see Lifecycle.
+//
+// handler may be any function signature accepted by lambda.Start.
+func Run(ctx context.Context, handler interface{}, console func(ctx context.Context) error) error {
+	switch DetectRuntime() {
+	case LambdaRuntime:
+		StartLambda(handler)
+		return nil
+	case ReplayRuntime:
+		return DefaultLifecycle().Run(ctx, func(ctx context.Context) error {
+			return ReplayEvents(ctx, handler, RuntimeOpts.Events.Value()...)
+		})
+	default:
+		if console == nil {
+			return fmt.Errorf("no console mode available; run in lambda, or supply --%v", EventsFlag.Name)
+		}
+		return DefaultLifecycle().Run(ctx, console)
+	}
+}
+
+// ReplayEvents feeds every event in sources to handler, in order, stopping at
+// the first error. Each source is a JSON file, a directory (whose *.json files
+// are read in lexical order), or "-" for stdin. A source may contain a single
+// event, a JSON array of events, or a stream of concatenated / newline
+// delimited events. Any non-empty response is written to stdout as a line of
+// JSON.
+func ReplayEvents(ctx context.Context, handler interface{}, sources ...string) error {
+	invoker := lambda.NewHandler(handler)
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	files, err := expandEventSources(sources)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, file := range files {
+		err := readEvents(file, func(event json.RawMessage) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			count++
+			response, err := invoker.Invoke(ctx, event)
+			if err != nil {
+				return fmt.Errorf("event %v from %v: %w", count, file, err)
+			}
+			if len(response) > 0 && string(response) != "null" {
+				out.Write(response)
+				out.WriteByte('\n')
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func expandEventSources(sources []string) ([]string, error) {
+	var files []string
+	for _, source := range sources {
+		if source == "-" {
+			files = append(files, source)
+			continue
+		}
+		info, err := os.Stat(source)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read events from %v: %w", source, err)
+		}
+		if !info.IsDir() {
+			files = append(files, source)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(source, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+func readEvents(file string, callback func(event json.RawMessage) error) error {
+	var r io.Reader
+	if file == "-" {
+		r = os.Stdin
+	} else {
+		f, err := os.Open(file)
+		if err != nil {
+			return fmt.Errorf("unable to read events from %v: %w", file, err)
+		}
+		defer f.Close()
+		r = f
+	}
+	return decodeEvents(r, callback)
+}
+
+// decodeEvents calls callback for each top level JSON value in r, flattening
+// top level arrays into their elements
+func decodeEvents(r io.Reader, callback func(event json.RawMessage) error) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("unable to decode event: %w", err)
+		}
+		if strings.HasPrefix(strings.TrimSpace(string(raw)), "[") {
+			var events []json.RawMessage
+			if err := json.Unmarshal(raw, &events); err != nil {
+				return fmt.Errorf("unable to decode event list: %w", err)
+			}
+			for _, event := range events {
+				if err := callback(event); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := callback(raw); err != nil {
+			return err
+		}
+	}
+}
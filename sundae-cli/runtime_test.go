@@ -0,0 +1,45 @@
+package sundaecli
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func TestDecodeEvents(t *testing.T) {
+	input := `{"id":1}
+{"id":2} [{"id":3},{"id":4}]
+`
+	var got []string
+	err := decodeEvents(strings.NewReader(input), func(event json.RawMessage) error {
+		got = append(got, string(event))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"id":1}`, `{"id":2}`, `{"id":3}`, `{"id":4}`}, got)
+
+	err = decodeEvents(strings.NewReader(`{"id":`), func(json.RawMessage) error { return nil })
+	assert.Error(t, err)
+}
+
+func TestReplayEvents(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"id":2}`), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.json"), []byte(`[{"id":0},{"id":1}]`), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "ignored.txt"), []byte(`{"id":9}`), 0o644))
+
+	var seen []int
+	handler := func(_ context.Context, event struct{ ID int }) error {
+		seen = append(seen, event.ID)
+		return nil
+	}
+	assert.NoError(t, ReplayEvents(context.Background(), handler, dir))
+	assert.Equal(t, []int{0, 1, 2}, seen)
+
+	assert.Error(t, ReplayEvents(context.Background(), handler, filepath.Join(dir, "missing.json")))
+}
@@ -0,0 +1,76 @@
+package sundaecli
+
+import (
+	"sort"
+	"sync"
+)
+
+// Table is a DynamoDB table a package reads or writes, identified by the
+// TableName(env) helper that derives its name
+type Table struct {
+	// Component is the package the table belongs to, e.g. cursordao
+	Component string
+	TableName func(env string) string
+	// Model is the record type whose ddb struct tags define the table's keys
+	// and indexes, or nil if the table isn't provisioned from them
+	Model interface{}
+	// TTL is the attribute that holds each item's expiry, if any
+	TTL string
+	// Optional tables belong to features a binary may not use, and are only
+	// provisioned when asked for by component
+	Optional bool
+}
+
+type TableOption func(*Table)
+
+// WithTableModel registers the record type the table stores, so sundaeddb can
+// derive its key schema and secondary indexes from the ddb tags
+func WithTableModel(model interface{}) TableOption {
+	return func(t *Table) {
+		t.Model = model
+	}
+}
+
+// WithTableTTL registers the attribute the table uses for time to live
+func WithTableTTL(attribute string) TableOption {
+	return func(t *Table) {
+		t.TTL = attribute
+	}
+}
+
+// WithTableOptional marks a table that is only used when its feature is
+// configured, such as a lock or checkpoint store, so it isn't provisioned for
+// every binary that links the package
+func WithTableOptional() TableOption {
+	return func(t *Table) {
+		t.Optional = true
+	}
+}
+
+var tables struct {
+	sync.Mutex
+	list []Table
+}
+
+// RegisterTable records a DAO's TableName helper, so the built-in tables
+// command can list every table a binary touches. DAO packages call it from
+// init.
+func RegisterTable(component string, tableName func(env string) string, opts ...TableOption) {
+	t := Table{Component: component, TableName: tableName}
+	for _, opt := range opts {
+		opt(&t)
+	}
+
+	tables.Lock()
+	defer tables.Unlock()
+	tables.list = append(tables.list, t)
+}
+
+// Tables returns every registered table, sorted by component
+func Tables() []Table {
+	tables.Lock()
+	defer tables.Unlock()
+	list := append([]Table(nil), tables.list...)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Component < list[j].Component })
+	return list
+}
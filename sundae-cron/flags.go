@@ -0,0 +1,33 @@
+package sundaecron
+
+import (
+	"time"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/urfave/cli/v2"
+)
+
+var CronOpts struct {
+	Schedule string
+	Lock     string
+	History  string
+	CatchUp  time.Duration
+	Timeout  time.Duration
+	Jitter   time.Duration
+}
+
+var ScheduleFlag = sundaecli.StringFlag("cron-schedule", "when the task runs, in console mode and to catch up, in place of the schedule in code, e.g. rate(5 minutes) or cron(0 12 * * ? *)", &CronOpts.Schedule)
+var LockFlag = sundaecli.StringFlag("cron-lock", "how to keep runs from overlapping, in place of the lock in code: ddb, memory or none", &CronOpts.Lock)
+var HistoryFlag = sundaecli.StringFlag("cron-history", "where to record runs, in place of the history in code: ddb, memory or none", &CronOpts.History)
+var CatchUpFlag = sundaecli.DurationFlag("cron-catch-up", "how far back to run fires missed since the last success, in place of the window in code", &CronOpts.CatchUp)
+var TimeoutFlag = sundaecli.DurationFlag("cron-timeout", "how long a run may take, in place of the timeout in code", &CronOpts.Timeout)
+var JitterFlag = sundaecli.DurationFlag("cron-jitter", "the longest random delay before each run, in place of the jitter in code", &CronOpts.Jitter)
+
+var CronFlags = []cli.Flag{
+	ScheduleFlag,
+	LockFlag,
+	HistoryFlag,
+	CatchUpFlag,
+	TimeoutFlag,
+	JitterFlag,
+}
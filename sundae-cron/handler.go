@@ -3,45 +3,461 @@ package sundaecron
 
 import (
 	"context"
+	crand "crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"math/rand"
+	"time"
 
 	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
-	"github.com/aws/aws-lambda-go/lambda"
+	sundaetrace "github.com/SundaeSwap-finance/sundae-go-utils/sundae-trace"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/rs/zerolog"
 )
 
 type RunCallback func(ctx context.Context) error
 
+// RunFunc is a callback that is told which run it is
+type RunFunc func(ctx context.Context, run Run) error
+
+// Run identifies one run of a job
+type Run struct {
+	// ID is the ID of the EventBridge event that started the run, or a random
+	// one in console mode
+	ID string
+	// ScheduledTime is the fire the run is for; retries of a fire share it
+	ScheduledTime time.Time
+	// CatchUp is set for runs of fires that were missed
+	CatchUp bool
+}
+
+type runKey struct{}
+
+// RunFromContext returns the run whose context ctx is, for a RunCallback
+func RunFromContext(ctx context.Context) (Run, bool) {
+	run, ok := ctx.Value(runKey{}).(Run)
+	return run, ok
+}
+
+// The metrics of each run, with the JobDimension
+const (
+	RunSucceededMetric sundaecli.MetricName = "CronRunSucceeded"
+	RunFailedMetric    sundaecli.MetricName = "CronRunFailed"
+	RunSkippedMetric   sundaecli.MetricName = "CronRunSkipped"
+	RunDurationMetric  sundaecli.MetricName = "CronRunDuration"
+)
+
+const JobDimension sundaecli.DimensionName = "Job"
+
 type Handler struct {
 	service sundaecli.Service
 	logger  zerolog.Logger
+	name    string
+	metrics sundaecli.Metrics
+
+	fn RunFunc
+
+	schedule Schedule
+	lock     Lock
+	history  History
+	catchUp  time.Duration
+	timeout  time.Duration
+	jitter   time.Duration
+}
+
+type HandlerOption func(*Handler)
+
+// WithName names the job, for its lock, history, logs and metrics. It's the
+// service name by default.
+func WithName(name string) HandlerOption {
+	return func(h *Handler) {
+		h.name = name
+	}
+}
+
+// WithMetrics records the outcome and duration of each run
+func WithMetrics(metrics sundaecli.Metrics) HandlerOption {
+	return func(h *Handler) {
+		h.metrics = metrics
+	}
+}
+
+// WithSchedule sets when the task runs in console mode, and which fires a
+// catch up runs. In Lambda, the EventBridge rule decides when it runs; declare
+// it with the same expression.
+func WithSchedule(schedule Schedule) HandlerOption {
+	return func(h *Handler) {
+		h.schedule = schedule
+	}
+}
+
+// WithLock keeps runs of the task from overlapping, and a fire from being run
+// twice once it has succeeded; see Lock
+func WithLock(lock Lock) HandlerOption {
+	return func(h *Handler) {
+		h.lock = lock
+	}
+}
+
+// WithHistory records every run in history. A fire whose run succeeded isn't
+// run again.
+func WithHistory(history History) HandlerOption {
+	return func(h *Handler) {
+		h.history = history
+	}
+}
+
+// WithCatchUp runs the fires missed since the last one that succeeded, oldest
+// first, before the fire at hand, going back at most window. It needs the
+// schedule, also in Lambda, and the history; until the job has once
+// succeeded, there is nothing to catch up.
+func WithCatchUp(window time.Duration) HandlerOption {
+	return func(h *Handler) {
+		h.catchUp = window
+	}
+}
+
+// WithTimeout cancels the context of a run after d
+func WithTimeout(d time.Duration) HandlerOption {
+	return func(h *Handler) {
+		h.timeout = d
+	}
+}
 
-	runOnce RunCallback
+// WithJitter delays each run by a random duration up to d, to spread the
+// load of tasks scheduled at the same time
+func WithJitter(d time.Duration) HandlerOption {
+	return func(h *Handler) {
+		h.jitter = d
+	}
 }
 
 func NewHandler(
 	service sundaecli.Service,
 	runOnce RunCallback,
+	opts ...HandlerOption,
 ) *Handler {
-	return &Handler{
+	return NewRunHandler(service, func(ctx context.Context, _ Run) error { return runOnce(ctx) }, opts...)
+}
+
+// NewRunHandler is NewHandler for a callback that is told which run it is
+func NewRunHandler(
+	service sundaecli.Service,
+	fn RunFunc,
+	opts ...HandlerOption,
+) *Handler {
+	h := &Handler{
 		service: service,
-		logger:  sundaecli.Logger(service),
-		runOnce: runOnce,
+		name:    service.Name,
+		metrics: sundaecli.NopMetrics{},
+		fn:      fn,
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	h.logger = sundaecli.Logger(service).With().Str("job", h.name).Logger()
+	return h
 }
 
-func (h *Handler) RunOnce(ctx context.Context, _ json.RawMessage) error {
-	h.logger.Info().Msg("running scheduled task")
-	return h.runOnce(ctx)
+// scheduledEvent is the part of an EventBridge scheduled event we use
+type scheduledEvent struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
 }
 
-func (h *Handler) Start() error {
-	switch {
-	case sundaecli.CommonOpts.Console:
-		return h.runOnce(context.Background())
+// RunOnce runs the task for a scheduled event. The event's time is the fire
+// the run is for, so retries and duplicate deliveries of one event share it.
+// With a schedule, the time is taken back to the schedule's last fire at or
+// before it: an EventBridge rate rule counts from when the rule was created,
+// not from the epoch as Rate does, and its events carry seconds a cron
+// schedule doesn't, so catching up from an event's own time would see fires
+// between every pair of events.
+func (h *Handler) RunOnce(ctx context.Context, event json.RawMessage) error {
+	run := Run{ScheduledTime: time.Now()}
+	var e scheduledEvent
+	if err := json.Unmarshal(event, &e); err == nil {
+		if !e.Time.IsZero() {
+			run.ScheduledTime = e.Time
+			if h.schedule != nil {
+				if fire, ok := lastFire(h.schedule, e.Time); ok {
+					run.ScheduledTime = fire
+				}
+			}
+		}
+		run.ID = e.ID
+	}
+	if run.ID == "" {
+		run.ID = newRunID()
+	}
+	if !sleep(ctx, h.jitterDelay()) {
+		return ctx.Err()
+	}
+	return h.fire(ctx, run)
+}
+
+func newRunID() string {
+	b := make([]byte, 16)
+	_, _ = crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (h *Handler) jitterDelay() time.Duration {
+	if h.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(h.jitter)))
+}
+
+// sleep waits for d, returning false if ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
 
+func (h *Handler) lockTTL() time.Duration {
+	if h.timeout > 0 {
+		return h.timeout
+	}
+	return DefaultLockTTL
+}
+
+// fire runs the task for run, after catching up on the fires missed before it
+func (h *Handler) fire(ctx context.Context, run Run) error {
+	if err := h.catchUpTo(ctx, run.ScheduledTime); err != nil {
+		return err
+	}
+	return h.run(ctx, run)
+}
+
+// catchUpTo runs the fires missed before fire, if the handler catches up
+func (h *Handler) catchUpTo(ctx context.Context, fire time.Time) error {
+	if h.catchUp <= 0 || h.schedule == nil || h.history == nil {
+		return nil
+	}
+	missed, err := h.missed(ctx, fire)
+	if err != nil {
+		return err
+	}
+	if len(missed) > 0 {
+		h.logger.Info().Int("fires", len(missed)).Time("from", missed[0]).Msg("catching up on missed fires")
+	}
+	for _, t := range missed {
+		// A fire that fails stops the catch up, so none is skipped
+		if err := h.run(ctx, Run{ID: newRunID(), ScheduledTime: t, CatchUp: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// missed returns the fires of the schedule since the last that succeeded and
+// before fire, within the catch up window
+func (h *Handler) missed(ctx context.Context, fire time.Time) ([]time.Time, error) {
+	last, ok, err := h.history.LastSucceeded(ctx, h.name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+	from := last.ScheduledTime
+	if earliest := fire.Add(-h.catchUp); from.Before(earliest) {
+		from = earliest.Add(-time.Nanosecond)
+	}
+	var missed []time.Time
+	for t := h.schedule.Next(from); !t.IsZero() && t.Before(fire); t = h.schedule.Next(t) {
+		missed = append(missed, t)
+	}
+	return missed, nil
+}
+
+// run runs the task for run under the lock, skipping it if the lock is held
+// or the history shows the fire already succeeded
+func (h *Handler) run(ctx context.Context, run Run) error {
+	logger := h.logger.With().Str("run", run.ID).Time("fire", run.ScheduledTime).Bool("catchUp", run.CatchUp).Logger()
+	if h.lock != nil {
+		ok, err := h.lock.Acquire(ctx, h.name, run.ScheduledTime, h.lockTTL())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			logger.Info().Msg("skipping scheduled task: another run holds the lock, or this one already ran")
+			h.metrics.Event(ctx, RunSkippedMetric, h.dimensions())
+			return nil
+		}
+	}
+
+	err := h.record(ctx, logger, run)
+	if h.lock != nil {
+		// Release even if the run's context is done, so a retry needn't wait
+		// for the lease to run out
+		if rerr := h.lock.Release(context.WithoutCancel(ctx), h.name, run.ScheduledTime, err == nil); rerr != nil {
+			logger.Warn().Err(rerr).Msg("unable to release lock")
+		}
+	}
+	return err
+}
+
+func (h *Handler) dimensions() map[sundaecli.DimensionName]string {
+	return map[sundaecli.DimensionName]string{JobDimension: h.name}
+}
+
+// record calls the task, keeping the history of the run
+func (h *Handler) record(ctx context.Context, logger zerolog.Logger, run Run) error {
+	record := RunRecord{Job: h.name, ScheduledTime: run.ScheduledTime}
+	if h.history != nil {
+		previous, ok, err := h.history.LoadRun(ctx, h.name, run.ScheduledTime)
+		if err != nil {
+			return err
+		}
+		if ok && previous.Status == RunStatusSucceeded {
+			logger.Info().Str("previousRun", previous.RunID).Msg("skipping scheduled task: this fire already succeeded")
+			h.metrics.Event(ctx, RunSkippedMetric, h.dimensions())
+			return nil
+		}
+		record.Attempts = previous.Attempts
+	}
+	record.RunID = run.ID
+	record.Status = RunStatusRunning
+	record.Attempts++
+	record.Started = time.Now()
+	record.Version = h.service.Version
+	if h.history != nil {
+		if err := h.history.SaveRun(ctx, record); err != nil {
+			return err
+		}
+	}
+
+	runCtx, cancel := context.WithValue(ctx, runKey{}, run), context.CancelFunc(func() {})
+	if h.timeout > 0 {
+		runCtx, cancel = context.WithTimeout(runCtx, h.timeout)
+	}
+	logger.Info().Int("attempt", record.Attempts).Msg("running scheduled task")
+	err := h.fn(runCtx, run)
+	cancel()
+
+	record.Ended = time.Now()
+	record.Status = RunStatusSucceeded
+	h.metrics.Timing(ctx, RunDurationMetric, record.Started, h.dimensions())
+	if err != nil {
+		err = fmt.Errorf("scheduled task failed: %w", err)
+		record.Status = RunStatusFailed
+		record.Error = err.Error()
+		h.metrics.Event(ctx, RunFailedMetric, h.dimensions())
+	} else {
+		h.metrics.Event(ctx, RunSucceededMetric, h.dimensions())
+	}
+	if h.history != nil {
+		if herr := h.history.SaveRun(context.WithoutCancel(ctx), record); herr != nil {
+			if err != nil {
+				return err
+			}
+			return herr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	logger.Info().Dur("elapsed", record.Ended.Sub(record.Started)).Msg("scheduled task finished")
+	return nil
+}
+
+// configure applies the command line flags over the options given in code
+func (h *Handler) configure() error {
+	if CronOpts.Schedule != "" {
+		schedule, err := ParseSchedule(CronOpts.Schedule)
+		if err != nil {
+			return err
+		}
+		h.schedule = schedule
+	}
+	switch CronOpts.Lock {
+	case "":
+	case "none":
+		h.lock = nil
+	case "memory":
+		h.lock = NewMemoryLock()
+	case "ddb":
+		api := dynamodb.New(sundaetrace.Session(aws.NewConfig()))
+		h.lock = NewDDBLock(api, LockTableName(sundaecli.CommonOpts.Env))
 	default:
-		lambda.Start(h.RunOnce)
+		return fmt.Errorf("unknown cron lock %q: expected ddb, memory or none", CronOpts.Lock)
+	}
+	switch CronOpts.History {
+	case "":
+	case "none":
+		h.history = nil
+	case "memory":
+		h.history = NewMemoryHistory()
+	case "ddb":
+		api := dynamodb.New(sundaetrace.Session(aws.NewConfig()))
+		h.history = NewDDBHistory(api, HistoryTableName(sundaecli.CommonOpts.Env))
+	default:
+		return fmt.Errorf("unknown cron history %q: expected ddb, memory or none", CronOpts.History)
+	}
+	if CronOpts.CatchUp > 0 {
+		h.catchUp = CronOpts.CatchUp
+	}
+	if CronOpts.Timeout > 0 {
+		h.timeout = CronOpts.Timeout
+	}
+	if CronOpts.Jitter > 0 {
+		h.jitter = CronOpts.Jitter
 	}
 	return nil
 }
+
+// Schedule runs the task on its schedule until ctx is done. A run still
+// going at the next fire makes that fire be skipped, unless the handler
+// catches up; failed runs are logged, and don't stop the schedule. Without a
+// schedule, the task runs once.
+func (h *Handler) Schedule(ctx context.Context) error {
+	if h.schedule == nil {
+		return h.RunOnce(ctx, nil)
+	}
+	h.logger.Info().Str("schedule", h.schedule.String()).Msg("scheduling task")
+
+	// Catch up on the fires missed while the process wasn't running
+	drain, cancel := sundaecli.Drain(ctx)
+	err := h.catchUpTo(drain, time.Now())
+	cancel()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("unable to catch up on missed fires")
+	}
+
+	for {
+		fire := h.schedule.Next(time.Now())
+		if fire.IsZero() {
+			h.logger.Info().Str("schedule", h.schedule.String()).Msg("schedule will not fire again")
+			return nil
+		}
+		if !sleep(ctx, time.Until(fire)+h.jitterDelay()) {
+			return nil
+		}
+		// A run in flight may finish during the drain timeout
+		drain, cancel := sundaecli.Drain(ctx)
+		err := h.fire(drain, Run{ID: newRunID(), ScheduledTime: fire})
+		cancel()
+		if err != nil {
+			h.logger.Error().Err(err).Time("fire", fire).Msg("scheduled run failed")
+		}
+	}
+}
+
+func (h *Handler) Start() error {
+	if err := h.configure(); err != nil {
+		return err
+	}
+	return sundaecli.Run(context.Background(), h.RunOnce, h.Schedule)
+}
@@ -0,0 +1,180 @@
+package sundaecron
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ddb/memddb"
+	"github.com/savaki/ddb"
+	"github.com/tj/assert"
+)
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	api := memddb.New()
+	assert.Nil(t, ddb.New(api).MustTable("locks", lockRecord{}).CreateTableIfNotExists(ctx))
+
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	a, b := NewDDBLock(api, "locks"), NewDDBLock(api, "locks")
+	a.now, b.now = clock, clock
+	memory := NewMemoryLock()
+	memory.now = clock
+
+	for name, locks := range map[string][2]Lock{"ddb": {a, b}, "memory": {memory, memory}} {
+		t.Run(name, func(t *testing.T) {
+			a, b := locks[0], locks[1]
+			fire := now.Add(-time.Second)
+			ok, err := a.Acquire(ctx, "job", fire, time.Minute)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+
+			ok, err = b.Acquire(ctx, "job", fire.Add(time.Second), time.Minute)
+			assert.Nil(t, err)
+			assert.False(t, ok, "overlapping runs are skipped")
+
+			assert.Nil(t, a.Release(ctx, "job", fire, false))
+			ok, err = b.Acquire(ctx, "job", fire, time.Minute)
+			assert.Nil(t, err)
+			assert.True(t, ok, "a failed fire may be retried")
+
+			assert.Nil(t, b.Release(ctx, "job", fire, true))
+			ok, err = a.Acquire(ctx, "job", fire, time.Minute)
+			assert.Nil(t, err)
+			assert.False(t, ok, "a fire that succeeded isn't run again")
+
+			next := fire.Add(time.Minute)
+			ok, err = a.Acquire(ctx, "job", next, time.Second)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			now = now.Add(2 * time.Second)
+			ok, err = b.Acquire(ctx, "job", next, time.Minute)
+			assert.Nil(t, err)
+			assert.True(t, ok, "an expired lease may be taken")
+			assert.Nil(t, b.Release(ctx, "job", next, true))
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	service := sundaecli.NewService("test")
+
+	t.Run("event", func(t *testing.T) {
+		var runs int
+		h := NewHandler(service, func(ctx context.Context) error {
+			runs++
+			if runs == 1 {
+				return fmt.Errorf("boom")
+			}
+			_, ok := ctx.Deadline()
+			assert.True(t, ok)
+			return nil
+		}, WithLock(NewMemoryLock()), WithTimeout(time.Minute), WithJitter(time.Millisecond))
+
+		event := []byte(`{"detail-type":"Scheduled Event","time":"2026-10-14T10:00:00Z"}`)
+		assert.EqualError(t, h.RunOnce(ctx, event), "scheduled task failed: boom")
+		assert.Nil(t, h.RunOnce(ctx, event))
+		assert.Nil(t, h.RunOnce(ctx, event))
+		assert.Equal(t, 2, runs, "the retry runs, the duplicate doesn't")
+	})
+
+	t.Run("history", func(t *testing.T) {
+		at := func(hour int) time.Time { return time.Date(2026, 10, 14, hour, 0, 0, 0, time.UTC) }
+		event := func(hour int) []byte {
+			return []byte(fmt.Sprintf(`{"id":"event-%v","time":%q}`, hour, at(hour).Format(time.RFC3339)))
+		}
+
+		history := NewMemoryHistory()
+		var fires []int
+		fail := true
+		h := NewRunHandler(service, func(ctx context.Context, run Run) error {
+			got, ok := RunFromContext(ctx)
+			assert.True(t, ok)
+			assert.Equal(t, run, got)
+			assert.NotEmpty(t, run.ID)
+			fires = append(fires, run.ScheduledTime.Hour())
+			if run.ScheduledTime.Hour() == 10 && fail {
+				fail = false
+				return fmt.Errorf("boom")
+			}
+			return nil
+		}, WithSchedule(Rate(time.Hour)), WithHistory(history), WithCatchUp(3*time.Hour))
+
+		assert.Nil(t, h.RunOnce(ctx, event(7)))
+		assert.Equal(t, []int{7}, fires, "nothing to catch up before the first success")
+
+		// After an outage, the missed fires within the window run first, and
+		// a failure stops the catch up
+		assert.EqualError(t, h.RunOnce(ctx, event(12)), "scheduled task failed: boom")
+		assert.Equal(t, []int{7, 9, 10}, fires)
+		assert.Nil(t, h.RunOnce(ctx, event(12)))
+		assert.Equal(t, []int{7, 9, 10, 10, 11, 12}, fires)
+
+		// Duplicates of a fire that succeeded are skipped
+		assert.Nil(t, h.RunOnce(ctx, event(12)))
+		assert.Equal(t, []int{7, 9, 10, 10, 11, 12}, fires)
+
+		runs := history.Runs(service.Name)
+		assert.Len(t, runs, 5)
+		assert.Equal(t, RunRecord{Job: service.Name, ScheduledTime: at(10), Status: RunStatusSucceeded, Attempts: 2, Version: service.Version}, RunRecord{
+			Job: runs[2].Job, ScheduledTime: runs[2].ScheduledTime, Status: runs[2].Status, Attempts: runs[2].Attempts, Version: runs[2].Version,
+		})
+		assert.Equal(t, "event-12", runs[4].RunID)
+		assert.False(t, runs[4].Ended.Before(runs[4].Started))
+	})
+
+	t.Run("unaligned", func(t *testing.T) {
+		// EventBridge counts rate(1 hour) from when the rule was created
+		var fires []time.Time
+		h := NewRunHandler(service, func(ctx context.Context, run Run) error {
+			fires = append(fires, run.ScheduledTime)
+			return nil
+		}, WithSchedule(MustParseSchedule("rate(1 hour)")), WithHistory(NewMemoryHistory()), WithCatchUp(24*time.Hour))
+		for _, at := range []string{"10:23:00", "11:23:00", "12:23:41"} {
+			assert.Nil(t, h.RunOnce(ctx, []byte(`{"time":"2026-10-14T`+at+`Z"}`)))
+		}
+		at := func(hour int) time.Time { return time.Date(2026, 10, 14, hour, 0, 0, 0, time.UTC) }
+		assert.Equal(t, []time.Time{at(10), at(11), at(12)}, fires, "no fire is caught up between events")
+
+		// A missed event is still caught up
+		assert.Nil(t, h.RunOnce(ctx, []byte(`{"time":"2026-10-14T14:23:00Z"}`)))
+		assert.Equal(t, []time.Time{at(10), at(11), at(12), at(13), at(14)}, fires)
+	})
+
+	t.Run("schedule", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var mu sync.Mutex
+		var fires []time.Time
+		h := NewHandler(service, func(context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			fires = append(fires, time.Now())
+			if len(fires) == 3 {
+				cancel()
+			}
+			return fmt.Errorf("failures don't stop the schedule")
+		}, WithSchedule(Rate(20*time.Millisecond)))
+		assert.Nil(t, h.Schedule(ctx))
+		assert.Len(t, fires, 3)
+	})
+
+	t.Run("flags", func(t *testing.T) {
+		defer func() { CronOpts.Schedule, CronOpts.Lock, CronOpts.Timeout = "", "", 0 }()
+		h := NewHandler(service, nil, WithLock(NewMemoryLock()))
+		CronOpts.Schedule, CronOpts.Lock, CronOpts.Timeout = "rate(2 minutes)", "none", time.Second
+		assert.Nil(t, h.configure())
+		assert.Equal(t, "rate(2 minutes)", h.schedule.String())
+		assert.Nil(t, h.lock)
+		assert.Equal(t, time.Second, h.timeout)
+
+		CronOpts.Lock = "redis"
+		assert.NotNil(t, h.configure())
+	})
+}
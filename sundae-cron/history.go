@@ -0,0 +1,205 @@
+package sundaecron
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/savaki/ddb"
+)
+
+// The status of a run
+const (
+	RunStatusRunning   = "running"
+	RunStatusSucceeded = "succeeded"
+	RunStatusFailed    = "failed"
+)
+
+// historyRetention is how long the record of a run is kept
+const historyRetention = 90 * 24 * time.Hour
+
+func init() {
+	sundaecli.RegisterTable("cronhistory", HistoryTableName, sundaecli.WithTableModel(runRecord{}), sundaecli.WithTableTTL("ttl"), sundaecli.WithTableOptional())
+}
+
+func HistoryTableName(env string) string {
+	return env + "-sundae-cron--runs"
+}
+
+// RunRecord is the history of the runs for one fire of a job; retries of the
+// fire update the same record
+type RunRecord struct {
+	Job           string
+	ScheduledTime time.Time
+	// RunID is the ID of the latest attempt
+	RunID    string
+	Status   string
+	Attempts int
+	Started  time.Time
+	Ended    time.Time
+	Error    string
+	// Version is the version of the service that made the latest attempt
+	Version string
+}
+
+// History records the runs of jobs, keyed by the time they were scheduled
+type History interface {
+	// LoadRun returns false if the fire has no record
+	LoadRun(ctx context.Context, job string, scheduled time.Time) (RunRecord, bool, error)
+	SaveRun(ctx context.Context, r RunRecord) error
+	// LastSucceeded returns the latest fire of job that succeeded, or false
+	// if none has
+	LastSucceeded(ctx context.Context, job string) (RunRecord, bool, error)
+}
+
+type runRecord struct {
+	Job       string `dynamodbav:"job" ddb:"hash"`
+	Scheduled int64  `dynamodbav:"scheduled" ddb:"range"`
+	RunID     string `dynamodbav:"run_id"`
+	Status    string `dynamodbav:"status"`
+	Attempts  int    `dynamodbav:"attempts"`
+	Started   int64  `dynamodbav:"started"`
+	Ended     int64  `dynamodbav:"ended,omitempty"`
+	Error     string `dynamodbav:"error,omitempty"`
+	Version   string `dynamodbav:"version,omitempty"`
+	TTL       int64  `dynamodbav:"ttl,omitempty"`
+}
+
+func toRunRecord(r RunRecord) runRecord {
+	record := runRecord{
+		Job:       r.Job,
+		Scheduled: r.ScheduledTime.UnixMilli(),
+		RunID:     r.RunID,
+		Status:    r.Status,
+		Attempts:  r.Attempts,
+		Started:   r.Started.UnixMilli(),
+		Error:     r.Error,
+		Version:   r.Version,
+		TTL:       r.Started.Add(historyRetention).Unix(),
+	}
+	if !r.Ended.IsZero() {
+		record.Ended = r.Ended.UnixMilli()
+	}
+	return record
+}
+
+func (r runRecord) toRunRecord() RunRecord {
+	record := RunRecord{
+		Job:           r.Job,
+		ScheduledTime: time.UnixMilli(r.Scheduled).UTC(),
+		RunID:         r.RunID,
+		Status:        r.Status,
+		Attempts:      r.Attempts,
+		Started:       time.UnixMilli(r.Started).UTC(),
+		Error:         r.Error,
+		Version:       r.Version,
+	}
+	if r.Ended != 0 {
+		record.Ended = time.UnixMilli(r.Ended).UTC()
+	}
+	return record
+}
+
+// DDBHistory keeps the history of runs in a DynamoDB table
+type DDBHistory struct {
+	table *ddb.Table
+}
+
+// NewDDBHistory keeps the history in tableName, usually HistoryTableName(env)
+func NewDDBHistory(api dynamodbiface.DynamoDBAPI, tableName string) *DDBHistory {
+	return &DDBHistory{
+		table: ddb.New(api).MustTable(tableName, runRecord{}),
+	}
+}
+
+func (h *DDBHistory) LoadRun(ctx context.Context, job string, scheduled time.Time) (RunRecord, bool, error) {
+	var r runRecord
+	if err := h.table.Get(job).Range(scheduled.UnixMilli()).ConsistentRead(true).ScanWithContext(ctx, &r); err != nil {
+		if ddb.IsItemNotFoundError(err) {
+			return RunRecord{}, false, nil
+		}
+		return RunRecord{}, false, fmt.Errorf("unable to load run of %v at %v: %w", job, scheduled, err)
+	}
+	return r.toRunRecord(), true, nil
+}
+
+func (h *DDBHistory) SaveRun(ctx context.Context, r RunRecord) error {
+	if err := h.table.Put(toRunRecord(r)).RunWithContext(ctx); err != nil {
+		return fmt.Errorf("unable to save run of %v at %v: %w", r.Job, r.ScheduledTime, err)
+	}
+	return nil
+}
+
+func (h *DDBHistory) LastSucceeded(ctx context.Context, job string) (RunRecord, bool, error) {
+	var r runRecord
+	err := h.table.Query("#Job = ?", job).
+		ScanIndexForward(false).
+		Filter("#Status = ?", RunStatusSucceeded).
+		ConsistentRead(true).
+		FirstWithContext(ctx, &r)
+	if err != nil {
+		if ddb.IsItemNotFoundError(err) {
+			return RunRecord{}, false, nil
+		}
+		return RunRecord{}, false, fmt.Errorf("unable to find the last run of %v: %w", job, err)
+	}
+	return r.toRunRecord(), true, nil
+}
+
+// MemoryHistory keeps the history of runs in memory, for console mode
+type MemoryHistory struct {
+	mu   sync.Mutex
+	runs map[string]map[int64]RunRecord
+}
+
+func NewMemoryHistory() *MemoryHistory {
+	return &MemoryHistory{
+		runs: map[string]map[int64]RunRecord{},
+	}
+}
+
+func (h *MemoryHistory) LoadRun(_ context.Context, job string, scheduled time.Time) (RunRecord, bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.runs[job][scheduled.UnixMilli()]
+	return r, ok, nil
+}
+
+func (h *MemoryHistory) SaveRun(_ context.Context, r RunRecord) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.runs[r.Job] == nil {
+		h.runs[r.Job] = map[int64]RunRecord{}
+	}
+	h.runs[r.Job][r.ScheduledTime.UnixMilli()] = r
+	return nil
+}
+
+func (h *MemoryHistory) LastSucceeded(_ context.Context, job string) (RunRecord, bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var last RunRecord
+	var ok bool
+	for _, r := range h.runs[job] {
+		if r.Status == RunStatusSucceeded && (!ok || r.ScheduledTime.After(last.ScheduledTime)) {
+			last, ok = r, true
+		}
+	}
+	return last, ok, nil
+}
+
+// Runs returns the history of job, oldest fire first
+func (h *MemoryHistory) Runs(job string) []RunRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var runs []RunRecord
+	for _, r := range h.runs[job] {
+		runs = append(runs, r)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ScheduledTime.Before(runs[j].ScheduledTime) })
+	return runs
+}
@@ -0,0 +1,49 @@
+package sundaecron
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ddb/memddb"
+	"github.com/savaki/ddb"
+	"github.com/tj/assert"
+)
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	api := memddb.New()
+	assert.Nil(t, ddb.New(api).MustTable("runs", runRecord{}).CreateTableIfNotExists(ctx))
+
+	histories := map[string]History{
+		"ddb":    NewDDBHistory(api, "runs"),
+		"memory": NewMemoryHistory(),
+	}
+	for name, history := range histories {
+		t.Run(name, func(t *testing.T) {
+			fire := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+			_, ok, err := history.LastSucceeded(ctx, "job")
+			assert.Nil(t, err)
+			assert.False(t, ok)
+
+			want := RunRecord{Job: "job", ScheduledTime: fire, RunID: "a", Status: RunStatusSucceeded, Attempts: 2, Started: fire.Add(time.Second), Ended: fire.Add(time.Minute), Version: "abc"}
+			assert.Nil(t, history.SaveRun(ctx, want))
+			assert.Nil(t, history.SaveRun(ctx, RunRecord{Job: "job", ScheduledTime: fire.Add(time.Hour), RunID: "b", Status: RunStatusFailed, Attempts: 1, Started: fire.Add(time.Hour), Error: "boom"}))
+			assert.Nil(t, history.SaveRun(ctx, RunRecord{Job: "other", ScheduledTime: fire.Add(2 * time.Hour), RunID: "c", Status: RunStatusSucceeded, Started: fire}))
+
+			got, ok, err := history.LoadRun(ctx, "job", fire)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, want, got)
+
+			_, ok, err = history.LoadRun(ctx, "job", fire.Add(time.Minute))
+			assert.Nil(t, err)
+			assert.False(t, ok)
+
+			got, ok, err = history.LastSucceeded(ctx, "job")
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, "a", got.RunID)
+		})
+	}
+}
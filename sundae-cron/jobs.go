@@ -0,0 +1,144 @@
+package sundaecron
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+)
+
+// JobField is the field of the EventBridge input that names the job to run.
+// A rule with constant input replaces the scheduled event, losing its time;
+// keep it with an input transformer instead:
+//
+//	{"job": "snapshots", "id": <id>, "time": <time>}
+//
+// mapping id and time from $.id and $.time.
+const JobField = "job"
+
+// Jobs hosts several named jobs in one binary, each with its own schedule,
+// timeout, lock, history and metrics. In Lambda, the job is picked by the
+// JobField of the event; from the command line, by its subcommand.
+type Jobs struct {
+	service  sundaecli.Service
+	logger   zerolog.Logger
+	defaults []HandlerOption
+	jobs     []*Handler
+	byName   map[string]*Handler
+}
+
+// NewJobs hosts the jobs of service; opts apply to every job, before the
+// job's own
+func NewJobs(service sundaecli.Service, opts ...HandlerOption) *Jobs {
+	return &Jobs{
+		service:  service,
+		logger:   sundaecli.Logger(service),
+		defaults: opts,
+		byName:   map[string]*Handler{},
+	}
+}
+
+// Add adds the job name, which must be unique
+func (j *Jobs) Add(name string, fn RunFunc, opts ...HandlerOption) *Jobs {
+	if _, ok := j.byName[name]; ok {
+		panic(fmt.Sprintf("sundaecron: job %v added twice", name))
+	}
+	opts = append(append(append([]HandlerOption(nil), j.defaults...), opts...), WithName(name))
+	h := NewRunHandler(j.service, fn, opts...)
+	j.jobs = append(j.jobs, h)
+	j.byName[name] = h
+	return j
+}
+
+// Job returns the job called name
+func (j *Jobs) Job(name string) (*Handler, bool) {
+	h, ok := j.byName[name]
+	return h, ok
+}
+
+// RunOnce runs the job named by the event's JobField. The field may be left
+// out when there is only one job.
+func (j *Jobs) RunOnce(ctx context.Context, event json.RawMessage) error {
+	var e struct {
+		Job string `json:"job"`
+	}
+	if len(event) > 0 {
+		if err := json.Unmarshal(event, &e); err != nil {
+			return fmt.Errorf("unable to decode scheduled event: %w", err)
+		}
+	}
+	if e.Job == "" && len(j.jobs) == 1 {
+		return j.jobs[0].RunOnce(ctx, event)
+	}
+	h, ok := j.byName[e.Job]
+	if !ok {
+		return fmt.Errorf("no job named %q", e.Job)
+	}
+	return h.RunOnce(ctx, event)
+}
+
+// Schedule runs every job on its schedule until ctx is done; see
+// Handler.Schedule. A job that fails, such as one without a schedule that
+// runs once, doesn't stop the others; the first error is returned once they
+// are all done.
+func (j *Jobs) Schedule(ctx context.Context) error {
+	var group errgroup.Group
+	for _, h := range j.jobs {
+		h := h
+		group.Go(func() error {
+			if err := h.Schedule(ctx); err != nil {
+				return fmt.Errorf("job %v: %w", h.name, err)
+			}
+			return nil
+		})
+	}
+	return group.Wait()
+}
+
+// configure applies the command line flags to every job
+func (j *Jobs) configure() error {
+	if CronOpts.Schedule != "" {
+		return fmt.Errorf("--%v applies to one job; run it with its subcommand", ScheduleFlag.Name)
+	}
+	for _, h := range j.jobs {
+		if err := h.configure(); err != nil {
+			return fmt.Errorf("job %v: %w", h.name, err)
+		}
+	}
+	return nil
+}
+
+// Start runs the job named by each Lambda event, or every job on its schedule
+// in console mode
+func (j *Jobs) Start() error {
+	if err := j.configure(); err != nil {
+		return err
+	}
+	return sundaecli.Run(context.Background(), j.RunOnce, j.Schedule)
+}
+
+// Commands builds the commands for sundaecli.CommandApp: run, first so
+// Lambda invokes it, which starts every job, then one per job, which starts
+// only that job, in Lambda or on its schedule in console mode
+func (j *Jobs) Commands() []*cli.Command {
+	commands := []*cli.Command{
+		sundaecli.Command("run", "run every job: the one named by each Lambda event, or each on its schedule in console mode", func(*cli.Context) error {
+			return j.Start()
+		}),
+	}
+	for _, h := range j.jobs {
+		h := h
+		usage := fmt.Sprintf("run the %v job", h.name)
+		if h.schedule != nil {
+			usage += fmt.Sprintf(", on %v in console mode", h.schedule)
+		}
+		commands = append(commands, sundaecli.Command(h.name, usage, func(*cli.Context) error {
+			return h.Start()
+		}))
+	}
+	return commands
+}
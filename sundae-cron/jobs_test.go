@@ -0,0 +1,78 @@
+package sundaecron
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/tj/assert"
+)
+
+func TestJobs(t *testing.T) {
+	ctx := context.Background()
+	service := sundaecli.NewService("test")
+	metrics := sundaecli.NewMemoryMetrics(service)
+	history := NewMemoryHistory()
+
+	var ran []string
+	record := func(ctx context.Context, run Run) error {
+		ran = append(ran, fmt.Sprintf("%v", run.ScheduledTime.Hour()))
+		return nil
+	}
+	jobs := NewJobs(service, WithHistory(history), WithMetrics(metrics)).
+		Add("prices", record, WithSchedule(Rate(5*time.Minute)), WithTimeout(time.Minute)).
+		Add("snapshots", func(ctx context.Context, run Run) error {
+			return fmt.Errorf("boom")
+		}, WithSchedule(MustParseSchedule("cron(0 * * * ? *)")))
+
+	assert.Nil(t, jobs.RunOnce(ctx, []byte(`{"job":"prices","id":"a","time":"2026-10-14T10:00:00Z"}`)))
+	assert.EqualError(t, jobs.RunOnce(ctx, []byte(`{"job":"snapshots","id":"b","time":"2026-10-14T11:00:00Z"}`)), "scheduled task failed: boom")
+	assert.EqualError(t, jobs.RunOnce(ctx, []byte(`{"job":"other"}`)), `no job named "other"`)
+	assert.EqualError(t, jobs.RunOnce(ctx, []byte(`{}`)), `no job named ""`)
+	assert.Equal(t, []string{"10"}, ran)
+
+	// Each job keeps its own history and metrics
+	assert.Len(t, history.Runs("prices"), 1)
+	assert.Equal(t, RunStatusFailed, history.Runs("snapshots")[0].Status)
+	assert.Equal(t, "prices", metrics.Named(RunSucceededMetric)[0].Dimensions[JobDimension])
+	assert.Equal(t, "snapshots", metrics.Named(RunFailedMetric)[0].Dimensions[JobDimension])
+	assert.Len(t, metrics.Named(RunDurationMetric), 2)
+
+	prices, ok := jobs.Job("prices")
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, prices.timeout)
+
+	var names []string
+	for _, c := range jobs.Commands() {
+		names = append(names, c.Name)
+	}
+	assert.Equal(t, []string{"run", "prices", "snapshots"}, names)
+	assert.Panics(t, func() { jobs.Add("prices", record) })
+
+	// A lone job needn't be named
+	single := NewJobs(service).Add("prices", record)
+	assert.Nil(t, single.RunOnce(ctx, []byte(`{"time":"2026-10-14T12:00:00Z"}`)))
+	assert.Equal(t, []string{"10", "12"}, ran)
+
+	// A job that fails doesn't cancel the others
+	var finished bool
+	jobs = NewJobs(service).
+		Add("fails", func(ctx context.Context, run Run) error { return fmt.Errorf("boom") }).
+		Add("slow", func(ctx context.Context, run Run) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(50 * time.Millisecond):
+				finished = true
+				return nil
+			}
+		})
+	assert.EqualError(t, jobs.Schedule(ctx), "job fails: scheduled task failed: boom")
+	assert.True(t, finished)
+
+	defer func() { CronOpts.Schedule = "" }()
+	CronOpts.Schedule = "rate(1 minute)"
+	assert.NotNil(t, jobs.configure())
+}
@@ -0,0 +1,167 @@
+package sundaecron
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/savaki/ddb"
+)
+
+// DefaultLockTTL is how long a run holds its lock when the handler has no
+// timeout: the longest a Lambda function can run
+const DefaultLockTTL = 15 * time.Minute
+
+// lockRetention is how long the lock of a job that stopped running is kept
+const lockRetention = 7 * 24 * time.Hour
+
+func init() {
+	sundaecli.RegisterTable("cronlocks", LockTableName, sundaecli.WithTableModel(lockRecord{}), sundaecli.WithTableTTL("ttl"), sundaecli.WithTableOptional())
+}
+
+func LockTableName(env string) string {
+	return env + "-sundae-cron--locks"
+}
+
+// Lock keeps runs of a job from overlapping, across invocations and
+// processes. Each run is for a fire, the time it was scheduled at.
+type Lock interface {
+	// Acquire leases the lock of job name for ttl. It returns false, without
+	// an error, if another run holds the lease, or if the run for fire or a
+	// later one has already succeeded.
+	Acquire(ctx context.Context, name string, fire time.Time, ttl time.Duration) (bool, error)
+	// Release gives up the lease taken for fire; succeeded records that the
+	// run for fire needn't be repeated
+	Release(ctx context.Context, name string, fire time.Time, succeeded bool) error
+}
+
+type lockRecord struct {
+	Name  string `dynamodbav:"name" ddb:"hash"`
+	Owner string `dynamodbav:"owner,omitempty"`
+	// Expires is when the lease ends, in unix millis; 0 once released
+	Expires int64 `dynamodbav:"expires"`
+	Fire    int64 `dynamodbav:"fire"`
+	// Succeeded is the fire of the latest run that succeeded, in unix millis
+	Succeeded int64 `dynamodbav:"succeeded,omitempty"`
+	TTL       int64 `dynamodbav:"ttl,omitempty"`
+}
+
+// DDBLock leases locks in a DynamoDB table, so runs in different processes
+// are kept apart
+type DDBLock struct {
+	owner string
+	table *ddb.Table
+	now   func() time.Time
+}
+
+// NewDDBLock keeps locks in tableName, usually LockTableName(env)
+func NewDDBLock(api dynamodbiface.DynamoDBAPI, tableName string) *DDBLock {
+	return &DDBLock{
+		owner: lockOwner(),
+		table: ddb.New(api).MustTable(tableName, lockRecord{}),
+		now:   time.Now,
+	}
+}
+
+// lockOwner identifies this process in the locks it holds
+func lockOwner() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%v/%v/%v", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+func (l *DDBLock) Acquire(ctx context.Context, name string, fire time.Time, ttl time.Duration) (bool, error) {
+	now := l.now()
+	expires := now.Add(ttl)
+	err := l.table.Update(name).
+		Set("#Owner = ?", l.owner).
+		Set("#Expires = ?", expires.UnixMilli()).
+		Set("#Fire = ?", fire.UnixMilli()).
+		Set("#TTL = ?", expires.Add(lockRetention).Unix()).
+		Condition("attribute_not_exists(#Name) OR (#Expires < ? AND (attribute_not_exists(#Succeeded) OR #Succeeded < ?))", now.UnixMilli(), fire.UnixMilli()).
+		RunWithContext(ctx)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to acquire lock %v: %w", name, err)
+	}
+	return true, nil
+}
+
+func (l *DDBLock) Release(ctx context.Context, name string, fire time.Time, succeeded bool) error {
+	update := l.table.Update(name).
+		Set("#Expires = ?", 0).
+		Condition("#Owner = ? AND #Fire = ?", l.owner, fire.UnixMilli())
+	if succeeded {
+		update = update.Set("#Succeeded = ?", fire.UnixMilli())
+	}
+	if err := update.RunWithContext(ctx); err != nil {
+		if isConditionalCheckFailed(err) {
+			// The lease ran out and another run took it
+			return nil
+		}
+		return fmt.Errorf("unable to release lock %v: %w", name, err)
+	}
+	return nil
+}
+
+func isConditionalCheckFailed(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// MemoryLock keeps runs apart within a process
+type MemoryLock struct {
+	mu    sync.Mutex
+	locks map[string]lockRecord
+	now   func() time.Time
+}
+
+func NewMemoryLock() *MemoryLock {
+	return &MemoryLock{
+		locks: map[string]lockRecord{},
+		now:   time.Now,
+	}
+}
+
+func (l *MemoryLock) Acquire(_ context.Context, name string, fire time.Time, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	r := l.locks[name]
+	if r.Expires >= now.UnixMilli() || r.Succeeded >= fire.UnixMilli() {
+		return false, nil
+	}
+	r.Expires = now.Add(ttl).UnixMilli()
+	r.Fire = fire.UnixMilli()
+	l.locks[name] = r
+	return true, nil
+}
+
+func (l *MemoryLock) Release(_ context.Context, name string, fire time.Time, succeeded bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r, ok := l.locks[name]
+	if !ok || r.Fire != fire.UnixMilli() {
+		return nil
+	}
+	r.Expires = 0
+	if succeeded {
+		r.Succeeded = r.Fire
+	}
+	l.locks[name] = r
+	return nil
+}
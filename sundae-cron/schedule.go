@@ -0,0 +1,352 @@
+package sundaecron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule decides when a job runs. Times are in UTC.
+type Schedule interface {
+	// Next returns the first time the schedule fires strictly after t, or the
+	// zero time if it never fires again
+	Next(t time.Time) time.Time
+	// String renders the schedule as it was declared, e.g. rate(5 minutes)
+	String() string
+}
+
+// ParseSchedule parses the expressions EventBridge accepts:
+//
+//	rate(5 minutes)
+//	cron(0/15 8-17 ? * MON-FRI *)
+//
+// as well as a bare 5 field crontab expression ("*/15 8-17 * * 1-5"), one of
+// @hourly, @daily, @midnight, @weekly, @monthly, @yearly or @annually, and
+// "@every <duration>"
+func ParseSchedule(s string) (Schedule, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "rate(") && strings.HasSuffix(s, ")"):
+		return parseRate(s)
+	case strings.HasPrefix(s, "@every "):
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(s, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", s, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid schedule %q: the interval must be positive", s)
+		}
+		return Rate(d), nil
+	}
+	return Cron(s)
+}
+
+// MustParseSchedule is ParseSchedule for schedules declared in code
+func MustParseSchedule(s string) Schedule {
+	schedule, err := ParseSchedule(s)
+	if err != nil {
+		panic(err)
+	}
+	return schedule
+}
+
+// lastFire returns the latest time s fires at or before t, searching back
+// over ever longer windows, or false if it didn't fire in the cronHorizon
+// years before t
+func lastFire(s Schedule, t time.Time) (time.Time, bool) {
+	t = t.UTC()
+	for window := time.Minute; window <= cronHorizon*366*24*time.Hour; window *= 2 {
+		fire := s.Next(t.Add(-window))
+		if fire.IsZero() || fire.After(t) {
+			continue
+		}
+		for next := s.Next(fire); !next.IsZero() && !next.After(t); next = s.Next(next) {
+			fire = next
+		}
+		return fire, true
+	}
+	return time.Time{}, false
+}
+
+type rate time.Duration
+
+// Rate fires every d, at multiples of d since the Unix epoch, so every
+// instance of a service agrees on when it fires
+func Rate(d time.Duration) Schedule {
+	return rate(d)
+}
+
+func (r rate) Next(t time.Time) time.Time {
+	d := time.Duration(r)
+	return t.UTC().Truncate(d).Add(d)
+}
+
+func (r rate) String() string {
+	d := time.Duration(r)
+	unit := func(n time.Duration, name string) string {
+		if n == 1 {
+			return fmt.Sprintf("rate(1 %v)", name)
+		}
+		return fmt.Sprintf("rate(%v %vs)", int64(n), name)
+	}
+	switch {
+	case d%(24*time.Hour) == 0:
+		return unit(d/(24*time.Hour), "day")
+	case d%time.Hour == 0:
+		return unit(d/time.Hour, "hour")
+	case d%time.Minute == 0:
+		return unit(d/time.Minute, "minute")
+	case d%time.Second == 0:
+		return unit(d/time.Second, "second")
+	}
+	return fmt.Sprintf("@every %v", d)
+}
+
+func parseRate(s string) (Schedule, error) {
+	fields := strings.Fields(strings.TrimSuffix(strings.TrimPrefix(s, "rate("), ")"))
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid schedule %q: expected rate(value unit)", s)
+	}
+	n, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid schedule %q: the value must be a positive integer", s)
+	}
+	var unit time.Duration
+	switch strings.TrimSuffix(strings.ToLower(fields[1]), "s") {
+	case "second":
+		unit = time.Second
+	case "minute":
+		unit = time.Minute
+	case "hour":
+		unit = time.Hour
+	case "day":
+		unit = 24 * time.Hour
+	default:
+		return nil, fmt.Errorf("invalid schedule %q: unknown unit %v", s, fields[1])
+	}
+	return Rate(time.Duration(n) * unit), nil
+}
+
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+var (
+	monthNames = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+	dayNames   = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+)
+
+// cronField is the allowed values of a field, one bit per value
+type cronField uint64
+
+func (f cronField) has(v int) bool { return f&(1<<uint(v)) != 0 }
+
+type cronSchedule struct {
+	expr                         string
+	minute, hour, dom, month     cronField
+	dow                          cronField // 0 is Sunday
+	domRestricted, dowRestricted bool
+	years                        map[int]bool // nil for every year
+	lastYear                     int
+}
+
+// Cron parses a cron expression: either EventBridge's six fields in
+// "cron(minutes hours day-of-month month day-of-week year)", where Sunday is
+// 1, or the five fields of crontab, where Sunday is 0 or 7. Fields take
+// lists, ranges, steps and the names of months and days; the L, W and #
+// extensions aren't supported. As in crontab, a job whose day of month and
+// day of week are both restricted runs on days matching either.
+func Cron(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	fields := strings.Fields(expr)
+	eventBridge := false
+	switch {
+	case strings.HasPrefix(expr, "cron(") && strings.HasSuffix(expr, ")"):
+		fields = strings.Fields(strings.TrimSuffix(strings.TrimPrefix(expr, "cron("), ")"))
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("invalid schedule %q: expected 6 fields", expr)
+		}
+		eventBridge = true
+	case cronMacros[expr] != "":
+		fields = strings.Fields(cronMacros[expr])
+	case len(fields) != 5:
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields", expr)
+	}
+
+	c := &cronSchedule{expr: expr}
+	var err error
+	parse := func(i int, min, max int, names []string, offset int) (cronField, bool) {
+		if err != nil {
+			return 0, false
+		}
+		var f cronField
+		var restricted bool
+		f, restricted, err = parseCronField(fields[i], min, max, names, offset)
+		if err != nil {
+			err = fmt.Errorf("invalid schedule %q: %w", expr, err)
+		}
+		return f, restricted
+	}
+	c.minute, _ = parse(0, 0, 59, nil, 0)
+	c.hour, _ = parse(1, 0, 23, nil, 0)
+	c.dom, c.domRestricted = parse(2, 1, 31, nil, 0)
+	c.month, _ = parse(3, 1, 12, monthNames, 1)
+	if eventBridge {
+		var dow cronField
+		dow, c.dowRestricted = parse(4, 1, 7, dayNames, 1)
+		c.dow = dow >> 1
+	} else {
+		c.dow, c.dowRestricted = parse(4, 0, 7, dayNames, 0)
+		if c.dow.has(7) {
+			c.dow = c.dow&^(1<<7) | 1
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if eventBridge && c.domRestricted && c.dowRestricted {
+		return nil, fmt.Errorf("invalid schedule %q: one of day-of-month and day-of-week must be ?", expr)
+	}
+
+	c.lastYear = -1
+	if eventBridge && fields[5] != "*" {
+		c.years = map[int]bool{}
+		for _, part := range strings.Split(fields[5], ",") {
+			lo, hi, step, err := parseCronRange(part, 1970, 2199, nil, 0)
+			if err != nil {
+				return nil, fmt.Errorf("invalid schedule %q: %w", expr, err)
+			}
+			for y := lo; y <= hi; y += step {
+				c.years[y] = true
+				if y > c.lastYear {
+					c.lastYear = y
+				}
+			}
+		}
+	}
+	return c, nil
+}
+
+func (c *cronSchedule) String() string {
+	return c.expr
+}
+
+// cronHorizon bounds the search for a schedule without years, such as the
+// 30th of February, that never fires
+const cronHorizon = 5
+
+func (c *cronSchedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	last := t.Year() + cronHorizon
+	if c.years != nil {
+		last = c.lastYear
+	}
+	for t.Year() <= last {
+		switch {
+		case c.years != nil && !c.years[t.Year()]:
+			t = time.Date(t.Year()+1, 1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.month.has(int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case !c.hour.has(t.Hour()):
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case !c.minute.has(t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := c.dom.has(t.Day()), c.dow.has(int(t.Weekday()))
+	if c.domRestricted && c.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// parseCronField returns the values of a field, and whether it restricts
+// them at all
+func parseCronField(field string, min, max int, names []string, offset int) (cronField, bool, error) {
+	if field == "*" || field == "?" {
+		var f cronField
+		for v := min; v <= max; v++ {
+			f |= 1 << uint(v)
+		}
+		return f, false, nil
+	}
+	var f cronField
+	for _, part := range strings.Split(field, ",") {
+		lo, hi, step, err := parseCronRange(part, min, max, names, offset)
+		if err != nil {
+			return 0, false, err
+		}
+		for v := lo; v <= hi; v += step {
+			f |= 1 << uint(v)
+		}
+	}
+	return f, true, nil
+}
+
+// parseCronRange parses one of v, a-b, */n, v/n or a-b/n
+func parseCronRange(part string, min, max int, names []string, offset int) (lo, hi, step int, err error) {
+	step = 1
+	r, s, stepped := strings.Cut(part, "/")
+	if stepped {
+		if step, err = strconv.Atoi(s); err != nil || step <= 0 {
+			return 0, 0, 0, fmt.Errorf("invalid step in %q", part)
+		}
+		part = r
+	}
+	switch {
+	case part == "*":
+		return min, max, step, nil
+	case strings.Contains(part, "-"):
+		a, b, _ := strings.Cut(part, "-")
+		if lo, err = parseCronValue(a, min, max, names, offset); err != nil {
+			return 0, 0, 0, err
+		}
+		if hi, err = parseCronValue(b, min, max, names, offset); err != nil {
+			return 0, 0, 0, err
+		}
+		if hi < lo {
+			return 0, 0, 0, fmt.Errorf("invalid range %q", part)
+		}
+	default:
+		if lo, err = parseCronValue(part, min, max, names, offset); err != nil {
+			return 0, 0, 0, err
+		}
+		// v/n runs from v to the end of the range
+		hi = lo
+		if stepped {
+			hi = max
+		}
+	}
+	return lo, hi, step, nil
+}
+
+func parseCronValue(s string, min, max int, names []string, offset int) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(s, name) {
+			return i + offset, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("value %v out of range %v-%v", v, min, max)
+	}
+	return v, nil
+}
@@ -0,0 +1,58 @@
+package sundaecron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+)
+
+func TestSchedule(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2026, 10, 14, 10, 7, 30, 0, time.UTC)
+	cases := map[string]string{
+		"rate(5 minutes)":                "2026-10-14T10:10:00Z",
+		"rate(1 hour)":                   "2026-10-14T11:00:00Z",
+		"@every 90s":                     "2026-10-14T10:09:00Z",
+		"*/15 * * * *":                   "2026-10-14T10:15:00Z",
+		"0 9 * * *":                      "2026-10-15T09:00:00Z",
+		"@monthly":                       "2026-11-01T00:00:00Z",
+		"30 8 * * sun":                   "2026-10-18T08:30:00Z",
+		"30 8 * * 7":                     "2026-10-18T08:30:00Z",
+		"0 0 1 * 1":                      "2026-10-19T00:00:00Z", // the 1st or a Monday
+		"0 12 29 feb *":                  "2028-02-29T12:00:00Z",
+		"cron(0/20 10-12 ? * MON-FRI *)": "2026-10-14T10:20:00Z",
+		"cron(0 8 ? * 1 *)":              "2026-10-18T08:00:00Z", // Sunday is 1
+		"cron(0 0 1 JAN ? 2028-2030)":    "2028-01-01T00:00:00Z",
+		"cron(0 0 1 1 ? 2020)":           "0001-01-01T00:00:00Z",
+		"0 0 30 2 *":                     "0001-01-01T00:00:00Z",
+	}
+	for expr, want := range cases {
+		schedule, err := ParseSchedule(expr)
+		assert.Nil(t, err, expr)
+		assert.Equal(t, want, schedule.Next(from).Format(time.RFC3339), expr)
+	}
+
+	assert.Equal(t, "rate(5 minutes)", Rate(5*time.Minute).String())
+	assert.Equal(t, "rate(1 day)", MustParseSchedule("rate(24 hours)").String())
+
+	for _, expr := range []string{"", "* * * *", "61 * * * *", "cron(0 0 1 * MON *)", "0 0 L * *", "5-1 * * * *", "*/0 * * * *", "rate(0 minutes)", "rate(5 fortnights)"} {
+		_, err := ParseSchedule(expr)
+		assert.NotNil(t, err, expr)
+	}
+
+	for expr, want := range map[string]string{
+		"rate(1 hour)":         "2026-10-14T10:00:00Z",
+		"*/15 * * * *":         "2026-10-14T10:00:00Z",
+		"7 10 * * *":           "2026-10-14T10:07:00Z",
+		"0 9 * * mon":          "2026-10-12T09:00:00Z",
+		"0 0 1 1 *":            "2026-01-01T00:00:00Z",
+		"cron(0 0 1 1 ? 2030)": "",
+	} {
+		fire, ok := lastFire(MustParseSchedule(expr), from)
+		assert.Equal(t, want != "", ok, expr)
+		if ok {
+			assert.Equal(t, want, fire.Format(time.RFC3339), expr)
+		}
+	}
+}
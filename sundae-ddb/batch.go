@@ -0,0 +1,327 @@
+package sundaeddb
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// DynamoDB's limits on the items in one BatchGetItem or BatchWriteItem
+const (
+	MaxBatchGetItems   = 100
+	MaxBatchWriteItems = 25
+)
+
+const (
+	DefaultBatchConcurrency = 4
+	DefaultBatchAttempts    = 8
+	DefaultBatchBackoff     = 50 * time.Millisecond
+	DefaultBatchMaxBackoff  = 5 * time.Second
+)
+
+type batchOptions struct {
+	concurrency    int
+	attempts       int
+	backoff        time.Duration
+	maxBackoff     time.Duration
+	consistentRead bool
+}
+
+type BatchOption func(*batchOptions)
+
+// WithBatchConcurrency sets how many requests run at once
+func WithBatchConcurrency(n int) BatchOption {
+	return func(o *batchOptions) {
+		o.concurrency = n
+	}
+}
+
+// WithBatchAttempts sets how many times a request is made before unprocessed
+// items or throttling become an error
+func WithBatchAttempts(n int) BatchOption {
+	return func(o *batchOptions) {
+		o.attempts = n
+	}
+}
+
+// WithBatchBackoff sets the wait before the first retry, which doubles with
+// each retry up to max, with jitter
+func WithBatchBackoff(backoff, max time.Duration) BatchOption {
+	return func(o *batchOptions) {
+		o.backoff = backoff
+		o.maxBackoff = max
+	}
+}
+
+// WithConsistentRead makes BatchGet use strongly consistent reads
+func WithConsistentRead() BatchOption {
+	return func(o *batchOptions) {
+		o.consistentRead = true
+	}
+}
+
+func makeBatchOptions(opts []BatchOption) batchOptions {
+	o := batchOptions{
+		concurrency: DefaultBatchConcurrency,
+		attempts:    DefaultBatchAttempts,
+		backoff:     DefaultBatchBackoff,
+		maxBackoff:  DefaultBatchMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.concurrency < 1 {
+		o.concurrency = 1
+	}
+	if o.attempts < 1 {
+		o.attempts = 1
+	}
+	return o
+}
+
+// isThrottled reports whether err is DynamoDB shedding load, which is worth
+// retrying after a backoff
+func isThrottled(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	switch aerr.Code() {
+	case dynamodb.ErrCodeProvisionedThroughputExceededException,
+		dynamodb.ErrCodeRequestLimitExceeded,
+		"ThrottlingException":
+		return true
+	}
+	return false
+}
+
+// wait sleeps before retry attempt (counting from 1), with full jitter
+func (o batchOptions) wait(ctx context.Context, attempt int) error {
+	backoff := o.backoff << (attempt - 1)
+	if backoff > o.maxBackoff || backoff <= 0 {
+		backoff = o.maxBackoff
+	}
+	if backoff > 0 {
+		backoff = time.Duration(rand.Int63n(int64(backoff))) + 1
+	}
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// parallel calls fn for each of n chunks, at most concurrency at a time, and
+// returns the first error, which cancels the chunks still to run
+func parallel(ctx context.Context, n, concurrency int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		sem      = make(chan struct{}, concurrency)
+	)
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(ctx, i); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// BatchGetItems reads keys from tableName with BatchGetItem, in chunks of
+// MaxBatchGetItems. Unprocessed keys and throttled requests are retried with
+// backoff; if keys are still unprocessed after every attempt, it's an error
+// rather than a silent gap. Keys that don't exist are left out, and items are
+// in no particular order.
+func BatchGetItems(ctx context.Context, api dynamodbiface.DynamoDBAPI, tableName string, keys []map[string]*dynamodb.AttributeValue, opts ...BatchOption) ([]map[string]*dynamodb.AttributeValue, error) {
+	o := makeBatchOptions(opts)
+	chunks := (len(keys) + MaxBatchGetItems - 1) / MaxBatchGetItems
+
+	var (
+		mu    sync.Mutex
+		items []map[string]*dynamodb.AttributeValue
+	)
+	err := parallel(ctx, chunks, o.concurrency, func(ctx context.Context, i int) error {
+		end := (i + 1) * MaxBatchGetItems
+		if end > len(keys) {
+			end = len(keys)
+		}
+		pending := keys[i*MaxBatchGetItems : end]
+		for attempt := 1; ; attempt++ {
+			out, err := api.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: map[string]*dynamodb.KeysAndAttributes{
+					tableName: {Keys: pending, ConsistentRead: aws.Bool(o.consistentRead)},
+				},
+			})
+			if err != nil && !isThrottled(err) {
+				return fmt.Errorf("unable to batch get from %v: %w", tableName, err)
+			}
+			if err == nil {
+				mu.Lock()
+				items = append(items, out.Responses[tableName]...)
+				mu.Unlock()
+
+				pending = nil
+				if unprocessed := out.UnprocessedKeys[tableName]; unprocessed != nil {
+					pending = unprocessed.Keys
+				}
+				if len(pending) == 0 {
+					return nil
+				}
+			}
+			if attempt >= o.attempts {
+				if err != nil {
+					return fmt.Errorf("unable to batch get from %v after %v attempts: %w", tableName, attempt, err)
+				}
+				return fmt.Errorf("unable to batch get from %v: %v keys unprocessed after %v attempts", tableName, len(pending), attempt)
+			}
+			if err := o.wait(ctx, attempt); err != nil {
+				return err
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// BatchGet is BatchGetItems, with each item unmarshalled into a T by
+// UnmarshalMap, so ddbalias and ddbformat tags apply
+func BatchGet[T any](ctx context.Context, api dynamodbiface.DynamoDBAPI, tableName string, keys []map[string]*dynamodb.AttributeValue, opts ...BatchOption) ([]T, error) {
+	items, err := BatchGetItems(ctx, api, tableName, keys, opts...)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]T, 0, len(items))
+	for _, item := range items {
+		var v T
+		if err := UnmarshalMap(item, &v); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal item from %v: %w", tableName, err)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// BatchWriter buffers puts and deletes to one table and writes them with
+// BatchWriteItem, in chunks of MaxBatchWriteItems, retrying unprocessed items
+// and throttled requests with backoff. Writes are sent once enough are
+// buffered to keep every concurrent request full, and by Flush.
+//
+// A BatchWriter isn't safe for concurrent use. As with BatchWriteItem, the
+// same item must not be written twice between flushes.
+type BatchWriter struct {
+	api       dynamodbiface.DynamoDBAPI
+	tableName string
+	options   batchOptions
+	pending   []*dynamodb.WriteRequest
+}
+
+func NewBatchWriter(api dynamodbiface.DynamoDBAPI, tableName string, opts ...BatchOption) *BatchWriter {
+	return &BatchWriter{
+		api:       api,
+		tableName: tableName,
+		options:   makeBatchOptions(opts),
+	}
+}
+
+// Put marshals v, usually a struct with dynamodbav tags, and buffers a put of
+// it
+func (w *BatchWriter) Put(ctx context.Context, v interface{}) error {
+	item, err := dynamodbattribute.MarshalMap(v)
+	if err != nil {
+		return fmt.Errorf("unable to marshal item for %v: %w", w.tableName, err)
+	}
+	return w.PutItem(ctx, item)
+}
+
+// PutItem buffers a put of item
+func (w *BatchWriter) PutItem(ctx context.Context, item map[string]*dynamodb.AttributeValue) error {
+	return w.add(ctx, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+}
+
+// Delete buffers a delete of the item with key
+func (w *BatchWriter) Delete(ctx context.Context, key map[string]*dynamodb.AttributeValue) error {
+	return w.add(ctx, &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{Key: key}})
+}
+
+func (w *BatchWriter) add(ctx context.Context, request *dynamodb.WriteRequest) error {
+	w.pending = append(w.pending, request)
+	if len(w.pending) >= MaxBatchWriteItems*w.options.concurrency {
+		return w.Flush(ctx)
+	}
+	return nil
+}
+
+// Flush writes everything buffered. On error, some of the writes may have
+// been made; the buffer is cleared either way.
+func (w *BatchWriter) Flush(ctx context.Context) error {
+	requests := w.pending
+	w.pending = nil
+
+	chunks := (len(requests) + MaxBatchWriteItems - 1) / MaxBatchWriteItems
+	return parallel(ctx, chunks, w.options.concurrency, func(ctx context.Context, i int) error {
+		end := (i + 1) * MaxBatchWriteItems
+		if end > len(requests) {
+			end = len(requests)
+		}
+		pending := requests[i*MaxBatchWriteItems : end]
+		for attempt := 1; ; attempt++ {
+			out, err := w.api.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+				RequestItems: map[string][]*dynamodb.WriteRequest{w.tableName: pending},
+			})
+			if err != nil && !isThrottled(err) {
+				return fmt.Errorf("unable to batch write to %v: %w", w.tableName, err)
+			}
+			if err == nil {
+				pending = out.UnprocessedItems[w.tableName]
+				if len(pending) == 0 {
+					return nil
+				}
+			}
+			if attempt >= w.options.attempts {
+				if err != nil {
+					return fmt.Errorf("unable to batch write to %v after %v attempts: %w", w.tableName, attempt, err)
+				}
+				return fmt.Errorf("unable to batch write to %v: %v items unprocessed after %v attempts", w.tableName, len(pending), attempt)
+			}
+			if err := w.options.wait(ctx, attempt); err != nil {
+				return err
+			}
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package sundaeddb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ddb/memddb"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/savaki/ddb"
+	"github.com/tj/assert"
+)
+
+type batchRecord struct {
+	ID    string `dynamodbav:"pk" ddb:"hash"`
+	Value int    `dynamodbav:"value"`
+}
+
+// throttledDB fails the next throttles batch requests with a throughput
+// error
+type throttledDB struct {
+	*memddb.DB
+	throttles int32
+}
+
+func (t *throttledDB) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, opts ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	if atomic.AddInt32(&t.throttles, -1) >= 0 {
+		return nil, &dynamodb.ProvisionedThroughputExceededException{Message_: aws.String("slow down")}
+	}
+	return t.DB.BatchGetItemWithContext(ctx, input, opts...)
+}
+
+func (t *throttledDB) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	if atomic.AddInt32(&t.throttles, -1) >= 0 {
+		return nil, &dynamodb.ProvisionedThroughputExceededException{Message_: aws.String("slow down")}
+	}
+	return t.DB.BatchWriteItemWithContext(ctx, input, opts...)
+}
+
+func batchKeys(n int) []map[string]*dynamodb.AttributeValue {
+	var keys []map[string]*dynamodb.AttributeValue
+	for i := 0; i < n; i++ {
+		keys = append(keys, map[string]*dynamodb.AttributeValue{"pk": {S: aws.String(fmt.Sprint(i))}})
+	}
+	return keys
+}
+
+func TestBatch(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		db    = &throttledDB{DB: memddb.New()}
+		fast  = WithBatchBackoff(time.Millisecond, 5*time.Millisecond)
+		table = "batch"
+	)
+	assert.Nil(t, ddb.New(db).MustTable(table, batchRecord{}).CreateTableIfNotExists(ctx))
+
+	// More than a full round of concurrent requests, with items left
+	// unprocessed and requests throttled along the way
+	db.DeferBatchItems(30)
+	db.throttles = 2
+	w := NewBatchWriter(db, table, fast, WithBatchConcurrency(2))
+	for i := 0; i < 230; i++ {
+		assert.Nil(t, w.Put(ctx, batchRecord{ID: fmt.Sprint(i), Value: i}))
+	}
+	assert.Nil(t, w.Flush(ctx))
+
+	db.DeferBatchItems(150)
+	db.throttles = 2
+	records, err := BatchGet[batchRecord](ctx, db, table, batchKeys(250), fast, WithConsistentRead())
+	assert.Nil(t, err)
+	assert.Len(t, records, 230)
+	sort.Slice(records, func(i, j int) bool { return records[i].Value < records[j].Value })
+	for i, r := range records {
+		assert.Equal(t, i, r.Value)
+	}
+
+	t.Run("delete", func(t *testing.T) {
+		w := NewBatchWriter(db, table, fast)
+		for _, key := range batchKeys(100) {
+			assert.Nil(t, w.Delete(ctx, key))
+		}
+		assert.Nil(t, w.Flush(ctx))
+
+		items, err := BatchGetItems(ctx, db, table, batchKeys(230), fast)
+		assert.Nil(t, err)
+		assert.Len(t, items, 130)
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		db.DeferBatchItems(1000)
+		defer db.DeferBatchItems(0)
+		_, err := BatchGetItems(ctx, db, table, batchKeys(10), fast, WithBatchAttempts(3))
+		assert.EqualError(t, err, "unable to batch get from batch: 10 keys unprocessed after 3 attempts")
+
+		w := NewBatchWriter(db, table, fast, WithBatchAttempts(2))
+		assert.Nil(t, w.Put(ctx, batchRecord{ID: "x"}))
+		assert.EqualError(t, w.Flush(ctx), "unable to batch write to batch: 1 items unprocessed after 2 attempts")
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := BatchGetItems(ctx, db, table, batchKeys(10))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("aliases", func(t *testing.T) {
+		type renamed struct {
+			ID     string `dynamodbav:"pk"`
+			Amount int    `dynamodbav:"amount" ddbalias:"value"`
+		}
+		records, err := BatchGet[renamed](ctx, db, table, batchKeys(230)[200:], fast)
+		assert.Nil(t, err)
+		assert.Len(t, records, 30)
+		for _, r := range records {
+			assert.Equal(t, r.ID, fmt.Sprint(r.Amount))
+		}
+	})
+}
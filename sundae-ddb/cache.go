@@ -0,0 +1,502 @@
+package sundaeddb
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	DefaultCacheSize = 10000
+	DefaultCacheTTL  = time.Minute
+)
+
+const (
+	CacheHitMetric      sundaecli.MetricName = "DDBCacheHit"
+	CacheMissMetric     sundaecli.MetricName = "DDBCacheMiss"
+	CacheEvictionMetric sundaecli.MetricName = "DDBCacheEviction"
+
+	TableDimension sundaecli.DimensionName = "Table"
+)
+
+// CacheStats counts the reads a CachedAPI served since it was created
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	// Items is the number of items cached right now
+	Items int
+}
+
+type cacheKey struct {
+	table string
+	key   string
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	item    map[string]*dynamodb.AttributeValue // nil if the item doesn't exist
+	expires time.Time
+}
+
+// CachedAPI wraps a DynamoDBAPI with an in-process read-through cache of
+// GetItem and BatchGetItem, bounded in size (least recently used items go
+// first) and age. Items that don't exist are cached too.
+//
+// Reads bypass the cache if they're strongly consistent or project
+// attributes. Puts, updates and deletes made through the same CachedAPI,
+// singly, in batches or in transactions, drop the items they write; writes
+// from anywhere else (other processes, PartiQL, the XxxRequest methods) are
+// only seen once cached items expire.
+type CachedAPI struct {
+	dynamodbiface.DynamoDBAPI
+
+	tables  map[string]bool
+	size    int
+	ttl     time.Duration
+	metrics sundaecli.Metrics
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List // front is the most recently used
+	// keyNames remembers the key attributes of each table, as seen in reads,
+	// to find the cached item a put replaces
+	keyNames map[string][]string
+	// generations counts the invalidations of each table, so a read that
+	// raced with a write doesn't cache what it read
+	generations map[string]uint64
+	stats       CacheStats
+}
+
+type CacheOption func(*CachedAPI)
+
+// WithCacheTables limits caching to the named tables; every table is cached
+// if unset
+func WithCacheTables(tableNames ...string) CacheOption {
+	return func(c *CachedAPI) {
+		for _, name := range tableNames {
+			c.tables[name] = true
+		}
+	}
+}
+
+// WithCacheSize sets how many items are cached, across all tables;
+// DefaultCacheSize if unset
+func WithCacheSize(n int) CacheOption {
+	return func(c *CachedAPI) {
+		if n > 0 {
+			c.size = n
+		}
+	}
+}
+
+// WithCacheTTL sets how long an item is cached; DefaultCacheTTL if unset
+func WithCacheTTL(ttl time.Duration) CacheOption {
+	return func(c *CachedAPI) {
+		if ttl > 0 {
+			c.ttl = ttl
+		}
+	}
+}
+
+// WithCacheMetrics counts hits, misses and evictions as the DDBCacheHit,
+// DDBCacheMiss and DDBCacheEviction counters, by table
+func WithCacheMetrics(metrics sundaecli.Metrics) CacheOption {
+	return func(c *CachedAPI) {
+		c.metrics = metrics
+	}
+}
+
+func withCacheClock(now func() time.Time) CacheOption {
+	return func(c *CachedAPI) {
+		c.now = now
+	}
+}
+
+func NewCachedAPI(api dynamodbiface.DynamoDBAPI, opts ...CacheOption) *CachedAPI {
+	c := &CachedAPI{
+		DynamoDBAPI: api,
+		tables:      map[string]bool{},
+		size:        DefaultCacheSize,
+		ttl:         DefaultCacheTTL,
+		metrics:     sundaecli.NopMetrics{},
+		now:         time.Now,
+		entries:     map[cacheKey]*list.Element{},
+		lru:         list.New(),
+		keyNames:    map[string][]string{},
+		generations: map[string]uint64{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Stats returns the hits, misses and evictions so far
+func (c *CachedAPI) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Items = c.lru.Len()
+	return stats
+}
+
+func (c *CachedAPI) cached(tableName string) bool {
+	return len(c.tables) == 0 || c.tables[tableName]
+}
+
+// encodeKey renders the key attributes of item in a canonical form, or
+// reports false if item lacks one of them
+func encodeKey(item map[string]*dynamodb.AttributeValue, names []string) (string, bool) {
+	var b strings.Builder
+	for _, name := range names {
+		v, ok := item[name]
+		if !ok || v == nil {
+			return "", false
+		}
+		var value string
+		switch {
+		case v.S != nil:
+			value = "S" + *v.S
+		case v.N != nil:
+			value = "N" + *v.N
+		case v.B != nil:
+			value = "B" + string(v.B)
+		default:
+			return "", false
+		}
+		fmt.Fprintf(&b, "%d:%s%d:%s", len(name), name, len(value), value)
+	}
+	return b.String(), true
+}
+
+// keyOf returns the cache key of a key, learning the table's key attributes
+func (c *CachedAPI) keyOf(tableName string, key map[string]*dynamodb.AttributeValue) (cacheKey, bool) {
+	names := make([]string, 0, len(key))
+	for name := range key {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	c.mu.Lock()
+	if _, ok := c.keyNames[tableName]; !ok {
+		c.keyNames[tableName] = names
+	}
+	c.mu.Unlock()
+
+	k, ok := encodeKey(key, names)
+	return cacheKey{table: tableName, key: k}, ok
+}
+
+// lookup returns the cached item for key, if there's one that hasn't expired
+func (c *CachedAPI) lookup(ctx context.Context, key cacheKey) (map[string]*dynamodb.AttributeValue, bool) {
+	c.mu.Lock()
+	var (
+		item map[string]*dynamodb.AttributeValue
+		hit  bool
+	)
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*cacheEntry)
+		if c.now().Before(entry.expires) {
+			c.lru.MoveToFront(e)
+			item, hit = entry.item, true
+		} else {
+			c.remove(e)
+		}
+	}
+	if hit {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+	c.mu.Unlock()
+
+	dimensions := map[sundaecli.DimensionName]string{TableDimension: key.table}
+	if hit {
+		c.metrics.Counter(ctx, CacheHitMetric, 1, sundaecli.UnitCount, dimensions)
+	} else {
+		c.metrics.Counter(ctx, CacheMissMetric, 1, sundaecli.UnitCount, dimensions)
+	}
+	return copyItem(item), hit
+}
+
+// store caches items read when their tables were at generations, unless
+// they've been written since
+func (c *CachedAPI) store(ctx context.Context, generations map[string]uint64, items map[cacheKey]map[string]*dynamodb.AttributeValue) {
+	evicted := map[string]int{}
+	c.mu.Lock()
+	expires := c.now().Add(c.ttl)
+	for key, item := range items {
+		if generations[key.table] != c.generations[key.table] {
+			continue
+		}
+		if e, ok := c.entries[key]; ok {
+			c.remove(e)
+		}
+		c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, item: copyItem(item), expires: expires})
+		for c.lru.Len() > c.size {
+			oldest := c.lru.Back()
+			evicted[oldest.Value.(*cacheEntry).key.table]++
+			c.remove(oldest)
+			c.stats.Evictions++
+		}
+	}
+	c.mu.Unlock()
+
+	for table, n := range evicted {
+		c.metrics.Counter(ctx, CacheEvictionMetric, float64(n), sundaecli.UnitCount, map[sundaecli.DimensionName]string{TableDimension: table})
+	}
+}
+
+func (c *CachedAPI) currentGenerations(tableNames ...string) map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	generations := make(map[string]uint64, len(tableNames))
+	for _, name := range tableNames {
+		generations[name] = c.generations[name]
+	}
+	return generations
+}
+
+// remove drops e; c.mu must be held
+func (c *CachedAPI) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*cacheEntry).key)
+}
+
+// invalidate drops the cached item with the key of item, which may be a key
+// or a whole item. If the table's key attributes aren't known yet, every item
+// of the table goes.
+func (c *CachedAPI) invalidate(tableName string, item map[string]*dynamodb.AttributeValue) {
+	if !c.cached(tableName) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generations[tableName]++
+
+	if names, ok := c.keyNames[tableName]; ok {
+		if k, ok := encodeKey(item, names); ok {
+			if e, ok := c.entries[cacheKey{table: tableName, key: k}]; ok {
+				c.remove(e)
+			}
+			return
+		}
+	}
+	for key, e := range c.entries {
+		if key.table == tableName {
+			c.remove(e)
+		}
+	}
+}
+
+// copyItem copies the top level of item, so callers can't change what's
+// cached
+func copyItem(item map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	if item == nil {
+		return nil
+	}
+	cp := make(map[string]*dynamodb.AttributeValue, len(item))
+	for k, v := range item {
+		cp[k] = v
+	}
+	return cp
+}
+
+func (c *CachedAPI) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return c.GetItemWithContext(context.Background(), input)
+}
+
+func (c *CachedAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	tableName := aws.StringValue(input.TableName)
+	if !c.cached(tableName) || aws.BoolValue(input.ConsistentRead) || input.ProjectionExpression != nil || len(input.AttributesToGet) > 0 {
+		return c.DynamoDBAPI.GetItemWithContext(ctx, input, opts...)
+	}
+	key, ok := c.keyOf(tableName, input.Key)
+	if !ok {
+		return c.DynamoDBAPI.GetItemWithContext(ctx, input, opts...)
+	}
+	if item, hit := c.lookup(ctx, key); hit {
+		return &dynamodb.GetItemOutput{Item: item}, nil
+	}
+
+	generations := c.currentGenerations(tableName)
+	out, err := c.DynamoDBAPI.GetItemWithContext(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.store(ctx, generations, map[cacheKey]map[string]*dynamodb.AttributeValue{key: out.Item})
+	return out, nil
+}
+
+func (c *CachedAPI) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	return c.BatchGetItemWithContext(context.Background(), input)
+}
+
+// BatchGetItemWithContext serves the keys it can from the cache and requests
+// the rest; if every key is cached, DynamoDB isn't called at all
+func (c *CachedAPI) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, opts ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	var (
+		hits      = map[string][]map[string]*dynamodb.AttributeValue{}
+		requested = map[string]map[cacheKey]bool{} // by table, the missed keys requested
+		remaining = map[string]*dynamodb.KeysAndAttributes{}
+	)
+	for tableName, ka := range input.RequestItems {
+		if ka == nil || !c.cached(tableName) || aws.BoolValue(ka.ConsistentRead) || ka.ProjectionExpression != nil || len(ka.AttributesToGet) > 0 {
+			remaining[tableName] = ka
+			continue
+		}
+		var missed []map[string]*dynamodb.AttributeValue
+		for _, k := range ka.Keys {
+			key, ok := c.keyOf(tableName, k)
+			if !ok {
+				missed = append(missed, k)
+				continue
+			}
+			item, hit := c.lookup(ctx, key)
+			if !hit {
+				if requested[tableName] == nil {
+					requested[tableName] = map[cacheKey]bool{}
+				}
+				requested[tableName][key] = true
+				missed = append(missed, k)
+			} else if item != nil {
+				hits[tableName] = append(hits[tableName], item)
+			}
+		}
+		if len(missed) > 0 {
+			rest := *ka
+			rest.Keys = missed
+			remaining[tableName] = &rest
+		}
+	}
+
+	out := &dynamodb.BatchGetItemOutput{Responses: map[string][]map[string]*dynamodb.AttributeValue{}}
+	if len(remaining) > 0 {
+		var tableNames []string
+		for tableName := range requested {
+			tableNames = append(tableNames, tableName)
+		}
+		generations := c.currentGenerations(tableNames...)
+		rest := *input
+		rest.RequestItems = remaining
+		var err error
+		out, err = c.DynamoDBAPI.BatchGetItemWithContext(ctx, &rest, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if out.Responses == nil {
+			out.Responses = map[string][]map[string]*dynamodb.AttributeValue{}
+		}
+
+		found := map[cacheKey]map[string]*dynamodb.AttributeValue{}
+		for tableName, keys := range requested {
+			c.mu.Lock()
+			names := c.keyNames[tableName]
+			c.mu.Unlock()
+			for _, item := range out.Responses[tableName] {
+				if k, ok := encodeKey(item, names); ok && keys[cacheKey{table: tableName, key: k}] {
+					found[cacheKey{table: tableName, key: k}] = item
+				}
+			}
+			// keys neither returned nor left unprocessed don't exist
+			unprocessed := map[cacheKey]bool{}
+			if ka := out.UnprocessedKeys[tableName]; ka != nil {
+				for _, k := range ka.Keys {
+					if s, ok := encodeKey(k, names); ok {
+						unprocessed[cacheKey{table: tableName, key: s}] = true
+					}
+				}
+			}
+			for key := range keys {
+				if _, ok := found[key]; !ok && !unprocessed[key] {
+					found[key] = nil
+				}
+			}
+		}
+		c.store(ctx, generations, found)
+	}
+
+	for tableName, items := range hits {
+		out.Responses[tableName] = append(out.Responses[tableName], items...)
+	}
+	return out, nil
+}
+
+func (c *CachedAPI) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	return c.PutItemWithContext(context.Background(), input)
+}
+
+func (c *CachedAPI) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	defer c.invalidate(aws.StringValue(input.TableName), input.Item)
+	return c.DynamoDBAPI.PutItemWithContext(ctx, input, opts...)
+}
+
+func (c *CachedAPI) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	return c.UpdateItemWithContext(context.Background(), input)
+}
+
+func (c *CachedAPI) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	defer c.invalidate(aws.StringValue(input.TableName), input.Key)
+	return c.DynamoDBAPI.UpdateItemWithContext(ctx, input, opts...)
+}
+
+func (c *CachedAPI) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	return c.DeleteItemWithContext(context.Background(), input)
+}
+
+func (c *CachedAPI) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	defer c.invalidate(aws.StringValue(input.TableName), input.Key)
+	return c.DynamoDBAPI.DeleteItemWithContext(ctx, input, opts...)
+}
+
+func (c *CachedAPI) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	return c.BatchWriteItemWithContext(context.Background(), input)
+}
+
+func (c *CachedAPI) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	defer func() {
+		for tableName, requests := range input.RequestItems {
+			for _, r := range requests {
+				switch {
+				case r.PutRequest != nil:
+					c.invalidate(tableName, r.PutRequest.Item)
+				case r.DeleteRequest != nil:
+					c.invalidate(tableName, r.DeleteRequest.Key)
+				}
+			}
+		}
+	}()
+	return c.DynamoDBAPI.BatchWriteItemWithContext(ctx, input, opts...)
+}
+
+func (c *CachedAPI) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	return c.TransactWriteItemsWithContext(context.Background(), input)
+}
+
+func (c *CachedAPI) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	defer func() {
+		for _, item := range input.TransactItems {
+			switch {
+			case item.Put != nil:
+				c.invalidate(aws.StringValue(item.Put.TableName), item.Put.Item)
+			case item.Update != nil:
+				c.invalidate(aws.StringValue(item.Update.TableName), item.Update.Key)
+			case item.Delete != nil:
+				c.invalidate(aws.StringValue(item.Delete.TableName), item.Delete.Key)
+			}
+		}
+	}()
+	return c.DynamoDBAPI.TransactWriteItemsWithContext(ctx, input, opts...)
+}
@@ -0,0 +1,136 @@
+package sundaeddb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ddb/memddb"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/savaki/ddb"
+	"github.com/tj/assert"
+)
+
+// countingDB counts the reads that reach DynamoDB
+type countingDB struct {
+	*memddb.DB
+	gets      int
+	batchKeys int
+}
+
+func (c *countingDB) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	c.gets++
+	return c.DB.GetItemWithContext(ctx, input, opts...)
+}
+
+func (c *countingDB) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, opts ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	for _, ka := range input.RequestItems {
+		c.batchKeys += len(ka.Keys)
+	}
+	return c.DB.BatchGetItemWithContext(ctx, input, opts...)
+}
+
+func TestCachedAPI(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		db    = &countingDB{DB: memddb.New()}
+		now   = time.Unix(1000, 0)
+		cache = NewCachedAPI(db,
+			WithCacheTables("cached"),
+			WithCacheSize(3),
+			WithCacheTTL(time.Minute),
+			withCacheClock(func() time.Time { return now }),
+		)
+	)
+	for _, name := range []string{"cached", "other"} {
+		assert.Nil(t, ddb.New(db).MustTable(name, batchRecord{}).CreateTableIfNotExists(ctx))
+		for i := 0; i < 5; i++ {
+			assert.Nil(t, ddb.New(db).MustTable(name, batchRecord{}).Put(batchRecord{ID: fmt.Sprint(i), Value: i}).RunWithContext(ctx))
+		}
+	}
+	table := ddb.New(cache).MustTable("cached", batchRecord{})
+	get := func(id string) (batchRecord, error) {
+		var r batchRecord
+		err := table.Get(id).ScanWithContext(ctx, &r)
+		return r, err
+	}
+
+	r, err := get("1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, r.Value)
+	r, err = get("1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, r.Value)
+	assert.Equal(t, 1, db.gets)
+
+	// Items that don't exist are cached too
+	_, err = get("missing")
+	assert.True(t, ddb.IsItemNotFoundError(err))
+	_, err = get("missing")
+	assert.True(t, ddb.IsItemNotFoundError(err))
+	assert.Equal(t, 2, db.gets)
+
+	t.Run("bypass", func(t *testing.T) {
+		db.gets = 0
+		var r batchRecord
+		assert.Nil(t, table.Get("1").ConsistentRead(true).ScanWithContext(ctx, &r))
+		assert.Nil(t, ddb.New(cache).MustTable("other", batchRecord{}).Get("1").ScanWithContext(ctx, &r))
+		assert.Nil(t, ddb.New(cache).MustTable("other", batchRecord{}).Get("1").ScanWithContext(ctx, &r))
+		assert.Equal(t, 3, db.gets)
+	})
+
+	t.Run("invalidate", func(t *testing.T) {
+		db.gets = 0
+		assert.Nil(t, table.Put(batchRecord{ID: "1", Value: 10}).RunWithContext(ctx))
+		r, err := get("1")
+		assert.Nil(t, err)
+		assert.Equal(t, 10, r.Value)
+
+		assert.Nil(t, table.Update("1").Set("#value = ?", 11).RunWithContext(ctx))
+		r, err = get("1")
+		assert.Nil(t, err)
+		assert.Equal(t, 11, r.Value)
+
+		w := NewBatchWriter(cache, "cached")
+		assert.Nil(t, w.Delete(ctx, map[string]*dynamodb.AttributeValue{"pk": {S: aws.String("1")}}))
+		assert.Nil(t, w.Flush(ctx))
+		_, err = get("1")
+		assert.True(t, ddb.IsItemNotFoundError(err))
+
+		assert.Nil(t, table.Put(batchRecord{ID: "1", Value: 1}).RunWithContext(ctx))
+		_, err = get("1")
+		assert.Nil(t, err)
+		assert.Equal(t, 4, db.gets)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		db.batchKeys = 0
+		// 1 is cached; 0, 2 and 3 are read, evicting missing and then 1
+		records, err := BatchGet[batchRecord](ctx, cache, "cached", batchKeys(4))
+		assert.Nil(t, err)
+		assert.Len(t, records, 4)
+		assert.Equal(t, 3, db.batchKeys)
+
+		records, err = BatchGet[batchRecord](ctx, cache, "cached", batchKeys(4)[2:])
+		assert.Nil(t, err)
+		sort.Slice(records, func(i, j int) bool { return records[i].Value < records[j].Value })
+		assert.Equal(t, []batchRecord{{ID: "2", Value: 2}, {ID: "3", Value: 3}}, records)
+		assert.Equal(t, 3, db.batchKeys)
+
+		stats := cache.Stats()
+		assert.Equal(t, 3, stats.Items)
+		assert.Equal(t, int64(2), stats.Evictions)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		db.gets = 0
+		now = now.Add(time.Minute)
+		_, err := get("3")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, db.gets)
+	})
+}
@@ -0,0 +1,202 @@
+package sundaeddb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/savaki/ddb"
+)
+
+// finishedCheckpointTTL is how long the checkpoint of a closed shard is kept;
+// streams drop shards 24 hours after they close, after which the checkpoint is
+// no longer needed
+const finishedCheckpointTTL = 48 * time.Hour
+
+func init() {
+	sundaecli.RegisterTable("checkpoints", CheckpointTableName, sundaecli.WithTableModel(checkpointRecord{}), sundaecli.WithTableTTL("ttl"), sundaecli.WithTableOptional())
+}
+
+func CheckpointTableName(env string) string {
+	return env + "-sundae-ddb--checkpoints"
+}
+
+// Checkpoint is how far a consumer has read a stream shard
+type Checkpoint struct {
+	StreamArn string `json:"stream"`
+	ShardID   string `json:"shard"`
+	// SequenceNumber is the last record handled; reading resumes after it
+	SequenceNumber string `json:"sequence,omitempty"`
+	// Finished is set once the shard has closed and every record in it was
+	// handled
+	Finished  bool      `json:"finished,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CheckpointStore persists Checkpoints between runs of a StreamReader
+type CheckpointStore interface {
+	// LoadCheckpoint returns false if there's no checkpoint for the shard
+	LoadCheckpoint(ctx context.Context, streamArn, shardID string) (Checkpoint, bool, error)
+	SaveCheckpoint(ctx context.Context, c Checkpoint) error
+}
+
+type checkpointRecord struct {
+	// Consumer is the consumer name and stream arn, so several consumers of
+	// one stream can share the table
+	Consumer       string `dynamodbav:"consumer" ddb:"hash"`
+	ShardID        string `dynamodbav:"shard" ddb:"range"`
+	StreamArn      string `dynamodbav:"stream"`
+	SequenceNumber string `dynamodbav:"sequence,omitempty"`
+	Finished       bool   `dynamodbav:"finished,omitempty"`
+	UpdatedAt      int64  `dynamodbav:"updated_at"`
+	TTL            int64  `dynamodbav:"ttl,omitempty"`
+}
+
+// DDBCheckpointStore keeps checkpoints in a DynamoDB table, keyed by consumer
+type DDBCheckpointStore struct {
+	consumer string
+	table    *ddb.Table
+}
+
+// NewDDBCheckpointStore stores the checkpoints of consumer, usually the
+// service name, in tableName
+func NewDDBCheckpointStore(api dynamodbiface.DynamoDBAPI, tableName, consumer string) *DDBCheckpointStore {
+	return &DDBCheckpointStore{
+		consumer: consumer,
+		table:    ddb.New(api).MustTable(tableName, checkpointRecord{}),
+	}
+}
+
+func (s *DDBCheckpointStore) key(streamArn string) string {
+	return s.consumer + "|" + streamArn
+}
+
+func (s *DDBCheckpointStore) LoadCheckpoint(ctx context.Context, streamArn, shardID string) (Checkpoint, bool, error) {
+	var r checkpointRecord
+	if err := s.table.Get(s.key(streamArn)).Range(shardID).ConsistentRead(true).ScanWithContext(ctx, &r); err != nil {
+		if ddb.IsItemNotFoundError(err) {
+			return Checkpoint{}, false, nil
+		}
+		return Checkpoint{}, false, fmt.Errorf("unable to load checkpoint for shard %v: %w", shardID, err)
+	}
+	return Checkpoint{
+		StreamArn:      r.StreamArn,
+		ShardID:        r.ShardID,
+		SequenceNumber: r.SequenceNumber,
+		Finished:       r.Finished,
+		UpdatedAt:      time.Unix(r.UpdatedAt, 0),
+	}, true, nil
+}
+
+func (s *DDBCheckpointStore) SaveCheckpoint(ctx context.Context, c Checkpoint) error {
+	r := checkpointRecord{
+		Consumer:       s.key(c.StreamArn),
+		ShardID:        c.ShardID,
+		StreamArn:      c.StreamArn,
+		SequenceNumber: c.SequenceNumber,
+		Finished:       c.Finished,
+		UpdatedAt:      c.UpdatedAt.Unix(),
+	}
+	if c.Finished {
+		r.TTL = c.UpdatedAt.Add(finishedCheckpointTTL).Unix()
+	}
+	if err := s.table.Put(r).RunWithContext(ctx); err != nil {
+		return fmt.Errorf("unable to save checkpoint for shard %v: %w", c.ShardID, err)
+	}
+	return nil
+}
+
+// FileCheckpointStore keeps checkpoints in a local JSON file, for processes
+// with a persistent disk
+type FileCheckpointStore struct {
+	path string
+
+	mu          sync.Mutex
+	checkpoints map[string]Checkpoint
+}
+
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{path: path}
+}
+
+func fileCheckpointKey(streamArn, shardID string) string {
+	return streamArn + "|" + shardID
+}
+
+// load must be called with s.mu held
+func (s *FileCheckpointStore) load() error {
+	if s.checkpoints != nil {
+		return nil
+	}
+	s.checkpoints = map[string]Checkpoint{}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to read checkpoint file %v: %w", s.path, err)
+	}
+	var list []Checkpoint
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("unable to parse checkpoint file %v: %w", s.path, err)
+	}
+	for _, c := range list {
+		s.checkpoints[fileCheckpointKey(c.StreamArn, c.ShardID)] = c
+	}
+	return nil
+}
+
+func (s *FileCheckpointStore) LoadCheckpoint(_ context.Context, streamArn, shardID string) (Checkpoint, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return Checkpoint{}, false, err
+	}
+	c, ok := s.checkpoints[fileCheckpointKey(streamArn, shardID)]
+	return c, ok, nil
+}
+
+// SaveCheckpoint rewrites the whole file, via a rename so a crash can't leave
+// it truncated
+func (s *FileCheckpointStore) SaveCheckpoint(_ context.Context, c Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.checkpoints[fileCheckpointKey(c.StreamArn, c.ShardID)] = c
+
+	list := make([]Checkpoint, 0, len(s.checkpoints))
+	for _, c := range s.checkpoints {
+		if c.Finished && time.Since(c.UpdatedAt) > finishedCheckpointTTL {
+			continue
+		}
+		list = append(list, c)
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal checkpoints: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to write checkpoint file %v: %w", s.path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write checkpoint file %v: %w", s.path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write checkpoint file %v: %w", s.path, err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("unable to write checkpoint file %v: %w", s.path, err)
+	}
+	return nil
+}
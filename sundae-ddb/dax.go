@@ -15,19 +15,35 @@ type DAXWrapper struct {
 	*dax.Dax
 }
 
-func DynamoDBAPI(s *session.Session) (dynamodbiface.DynamoDBAPI, error) {
+// DynamoDBAPI connects to --dax-cluster if set, in the region of s, or else
+// DynamoDB. Reads of each --ddb-cache-table are then cached in process by a
+// CachedAPI, built with opts after the --ddb-cache-size and --ddb-cache-ttl
+// flags. The cache counts hits and misses only if opts include
+// WithCacheMetrics.
+func DynamoDBAPI(s *session.Session, opts ...CacheOption) (dynamodbiface.DynamoDBAPI, error) {
+	var api dynamodbiface.DynamoDBAPI
 	if DDBOpts.DAXCluster != "" {
 		config := dax.DefaultConfig()
 		config.HostPorts = []string{DDBOpts.DAXCluster}
-		config.Region = "us-east-2"
+		config.Region = aws.StringValue(s.Config.Region)
 		daxClient, err := dax.New(config)
 		if err != nil {
 			return nil, err
 		}
-		return DAXWrapper{Dax: daxClient}, nil
+		api = DAXWrapper{Dax: daxClient}
 	} else {
-		return dynamodb.New(s), nil
+		api = dynamodb.New(s)
 	}
+
+	if tables := DDBOpts.CacheTables.Value(); len(tables) > 0 {
+		opts = append([]CacheOption{
+			WithCacheTables(tables...),
+			WithCacheSize(DDBOpts.CacheSize),
+			WithCacheTTL(DDBOpts.CacheTTL),
+		}, opts...)
+		api = NewCachedAPI(api, opts...)
+	}
+	return api, nil
 }
 
 // These methods aren't implemented by the DAX library, meaning we can't use it as dynamodbiface
@@ -0,0 +1,245 @@
+package sundaeddb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Unmarshal decodes av into v, a pointer, like dynamodbattribute.Unmarshal,
+// but tolerating records written under older names and encodings, as
+// declared by two struct tags:
+//
+//	ddbalias:"outputCoin,coin"   other names the attribute was stored under;
+//	                             the dynamodbav name wins if present
+//	ddbformat:"number"           a number stored as N, S, or serde_dynamo's
+//	                             {"int": N or S}; decoded as N into numeric
+//	                             fields and as S into anything else
+//	ddbformat:"unwrap=key"       a value stored bare, or as the key attribute
+//	                             of a map
+//
+// Tags apply at any depth, through nested structs, slices and maps, except
+// below a type that implements dynamodbattribute.Unmarshaler, which decodes
+// its value itself (with formats already applied). Such a type can use
+// Unmarshal on a method-less copy of itself to get the same treatment.
+func Unmarshal(av *dynamodb.AttributeValue, v interface{}) error {
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return fmt.Errorf("unable to unmarshal into non-pointer %T", v)
+	}
+	normalized, err := normalize(av, t.Elem())
+	if err != nil {
+		return err
+	}
+	if normalized == nil {
+		return nil
+	}
+	return dynamodbattribute.Unmarshal(normalized, v)
+}
+
+// UnmarshalMap is Unmarshal for an item
+func UnmarshalMap(item map[string]*dynamodb.AttributeValue, v interface{}) error {
+	return Unmarshal(&dynamodb.AttributeValue{M: item}, v)
+}
+
+// decodeField is a struct field as Unmarshal sees it
+type decodeField struct {
+	name    string
+	aliases []string
+	format  string
+	unwrap  string // for ddbformat:"unwrap=key"
+	typ     reflect.Type
+}
+
+var decodeFields sync.Map // reflect.Type => []decodeField
+
+var unmarshalerType = reflect.TypeOf((*dynamodbattribute.Unmarshaler)(nil)).Elem()
+
+// fieldsOf parses the dynamodbav (or json), ddbalias and ddbformat tags of
+// a struct type, flattening embedded structs as dynamodbattribute does
+func fieldsOf(t reflect.Type) ([]decodeField, error) {
+	if v, ok := decodeFields.Load(t); ok {
+		return v.([]decodeField), nil
+	}
+
+	var fields []decodeField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("dynamodbav")
+		if tag == "" {
+			// dynamodbattribute falls back to json tags
+			tag = f.Tag.Get("json")
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" && f.Anonymous {
+			embedded := f.Type
+			for embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				inner, err := fieldsOf(embedded)
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, inner...)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		field := decodeField{name: name, typ: f.Type}
+		if aliases := f.Tag.Get("ddbalias"); aliases != "" {
+			field.aliases = strings.Split(aliases, ",")
+		}
+		switch format := f.Tag.Get("ddbformat"); {
+		case format == "":
+		case format == "number":
+			field.format = format
+		case strings.HasPrefix(format, "unwrap="):
+			field.format = "unwrap"
+			field.unwrap = strings.TrimPrefix(format, "unwrap=")
+		default:
+			return nil, fmt.Errorf("unknown ddbformat %q on %v.%v", format, t, f.Name)
+		}
+		fields = append(fields, field)
+	}
+
+	decodeFields.Store(t, fields)
+	return fields, nil
+}
+
+// normalize rewrites av, meant for a t, so dynamodbattribute can decode it:
+// aliased attributes take their current names and formats are undone
+func normalize(av *dynamodb.AttributeValue, t reflect.Type) (*dynamodb.AttributeValue, error) {
+	if av == nil {
+		return nil, nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Implements(unmarshalerType) || reflect.PtrTo(t).Implements(unmarshalerType) {
+		return av, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if av.M == nil {
+			return av, nil
+		}
+		fields, err := fieldsOf(t)
+		if err != nil {
+			return nil, err
+		}
+		item := make(map[string]*dynamodb.AttributeValue, len(av.M))
+		for k, v := range av.M {
+			item[k] = v
+		}
+		for _, f := range fields {
+			value, ok := item[f.name]
+			for _, alias := range f.aliases {
+				if ok {
+					break
+				}
+				value, ok = item[alias]
+			}
+			if !ok {
+				continue
+			}
+			if value, err = format(value, f); err != nil {
+				return nil, fmt.Errorf("%v.%v: %w", t, f.name, err)
+			}
+			if value, err = normalize(value, f.typ); err != nil {
+				return nil, err
+			}
+			if value == nil {
+				delete(item, f.name)
+				continue
+			}
+			item[f.name] = value
+		}
+		return &dynamodb.AttributeValue{M: item}, nil
+
+	case reflect.Slice, reflect.Array:
+		if av.L == nil {
+			return av, nil
+		}
+		list := make([]*dynamodb.AttributeValue, len(av.L))
+		for i, v := range av.L {
+			var err error
+			if list[i], err = normalize(v, t.Elem()); err != nil {
+				return nil, err
+			}
+		}
+		return &dynamodb.AttributeValue{L: list}, nil
+
+	case reflect.Map:
+		if av.M == nil {
+			return av, nil
+		}
+		item := make(map[string]*dynamodb.AttributeValue, len(av.M))
+		for k, v := range av.M {
+			var err error
+			if item[k], err = normalize(v, t.Elem()); err != nil {
+				return nil, err
+			}
+		}
+		return &dynamodb.AttributeValue{M: item}, nil
+	}
+	return av, nil
+}
+
+// format undoes the ddbformat of f on av
+func format(av *dynamodb.AttributeValue, f decodeField) (*dynamodb.AttributeValue, error) {
+	if av == nil || aws.BoolValue(av.NULL) {
+		return av, nil
+	}
+	switch f.format {
+	case "number":
+		if av.M != nil {
+			// serde_dynamo serializes Rust numeric types as {"int": {"S": "..."}} or {"int": {"N": "..."}}
+			av = av.M["int"]
+			if av == nil {
+				return nil, fmt.Errorf("expected a number, got a map without an int")
+			}
+		}
+		var n string
+		switch {
+		case av.N != nil:
+			n = *av.N
+		case av.S != nil:
+			n = *av.S
+		default:
+			return nil, fmt.Errorf("expected a number, got %v", av)
+		}
+		t := f.typ
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			return &dynamodb.AttributeValue{N: aws.String(n)}, nil
+		}
+		return &dynamodb.AttributeValue{S: aws.String(n)}, nil
+
+	case "unwrap":
+		if av.M != nil {
+			return av.M[f.unwrap], nil
+		}
+	}
+	return av, nil
+}
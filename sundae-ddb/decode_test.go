@@ -0,0 +1,130 @@
+package sundaeddb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/tj/assert"
+)
+
+type decodeLine struct {
+	Qty   int64  `dynamodbav:"qty" ddbformat:"number"`
+	Price string `dynamodbav:"price" ddbalias:"unitPrice,unit_price" ddbformat:"number"`
+}
+
+type decodeMeta struct {
+	Note string `dynamodbav:"note" ddbformat:"unwrap=text"`
+}
+
+type decodeOrder struct {
+	decodeMeta
+	ID     string                `dynamodbav:"pk"`
+	Lines  []decodeLine          `dynamodbav:"lines" ddbalias:"items"`
+	ByName map[string]decodeLine `dynamodbav:"by_name"`
+	Owner  *string               `dynamodbav:"owner" ddbalias:"ownerId"`
+}
+
+func TestUnmarshal(t *testing.T) {
+	s := aws.String
+	item := map[string]*dynamodb.AttributeValue{
+		"pk": {S: s("order#1")},
+		"items": {L: []*dynamodb.AttributeValue{
+			{M: map[string]*dynamodb.AttributeValue{"qty": {S: s("2")}, "unitPrice": {N: s("1.5")}}},
+			{M: map[string]*dynamodb.AttributeValue{"qty": {M: map[string]*dynamodb.AttributeValue{"int": {N: s("3")}}}, "unit_price": {S: s("7")}}},
+		}},
+		"by_name": {M: map[string]*dynamodb.AttributeValue{
+			"a": {M: map[string]*dynamodb.AttributeValue{"qty": {N: s("1")}, "price": {N: s("9")}, "unitPrice": {N: s("0")}}},
+		}},
+		"ownerId": {S: s("alice")},
+		"note":    {M: map[string]*dynamodb.AttributeValue{"text": {S: s("hi")}}},
+	}
+
+	var order decodeOrder
+	assert.Nil(t, UnmarshalMap(item, &order))
+	assert.Equal(t, decodeOrder{
+		decodeMeta: decodeMeta{Note: "hi"},
+		ID:         "order#1",
+		Lines:      []decodeLine{{Qty: 2, Price: "1.5"}, {Qty: 3, Price: "7"}},
+		ByName:     map[string]decodeLine{"a": {Qty: 1, Price: "9"}},
+		Owner:      aws.String("alice"),
+	}, order)
+
+	// The current encodings decode as they always did
+	item["note"] = &dynamodb.AttributeValue{S: s("bare")}
+	order = decodeOrder{}
+	assert.Nil(t, UnmarshalMap(item, &order))
+	assert.Equal(t, "bare", order.Note)
+
+	t.Run("errors", func(t *testing.T) {
+		item["items"].L[0].M["qty"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+		assert.NotNil(t, UnmarshalMap(item, &decodeOrder{}))
+
+		var bad struct {
+			X string `ddbformat:"base64"`
+		}
+		assert.EqualError(t, UnmarshalMap(map[string]*dynamodb.AttributeValue{}, &bad), `unknown ddbformat "base64" on struct { X string "ddbformat:\"base64\"" }.X`)
+		assert.NotNil(t, UnmarshalMap(item, decodeOrder{}))
+	})
+
+	t.Run("json tags", func(t *testing.T) {
+		var line struct {
+			Qty   int    `json:"qty"`
+			Price string `json:"price" ddbalias:"unitPrice" ddbformat:"number"`
+		}
+		assert.Nil(t, UnmarshalMap(map[string]*dynamodb.AttributeValue{"qty": {N: s("2")}, "unitPrice": {N: s("1.5")}}, &line))
+		assert.Equal(t, 2, line.Qty)
+		assert.Equal(t, "1.5", line.Price)
+	})
+}
+
+type schemaRecord struct {
+	ID     string `dynamodbav:"pk"`
+	Amount int64  `dynamodbav:"amount"`
+	Owner  string `dynamodbav:"owner"`
+}
+
+func TestSchema(t *testing.T) {
+	s := aws.String
+	schema := MustSchema[schemaRecord](2,
+		// v1 renamed value to amount
+		WithUpgrade(0, RenameAttribute("value", "amount")),
+		// v2 split the owner out of the key
+		WithUpgrade(1, func(item map[string]*dynamodb.AttributeValue) error {
+			var owner, id string
+			if _, err := fmt.Sscanf(aws.StringValue(item["pk"].S), "%s %s", &owner, &id); err != nil {
+				return err
+			}
+			item["pk"] = &dynamodb.AttributeValue{S: aws.String(id)}
+			item["owner"] = &dynamodb.AttributeValue{S: aws.String(owner)}
+			return nil
+		}),
+	)
+	want := schemaRecord{ID: "1", Amount: 5, Owner: "alice"}
+
+	v0 := map[string]*dynamodb.AttributeValue{"pk": {S: s("alice 1")}, "value": {N: s("5")}}
+	got, err := schema.Decode(v0)
+	assert.Nil(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, "alice 1", *v0["pk"].S, "the item is left as it was")
+
+	v1 := map[string]*dynamodb.AttributeValue{"pk": {S: s("alice 1")}, "amount": {N: s("5")}, "schema_version": {N: s("1")}}
+	got, err = schema.Decode(v1)
+	assert.Nil(t, err)
+	assert.Equal(t, want, got)
+
+	item, err := schema.Encode(want)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", *item["schema_version"].N)
+	got, err = schema.Decode(item)
+	assert.Nil(t, err)
+	assert.Equal(t, want, got)
+
+	item["schema_version"] = &dynamodb.AttributeValue{N: s("3")}
+	_, err = schema.Decode(item)
+	assert.EqualError(t, err, "record has schema version 3, newer than 2")
+
+	_, err = NewSchema[schemaRecord](2, WithUpgrade(0, RenameAttribute("a", "b")))
+	assert.EqualError(t, err, "no upgrade from version 1 to 2")
+}
@@ -0,0 +1,351 @@
+package sundaeddb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	sundaetrace "github.com/SundaeSwap-finance/sundae-go-utils/sundae-trace"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/savaki/ddb"
+)
+
+// The output formats of a table export
+const (
+	ExportFormatJSON = "DYNAMODB_JSON"
+	ExportFormatION  = "ION"
+)
+
+// ExportFiles reads the files of a table export, by their path relative to
+// the export's directory, e.g. "manifest-summary.json"
+type ExportFiles interface {
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// DirExportFiles reads an export copied to a local directory
+type DirExportFiles string
+
+func (d DirExportFiles) Open(_ context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), filepath.FromSlash(name)))
+}
+
+// S3ExportFiles reads an export where DynamoDB wrote it
+type S3ExportFiles struct {
+	api    s3iface.S3API
+	bucket string
+	prefix string
+}
+
+// NewS3ExportFiles reads the export under prefix, the directory holding
+// manifest-summary.json, e.g. "exports/AWSDynamoDB/01234567890123-abcdefgh"
+func NewS3ExportFiles(api s3iface.S3API, bucket, prefix string) *S3ExportFiles {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &S3ExportFiles{api: api, bucket: bucket, prefix: prefix}
+}
+
+func (s *S3ExportFiles) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	out, err := s.api.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// Export is a full export of a table to S3, as made by ExportTableToPointInTime
+type Export struct {
+	ID       string
+	TableArn string
+	Format   string
+	// ItemCount is the number of items the manifest claims
+	ItemCount int64
+
+	files     ExportFiles
+	dataFiles []string
+}
+
+type exportSummary struct {
+	ExportArn          string `json:"exportArn"`
+	TableArn           string `json:"tableArn"`
+	ManifestFilesS3Key string `json:"manifestFilesS3Key"`
+	ItemCount          int64  `json:"itemCount"`
+	OutputFormat       string `json:"outputFormat"`
+	ExportType         string `json:"exportType"`
+}
+
+type exportManifestFile struct {
+	DataFileS3Key string `json:"dataFileS3Key"`
+}
+
+// OpenExport reads the export at location: s3://bucket/prefix or a local
+// directory, either holding manifest-summary.json
+func OpenExport(ctx context.Context, location string) (*Export, error) {
+	if strings.HasPrefix(location, "s3://") {
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("invalid export location %v: missing bucket", location)
+		}
+		api := s3.New(sundaetrace.Session(aws.NewConfig()))
+		return ReadExport(ctx, NewS3ExportFiles(api, bucket, prefix))
+	}
+	return ReadExport(ctx, DirExportFiles(location))
+}
+
+// ReadExport reads the manifests of the export in files
+func ReadExport(ctx context.Context, files ExportFiles) (*Export, error) {
+	r, err := files.Open(ctx, "manifest-summary.json")
+	if err != nil {
+		return nil, fmt.Errorf("unable to open export manifest: %w", err)
+	}
+	var summary exportSummary
+	err = json.NewDecoder(r).Decode(&summary)
+	r.Close()
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode export manifest: %w", err)
+	}
+	if summary.ExportType != "" && summary.ExportType != "FULL_EXPORT" {
+		return nil, fmt.Errorf("unable to read %v export: only full exports are supported", summary.ExportType)
+	}
+	switch summary.OutputFormat {
+	case ExportFormatJSON, ExportFormatION:
+	default:
+		return nil, fmt.Errorf("unsupported export format %q", summary.OutputFormat)
+	}
+
+	export := &Export{
+		ID:        path.Base(path.Dir(summary.ManifestFilesS3Key)),
+		TableArn:  summary.TableArn,
+		Format:    summary.OutputFormat,
+		ItemCount: summary.ItemCount,
+		files:     files,
+	}
+	if _, id, ok := strings.Cut(summary.ExportArn, "/export/"); ok {
+		export.ID = id
+	}
+
+	r, err = files.Open(ctx, "manifest-files.json")
+	if err != nil {
+		return nil, fmt.Errorf("unable to open export file manifest: %w", err)
+	}
+	defer r.Close()
+	decoder := json.NewDecoder(r)
+	for decoder.More() {
+		var file exportManifestFile
+		if err := decoder.Decode(&file); err != nil {
+			return nil, fmt.Errorf("unable to decode export file manifest: %w", err)
+		}
+		// The keys are absolute; the files are found relative to the manifests
+		// so a copied export reads the same
+		export.dataFiles = append(export.dataFiles, "data/"+path.Base(file.DataFileS3Key))
+	}
+	return export, nil
+}
+
+// Items calls fn with each item of the export, in no particular order
+func (e *Export) Items(ctx context.Context, fn func(item map[string]*dynamodb.AttributeValue) error) error {
+	for _, name := range e.dataFiles {
+		if err := e.readFile(ctx, name, fn); err != nil {
+			return fmt.Errorf("unable to read export file %v: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (e *Export) readFile(ctx context.Context, name string, fn func(item map[string]*dynamodb.AttributeValue) error) error {
+	rc, err := e.files.Open(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	var r io.Reader = bufio.NewReader(rc)
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	if e.Format == ExportFormatION {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return readIONItems(data, fn)
+	}
+
+	decoder := json.NewDecoder(r)
+	for decoder.More() {
+		var line struct {
+			Item map[string]*dynamodb.AttributeValue
+		}
+		if err := decoder.Decode(&line); err != nil {
+			return err
+		}
+		if err := fn(line.Item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Records returns the export as INSERT records, ordered by the keys, usually
+// the hash and range keys of the table. Every item is held in memory to sort
+// them.
+func (e *Export) Records(ctx context.Context, keys ...string) ([]ddb.Record, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("the key attributes are required to order the export")
+	}
+	var items []map[string]*dynamodb.AttributeValue
+	err := e.Items(ctx, func(item map[string]*dynamodb.AttributeValue) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		for _, key := range keys {
+			if c := compareKey(items[i][key], items[j][key]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	records := make([]ddb.Record, 0, len(items))
+	for i, item := range items {
+		keyValues := map[string]*dynamodb.AttributeValue{}
+		for _, key := range keys {
+			if v, ok := item[key]; ok {
+				keyValues[key] = v
+			}
+		}
+		// Sequence numbers are synthetic, but ordered like the real ones
+		sequence := fmt.Sprintf("%021d", i+1)
+		records = append(records, ddb.Record{
+			EventID:        e.ID + "-" + sequence,
+			EventName:      "INSERT",
+			EventSource:    "aws:dynamodb",
+			EventSourceARN: e.TableArn,
+			Change: ddb.Change{
+				Keys:           keyValues,
+				NewImage:       item,
+				SequenceNumber: sequence,
+				StreamViewType: dynamodb.StreamViewTypeNewImage,
+			},
+		})
+	}
+	return records, nil
+}
+
+// compareKey orders key values as DynamoDB does: strings and binary by their
+// bytes, numbers by value. Missing values come first.
+func compareKey(a, b *dynamodb.AttributeValue) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	case a.N != nil && b.N != nil:
+		x, _, errX := big.ParseFloat(*a.N, 10, 256, big.ToNearestEven)
+		y, _, errY := big.ParseFloat(*b.N, 10, 256, big.ToNearestEven)
+		if errX == nil && errY == nil {
+			return x.Cmp(y)
+		}
+		return strings.Compare(*a.N, *b.N)
+	case a.S != nil && b.S != nil:
+		return strings.Compare(*a.S, *b.S)
+	case a.B != nil && b.B != nil:
+		return bytes.Compare(a.B, b.B)
+	}
+	return 0
+}
+
+// BackfillOption configures Handler.Backfill
+type BackfillOption func(*backfillOptions)
+
+type backfillOptions struct {
+	batchSize int
+}
+
+// DefaultBackfillBatchSize is the number of records in each batch, matching
+// the default of a DynamoDB event source mapping
+const DefaultBackfillBatchSize = 100
+
+// WithBackfillBatchSize sets how many records are handled in each batch
+func WithBackfillBatchSize(n int) BackfillOption {
+	return func(o *backfillOptions) {
+		if n > 0 {
+			o.batchSize = n
+		}
+	}
+}
+
+// Backfill hands every item of export to the handler as an INSERT, in the
+// order of keys, in batches. Records go through the handler's batch policy
+// as they do from the stream: a record that fails is retried, and ends the
+// backfill unless the policy quarantines it.
+func (h *Handler) Backfill(ctx context.Context, export *Export, keys []string, opts ...BackfillOption) error {
+	o := backfillOptions{batchSize: DefaultBackfillBatchSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	records, err := export.Records(ctx, keys...)
+	if err != nil {
+		return err
+	}
+	h.Logger.Info().Str("export", export.ID).Int("records", len(records)).Msg("backfilling from export")
+
+	for start := 0; start < len(records); start += o.batchSize {
+		end := start + o.batchSize
+		if end > len(records) {
+			end = len(records)
+		}
+		batch := records[start:end]
+		for len(batch) > 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			result := h.handleBatch(ctx, ddb.Event{Records: batch})
+			if result.Err == nil {
+				break
+			}
+			if !h.batchProcessor().Policy().Quarantines() {
+				return fmt.Errorf("unable to backfill record %v: %w", result.FailedID, result.Err)
+			}
+			// As Lambda would, redeliver from the failed record until the
+			// policy gives up on it
+			for i, record := range batch {
+				if recordID(record) == result.FailedID {
+					batch = batch[i:]
+					break
+				}
+			}
+		}
+		h.Logger.Debug().Int("handled", end).Int("records", len(records)).Msg("backfill progress")
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package sundaeddb
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+// BackfillCommand builds the ddb-backfill command, for sundaecli.CommandApp,
+// which runs h over a table export, so a derived table can be backfilled by
+// the same code that maintains it from the stream:
+//
+//	ddb-backfill --key pk [--key sk] [--batch-size n] <s3://bucket/prefix or dir>
+//
+// The location is the directory of the export holding manifest-summary.json.
+func BackfillCommand(h *Handler) *cli.Command {
+	return &cli.Command{
+		Name:      "ddb-backfill",
+		Usage:     "handle every item of a table export as an INSERT, in key order",
+		ArgsUsage: "<s3://bucket/prefix or dir>",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{Name: "key", Usage: "a key attribute to order items by, hash key first (repeatable)", Required: true},
+			&cli.IntFlag{Name: "batch-size", Usage: "how many records to handle at a time", Value: DefaultBackfillBatchSize},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("expected the location of one export")
+			}
+			export, err := OpenExport(c.Context, c.Args().First())
+			if err != nil {
+				return err
+			}
+			if err := h.Backfill(c.Context, export, c.StringSlice("key"), WithBackfillBatchSize(c.Int("batch-size"))); err != nil {
+				return err
+			}
+			fmt.Fprintf(c.App.Writer, "backfilled export %v\n", export.ID)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,433 @@
+package sundaeddb
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// readIONItems calls fn with the Item of each value in data, an export file
+// in Amazon Ion text. Only the subset of Ion that exports use is understood:
+// structs, lists, strings, decimals, booleans, nulls, blobs, and the
+// $dynamodb_SS, $dynamodb_NS and $dynamodb_BS annotations on sets.
+func readIONItems(data []byte, fn func(item map[string]*dynamodb.AttributeValue) error) error {
+	p := &ionParser{data: data}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if v.symbol != "" {
+			// the version marker, $ion_1_0
+			continue
+		}
+		if v.av.M == nil {
+			return fmt.Errorf("ion offset %v: expected a struct", p.pos)
+		}
+		item, ok := v.av.M["Item"]
+		if !ok || item.M == nil {
+			return fmt.Errorf("ion offset %v: expected an Item", p.pos)
+		}
+		if err := fn(item.M); err != nil {
+			return err
+		}
+	}
+}
+
+type ionParser struct {
+	data []byte
+	pos  int
+}
+
+// ionValue is a parsed value; a bare symbol has no AttributeValue
+type ionValue struct {
+	av     *dynamodb.AttributeValue
+	symbol string
+}
+
+func (p *ionParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("ion offset %v: %v", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *ionParser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch c := p.data[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			p.pos++
+		case p.peek("//"):
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		case p.peek("/*"):
+			end := strings.Index(string(p.data[p.pos+2:]), "*/")
+			if end < 0 {
+				p.pos = len(p.data)
+				return
+			}
+			p.pos += end + 4
+		default:
+			return
+		}
+	}
+}
+
+func (p *ionParser) peek(s string) bool {
+	return strings.HasPrefix(string(p.data[p.pos:min(p.pos+len(s), len(p.data))]), s)
+}
+
+func isIdentifierByte(c byte, first bool) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+func (p *ionParser) identifier() string {
+	start := p.pos
+	for p.pos < len(p.data) && isIdentifierByte(p.data[p.pos], p.pos == start) {
+		p.pos++
+	}
+	return string(p.data[start:p.pos])
+}
+
+// symbolOrAnnotation reads an identifier or quoted symbol, and reports
+// whether it's an annotation
+func (p *ionParser) symbolOrAnnotation() (string, bool, error) {
+	var name string
+	if p.peek("'") {
+		s, err := p.quoted('\'')
+		if err != nil {
+			return "", false, err
+		}
+		name = s
+	} else {
+		name = p.identifier()
+	}
+	p.skipSpace()
+	if p.peek("::") {
+		p.pos += 2
+		return name, true, nil
+	}
+	return name, false, nil
+}
+
+func (p *ionParser) value() (ionValue, error) {
+	var annotations []string
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return ionValue{}, p.errorf("unexpected end of input")
+		}
+		c := p.data[p.pos]
+		if !(isIdentifierByte(c, true) || (c == '\'' && !p.peek("'''"))) {
+			break
+		}
+		name, annotation, err := p.symbolOrAnnotation()
+		if err != nil {
+			return ionValue{}, err
+		}
+		if annotation {
+			annotations = append(annotations, name)
+			continue
+		}
+		switch {
+		case c == '\'':
+			return ionValue{av: &dynamodb.AttributeValue{S: aws.String(name)}}, nil
+		case name == "true" || name == "false":
+			return ionValue{av: &dynamodb.AttributeValue{BOOL: aws.Bool(name == "true")}}, nil
+		case name == "null":
+			// typed nulls, e.g. null.string
+			if p.peek(".") {
+				p.pos++
+				p.identifier()
+			}
+			return ionValue{av: &dynamodb.AttributeValue{NULL: aws.Bool(true)}}, nil
+		}
+		return ionValue{symbol: name}, nil
+	}
+
+	v, err := p.literal()
+	if err != nil {
+		return ionValue{}, err
+	}
+	for _, annotation := range annotations {
+		switch annotation {
+		case "$dynamodb_SS", "$dynamodb_NS", "$dynamodb_BS":
+			if v.L == nil {
+				return ionValue{}, p.errorf("%v on a value that isn't a list", annotation)
+			}
+			set := &dynamodb.AttributeValue{}
+			for _, el := range v.L {
+				switch {
+				case annotation == "$dynamodb_SS" && el.S != nil:
+					set.SS = append(set.SS, el.S)
+				case annotation == "$dynamodb_NS" && el.N != nil:
+					set.NS = append(set.NS, el.N)
+				case annotation == "$dynamodb_BS" && el.B != nil:
+					set.BS = append(set.BS, el.B)
+				default:
+					return ionValue{}, p.errorf("unexpected member of %v", annotation)
+				}
+			}
+			v = set
+		}
+	}
+	return ionValue{av: v}, nil
+}
+
+// literal reads a value that isn't a symbol
+func (p *ionParser) literal() (*dynamodb.AttributeValue, error) {
+	switch c := p.data[p.pos]; {
+	case p.peek("{{"):
+		p.pos += 2
+		end := strings.Index(string(p.data[p.pos:]), "}}")
+		if end < 0 {
+			return nil, p.errorf("unterminated blob")
+		}
+		encoded := strings.Join(strings.Fields(string(p.data[p.pos:p.pos+end])), "")
+		p.pos += end + 2
+		b, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, p.errorf("invalid blob: %v", err)
+		}
+		return &dynamodb.AttributeValue{B: b}, nil
+
+	case c == '{':
+		p.pos++
+		m := map[string]*dynamodb.AttributeValue{}
+		for {
+			p.skipSpace()
+			if p.peek("}") {
+				p.pos++
+				return &dynamodb.AttributeValue{M: m}, nil
+			}
+			var name string
+			switch {
+			case p.peek("\""):
+				s, err := p.quoted('"')
+				if err != nil {
+					return nil, err
+				}
+				name = s
+			case p.peek("'''"):
+				s, err := p.longString()
+				if err != nil {
+					return nil, err
+				}
+				name = s
+			case p.peek("'"):
+				s, err := p.quoted('\'')
+				if err != nil {
+					return nil, err
+				}
+				name = s
+			default:
+				name = p.identifier()
+				if name == "" {
+					return nil, p.errorf("expected a field name")
+				}
+			}
+			p.skipSpace()
+			if !p.peek(":") {
+				return nil, p.errorf("expected ':' after field %v", name)
+			}
+			p.pos++
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			if v.av == nil {
+				return nil, p.errorf("unsupported symbol value %v", v.symbol)
+			}
+			m[name] = v.av
+			p.skipSpace()
+			if p.peek(",") {
+				p.pos++
+			} else if !p.peek("}") {
+				return nil, p.errorf("expected ',' or '}'")
+			}
+		}
+
+	case c == '[':
+		p.pos++
+		l := []*dynamodb.AttributeValue{}
+		for {
+			p.skipSpace()
+			if p.peek("]") {
+				p.pos++
+				return &dynamodb.AttributeValue{L: l}, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			if v.av == nil {
+				return nil, p.errorf("unsupported symbol value %v", v.symbol)
+			}
+			l = append(l, v.av)
+			p.skipSpace()
+			if p.peek(",") {
+				p.pos++
+			} else if !p.peek("]") {
+				return nil, p.errorf("expected ',' or ']'")
+			}
+		}
+
+	case c == '"':
+		s, err := p.quoted('"')
+		if err != nil {
+			return nil, err
+		}
+		return &dynamodb.AttributeValue{S: aws.String(s)}, nil
+
+	case p.peek("'''"):
+		s, err := p.longString()
+		if err != nil {
+			return nil, err
+		}
+		return &dynamodb.AttributeValue{S: aws.String(s)}, nil
+
+	case c == '-' || c == '+' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.data) && strings.IndexByte("0123456789+-._eEdDxXabcfABCF", p.data[p.pos]) >= 0 {
+			p.pos++
+		}
+		n, err := ionNumber(string(p.data[start:p.pos]))
+		if err != nil {
+			p.pos = start
+			return nil, p.errorf("%v", err)
+		}
+		return &dynamodb.AttributeValue{N: aws.String(n)}, nil
+	}
+	return nil, p.errorf("unexpected %q", p.data[p.pos])
+}
+
+// ionNumber converts an Ion int or decimal, e.g. 12, 1_000, 12., 1.5d-3, to
+// a DynamoDB number, which is spelled without an exponent or trailing zeros
+func ionNumber(s string) (string, error) {
+	s = strings.ReplaceAll(s, "_", "")
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "-0x") || strings.HasPrefix(s, "0b") || strings.HasPrefix(s, "-0b") {
+		i, err := strconv.ParseInt(s, 0, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid number %v", s)
+		}
+		return strconv.FormatInt(i, 10), nil
+	}
+
+	mantissa, exponent, _ := strings.Cut(strings.NewReplacer("d", "e", "D", "e", "E", "e").Replace(s), "e")
+	exp := 0
+	if exponent != "" {
+		var err error
+		if exp, err = strconv.Atoi(exponent); err != nil {
+			return "", fmt.Errorf("invalid number %v", s)
+		}
+	}
+	_, fraction, _ := strings.Cut(mantissa, ".")
+	r, ok := new(big.Rat).SetString(strings.TrimSuffix(mantissa, ".") + "e" + strconv.Itoa(exp))
+	if !ok || strings.HasPrefix(mantissa, "+") {
+		return "", fmt.Errorf("invalid number %v", s)
+	}
+	digits := len(fraction) - exp
+	if digits < 0 {
+		digits = 0
+	}
+	n := r.FloatString(digits)
+	if strings.Contains(n, ".") {
+		n = strings.TrimRight(strings.TrimRight(n, "0"), ".")
+	}
+	if n == "-0" {
+		n = "0"
+	}
+	return n, nil
+}
+
+// quoted reads a string or symbol quoted with q
+func (p *ionParser) quoted(q byte) (string, error) {
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		switch {
+		case c == q:
+			p.pos++
+			return b.String(), nil
+		case c == '\\':
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// longString reads adjacent long strings, each in triple single quotes, as
+// one string
+func (p *ionParser) longString() (string, error) {
+	var b strings.Builder
+	for p.peek("'''") {
+		p.pos += 3
+		for {
+			if p.pos >= len(p.data) {
+				return "", p.errorf("unterminated long string")
+			}
+			if p.peek("'''") {
+				p.pos += 3
+				break
+			}
+			if p.data[p.pos] == '\\' {
+				if err := p.escape(&b); err != nil {
+					return "", err
+				}
+				continue
+			}
+			b.WriteByte(p.data[p.pos])
+			p.pos++
+		}
+		p.skipSpace()
+	}
+	return b.String(), nil
+}
+
+func (p *ionParser) escape(b *strings.Builder) error {
+	p.pos++
+	if p.pos >= len(p.data) {
+		return p.errorf("unterminated escape")
+	}
+	c := p.data[p.pos]
+	p.pos++
+	simple := map[byte]string{
+		'a': "\a", 'b': "\b", 't': "\t", 'n': "\n", 'f': "\f", 'r': "\r", 'v': "\v",
+		'?': "?", '0': "\x00", '\'': "'", '"': "\"", '/': "/", '\\': "\\", '\n': "",
+	}
+	if s, ok := simple[c]; ok {
+		b.WriteString(s)
+		return nil
+	}
+	digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+	if digits == 0 || p.pos+digits > len(p.data) {
+		return p.errorf("invalid escape \\%c", c)
+	}
+	r, err := strconv.ParseUint(string(p.data[p.pos:p.pos+digits]), 16, 32)
+	if err != nil {
+		return p.errorf("invalid escape \\%c", c)
+	}
+	p.pos += digits
+	if c == 'x' {
+		b.WriteRune(rune(r))
+	} else if utf8.ValidRune(rune(r)) {
+		b.WriteRune(rune(r))
+	} else {
+		return p.errorf("invalid code point %x", r)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package sundaeddb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/savaki/ddb"
+	"github.com/tj/assert"
+	"github.com/urfave/cli/v2"
+)
+
+// writeExport lays out an export as DynamoDB writes it, with one gzipped data
+// file per entry of files
+func writeExport(t *testing.T, format, ext string, files ...string) string {
+	dir := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "data"), 0o755))
+	const prefix = "exports/AWSDynamoDB/01700000000000-abcdef12"
+
+	var manifest bytes.Buffer
+	for i, content := range files {
+		name := fmt.Sprintf("file%v.%v.gz", i, ext)
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write([]byte(content))
+		assert.Nil(t, err)
+		assert.Nil(t, gz.Close())
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, "data", name), buf.Bytes(), 0o644))
+		fmt.Fprintf(&manifest, `{"itemCount":2,"md5Checksum":"x","etag":"y","dataFileS3Key":"%v/data/%v"}`+"\n", prefix, name)
+	}
+	summary := fmt.Sprintf(`{"version":"2020-06-30","exportArn":"arn:aws:dynamodb:us-east-2:1:table/pools/export/01700000000000-abcdef12","tableArn":"arn:aws:dynamodb:us-east-2:1:table/pools","manifestFilesS3Key":"%v/manifest-files.json","itemCount":4,"outputFormat":"%v","exportType":"FULL_EXPORT"}`, prefix, format)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "manifest-summary.json"), []byte(summary), 0o644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "manifest-files.json"), manifest.Bytes(), 0o644))
+	return dir
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	want := []string{"a/2", "a/10", "b/1", "c/1"}
+
+	formats := map[string]string{
+		ExportFormatJSON: writeExport(t, ExportFormatJSON, "json",
+			`{"Item":{"pk":{"S":"b"},"sk":{"N":"1"},"tags":{"SS":["x","y"]}}}
+{"Item":{"pk":{"S":"a"},"sk":{"N":"10"},"raw":{"B":"aGk="}}}`,
+			`{"Item":{"pk":{"S":"c"},"sk":{"N":"1"},"nested":{"M":{"on":{"BOOL":true},"none":{"NULL":true}}}}}
+{"Item":{"pk":{"S":"a"},"sk":{"N":"2"},"list":{"L":[{"S":"s"},{"N":"1.5"}]}}}`,
+		),
+		ExportFormatION: writeExport(t, ExportFormatION, "ion",
+			`$ion_1_0 {Item:{pk:"b",sk:1.,tags:$dynamodb_SS::["x","y"]}}
+$ion_1_0 {Item:{pk:"a",sk:10,raw:{{aGk=}}}}`,
+			`$ion_1_0 {Item:{pk:"c",sk:1d0,nested:{on:true,none:null}}}
+$ion_1_0 {Item:{'pk':'''a''',"sk":2.,list:["s",1.5]}}`,
+		),
+	}
+	for format, dir := range formats {
+		t.Run(format, func(t *testing.T) {
+			export, err := OpenExport(ctx, dir)
+			assert.Nil(t, err)
+			assert.Equal(t, "01700000000000-abcdef12", export.ID)
+			assert.Equal(t, format, export.Format)
+
+			records, err := export.Records(ctx, "pk", "sk")
+			assert.Nil(t, err)
+			var got []string
+			for _, r := range records {
+				assert.Equal(t, "INSERT", r.EventName)
+				assert.Len(t, r.Change.Keys, 2)
+				got = append(got, aws.StringValue(r.Change.NewImage["pk"].S)+"/"+aws.StringValue(r.Change.NewImage["sk"].N))
+			}
+			assert.Equal(t, want, got)
+
+			assert.Equal(t, []*string{aws.String("x"), aws.String("y")}, records[2].Change.NewImage["tags"].SS)
+			assert.Equal(t, []byte("hi"), records[1].Change.NewImage["raw"].B)
+			assert.Equal(t, "1.5", aws.StringValue(records[0].Change.NewImage["list"].L[1].N))
+			assert.True(t, aws.BoolValue(records[3].Change.NewImage["nested"].M["on"].BOOL))
+			assert.True(t, aws.BoolValue(records[3].Change.NewImage["nested"].M["none"].NULL))
+		})
+	}
+
+	t.Run("backfill", func(t *testing.T) {
+		export, err := OpenExport(ctx, formats[ExportFormatJSON])
+		assert.Nil(t, err)
+
+		var got []string
+		h := NewHandler(sundaecli.NewService("test"), func(ctx context.Context, item map[string]*dynamodb.AttributeValue) error {
+			got = append(got, *item["pk"].S+"/"+*item["sk"].N)
+			if *item["pk"].S == "b" {
+				return fmt.Errorf("boom")
+			}
+			return nil
+		}, nil, nil)
+		var discarded []string
+		h.SetBatchPolicy(sundaecli.BatchPolicy{
+			MaxAttempts: 2,
+			OnDiscard:   func(ctx context.Context, id string, err error) { discarded = append(discarded, id) },
+		})
+		assert.Nil(t, h.Backfill(ctx, export, []string{"pk", "sk"}, WithBackfillBatchSize(3)))
+		assert.Equal(t, []string{"a/2", "a/10", "b/1", "b/1", "c/1"}, got)
+		assert.Equal(t, []string{"000000000000000000003"}, discarded)
+
+		// Without quarantine, a failure ends the backfill
+		h = NewBatchHandler(sundaecli.NewService("test"), func(ctx context.Context, event ddb.Event) error {
+			return fmt.Errorf("boom")
+		})
+		h.SetBatchPolicy(sundaecli.BatchPolicy{})
+		assert.EqualError(t, h.Backfill(ctx, export, []string{"pk", "sk"}), "unable to backfill record 000000000000000000001: boom")
+	})
+
+	t.Run("command", func(t *testing.T) {
+		var n int
+		h := NewHandler(sundaecli.NewService("test"), func(ctx context.Context, item map[string]*dynamodb.AttributeValue) error {
+			n++
+			return nil
+		}, nil, nil)
+		var out bytes.Buffer
+		app := &cli.App{Writer: &out, Commands: []*cli.Command{BackfillCommand(h)}}
+		assert.Nil(t, app.Run([]string{"app", "ddb-backfill", "--key", "pk", formats[ExportFormatION]}))
+		assert.Equal(t, 4, n)
+		assert.Equal(t, "backfilled export 01700000000000-abcdef12\n", out.String())
+	})
+
+	t.Run("numbers", func(t *testing.T) {
+		for in, want := range map[string]string{"12": "12", "1_000": "1000", "12.": "12", "1.50": "1.5", "15d-1": "1.5", "1.5d2": "150", "-0.": "0", "0x1f": "31", "-2.5e-3": "-0.0025"} {
+			got, err := ionNumber(in)
+			assert.Nil(t, err, in)
+			assert.Equal(t, want, got, in)
+		}
+		_, err := ionNumber("1.2.3")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := OpenExport(ctx, t.TempDir())
+		assert.NotNil(t, err)
+
+		err = readIONItems([]byte(`{Item:{pk:"a",sk:}}`), func(map[string]*dynamodb.AttributeValue) error { return nil })
+		assert.NotNil(t, err)
+	})
+}
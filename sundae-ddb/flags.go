@@ -1,19 +1,47 @@
 package sundaeddb
 
 import (
+	"time"
+
 	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
 	"github.com/urfave/cli/v2"
 )
 
 var DDBOpts struct {
-	DAXCluster string
-	TableName  string
+	DAXCluster           string
+	TableName            string
+	StartPosition        string
+	StartSequences       cli.StringSlice
+	Checkpoint           string
+	ShardRefreshInterval time.Duration
+	IdleBackoff          time.Duration
+	CacheTables          cli.StringSlice
+	CacheSize            int
+	CacheTTL             time.Duration
 }
 
 var DAXClusterFlag = sundaecli.StringFlag("dax-cluster", "The DAX cluster to connect to", &DDBOpts.DAXCluster)
 var TableNameFlag = sundaecli.StringFlag("table-name", "The table name to read streams from", &DDBOpts.TableName)
 
+var StartPositionFlag = sundaecli.StringFlag("start-position", "where to start reading shards without a checkpoint, in console mode: trim-horizon, latest or at-sequence", &DDBOpts.StartPosition, "trim-horizon")
+var StartSequenceFlag = sundaecli.StringSliceFlag("start-sequence", "shardId=sequenceNumber to start a shard at, with --start-position=at-sequence", nil, &DDBOpts.StartSequences)
+var CheckpointFlag = sundaecli.StringFlag("checkpoint", "where to checkpoint stream progress, in console mode: ddb, the path to a local file, or none", &DDBOpts.Checkpoint, "none")
+var ShardRefreshIntervalFlag = sundaecli.DurationFlag("shard-refresh-interval", "how often to look for new stream shards, in console mode", &DDBOpts.ShardRefreshInterval, DefaultShardRefreshInterval)
+var IdleBackoffFlag = sundaecli.DurationFlag("idle-backoff", "how long to wait after reading nothing from a shard, in console mode", &DDBOpts.IdleBackoff, DefaultIdleBackoff)
+
+var CacheTablesFlag = sundaecli.StringSliceFlag("ddb-cache-table", "cache reads of this table in process, to spare DAX (repeatable)", nil, &DDBOpts.CacheTables)
+var CacheSizeFlag = sundaecli.IntFlag("ddb-cache-size", "how many items to cache, across every --ddb-cache-table", &DDBOpts.CacheSize, DefaultCacheSize)
+var CacheTTLFlag = sundaecli.DurationFlag("ddb-cache-ttl", "how long to cache an item read from a --ddb-cache-table", &DDBOpts.CacheTTL, DefaultCacheTTL)
+
 var DDBFlags = []cli.Flag{
 	DAXClusterFlag,
 	TableNameFlag,
+	StartPositionFlag,
+	StartSequenceFlag,
+	CheckpointFlag,
+	ShardRefreshIntervalFlag,
+	IdleBackoffFlag,
+	CacheTablesFlag,
+	CacheSizeFlag,
+	CacheTTLFlag,
 }
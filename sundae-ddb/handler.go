@@ -6,17 +6,17 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 
 	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
-	"github.com/aws/aws-lambda-go/lambda"
+	sundaetrace "github.com/SundaeSwap-finance/sundae-go-utils/sundae-trace"
+	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
 	"github.com/rs/zerolog"
 	"github.com/savaki/ddb"
-	"golang.org/x/sync/errgroup"
 )
 
 type BatchCallback func(ctx context.Context, event ddb.Event) error
@@ -32,6 +32,9 @@ type Handler struct {
 	onInsert InsertCallback
 	onUpdate UpdateCallback
 	onDelete DeleteCallback
+
+	batchOnce sync.Once
+	batch     *sundaecli.BatchProcessor
 }
 
 func NewHandler(
@@ -60,29 +63,101 @@ func NewBatchHandler(
 	}
 }
 
+// SetBatchPolicy overrides the BatchPolicy set by sundaecli.BatchFlags; it
+// must be called before the first event is handled. Dead letters are stamped
+// with the handler's service unless the policy names another.
+func (h *Handler) SetBatchPolicy(policy sundaecli.BatchPolicy) {
+	if policy.Service.Name == "" {
+		policy.Service = h.service
+	}
+	h.batchOnce.Do(func() {
+		h.batch = sundaecli.NewBatchProcessor(policy, h.Logger, nil)
+	})
+}
+
+func (h *Handler) batchProcessor() *sundaecli.BatchProcessor {
+	h.SetBatchPolicy(sundaecli.DefaultBatchPolicy("sundae-ddb"))
+	return h.batch
+}
+
 func (h *Handler) Start() error {
-	switch {
-	case sundaecli.CommonOpts.Console:
-		return h.handleRealtime()
+	metrics, err := sundaecli.BuildMetrics(h.service)
+	if err != nil {
+		return err
+	}
+	if _, err := sundaecli.BuildFeatures(h.service, metrics); err != nil {
+		return err
+	}
+	// The policy may have been set before the metrics were built
+	h.batchProcessor().SetMetrics(metrics)
 
-	default:
-		lambda.Start(h.HandleEvent)
+	var handler interface{} = h.HandleEvent
+	if h.batchProcessor().Policy().ReportItemFailures {
+		handler = h.HandleEventWithResponse
 	}
-	return nil
+	return sundaecli.Run(context.Background(), handler, func(ctx context.Context) error {
+		admin, err := sundaecli.StartAdmin(h.service, metrics)
+		if err != nil {
+			return err
+		}
+		defer admin.Close()
+		session := sundaetrace.Session(aws.NewConfig())
+		admin.AddReadinessCheck("table", sundaecli.DynamoDBCheck(dynamodb.New(session), DDBOpts.TableName))
+
+		return h.handleRealtime(ctx)
+	})
 }
 
 func (h *Handler) HandleEvent(ctx context.Context, event ddb.Event) error {
+	result := h.handleBatch(ctx, event)
+	if result.Err != nil && h.onBatch == nil {
+		return fmt.Errorf("unable to handle record: %w", result.Err)
+	}
+	return result.Err
+}
+
+// HandleEventWithResponse is HandleEvent for event source mappings with
+// ReportBatchItemFailures enabled: rather than failing, it names the first
+// record that failed, so Lambda retries from there instead of from the start
+// of the batch
+func (h *Handler) HandleEventWithResponse(ctx context.Context, event ddb.Event) (events.DynamoDBEventResponse, error) {
+	var response events.DynamoDBEventResponse
+	if result := h.handleBatch(ctx, event); result.Err != nil {
+		response.BatchItemFailures = append(response.BatchItemFailures, events.DynamoDBBatchItemFailure{
+			ItemIdentifier: result.FailedID,
+		})
+	}
+	return response, nil
+}
+
+func (h *Handler) handleBatch(ctx context.Context, event ddb.Event) sundaecli.BatchResult {
 	h.Logger.Trace().Int("count", len(event.Records)).Msg("handling a batch of events")
 	if h.onBatch != nil {
-		return h.onBatch(ctx, event)
+		return sundaecli.ProcessBatch(ctx, h.batchProcessor(), event.Records, recordID, func(ctx context.Context, records []ddb.Record) error {
+			return h.onBatch(ctx, ddb.Event{Records: records})
+		})
 	}
-	for _, record := range event.Records {
-		if err := h.HandleSingleRecord(ctx, record); err != nil {
+	return sundaecli.ProcessRecords(ctx, h.batchProcessor(), event.Records, recordID, func(ctx context.Context, record ddb.Record) error {
+		err := h.HandleSingleRecord(ctx, record)
+		if err != nil {
 			h.Logger.Error().Err(err).Str("event", record.EventID).Msg("unable to handle record")
-			return fmt.Errorf("unable to handle record: %w", err)
 		}
+		return err
+	})
+}
+
+func recordID(record ddb.Record) string {
+	return record.Change.SequenceNumber
+}
+
+// Redrive hands a record quarantined by this handler back to it, e.g. from
+// the sundaedeadletter command
+func (h *Handler) Redrive(ctx context.Context, letter sundaecli.DeadLetter) error {
+	var record ddb.Record
+	if err := json.Unmarshal(letter.Record, &record); err != nil {
+		return fmt.Errorf("unable to decode dead letter %v: %w", letter.ID, err)
 	}
-	return nil
+	return h.HandleSingleRecord(ctx, record)
 }
 
 func (h *Handler) HandleSingleRecord(ctx context.Context, record ddb.Record) error {
@@ -108,80 +183,36 @@ func (h *Handler) HandleSingleRecord(ctx context.Context, record ddb.Record) err
 	return nil
 }
 
-func (h *Handler) handleRealtime() error {
-	session := session.Must(session.NewSession(aws.NewConfig()))
-	streams := dynamodbstreams.New(session)
-	ss, err := streams.ListStreams(&dynamodbstreams.ListStreamsInput{
-		TableName: aws.String(DDBOpts.TableName),
-	})
+// handleRealtime reads the table's stream with a StreamReader configured by
+// DDBOpts until ctx is cancelled
+func (h *Handler) handleRealtime(ctx context.Context) error {
+	start, err := ParseStartPosition(DDBOpts.StartPosition, DDBOpts.StartSequences.Value())
 	if err != nil {
-		return fmt.Errorf("unable to list streams for table %v: %w", DDBOpts.TableName, err)
+		return err
 	}
-	if len(ss.Streams) != 1 {
-		return fmt.Errorf("too few or too many streams (%v) for table %v", len(ss.Streams), DDBOpts.TableName)
+	session := sundaetrace.Session(aws.NewConfig())
+	opts := []StreamReaderOption{
+		WithStartPosition(start),
+		WithShardRefreshInterval(DDBOpts.ShardRefreshInterval),
+		WithIdleBackoff(DDBOpts.IdleBackoff),
+		WithStreamLogger(h.Logger),
+	}
+	switch DDBOpts.Checkpoint {
+	case "", "none":
+	case "ddb":
+		store := NewDDBCheckpointStore(dynamodb.New(session), CheckpointTableName(sundaecli.CommonOpts.Env), h.service.Name)
+		opts = append(opts, WithCheckpointStore(store))
+	default:
+		opts = append(opts, WithCheckpointStore(NewFileCheckpointStore(DDBOpts.Checkpoint)))
 	}
-	stream := ss.Streams[0]
 
-	var shards []*dynamodbstreams.Shard
-	var lastShard *string
-	for {
-		ss, err := streams.DescribeStream(&dynamodbstreams.DescribeStreamInput{
-			StreamArn:             stream.StreamArn,
-			ExclusiveStartShardId: lastShard,
-		})
-		if err != nil {
-			return fmt.Errorf("unable to describe stream %v: %w", *stream.StreamArn, err)
-		}
-		shards = append(shards, ss.StreamDescription.Shards...)
-		if ss.StreamDescription.LastEvaluatedShardId == nil {
-			break
-		}
-		lastShard = ss.StreamDescription.LastEvaluatedShardId
-	}
-	group, ctx := errgroup.WithContext(context.Background())
-	group.SetLimit(256)
-
-	h.Logger.Info().Str("tableName", DDBOpts.TableName).Int("shardCount", len(shards)).Msg("responding to stream events")
-
-	for _, shard_ := range shards {
-		shard := shard_
-		group.Go(func() error {
-			it, err := streams.GetShardIteratorWithContext(ctx, &dynamodbstreams.GetShardIteratorInput{
-				StreamArn:         stream.StreamArn,
-				ShardId:           shard.ShardId,
-				ShardIteratorType: aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon),
-			})
-			if err != nil {
-				return fmt.Errorf("unable to get shard iterator: %w", err)
-			}
-
-			for it.ShardIterator != nil {
-				records, err := streams.GetRecordsWithContext(ctx, &dynamodbstreams.GetRecordsInput{
-					ShardIterator: it.ShardIterator,
-				})
-				if err != nil {
-					return fmt.Errorf("unable to get records: %w", err)
-				}
-				for _, record := range records.Records {
-					// Reserialize to the ddb event type, as it's nicer to work with
-					raw, err := json.Marshal(record)
-					if err != nil {
-						return fmt.Errorf("unable to marshal record: %w", err)
-					}
-					var ddbr ddb.Record
-					if err := json.Unmarshal(raw, &ddbr); err != nil {
-						return fmt.Errorf("unable to unmarshal record: %w", err)
-					}
-					if err := h.HandleSingleRecord(ctx, ddbr); err != nil {
-						return fmt.Errorf("error processing record %v: %w", ddbr.EventID, err)
-					}
-				}
-				it.ShardIterator = records.NextShardIterator
-			}
-			return nil
-		})
+	// Each record goes through the batch policy, so it's retried and
+	// quarantined as in Lambda rather than stopping the reader
+	handle := func(ctx context.Context, record ddb.Record) error {
+		return sundaecli.ProcessRecords(ctx, h.batchProcessor(), []ddb.Record{record}, recordID, h.HandleSingleRecord).Err
 	}
-	return group.Wait()
+	reader := NewStreamReader(dynamodbstreams.New(session), DDBOpts.TableName, handle, opts...)
+	return reader.Run(ctx)
 }
 
 func ParseItem(item map[string]*dynamodb.AttributeValue, v interface{}) error {
@@ -0,0 +1,1044 @@
+package memddb
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// This file parses and evaluates the expression languages DynamoDB accepts:
+// condition (and so key condition and filter) expressions, update
+// expressions and projection expressions.
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokName  // #name
+	tokValue // :value
+	tokNumber
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '#' || c == ':' || isIdentChar(c):
+			j := i + 1
+			for j < len(s) && isIdentChar(rune(s[j])) {
+				j++
+			}
+			kind := tokIdent
+			switch {
+			case c == '#':
+				kind = tokName
+			case c == ':':
+				kind = tokValue
+			case unicode.IsDigit(c):
+				kind = tokNumber
+			}
+			if j == i+1 && kind != tokIdent && kind != tokNumber {
+				return nil, validationError("invalid expression %q", s)
+			}
+			tokens = append(tokens, token{kind: kind, text: s[i:j]})
+			i = j
+		case strings.HasPrefix(s[i:], "<>") || strings.HasPrefix(s[i:], "<=") || strings.HasPrefix(s[i:], ">="):
+			tokens = append(tokens, token{kind: tokPunct, text: s[i : i+2]})
+			i += 2
+		case strings.ContainsRune("()[],.=<>+-", c):
+			tokens = append(tokens, token{kind: tokPunct, text: string(c)})
+			i++
+		default:
+			return nil, validationError("invalid character %q in expression %q", c, s)
+		}
+	}
+	return tokens, nil
+}
+
+func isIdentChar(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// exprContext resolves the placeholders of one request, and remembers which
+// were used so unused ones can be rejected as DynamoDB does
+type exprContext struct {
+	names      map[string]*string
+	values     map[string]*dynamodb.AttributeValue
+	usedNames  map[string]bool
+	usedValues map[string]bool
+}
+
+func newExprContext(names map[string]*string, values map[string]*dynamodb.AttributeValue) *exprContext {
+	return &exprContext{
+		names:      names,
+		values:     values,
+		usedNames:  map[string]bool{},
+		usedValues: map[string]bool{},
+	}
+}
+
+// checkUnused fails if a placeholder was supplied but not used
+func (c *exprContext) checkUnused() error {
+	for name := range c.names {
+		if !c.usedNames[name] {
+			return validationError("Value provided in ExpressionAttributeNames unused in expressions: keys: {%v}", name)
+		}
+	}
+	for name := range c.values {
+		if !c.usedValues[name] {
+			return validationError("Value provided in ExpressionAttributeValues unused in expressions: keys: {%v}", name)
+		}
+	}
+	return nil
+}
+
+type parser struct {
+	ctx    *exprContext
+	expr   string
+	tokens []token
+	pos    int
+}
+
+func newParser(ctx *exprContext, expr string) (*parser, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{ctx: ctx, expr: expr, tokens: tokens}, nil
+}
+
+func (p *parser) peek() token {
+	if p.pos >= len(p.tokens) {
+		return token{kind: tokEOF}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isKeyword(word string) bool {
+	t := p.peek()
+	return t.kind == tokIdent && strings.EqualFold(t.text, word)
+}
+
+func (p *parser) isPunct(text string) bool {
+	t := p.peek()
+	return t.kind == tokPunct && t.text == text
+}
+
+func (p *parser) expectPunct(text string) error {
+	if !p.isPunct(text) {
+		return p.errorf("expected %q", text)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	near := "end of expression"
+	if t := p.peek(); t.kind != tokEOF {
+		near = strconv.Quote(t.text)
+	}
+	return validationError("Invalid expression %q: "+format+", near %v", append([]interface{}{p.expr}, append(args, near)...)...)
+}
+
+func (p *parser) done() error {
+	if p.peek().kind != tokEOF {
+		return p.errorf("unexpected token")
+	}
+	return nil
+}
+
+// path is a document path: a top level attribute, then map keys and list
+// indexes
+type path []pathElem
+
+type pathElem struct {
+	name  string
+	index int
+	list  bool
+}
+
+func (p *parser) parsePath() (path, error) {
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	result := path{{name: name}}
+	for {
+		switch {
+		case p.isPunct("."):
+			p.next()
+			name, err := p.parseName()
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, pathElem{name: name})
+		case p.isPunct("["):
+			p.next()
+			t := p.next()
+			if t.kind != tokNumber {
+				return nil, p.errorf("expected a list index")
+			}
+			index, err := strconv.Atoi(t.text)
+			if err != nil {
+				return nil, p.errorf("invalid list index")
+			}
+			if err := p.expectPunct("]"); err != nil {
+				return nil, err
+			}
+			result = append(result, pathElem{index: index, list: true})
+		default:
+			return result, nil
+		}
+	}
+}
+
+func (p *parser) parseName() (string, error) {
+	t := p.next()
+	switch t.kind {
+	case tokIdent:
+		return t.text, nil
+	case tokName:
+		name, ok := p.ctx.names[t.text]
+		if !ok || name == nil {
+			return "", validationError("An expression attribute name used in the document path is not defined; attribute name: %v", t.text)
+		}
+		p.ctx.usedNames[t.text] = true
+		return *name, nil
+	}
+	p.pos--
+	return "", p.errorf("expected an attribute name")
+}
+
+func (p *parser) parseValueRef() (*dynamodb.AttributeValue, error) {
+	t := p.next()
+	if t.kind != tokValue {
+		p.pos--
+		return nil, p.errorf("expected an expression attribute value")
+	}
+	v, ok := p.ctx.values[t.text]
+	if !ok || v == nil {
+		return nil, validationError("An expression attribute value used in expression is not defined; attribute value: %v", t.text)
+	}
+	p.ctx.usedValues[t.text] = true
+	return v, nil
+}
+
+func (pa path) get(it item) *dynamodb.AttributeValue {
+	v := it[pa[0].name]
+	for _, e := range pa[1:] {
+		switch {
+		case v == nil:
+			return nil
+		case e.list:
+			if v.L == nil || e.index >= len(v.L) {
+				return nil
+			}
+			v = v.L[e.index]
+		default:
+			if v.M == nil {
+				return nil
+			}
+			v = v.M[e.name]
+		}
+	}
+	return v
+}
+
+func (pa path) set(it item, value *dynamodb.AttributeValue) error {
+	if len(pa) == 1 {
+		it[pa[0].name] = value
+		return nil
+	}
+	parent := path(pa[:len(pa)-1]).get(it)
+	last := pa[len(pa)-1]
+	switch {
+	case parent == nil:
+		return validationError("The document path provided in the update expression is invalid for update")
+	case last.list:
+		if parent.L == nil {
+			return validationError("The document path provided in the update expression is invalid for update")
+		}
+		if last.index >= len(parent.L) {
+			parent.L = append(parent.L, value)
+		} else {
+			parent.L[last.index] = value
+		}
+	default:
+		if parent.M == nil {
+			return validationError("The document path provided in the update expression is invalid for update")
+		}
+		parent.M[last.name] = value
+	}
+	return nil
+}
+
+func (pa path) remove(it item) {
+	if len(pa) == 1 {
+		delete(it, pa[0].name)
+		return
+	}
+	parent := path(pa[:len(pa)-1]).get(it)
+	last := pa[len(pa)-1]
+	switch {
+	case parent == nil:
+	case last.list:
+		if parent.L != nil && last.index < len(parent.L) {
+			parent.L = append(parent.L[:last.index], parent.L[last.index+1:]...)
+		}
+	case parent.M != nil:
+		delete(parent.M, last.name)
+	}
+}
+
+// operand is something that evaluates to a value: a path, a placeholder or
+// a function
+type operand func(it item) (*dynamodb.AttributeValue, error)
+
+func (p *parser) parseOperand() (operand, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokValue:
+		v, err := p.parseValueRef()
+		if err != nil {
+			return nil, err
+		}
+		return func(item) (*dynamodb.AttributeValue, error) { return v, nil }, nil
+
+	case t.kind == tokIdent && strings.EqualFold(t.text, "size") && p.peekAt(1, "("):
+		p.next()
+		p.next()
+		pa, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return func(it item) (*dynamodb.AttributeValue, error) {
+			n, ok := valueSize(pa.get(it))
+			if !ok {
+				return nil, nil
+			}
+			return &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(n))}, nil
+		}, nil
+
+	case t.kind == tokIdent && strings.EqualFold(t.text, "if_not_exists") && p.peekAt(1, "("):
+		p.next()
+		p.next()
+		pa, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(","); err != nil {
+			return nil, err
+		}
+		fallback, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return func(it item) (*dynamodb.AttributeValue, error) {
+			if v := pa.get(it); v != nil {
+				return v, nil
+			}
+			return fallback(it)
+		}, nil
+
+	case t.kind == tokIdent && strings.EqualFold(t.text, "list_append") && p.peekAt(1, "("):
+		p.next()
+		p.next()
+		a, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(","); err != nil {
+			return nil, err
+		}
+		b, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return func(it item) (*dynamodb.AttributeValue, error) {
+			x, err := a(it)
+			if err != nil {
+				return nil, err
+			}
+			y, err := b(it)
+			if err != nil {
+				return nil, err
+			}
+			if x == nil || y == nil || x.L == nil || y.L == nil {
+				return nil, validationError("Incorrect operand type for operator or function; operator or function: list_append")
+			}
+			l := append(append([]*dynamodb.AttributeValue{}, x.L...), y.L...)
+			return &dynamodb.AttributeValue{L: l}, nil
+		}, nil
+	}
+
+	pa, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	return func(it item) (*dynamodb.AttributeValue, error) { return pa.get(it), nil }, nil
+}
+
+func (p *parser) peekAt(offset int, punct string) bool {
+	i := p.pos + offset
+	return i < len(p.tokens) && p.tokens[i].kind == tokPunct && p.tokens[i].text == punct
+}
+
+// condition is a parsed condition expression
+type condition func(it item) (bool, error)
+
+func parseCondition(ctx *exprContext, expr string) (condition, error) {
+	p, err := newParser(ctx, expr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	return c, p.done()
+}
+
+func (p *parser) parseOr() (condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(it item) (bool, error) {
+			if ok, err := l(it); ok || err != nil {
+				return ok, err
+			}
+			return right(it)
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (condition, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(it item) (bool, error) {
+			if ok, err := l(it); !ok || err != nil {
+				return ok, err
+			}
+			return right(it)
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (condition, error) {
+	if p.isKeyword("NOT") {
+		p.next()
+		c, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(it item) (bool, error) {
+			ok, err := c(it)
+			return !ok, err
+		}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (condition, error) {
+	if p.isPunct("(") {
+		p.next()
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return c, p.expectPunct(")")
+	}
+
+	if t := p.peek(); t.kind == tokIdent && p.peekAt(1, "(") {
+		switch strings.ToLower(t.text) {
+		case "attribute_exists", "attribute_not_exists":
+			p.next()
+			p.next()
+			pa, err := p.parsePath()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct(")"); err != nil {
+				return nil, err
+			}
+			exists := strings.EqualFold(t.text, "attribute_exists")
+			return func(it item) (bool, error) { return (pa.get(it) != nil) == exists, nil }, nil
+
+		case "attribute_type":
+			p.next()
+			p.next()
+			pa, err := p.parsePath()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct(","); err != nil {
+				return nil, err
+			}
+			want, err := p.parseValueRef()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct(")"); err != nil {
+				return nil, err
+			}
+			return func(it item) (bool, error) {
+				return want.S != nil && typeOf(pa.get(it)) == *want.S, nil
+			}, nil
+
+		case "begins_with", "contains":
+			p.next()
+			p.next()
+			a, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct(","); err != nil {
+				return nil, err
+			}
+			b, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct(")"); err != nil {
+				return nil, err
+			}
+			fn := beginsWith
+			if strings.EqualFold(t.text, "contains") {
+				fn = contains
+			}
+			return func(it item) (bool, error) {
+				x, err := a(it)
+				if err != nil {
+					return false, err
+				}
+				y, err := b(it)
+				if err != nil {
+					return false, err
+				}
+				return fn(x, y), nil
+			}, nil
+		}
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.isKeyword("BETWEEN"):
+		p.next()
+		low, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isKeyword("AND") {
+			return nil, p.errorf("expected AND")
+		}
+		p.next()
+		high, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return func(it item) (bool, error) {
+			vals, err := evalOperands(it, left, low, high)
+			if err != nil {
+				return false, err
+			}
+			c1, ok1 := compareValues(vals[0], vals[1])
+			c2, ok2 := compareValues(vals[0], vals[2])
+			return ok1 && ok2 && c1 >= 0 && c2 <= 0, nil
+		}, nil
+
+	case p.isKeyword("IN"):
+		p.next()
+		if err := p.expectPunct("("); err != nil {
+			return nil, err
+		}
+		var candidates []operand
+		for {
+			o, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, o)
+			if !p.isPunct(",") {
+				break
+			}
+			p.next()
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return func(it item) (bool, error) {
+			v, err := left(it)
+			if err != nil || v == nil {
+				return false, err
+			}
+			for _, c := range candidates {
+				w, err := c(it)
+				if err != nil {
+					return false, err
+				}
+				if equalValues(v, w) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}, nil
+	}
+
+	t := p.next()
+	if t.kind != tokPunct {
+		p.pos--
+		return nil, p.errorf("expected a comparison")
+	}
+	op := t.text
+	switch op {
+	case "=", "<>", "<", "<=", ">", ">=":
+	default:
+		p.pos--
+		return nil, p.errorf("expected a comparison")
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return func(it item) (bool, error) {
+		vals, err := evalOperands(it, left, right)
+		if err != nil {
+			return false, err
+		}
+		return compare(op, vals[0], vals[1]), nil
+	}, nil
+}
+
+func evalOperands(it item, operands ...operand) ([]*dynamodb.AttributeValue, error) {
+	vals := make([]*dynamodb.AttributeValue, len(operands))
+	for i, o := range operands {
+		v, err := o(it)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// compare applies a comparison operator. A missing attribute equals nothing,
+// so = is false and <> is true, and it can't be ordered.
+func compare(op string, a, b *dynamodb.AttributeValue) bool {
+	switch op {
+	case "=":
+		return a != nil && b != nil && equalValues(a, b)
+	case "<>":
+		return a == nil || b == nil || !equalValues(a, b)
+	}
+	c, ok := compareValues(a, b)
+	if !ok {
+		return false
+	}
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+func beginsWith(a, b *dynamodb.AttributeValue) bool {
+	switch {
+	case a == nil || b == nil:
+		return false
+	case a.S != nil && b.S != nil:
+		return strings.HasPrefix(*a.S, *b.S)
+	case a.B != nil && b.B != nil:
+		return strings.HasPrefix(string(a.B), string(b.B))
+	}
+	return false
+}
+
+func contains(a, b *dynamodb.AttributeValue) bool {
+	switch {
+	case a == nil || b == nil:
+		return false
+	case a.S != nil && b.S != nil:
+		return strings.Contains(*a.S, *b.S)
+	case a.SS != nil && b.S != nil:
+		for _, s := range a.SS {
+			if aws.StringValue(s) == *b.S {
+				return true
+			}
+		}
+	case a.NS != nil && b.N != nil:
+		for _, n := range a.NS {
+			if equalValues(&dynamodb.AttributeValue{N: n}, b) {
+				return true
+			}
+		}
+	case a.BS != nil && b.B != nil:
+		for _, x := range a.BS {
+			if string(x) == string(b.B) {
+				return true
+			}
+		}
+	case a.L != nil:
+		for _, e := range a.L {
+			if equalValues(e, b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// projection is a parsed projection expression
+type projection []path
+
+func parseProjection(ctx *exprContext, expr string) (projection, error) {
+	p, err := newParser(ctx, expr)
+	if err != nil {
+		return nil, err
+	}
+	var paths projection
+	for {
+		pa, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, pa)
+		if !p.isPunct(",") {
+			break
+		}
+		p.next()
+	}
+	return paths, p.done()
+}
+
+// apply returns the projected attributes of it; nested paths keep the whole
+// top level attribute they are in
+func (pr projection) apply(it item) item {
+	if pr == nil || it == nil {
+		return it
+	}
+	out := item{}
+	for _, pa := range pr {
+		if v, ok := it[pa[0].name]; ok {
+			out[pa[0].name] = v
+		}
+	}
+	return out
+}
+
+type updateAction struct {
+	kind  string // SET, REMOVE, ADD or DELETE
+	path  path
+	value operand
+}
+
+func parseUpdate(ctx *exprContext, expr string) ([]updateAction, error) {
+	p, err := newParser(ctx, expr)
+	if err != nil {
+		return nil, err
+	}
+	var actions []updateAction
+	seen := map[string]bool{}
+	for p.peek().kind != tokEOF {
+		t := p.next()
+		kind := strings.ToUpper(t.text)
+		if t.kind != tokIdent || (kind != "SET" && kind != "REMOVE" && kind != "ADD" && kind != "DELETE") {
+			p.pos--
+			return nil, p.errorf("expected SET, REMOVE, ADD or DELETE")
+		}
+		if seen[kind] {
+			return nil, validationError("Invalid UpdateExpression: The %q section can only be used once in an update expression", kind)
+		}
+		seen[kind] = true
+		for {
+			pa, err := p.parsePath()
+			if err != nil {
+				return nil, err
+			}
+			action := updateAction{kind: kind, path: pa}
+			switch kind {
+			case "SET":
+				if err := p.expectPunct("="); err != nil {
+					return nil, err
+				}
+				if action.value, err = p.parseSetValue(); err != nil {
+					return nil, err
+				}
+			case "ADD", "DELETE":
+				if action.value, err = p.parseOperand(); err != nil {
+					return nil, err
+				}
+			}
+			actions = append(actions, action)
+			if !p.isPunct(",") {
+				break
+			}
+			p.next()
+		}
+	}
+	if len(actions) == 0 {
+		return nil, validationError("Invalid UpdateExpression: The expression can not be empty")
+	}
+	return actions, nil
+}
+
+func (p *parser) parseSetValue() (operand, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if !p.isPunct("+") && !p.isPunct("-") {
+		return left, nil
+	}
+	op := p.next().text
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return func(it item) (*dynamodb.AttributeValue, error) {
+		vals, err := evalOperands(it, left, right)
+		if err != nil {
+			return nil, err
+		}
+		if vals[0] == nil || vals[1] == nil || vals[0].N == nil || vals[1].N == nil {
+			return nil, validationError("An operand in the update expression has an incorrect data type")
+		}
+		x, err := parseNumber(*vals[0].N)
+		if err != nil {
+			return nil, err
+		}
+		y, err := parseNumber(*vals[1].N)
+		if err != nil {
+			return nil, err
+		}
+		if op == "+" {
+			x = new(big.Rat).Add(x, y)
+		} else {
+			x = new(big.Rat).Sub(x, y)
+		}
+		return &dynamodb.AttributeValue{N: aws.String(formatNumber(x))}, nil
+	}, nil
+}
+
+// applyUpdate applies actions to it, in place. Every value is evaluated
+// against the item as it was before the update.
+func applyUpdate(actions []updateAction, it item) error {
+	before := copyItem(it)
+	for _, a := range actions {
+		var value *dynamodb.AttributeValue
+		if a.value != nil {
+			v, err := a.value(before)
+			if err != nil {
+				return err
+			}
+			if v == nil {
+				return validationError("The provided expression refers to an attribute that does not exist in the item")
+			}
+			value = copyValue(v)
+		}
+
+		switch a.kind {
+		case "SET":
+			if err := a.path.set(it, value); err != nil {
+				return err
+			}
+		case "REMOVE":
+			a.path.remove(it)
+		case "ADD":
+			current := a.path.get(it)
+			next, err := addValues(current, value)
+			if err != nil {
+				return err
+			}
+			if err := a.path.set(it, next); err != nil {
+				return err
+			}
+		case "DELETE":
+			current := a.path.get(it)
+			if current == nil {
+				continue
+			}
+			next, err := deleteValues(current, value)
+			if err != nil {
+				return err
+			}
+			if next == nil {
+				a.path.remove(it)
+			} else if err := a.path.set(it, next); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func addValues(current, value *dynamodb.AttributeValue) (*dynamodb.AttributeValue, error) {
+	switch typeOf(value) {
+	case "N":
+		if current == nil {
+			return value, nil
+		}
+		if current.N == nil {
+			return nil, validationError("An operand in the update expression has an incorrect data type")
+		}
+		x, err := parseNumber(*current.N)
+		if err != nil {
+			return nil, err
+		}
+		y, err := parseNumber(*value.N)
+		if err != nil {
+			return nil, err
+		}
+		return &dynamodb.AttributeValue{N: aws.String(formatNumber(new(big.Rat).Add(x, y)))}, nil
+	case "SS", "NS", "BS":
+		if current == nil {
+			return value, nil
+		}
+		if typeOf(current) != typeOf(value) {
+			return nil, validationError("An operand in the update expression has an incorrect data type")
+		}
+		return setUnion(current, value), nil
+	}
+	return nil, validationError("Incorrect operand type for operator or function; operator: ADD, operand type: %v", typeOf(value))
+}
+
+func deleteValues(current, value *dynamodb.AttributeValue) (*dynamodb.AttributeValue, error) {
+	if typeOf(current) != typeOf(value) {
+		return nil, validationError("An operand in the update expression has an incorrect data type")
+	}
+	remove := map[string]bool{}
+	for _, m := range setMembers(value) {
+		remove[m] = true
+	}
+	out := &dynamodb.AttributeValue{}
+	switch typeOf(current) {
+	case "SS":
+		for _, s := range current.SS {
+			if !remove[aws.StringValue(s)] {
+				out.SS = append(out.SS, s)
+			}
+		}
+	case "NS":
+		for _, n := range current.NS {
+			if !remove[normalizeNumbers([]*string{n})[0]] {
+				out.NS = append(out.NS, n)
+			}
+		}
+	case "BS":
+		for _, b := range current.BS {
+			if !remove[string(b)] {
+				out.BS = append(out.BS, b)
+			}
+		}
+	default:
+		return nil, validationError("Incorrect operand type for operator or function; operator: DELETE, operand type: %v", typeOf(value))
+	}
+	if out.SS == nil && out.NS == nil && out.BS == nil {
+		// DynamoDB doesn't store empty sets
+		return nil, nil
+	}
+	return out, nil
+}
+
+func setMembers(v *dynamodb.AttributeValue) []string {
+	switch typeOf(v) {
+	case "SS":
+		return aws.StringValueSlice(v.SS)
+	case "NS":
+		return normalizeNumbers(v.NS)
+	case "BS":
+		return bytesToStrings(v.BS)
+	}
+	return nil
+}
+
+func setUnion(a, b *dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	seen := map[string]bool{}
+	for _, m := range setMembers(a) {
+		seen[m] = true
+	}
+	out := copyValue(a)
+	switch typeOf(a) {
+	case "SS":
+		for _, s := range b.SS {
+			if !seen[aws.StringValue(s)] {
+				out.SS = append(out.SS, aws.String(aws.StringValue(s)))
+				seen[aws.StringValue(s)] = true
+			}
+		}
+	case "NS":
+		for _, n := range b.NS {
+			key := normalizeNumbers([]*string{n})[0]
+			if !seen[key] {
+				out.NS = append(out.NS, aws.String(aws.StringValue(n)))
+				seen[key] = true
+			}
+		}
+	case "BS":
+		for _, x := range b.BS {
+			if !seen[string(x)] {
+				out.BS = append(out.BS, append([]byte{}, x...))
+				seen[string(x)] = true
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,533 @@
+package memddb
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// write is a change to one item, worked out before it is applied so a
+// transaction can check every condition first
+type write struct {
+	table *table
+	key   string
+	old   item
+	// next is the new item, or nil to delete it
+	next item
+	// check is set for condition checks, which change nothing
+	check bool
+}
+
+func (w write) apply() {
+	switch {
+	case w.check:
+	case w.next == nil:
+		delete(w.table.items, w.key)
+	default:
+		w.table.items[w.key] = w.next
+	}
+}
+
+// checkCondition evaluates a condition expression against the current item,
+// or an empty one if there is none
+func checkCondition(ctx *exprContext, expr *string, current item) error {
+	if expr == nil {
+		return nil
+	}
+	cond, err := parseCondition(ctx, *expr)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		current = item{}
+	}
+	ok, err := cond(current)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return conditionFailed()
+	}
+	return nil
+}
+
+// All the plan functions must be called with db.mu held
+
+func (db *DB) planPut(tableName *string, it item, condition *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (write, error) {
+	t, err := db.table(tableName)
+	if err != nil {
+		return write{}, err
+	}
+	key, err := t.keyOf(t.keySchema, it)
+	if err != nil {
+		return write{}, err
+	}
+	for name, idx := range t.indexes {
+		if _, err := t.keyOf(idx.keySchema, keyAttributes(it, idx.keySchema)); err != nil && hasAny(it, idx.names()) {
+			return write{}, validationError("One or more parameter values were invalid: index key for %v: %v", name, err)
+		}
+	}
+	w := write{table: t, key: key, old: t.items[key], next: copyItem(it)}
+	ctx := newExprContext(names, values)
+	if err := checkCondition(ctx, condition, w.old); err != nil {
+		return write{}, err
+	}
+	return w, ctx.checkUnused()
+}
+
+func hasAny(it item, names []string) bool {
+	for _, name := range names {
+		if _, ok := it[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *DB) planDelete(tableName *string, key item, condition *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (write, error) {
+	t, err := db.table(tableName)
+	if err != nil {
+		return write{}, err
+	}
+	k, err := t.primaryKey(key)
+	if err != nil {
+		return write{}, err
+	}
+	w := write{table: t, key: k, old: t.items[k]}
+	ctx := newExprContext(names, values)
+	if err := checkCondition(ctx, condition, w.old); err != nil {
+		return write{}, err
+	}
+	if w.old == nil {
+		// Nothing to delete
+		w.check = true
+	}
+	return w, ctx.checkUnused()
+}
+
+func (db *DB) planCheck(tableName *string, key item, condition *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (write, error) {
+	w, err := db.planDelete(tableName, key, condition, names, values)
+	w.check = true
+	return w, err
+}
+
+func (db *DB) planUpdate(tableName *string, key item, update, condition *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (write, error) {
+	t, err := db.table(tableName)
+	if err != nil {
+		return write{}, err
+	}
+	k, err := t.primaryKey(key)
+	if err != nil {
+		return write{}, err
+	}
+	w := write{table: t, key: k, old: t.items[k]}
+	ctx := newExprContext(names, values)
+	if err := checkCondition(ctx, condition, w.old); err != nil {
+		return write{}, err
+	}
+
+	next := copyItem(w.old)
+	if next == nil {
+		next = copyItem(key)
+	}
+	if update != nil {
+		actions, err := parseUpdate(ctx, *update)
+		if err != nil {
+			return write{}, err
+		}
+		for _, a := range actions {
+			for _, name := range t.names() {
+				if a.path[0].name == name {
+					return write{}, validationError("One or more parameter values were invalid: Cannot update attribute %v. This attribute is part of the key", name)
+				}
+			}
+		}
+		if err := applyUpdate(actions, next); err != nil {
+			return write{}, err
+		}
+	}
+	w.next = next
+	return w, ctx.checkUnused()
+}
+
+// updatedAttributes returns the attributes of it that are in either old or
+// new and differ between them
+func updatedAttributes(it, old, next item) item {
+	out := item{}
+	for name, v := range it {
+		if !equalValues(old[name], next[name]) {
+			out[name] = v
+		}
+	}
+	return out
+}
+
+func (db *DB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return db.GetItemWithContext(context.Background(), input)
+}
+
+func (db *DB) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, _ ...request.Option) (*dynamodb.GetItemOutput, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	k, err := t.primaryKey(input.Key)
+	if err != nil {
+		return nil, err
+	}
+	var proj projection
+	if input.ProjectionExpression != nil {
+		ectx := newExprContext(input.ExpressionAttributeNames, nil)
+		if proj, err = parseProjection(ectx, *input.ProjectionExpression); err != nil {
+			return nil, err
+		}
+		if err := ectx.checkUnused(); err != nil {
+			return nil, err
+		}
+	}
+	return &dynamodb.GetItemOutput{Item: copyItem(proj.apply(t.items[k]))}, nil
+}
+
+func (db *DB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	return db.PutItemWithContext(context.Background(), input)
+}
+
+func (db *DB) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, _ ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	w, err := db.planPut(input.TableName, input.Item, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	w.apply()
+	out := &dynamodb.PutItemOutput{}
+	if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllOld {
+		out.Attributes = copyItem(w.old)
+	}
+	return out, nil
+}
+
+func (db *DB) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	return db.DeleteItemWithContext(context.Background(), input)
+}
+
+func (db *DB) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, _ ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	w, err := db.planDelete(input.TableName, input.Key, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	w.apply()
+	out := &dynamodb.DeleteItemOutput{}
+	if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllOld {
+		out.Attributes = copyItem(w.old)
+	}
+	return out, nil
+}
+
+func (db *DB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	return db.UpdateItemWithContext(context.Background(), input)
+}
+
+func (db *DB) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, _ ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	if len(input.AttributeUpdates) > 0 {
+		return nil, validationError("AttributeUpdates is not supported; use UpdateExpression")
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	w, err := db.planUpdate(input.TableName, input.Key, input.UpdateExpression, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	w.apply()
+
+	out := &dynamodb.UpdateItemOutput{}
+	switch aws.StringValue(input.ReturnValues) {
+	case dynamodb.ReturnValueAllOld:
+		out.Attributes = copyItem(w.old)
+	case dynamodb.ReturnValueUpdatedOld:
+		if w.old != nil {
+			out.Attributes = copyItem(updatedAttributes(w.old, w.old, w.next))
+		}
+	case dynamodb.ReturnValueAllNew:
+		out.Attributes = copyItem(w.next)
+	case dynamodb.ReturnValueUpdatedNew:
+		out.Attributes = copyItem(updatedAttributes(w.next, w.old, w.next))
+	}
+	return out, nil
+}
+
+// maxBatchGetItems and maxBatchWriteItems are DynamoDB's limits per request
+const (
+	maxBatchGetItems   = 100
+	maxBatchWriteItems = 25
+	maxTransactItems   = 100
+)
+
+func (db *DB) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	return db.BatchGetItemWithContext(context.Background(), input)
+}
+
+func (db *DB) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, _ ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	var n int
+	for _, ka := range input.RequestItems {
+		n += len(ka.Keys)
+	}
+	if n == 0 || n > maxBatchGetItems {
+		return nil, validationError("Too many items requested for the BatchGetItem call: %v", n)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	out := &dynamodb.BatchGetItemOutput{
+		Responses:       map[string][]map[string]*dynamodb.AttributeValue{},
+		UnprocessedKeys: map[string]*dynamodb.KeysAndAttributes{},
+	}
+	for tableName, ka := range input.RequestItems {
+		t, err := db.table(aws.String(tableName))
+		if err != nil {
+			return nil, err
+		}
+		var proj projection
+		if ka.ProjectionExpression != nil {
+			ectx := newExprContext(ka.ExpressionAttributeNames, nil)
+			if proj, err = parseProjection(ectx, *ka.ProjectionExpression); err != nil {
+				return nil, err
+			}
+			if err := ectx.checkUnused(); err != nil {
+				return nil, err
+			}
+		}
+		seen := map[string]bool{}
+		for _, key := range ka.Keys {
+			k, err := t.primaryKey(key)
+			if err != nil {
+				return nil, err
+			}
+			if seen[k] {
+				return nil, validationError("Provided list of item keys contains duplicates")
+			}
+			seen[k] = true
+			if db.takeUnprocessed() {
+				unprocessed := out.UnprocessedKeys[tableName]
+				if unprocessed == nil {
+					unprocessed = &dynamodb.KeysAndAttributes{
+						ConsistentRead:           ka.ConsistentRead,
+						ExpressionAttributeNames: ka.ExpressionAttributeNames,
+						ProjectionExpression:     ka.ProjectionExpression,
+					}
+					out.UnprocessedKeys[tableName] = unprocessed
+				}
+				unprocessed.Keys = append(unprocessed.Keys, copyItem(key))
+				continue
+			}
+			if it, ok := t.items[k]; ok {
+				out.Responses[tableName] = append(out.Responses[tableName], copyItem(proj.apply(it)))
+			}
+		}
+	}
+	return out, nil
+}
+
+func (db *DB) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	return db.BatchWriteItemWithContext(context.Background(), input)
+}
+
+// BatchWriteItemWithContext applies each write independently; unlike a
+// transaction, the writes before an invalid one are kept
+func (db *DB) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, _ ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	var n int
+	for _, requests := range input.RequestItems {
+		n += len(requests)
+	}
+	if n == 0 || n > maxBatchWriteItems {
+		return nil, validationError("Too many items requested for the BatchWriteItem call: %v", n)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// Validate everything first, as DynamoDB rejects the whole request if any
+	// write is invalid
+	type pending struct {
+		table   string
+		request *dynamodb.WriteRequest
+		write   write
+	}
+	var writes []pending
+	seen := map[string]bool{}
+	for tableName, requests := range input.RequestItems {
+		for _, r := range requests {
+			var (
+				w   write
+				err error
+			)
+			switch {
+			case r.PutRequest != nil:
+				w, err = db.planPut(aws.String(tableName), r.PutRequest.Item, nil, nil, nil)
+			case r.DeleteRequest != nil:
+				w, err = db.planDelete(aws.String(tableName), r.DeleteRequest.Key, nil, nil, nil)
+			default:
+				err = validationError("A write request must contain a PutRequest or a DeleteRequest")
+			}
+			if err != nil {
+				return nil, err
+			}
+			if seen[tableName+"|"+w.key] {
+				return nil, validationError("Provided list of item keys contains duplicates")
+			}
+			seen[tableName+"|"+w.key] = true
+			writes = append(writes, pending{table: tableName, request: r, write: w})
+		}
+	}
+
+	out := &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]*dynamodb.WriteRequest{}}
+	for _, p := range writes {
+		if db.takeUnprocessed() {
+			out.UnprocessedItems[p.table] = append(out.UnprocessedItems[p.table], p.request)
+			continue
+		}
+		p.write.apply()
+	}
+	return out, nil
+}
+
+func (db *DB) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	return db.TransactWriteItemsWithContext(context.Background(), input)
+}
+
+// TransactWriteItemsWithContext checks every condition before applying any
+// write. If a condition fails, nothing is written and the error is a
+// TransactionCanceledException with a reason for each item.
+func (db *DB) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, _ ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	if n := len(input.TransactItems); n == 0 || n > maxTransactItems {
+		return nil, validationError("Member must have length between 1 and %v; got %v", maxTransactItems, n)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var (
+		writes   []write
+		reasons  []*dynamodb.CancellationReason
+		canceled bool
+		seen     = map[*table]map[string]bool{}
+	)
+	for _, ti := range input.TransactItems {
+		var (
+			w   write
+			err error
+		)
+		switch {
+		case ti.Put != nil:
+			p := ti.Put
+			w, err = db.planPut(p.TableName, p.Item, p.ConditionExpression, p.ExpressionAttributeNames, p.ExpressionAttributeValues)
+		case ti.Update != nil:
+			u := ti.Update
+			w, err = db.planUpdate(u.TableName, u.Key, u.UpdateExpression, u.ConditionExpression, u.ExpressionAttributeNames, u.ExpressionAttributeValues)
+		case ti.Delete != nil:
+			d := ti.Delete
+			w, err = db.planDelete(d.TableName, d.Key, d.ConditionExpression, d.ExpressionAttributeNames, d.ExpressionAttributeValues)
+		case ti.ConditionCheck != nil:
+			c := ti.ConditionCheck
+			w, err = db.planCheck(c.TableName, c.Key, c.ConditionExpression, c.ExpressionAttributeNames, c.ExpressionAttributeValues)
+		default:
+			return nil, validationError("A transact item must contain a Put, Update, Delete or ConditionCheck")
+		}
+
+		if _, ok := err.(*dynamodb.ConditionalCheckFailedException); ok {
+			canceled = true
+			reasons = append(reasons, &dynamodb.CancellationReason{
+				Code:    aws.String("ConditionalCheckFailed"),
+				Message: aws.String("The conditional request failed"),
+			})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if seen[w.table] == nil {
+			seen[w.table] = map[string]bool{}
+		}
+		if seen[w.table][w.key] {
+			return nil, validationError("Transaction request cannot include multiple operations on one item")
+		}
+		seen[w.table][w.key] = true
+		writes = append(writes, w)
+		reasons = append(reasons, &dynamodb.CancellationReason{Code: aws.String("None")})
+	}
+
+	if canceled {
+		return nil, &dynamodb.TransactionCanceledException{
+			Message_:            aws.String("Transaction cancelled, please refer cancellation reasons for specific reasons"),
+			CancellationReasons: reasons,
+		}
+	}
+	for _, w := range writes {
+		w.apply()
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func (db *DB) TransactGetItems(input *dynamodb.TransactGetItemsInput) (*dynamodb.TransactGetItemsOutput, error) {
+	return db.TransactGetItemsWithContext(context.Background(), input)
+}
+
+func (db *DB) TransactGetItemsWithContext(ctx aws.Context, input *dynamodb.TransactGetItemsInput, _ ...request.Option) (*dynamodb.TransactGetItemsOutput, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	if n := len(input.TransactItems); n == 0 || n > maxTransactItems {
+		return nil, validationError("Member must have length between 1 and %v; got %v", maxTransactItems, n)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	out := &dynamodb.TransactGetItemsOutput{}
+	for _, ti := range input.TransactItems {
+		if ti.Get == nil {
+			return nil, validationError("A transact get item must contain a Get")
+		}
+		t, err := db.table(ti.Get.TableName)
+		if err != nil {
+			return nil, err
+		}
+		k, err := t.primaryKey(ti.Get.Key)
+		if err != nil {
+			return nil, err
+		}
+		var proj projection
+		if ti.Get.ProjectionExpression != nil {
+			ectx := newExprContext(ti.Get.ExpressionAttributeNames, nil)
+			if proj, err = parseProjection(ectx, *ti.Get.ProjectionExpression); err != nil {
+				return nil, err
+			}
+		}
+		out.Responses = append(out.Responses, &dynamodb.ItemResponse{Item: copyItem(proj.apply(t.items[k]))})
+	}
+	return out, nil
+}
@@ -0,0 +1,432 @@
+// Package memddb is an in-memory implementation of the parts of
+// dynamodbiface.DynamoDBAPI the DAOs in this repository use, so they can be
+// tested with plain go test rather than against DynamoDB Local.
+//
+// It supports tables with global and local secondary indexes; GetItem,
+// PutItem, DeleteItem and UpdateItem with condition, update and projection
+// expressions; Query and Scan with filters, limits and pagination;
+// BatchGetItem and BatchWriteItem, optionally leaving items unprocessed;
+// TransactGetItems and TransactWriteItems; and TTL. Methods it doesn't
+// implement panic.
+//
+//	api := memddb.New()
+//	table := ddb.New(api).MustTable("cursors", cursordao.Record{})
+//	err := table.CreateTableIfNotExists(ctx)
+package memddb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// DB is an in-memory DynamoDB. It is safe for concurrent use; every request
+// is applied atomically.
+type DB struct {
+	// Unimplemented methods panic on the nil interface
+	dynamodbiface.DynamoDBAPI
+
+	now func() time.Time
+
+	mu          sync.Mutex
+	tables      map[string]*table
+	unprocessed int
+}
+
+type Option func(*DB)
+
+// WithClock sets the clock used for TTL expiry and table timestamps
+func WithClock(now func() time.Time) Option {
+	return func(db *DB) {
+		db.now = now
+	}
+}
+
+func New(opts ...Option) *DB {
+	db := &DB{
+		now:    time.Now,
+		tables: map[string]*table{},
+	}
+	for _, opt := range opts {
+		opt(db)
+	}
+	return db
+}
+
+// DeferBatchItems makes the next n items requested through BatchGetItem or
+// BatchWriteItem come back unprocessed, as DynamoDB does when throttled, to
+// exercise retry loops
+func (db *DB) DeferBatchItems(n int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.unprocessed = n
+}
+
+// takeUnprocessed reports whether the next batch item should be left
+// unprocessed; it must be called with db.mu held
+func (db *DB) takeUnprocessed() bool {
+	if db.unprocessed <= 0 {
+		return false
+	}
+	db.unprocessed--
+	return true
+}
+
+type keySchema struct {
+	hash string
+	rng  string
+}
+
+func (k keySchema) names() []string {
+	if k.rng == "" {
+		return []string{k.hash}
+	}
+	return []string{k.hash, k.rng}
+}
+
+type index struct {
+	keySchema
+	projection *dynamodb.Projection
+}
+
+type table struct {
+	keySchema
+	desc    *dynamodb.TableDescription
+	types   map[string]string
+	indexes map[string]index
+	items   map[string]item
+	ttl     string
+}
+
+func validationError(format string, args ...interface{}) error {
+	return awserr.New("ValidationException", fmt.Sprintf(format, args...), nil)
+}
+
+func conditionFailed() error {
+	return &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed")}
+}
+
+func tableNotFound(name string) error {
+	return &dynamodb.ResourceNotFoundException{Message_: aws.String("Requested resource not found: Table: " + name + " not found")}
+}
+
+func checkContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	return nil
+}
+
+// table returns the named table, with expired items removed; it must be
+// called with db.mu held
+func (db *DB) table(name *string) (*table, error) {
+	t, ok := db.tables[aws.StringValue(name)]
+	if !ok {
+		return nil, tableNotFound(aws.StringValue(name))
+	}
+	t.expire(db.now())
+	return t, nil
+}
+
+// expire removes items whose TTL has passed. DynamoDB deletes them some time
+// after they expire; here they go as soon as they're looked at, so tests are
+// deterministic.
+func (t *table) expire(now time.Time) {
+	if t.ttl == "" {
+		return
+	}
+	for k, it := range t.items {
+		v := it[t.ttl]
+		if v == nil || v.N == nil {
+			continue
+		}
+		at, err := parseNumber(*v.N)
+		if err != nil {
+			continue
+		}
+		if f, _ := at.Float64(); int64(f) > 0 && int64(f) <= now.Unix() {
+			delete(t.items, k)
+		}
+	}
+}
+
+// keyOf validates the key attributes of it against schema and returns the
+// key they encode
+func (t *table) keyOf(schema keySchema, it item) (string, error) {
+	for _, name := range schema.names() {
+		v := it[name]
+		if v == nil {
+			return "", validationError("One or more parameter values were invalid: Missing the key %v in the item", name)
+		}
+		if want := t.types[name]; want != "" && typeOf(v) != want {
+			return "", validationError("One or more parameter values were invalid: Type mismatch for key %v expected: %v actual: %v", name, want, typeOf(v))
+		}
+		if (v.S != nil && *v.S == "") || (v.B != nil && len(v.B) == 0) {
+			return "", validationError("One or more parameter values are not valid. The AttributeValue for a key attribute cannot contain an empty string value. Key: %v", name)
+		}
+	}
+	return keyString(it, schema.names()...), nil
+}
+
+// primaryKey validates a key passed to GetItem, DeleteItem, ...
+func (t *table) primaryKey(key item) (string, error) {
+	if len(key) != len(t.names()) {
+		return "", validationError("The provided key element does not match the schema")
+	}
+	return t.keyOf(t.keySchema, key)
+}
+
+// keyAttributes returns just the attributes of it named by schemas
+func keyAttributes(it item, schemas ...keySchema) item {
+	out := item{}
+	for _, schema := range schemas {
+		for _, name := range schema.names() {
+			if v, ok := it[name]; ok {
+				out[name] = copyValue(v)
+			}
+		}
+	}
+	return out
+}
+
+func (db *DB) CreateTable(input *dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error) {
+	return db.CreateTableWithContext(context.Background(), input)
+}
+
+func (db *DB) CreateTableWithContext(ctx aws.Context, input *dynamodb.CreateTableInput, _ ...request.Option) (*dynamodb.CreateTableOutput, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	name := aws.StringValue(input.TableName)
+	if name == "" {
+		return nil, validationError("TableName must be specified")
+	}
+	if _, ok := db.tables[name]; ok {
+		return nil, &dynamodb.ResourceInUseException{Message_: aws.String("Table already exists: " + name)}
+	}
+
+	t := &table{
+		types:   map[string]string{},
+		indexes: map[string]index{},
+		items:   map[string]item{},
+	}
+	for _, def := range input.AttributeDefinitions {
+		t.types[aws.StringValue(def.AttributeName)] = aws.StringValue(def.AttributeType)
+	}
+	schema, err := parseKeySchema(t.types, input.KeySchema)
+	if err != nil {
+		return nil, err
+	}
+	t.keySchema = schema
+
+	now := db.now()
+	desc := &dynamodb.TableDescription{
+		AttributeDefinitions: input.AttributeDefinitions,
+		CreationDateTime:     aws.Time(now),
+		ItemCount:            aws.Int64(0),
+		KeySchema:            input.KeySchema,
+		TableArn:             aws.String("arn:aws:dynamodb:local:000000000000:table/" + name),
+		TableName:            aws.String(name),
+		TableSizeBytes:       aws.Int64(0),
+		TableStatus:          aws.String(dynamodb.TableStatusActive),
+		StreamSpecification:  input.StreamSpecification,
+	}
+	if input.BillingMode != nil {
+		desc.BillingModeSummary = &dynamodb.BillingModeSummary{BillingMode: input.BillingMode}
+	}
+	if input.ProvisionedThroughput != nil {
+		desc.ProvisionedThroughput = &dynamodb.ProvisionedThroughputDescription{
+			ReadCapacityUnits:  input.ProvisionedThroughput.ReadCapacityUnits,
+			WriteCapacityUnits: input.ProvisionedThroughput.WriteCapacityUnits,
+		}
+	}
+	if spec := input.StreamSpecification; spec != nil && aws.BoolValue(spec.StreamEnabled) {
+		label := now.UTC().Format("2006-01-02T15:04:05.000")
+		desc.LatestStreamLabel = aws.String(label)
+		desc.LatestStreamArn = aws.String(aws.StringValue(desc.TableArn) + "/stream/" + label)
+	}
+
+	for _, gsi := range input.GlobalSecondaryIndexes {
+		schema, err := parseKeySchema(t.types, gsi.KeySchema)
+		if err != nil {
+			return nil, err
+		}
+		t.indexes[aws.StringValue(gsi.IndexName)] = index{keySchema: schema, projection: gsi.Projection}
+		desc.GlobalSecondaryIndexes = append(desc.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndexDescription{
+			IndexName:   gsi.IndexName,
+			IndexStatus: aws.String(dynamodb.IndexStatusActive),
+			KeySchema:   gsi.KeySchema,
+			Projection:  gsi.Projection,
+		})
+	}
+	for _, lsi := range input.LocalSecondaryIndexes {
+		schema, err := parseKeySchema(t.types, lsi.KeySchema)
+		if err != nil {
+			return nil, err
+		}
+		if schema.hash != t.hash {
+			return nil, validationError("Local secondary index %v must have the same hash key as the table", aws.StringValue(lsi.IndexName))
+		}
+		t.indexes[aws.StringValue(lsi.IndexName)] = index{keySchema: schema, projection: lsi.Projection}
+		desc.LocalSecondaryIndexes = append(desc.LocalSecondaryIndexes, &dynamodb.LocalSecondaryIndexDescription{
+			IndexName:  lsi.IndexName,
+			KeySchema:  lsi.KeySchema,
+			Projection: lsi.Projection,
+		})
+	}
+	t.desc = desc
+	db.tables[name] = t
+
+	return &dynamodb.CreateTableOutput{TableDescription: t.describe()}, nil
+}
+
+func parseKeySchema(types map[string]string, elements []*dynamodb.KeySchemaElement) (keySchema, error) {
+	var schema keySchema
+	for _, e := range elements {
+		name := aws.StringValue(e.AttributeName)
+		if _, ok := types[name]; !ok {
+			return schema, validationError("One or more parameter values were invalid: Some index key attributes are not defined in AttributeDefinitions: %v", name)
+		}
+		switch aws.StringValue(e.KeyType) {
+		case dynamodb.KeyTypeHash:
+			schema.hash = name
+		case dynamodb.KeyTypeRange:
+			schema.rng = name
+		}
+	}
+	if schema.hash == "" {
+		return schema, validationError("One or more parameter values were invalid: A hash key must be specified")
+	}
+	return schema, nil
+}
+
+// describe must be called with db.mu held
+func (t *table) describe() *dynamodb.TableDescription {
+	desc := *t.desc
+	desc.ItemCount = aws.Int64(int64(len(t.items)))
+	return &desc
+}
+
+func (db *DB) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	return db.DescribeTableWithContext(context.Background(), input)
+}
+
+func (db *DB) DescribeTableWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, _ ...request.Option) (*dynamodb.DescribeTableOutput, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.DescribeTableOutput{Table: t.describe()}, nil
+}
+
+func (db *DB) DeleteTable(input *dynamodb.DeleteTableInput) (*dynamodb.DeleteTableOutput, error) {
+	return db.DeleteTableWithContext(context.Background(), input)
+}
+
+func (db *DB) DeleteTableWithContext(ctx aws.Context, input *dynamodb.DeleteTableInput, _ ...request.Option) (*dynamodb.DeleteTableOutput, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	delete(db.tables, aws.StringValue(input.TableName))
+	desc := t.describe()
+	desc.TableStatus = aws.String(dynamodb.TableStatusDeleting)
+	return &dynamodb.DeleteTableOutput{TableDescription: desc}, nil
+}
+
+func (db *DB) ListTables(input *dynamodb.ListTablesInput) (*dynamodb.ListTablesOutput, error) {
+	return db.ListTablesWithContext(context.Background(), input)
+}
+
+func (db *DB) ListTablesWithContext(ctx aws.Context, input *dynamodb.ListTablesInput, _ ...request.Option) (*dynamodb.ListTablesOutput, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	names := make([]string, 0, len(db.tables))
+	for name := range db.tables {
+		if name > aws.StringValue(input.ExclusiveStartTableName) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	out := &dynamodb.ListTablesOutput{}
+	if limit := int(aws.Int64Value(input.Limit)); limit > 0 && len(names) > limit {
+		names = names[:limit]
+		out.LastEvaluatedTableName = aws.String(names[limit-1])
+	}
+	out.TableNames = aws.StringSlice(names)
+	return out, nil
+}
+
+func (db *DB) UpdateTimeToLive(input *dynamodb.UpdateTimeToLiveInput) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	return db.UpdateTimeToLiveWithContext(context.Background(), input)
+}
+
+func (db *DB) UpdateTimeToLiveWithContext(ctx aws.Context, input *dynamodb.UpdateTimeToLiveInput, _ ...request.Option) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	spec := input.TimeToLiveSpecification
+	if spec == nil || spec.AttributeName == nil || spec.Enabled == nil {
+		return nil, validationError("TimeToLiveSpecification must specify an attribute name and whether it is enabled")
+	}
+	if aws.BoolValue(spec.Enabled) {
+		t.ttl = aws.StringValue(spec.AttributeName)
+	} else {
+		t.ttl = ""
+	}
+	return &dynamodb.UpdateTimeToLiveOutput{TimeToLiveSpecification: spec}, nil
+}
+
+func (db *DB) DescribeTimeToLive(input *dynamodb.DescribeTimeToLiveInput) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	return db.DescribeTimeToLiveWithContext(context.Background(), input)
+}
+
+func (db *DB) DescribeTimeToLiveWithContext(ctx aws.Context, input *dynamodb.DescribeTimeToLiveInput, _ ...request.Option) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	desc := &dynamodb.TimeToLiveDescription{TimeToLiveStatus: aws.String(dynamodb.TimeToLiveStatusDisabled)}
+	if t.ttl != "" {
+		desc.TimeToLiveStatus = aws.String(dynamodb.TimeToLiveStatusEnabled)
+		desc.AttributeName = aws.String(t.ttl)
+	}
+	return &dynamodb.DescribeTimeToLiveOutput{TimeToLiveDescription: desc}, nil
+}
@@ -0,0 +1,372 @@
+package memddb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/savaki/ddb"
+	"github.com/tj/assert"
+)
+
+type Order struct {
+	Pool    string   `ddb:"hash"`
+	ID      int64    `ddb:"range"`
+	Owner   string   `ddb:"gsi_hash:owner-index"`
+	Created int64    `ddb:"gsi_range:owner-index"`
+	Amount  int64    `dynamodbav:"amount,omitempty"`
+	Tags    []string `dynamodbav:"tags,stringset,omitempty"`
+	Expires int64    `dynamodbav:"expires,omitempty"`
+}
+
+func withTable(t *testing.T, callback func(ctx context.Context, db *DB, table *ddb.Table)) {
+	var (
+		db    = New()
+		table = ddb.New(db).MustTable("orders", Order{})
+		ctx   = context.Background()
+	)
+	assert.Nil(t, table.CreateTableIfNotExists(ctx))
+	callback(ctx, db, table)
+}
+
+func TestCreateTable(t *testing.T) {
+	withTable(t, func(ctx context.Context, db *DB, table *ddb.Table) {
+		out, err := db.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String("orders")})
+		assert.Nil(t, err)
+		assert.Equal(t, dynamodb.TableStatusActive, aws.StringValue(out.Table.TableStatus))
+		assert.Len(t, out.Table.GlobalSecondaryIndexes, 1)
+
+		// Already exists
+		assert.Nil(t, table.CreateTableIfNotExists(ctx))
+		_, err = db.CreateTableWithContext(ctx, &dynamodb.CreateTableInput{
+			TableName:            aws.String("orders"),
+			AttributeDefinitions: []*dynamodb.AttributeDefinition{{AttributeName: aws.String("pk"), AttributeType: aws.String("S")}},
+			KeySchema:            []*dynamodb.KeySchemaElement{{AttributeName: aws.String("pk"), KeyType: aws.String("HASH")}},
+		})
+		var inUse *dynamodb.ResourceInUseException
+		assert.True(t, errors.As(err, &inUse))
+
+		assert.Nil(t, table.DeleteTableIfExists(ctx))
+		assert.Nil(t, table.DeleteTableIfExists(ctx))
+		_, err = db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String("orders"),
+			Key:       map[string]*dynamodb.AttributeValue{"Pool": {S: aws.String("a")}, "ID": {N: aws.String("1")}},
+		})
+		var notFound *dynamodb.ResourceNotFoundException
+		assert.True(t, errors.As(err, &notFound))
+	})
+}
+
+func TestItems(t *testing.T) {
+	withTable(t, func(ctx context.Context, db *DB, table *ddb.Table) {
+		want := Order{Pool: "ada", ID: 1, Owner: "alice", Created: 10, Amount: 5}
+		assert.Nil(t, table.Put(want).RunWithContext(ctx))
+
+		var got Order
+		assert.Nil(t, table.Get("ada").Range(1).ScanWithContext(ctx, &got))
+		assert.Equal(t, want, got)
+
+		err := table.Get("ada").Range(2).ScanWithContext(ctx, &got)
+		assert.True(t, ddb.IsItemNotFoundError(err))
+
+		t.Run("condition", func(t *testing.T) {
+			err := table.Put(want).Condition("attribute_not_exists(#Pool)").RunWithContext(ctx)
+			assert.True(t, isConditionFailed(err))
+
+			err = table.Put(Order{Pool: "ada", ID: 2, Owner: "bob", Created: 20}).Condition("attribute_not_exists(#Pool)").RunWithContext(ctx)
+			assert.Nil(t, err)
+
+			err = table.Delete("ada").Range(2).Condition("#Owner = ?", "alice").RunWithContext(ctx)
+			assert.True(t, isConditionFailed(err))
+			err = table.Delete("ada").Range(2).Condition("#Owner = ?", "bob").RunWithContext(ctx)
+			assert.Nil(t, err)
+		})
+
+		t.Run("update", func(t *testing.T) {
+			var got Order
+			err := table.Update("ada").Range(1).
+				Add("#Amount ?", 3).
+				Add("#Tags ?", ddb.StringSet{"a", "b"}).
+				NewValues(&got).
+				RunWithContext(ctx)
+			assert.Nil(t, err)
+			assert.EqualValues(t, 8, got.Amount)
+			assert.ElementsMatch(t, []string{"a", "b"}, got.Tags)
+
+			err = table.Update("ada").Range(1).
+				Set("#Owner = ?", "carol").
+				Delete("#Tags ?", ddb.StringSet{"a"}).
+				Condition("#Amount > ?", 7).
+				NewValues(&got).
+				RunWithContext(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, "carol", got.Owner)
+			assert.Equal(t, []string{"b"}, got.Tags)
+
+			err = table.Update("ada").Range(1).Set("#Amount = ?", 0).Condition("#Amount > ?", 100).RunWithContext(ctx)
+			assert.True(t, isConditionFailed(err))
+
+			// Updating a missing item creates it
+			err = table.Update("ada").Range(3).Set("#Owner = ?", "dave").RunWithContext(ctx)
+			assert.Nil(t, err)
+			assert.Nil(t, table.Get("ada").Range(3).ScanWithContext(ctx, &got))
+			assert.Equal(t, "dave", got.Owner)
+
+			// Key attributes can't be changed
+			_, err = db.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+				TableName:                 aws.String("orders"),
+				Key:                       map[string]*dynamodb.AttributeValue{"Pool": {S: aws.String("ada")}, "ID": {N: aws.String("1")}},
+				UpdateExpression:          aws.String("SET ID = :v"),
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":v": {N: aws.String("9")}},
+			})
+			assert.NotNil(t, err)
+		})
+
+		t.Run("validation", func(t *testing.T) {
+			_, err := db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+				TableName: aws.String("orders"),
+				Item:      map[string]*dynamodb.AttributeValue{"Pool": {S: aws.String("ada")}},
+			})
+			assertValidation(t, err)
+
+			_, err = db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+				TableName:                 aws.String("orders"),
+				Item:                      map[string]*dynamodb.AttributeValue{"Pool": {S: aws.String("ada")}, "ID": {N: aws.String("4")}},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":unused": {S: aws.String("x")}},
+			})
+			assertValidation(t, err)
+		})
+	})
+}
+
+func assertValidation(t *testing.T, err error) {
+	t.Helper()
+	var aerr awserr.Error
+	assert.True(t, errors.As(err, &aerr))
+	assert.Equal(t, "ValidationException", aerr.Code())
+}
+
+func TestQuery(t *testing.T) {
+	withTable(t, func(ctx context.Context, db *DB, table *ddb.Table) {
+		for i := int64(1); i <= 10; i++ {
+			owner := "alice"
+			if i%2 == 0 {
+				owner = "bob"
+			}
+			assert.Nil(t, table.Put(Order{Pool: "ada", ID: i, Owner: owner, Created: 100 - i, Amount: i}).RunWithContext(ctx))
+		}
+		// Not in the index
+		_, err := db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String("orders"),
+			Item:      map[string]*dynamodb.AttributeValue{"Pool": {S: aws.String("ada")}, "ID": {N: aws.String("11")}},
+		})
+		assert.Nil(t, err)
+
+		// savaki/ddb queries in descending order unless told otherwise
+		var orders []Order
+		err = table.Query("#Pool = ? AND #ID BETWEEN ? AND ?", "ada", 3, 5).FindAllWithContext(ctx, &orders)
+		assert.Nil(t, err)
+		assert.Equal(t, []int64{5, 4, 3}, ids(orders))
+
+		orders = nil
+		err = table.Query("#Owner = ?", "alice").IndexName("owner-index").ScanIndexForward(true).FindAllWithContext(ctx, &orders)
+		assert.Nil(t, err)
+		assert.Equal(t, []int64{9, 7, 5, 3, 1}, ids(orders))
+
+		orders = nil
+		err = table.Query("#Owner = ?", "bob").IndexName("owner-index").ScanIndexForward(false).Filter("#Amount > ?", 4).FindAllWithContext(ctx, &orders)
+		assert.Nil(t, err)
+		assert.Equal(t, []int64{6, 8, 10}, ids(orders))
+
+		t.Run("pages", func(t *testing.T) {
+			var (
+				input = &dynamodb.QueryInput{
+					TableName:                 aws.String("orders"),
+					IndexName:                 aws.String("owner-index"),
+					KeyConditionExpression:    aws.String("#o = :o"),
+					ExpressionAttributeNames:  map[string]*string{"#o": aws.String("Owner")},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":o": {S: aws.String("alice")}},
+					Limit:                     aws.Int64(2),
+				}
+				got   []string
+				pages int
+			)
+			for {
+				out, err := db.QueryWithContext(ctx, input)
+				assert.Nil(t, err)
+				for _, it := range out.Items {
+					got = append(got, *it["ID"].N)
+				}
+				pages++
+				if out.LastEvaluatedKey == nil {
+					break
+				}
+				assert.Len(t, out.LastEvaluatedKey, 4)
+				input.ExclusiveStartKey = out.LastEvaluatedKey
+			}
+			assert.Equal(t, []string{"9", "7", "5", "3", "1"}, got)
+			assert.Equal(t, 3, pages)
+		})
+
+		t.Run("count", func(t *testing.T) {
+			out, err := db.QueryWithContext(ctx, &dynamodb.QueryInput{
+				TableName:                 aws.String("orders"),
+				KeyConditionExpression:    aws.String("Pool = :p"),
+				FilterExpression:          aws.String("amount >= :a"),
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":p": {S: aws.String("ada")}, ":a": {N: aws.String("8")}},
+				Select:                    aws.String(dynamodb.SelectCount),
+			})
+			assert.Nil(t, err)
+			assert.EqualValues(t, 3, aws.Int64Value(out.Count))
+			assert.EqualValues(t, 11, aws.Int64Value(out.ScannedCount))
+			assert.Nil(t, out.Items)
+		})
+
+		t.Run("scan", func(t *testing.T) {
+			seen := map[int64]bool{}
+			for segment := int64(0); segment < 3; segment++ {
+				out, err := db.ScanWithContext(ctx, &dynamodb.ScanInput{
+					TableName:     aws.String("orders"),
+					Segment:       aws.Int64(segment),
+					TotalSegments: aws.Int64(3),
+				})
+				assert.Nil(t, err)
+				for _, it := range out.Items {
+					var id int64
+					fmt.Sscan(*it["ID"].N, &id)
+					assert.False(t, seen[id])
+					seen[id] = true
+				}
+			}
+			assert.Len(t, seen, 11)
+		})
+	})
+}
+
+func ids(orders []Order) []int64 {
+	var out []int64
+	for _, o := range orders {
+		out = append(out, o.ID)
+	}
+	return out
+}
+
+func key(pool string, id int) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{"Pool": {S: aws.String(pool)}, "ID": {N: aws.String(fmt.Sprint(id))}}
+}
+
+func TestBatch(t *testing.T) {
+	withTable(t, func(ctx context.Context, db *DB, table *ddb.Table) {
+		var requests []*dynamodb.WriteRequest
+		for i := 1; i <= 5; i++ {
+			requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: key("ada", i)}})
+		}
+
+		db.DeferBatchItems(2)
+		out, err := db.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]*dynamodb.WriteRequest{"orders": requests},
+		})
+		assert.Nil(t, err)
+		assert.Len(t, out.UnprocessedItems["orders"], 2)
+
+		out, err = db.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{RequestItems: out.UnprocessedItems})
+		assert.Nil(t, err)
+		assert.Len(t, out.UnprocessedItems, 0)
+
+		var keys []map[string]*dynamodb.AttributeValue
+		for i := 1; i <= 6; i++ {
+			keys = append(keys, key("ada", i))
+		}
+		db.DeferBatchItems(1)
+		got, err := db.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{
+			RequestItems: map[string]*dynamodb.KeysAndAttributes{"orders": {Keys: keys}},
+		})
+		assert.Nil(t, err)
+		assert.Len(t, got.Responses["orders"], 4)
+		assert.Len(t, got.UnprocessedKeys["orders"].Keys, 1)
+
+		_, err = db.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{
+			RequestItems: map[string]*dynamodb.KeysAndAttributes{"orders": {Keys: []map[string]*dynamodb.AttributeValue{key("ada", 1), key("ada", 1)}}},
+		})
+		assertValidation(t, err)
+	})
+}
+
+func TestTransactWriteItems(t *testing.T) {
+	withTable(t, func(ctx context.Context, db *DB, table *ddb.Table) {
+		assert.Nil(t, table.Put(Order{Pool: "ada", ID: 1, Owner: "alice", Created: 1}).RunWithContext(ctx))
+
+		put, err := table.Put(Order{Pool: "ada", ID: 2, Owner: "bob", Created: 2}).Tx()
+		assert.Nil(t, err)
+		update, err := table.Update("ada").Range(1).Set("#Owner = ?", "carol").Condition("#Owner = ?", "bob").Tx()
+		assert.Nil(t, err)
+
+		_, err = db.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{put, update},
+		})
+		var canceled *dynamodb.TransactionCanceledException
+		assert.True(t, errors.As(err, &canceled))
+		assert.Equal(t, "None", aws.StringValue(canceled.CancellationReasons[0].Code))
+		assert.Equal(t, "ConditionalCheckFailed", aws.StringValue(canceled.CancellationReasons[1].Code))
+
+		// Nothing was written
+		var got Order
+		err = table.Get("ada").Range(2).ScanWithContext(ctx, &got)
+		assert.True(t, ddb.IsItemNotFoundError(err))
+
+		update, err = table.Update("ada").Range(1).Set("#Owner = ?", "carol").Condition("#Owner = ?", "alice").Tx()
+		assert.Nil(t, err)
+		_, err = db.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{put, update},
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, table.Get("ada").Range(2).ScanWithContext(ctx, &got))
+		assert.Nil(t, table.Get("ada").Range(1).ScanWithContext(ctx, &got))
+		assert.Equal(t, "carol", got.Owner)
+
+		update, err = table.Update("ada").Range(1).Set("#Owner = ?", "dave").Tx()
+		assert.Nil(t, err)
+		_, err = db.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{update, update},
+		})
+		assertValidation(t, err)
+	})
+}
+
+func TestTTL(t *testing.T) {
+	now := time.Unix(1000, 0)
+	db := New(WithClock(func() time.Time { return now }))
+	table := ddb.New(db).MustTable("orders", Order{})
+	ctx := context.Background()
+	assert.Nil(t, table.CreateTableIfNotExists(ctx))
+
+	_, err := db.UpdateTimeToLiveWithContext(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String("orders"),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String("expires"),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, table.Put(Order{Pool: "ada", ID: 1, Owner: "a", Created: 1, Expires: 1500}).RunWithContext(ctx))
+	assert.Nil(t, table.Put(Order{Pool: "ada", ID: 2, Owner: "a", Created: 1}).RunWithContext(ctx))
+
+	var got Order
+	assert.Nil(t, table.Get("ada").Range(1).ScanWithContext(ctx, &got))
+
+	now = time.Unix(1500, 0)
+	err = table.Get("ada").Range(1).ScanWithContext(ctx, &got)
+	assert.True(t, ddb.IsItemNotFoundError(err))
+	assert.Nil(t, table.Get("ada").Range(2).ScanWithContext(ctx, &got))
+}
+
+func isConditionFailed(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
@@ -0,0 +1,309 @@
+package memddb
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// read holds what Query and Scan have in common
+type read struct {
+	table     *table
+	schema    keySchema
+	index     *index
+	filter    condition
+	project   projection
+	limit     int64
+	forward   bool
+	startKey  item
+	countOnly bool
+}
+
+// source returns the table or index being read
+func (db *DB) source(tableName, indexName *string, consistent *bool) (*table, keySchema, *index, error) {
+	t, err := db.table(tableName)
+	if err != nil {
+		return nil, keySchema{}, nil, err
+	}
+	if indexName == nil {
+		return t, t.keySchema, nil, nil
+	}
+	idx, ok := t.indexes[*indexName]
+	if !ok {
+		return nil, keySchema{}, nil, validationError("The table does not have the specified index: %v", *indexName)
+	}
+	if aws.BoolValue(consistent) && isGlobal(t, *indexName) {
+		return nil, keySchema{}, nil, validationError("Consistent reads are not supported on global secondary indexes")
+	}
+	return t, idx.keySchema, &idx, nil
+}
+
+func isGlobal(t *table, name string) bool {
+	for _, gsi := range t.desc.GlobalSecondaryIndexes {
+		if aws.StringValue(gsi.IndexName) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// order returns the names items are sorted by: the index keys, then the
+// table keys to break ties
+func (r read) order() []string {
+	names := r.schema.names()
+	for _, name := range r.table.names() {
+		if name != r.schema.hash && name != r.schema.rng {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func compareItems(a, b item, names []string) int {
+	for _, name := range names {
+		if c, _ := compareValues(a[name], b[name]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// candidates returns the items in the table or index, in read order
+func (r read) candidates(match func(item) (bool, error)) ([]item, error) {
+	var items []item
+	for _, it := range r.table.items {
+		if r.index != nil {
+			// Sparse indexes only contain items that have the index keys
+			if _, err := r.table.keyOf(r.schema, keyAttributes(it, r.schema)); err != nil {
+				continue
+			}
+		}
+		if match != nil {
+			ok, err := match(it)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		items = append(items, it)
+	}
+
+	names := r.order()
+	sort.Slice(items, func(i, j int) bool {
+		c := compareItems(items[i], items[j], names)
+		if r.forward {
+			return c < 0
+		}
+		return c > 0
+	})
+	return items, nil
+}
+
+// page applies the start key, limit, filter and projections to items
+func (r read) page(items []item) (found []item, count, scanned int64, last item, err error) {
+	names := r.order()
+	if r.startKey != nil {
+		i := sort.Search(len(items), func(i int) bool {
+			c := compareItems(items[i], r.startKey, names)
+			if r.forward {
+				return c > 0
+			}
+			return c < 0
+		})
+		items = items[i:]
+	}
+
+	for i, it := range items {
+		if r.limit > 0 && scanned == r.limit {
+			last = keyAttributes(items[i-1], r.table.keySchema, r.schema)
+			break
+		}
+		scanned++
+		if r.filter != nil {
+			ok, err := r.filter(it)
+			if err != nil {
+				return nil, 0, 0, nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		count++
+		if !r.countOnly {
+			found = append(found, copyItem(r.project.apply(r.indexProjection(it))))
+		}
+	}
+	return found, count, scanned, last, nil
+}
+
+// indexProjection returns the attributes of it the index holds
+func (r read) indexProjection(it item) item {
+	if r.index == nil || r.index.projection == nil {
+		return it
+	}
+	switch aws.StringValue(r.index.projection.ProjectionType) {
+	case dynamodb.ProjectionTypeKeysOnly:
+		return keyAttributes(it, r.table.keySchema, r.schema)
+	case dynamodb.ProjectionTypeInclude:
+		out := keyAttributes(it, r.table.keySchema, r.schema)
+		for _, name := range aws.StringValueSlice(r.index.projection.NonKeyAttributes) {
+			if v, ok := it[name]; ok {
+				out[name] = v
+			}
+		}
+		return out
+	}
+	return it
+}
+
+func parseSelect(ctx *exprContext, sel, proj *string) (projection, bool, error) {
+	countOnly := aws.StringValue(sel) == dynamodb.SelectCount
+	if proj == nil {
+		return nil, countOnly, nil
+	}
+	if countOnly {
+		return nil, false, validationError("Cannot specify the ProjectionExpression when choosing to get only the Count")
+	}
+	p, err := parseProjection(ctx, *proj)
+	return p, false, err
+}
+
+func (db *DB) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	return db.QueryWithContext(context.Background(), input)
+}
+
+func (db *DB) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, _ ...request.Option) (*dynamodb.QueryOutput, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	if input.KeyConditionExpression == nil {
+		return nil, validationError("Either the KeyConditions or KeyConditionExpression parameter must be specified in the request")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, schema, idx, err := db.source(input.TableName, input.IndexName, input.ConsistentRead)
+	if err != nil {
+		return nil, err
+	}
+	r := read{
+		table:    t,
+		schema:   schema,
+		index:    idx,
+		limit:    aws.Int64Value(input.Limit),
+		forward:  input.ScanIndexForward == nil || *input.ScanIndexForward,
+		startKey: input.ExclusiveStartKey,
+	}
+
+	ectx := newExprContext(input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	keyCondition, err := parseCondition(ectx, *input.KeyConditionExpression)
+	if err != nil {
+		return nil, err
+	}
+	if input.FilterExpression != nil {
+		if r.filter, err = parseCondition(ectx, *input.FilterExpression); err != nil {
+			return nil, err
+		}
+	}
+	if r.project, r.countOnly, err = parseSelect(ectx, input.Select, input.ProjectionExpression); err != nil {
+		return nil, err
+	}
+	if err := ectx.checkUnused(); err != nil {
+		return nil, err
+	}
+
+	items, err := r.candidates(keyCondition)
+	if err != nil {
+		return nil, err
+	}
+	found, count, scanned, last, err := r.page(items)
+	if err != nil {
+		return nil, err
+	}
+	out := &dynamodb.QueryOutput{
+		Count:            aws.Int64(count),
+		ScannedCount:     aws.Int64(scanned),
+		LastEvaluatedKey: last,
+	}
+	if !r.countOnly {
+		out.Items = found
+	}
+	return out, nil
+}
+
+func (db *DB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	return db.ScanWithContext(context.Background(), input)
+}
+
+// ScanWithContext returns items in key order. Segments split items by a hash
+// of their table key.
+func (db *DB) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, _ ...request.Option) (*dynamodb.ScanOutput, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	segments := aws.Int64Value(input.TotalSegments)
+	segment := aws.Int64Value(input.Segment)
+	if (input.Segment == nil) != (input.TotalSegments == nil) || segments < 0 || (segments > 0 && (segment < 0 || segment >= segments)) {
+		return nil, validationError("Segment must be less than TotalSegments")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, schema, idx, err := db.source(input.TableName, input.IndexName, input.ConsistentRead)
+	if err != nil {
+		return nil, err
+	}
+	r := read{
+		table:    t,
+		schema:   schema,
+		index:    idx,
+		limit:    aws.Int64Value(input.Limit),
+		forward:  true,
+		startKey: input.ExclusiveStartKey,
+	}
+
+	ectx := newExprContext(input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if input.FilterExpression != nil {
+		if r.filter, err = parseCondition(ectx, *input.FilterExpression); err != nil {
+			return nil, err
+		}
+	}
+	if r.project, r.countOnly, err = parseSelect(ectx, input.Select, input.ProjectionExpression); err != nil {
+		return nil, err
+	}
+	if err := ectx.checkUnused(); err != nil {
+		return nil, err
+	}
+
+	var inSegment func(item) (bool, error)
+	if segments > 0 {
+		inSegment = func(it item) (bool, error) {
+			h := fnv.New32a()
+			h.Write([]byte(keyString(it, t.names()...)))
+			return int64(h.Sum32())%segments == segment, nil
+		}
+	}
+	items, err := r.candidates(inSegment)
+	if err != nil {
+		return nil, err
+	}
+	found, count, scanned, last, err := r.page(items)
+	if err != nil {
+		return nil, err
+	}
+	out := &dynamodb.ScanOutput{
+		Count:            aws.Int64(count),
+		ScannedCount:     aws.Int64(scanned),
+		LastEvaluatedKey: last,
+	}
+	if !r.countOnly {
+		out.Items = found
+	}
+	return out, nil
+}
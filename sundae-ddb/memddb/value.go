@@ -0,0 +1,273 @@
+package memddb
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+type item = map[string]*dynamodb.AttributeValue
+
+// copyItem deep copies an item, so callers can't alias what's stored
+func copyItem(in item) item {
+	if in == nil {
+		return nil
+	}
+	out := make(item, len(in))
+	for k, v := range in {
+		out[k] = copyValue(v)
+	}
+	return out
+}
+
+func copyValue(v *dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	if v == nil {
+		return nil
+	}
+	out := &dynamodb.AttributeValue{}
+	if v.S != nil {
+		out.S = aws.String(*v.S)
+	}
+	if v.N != nil {
+		out.N = aws.String(*v.N)
+	}
+	if v.B != nil {
+		out.B = append([]byte{}, v.B...)
+	}
+	if v.BOOL != nil {
+		out.BOOL = aws.Bool(*v.BOOL)
+	}
+	if v.NULL != nil {
+		out.NULL = aws.Bool(*v.NULL)
+	}
+	if v.SS != nil {
+		out.SS = aws.StringSlice(aws.StringValueSlice(v.SS))
+	}
+	if v.NS != nil {
+		out.NS = aws.StringSlice(aws.StringValueSlice(v.NS))
+	}
+	if v.BS != nil {
+		out.BS = make([][]byte, len(v.BS))
+		for i, b := range v.BS {
+			out.BS[i] = append([]byte{}, b...)
+		}
+	}
+	if v.M != nil {
+		out.M = copyItem(v.M)
+	}
+	if v.L != nil {
+		out.L = make([]*dynamodb.AttributeValue, len(v.L))
+		for i, e := range v.L {
+			out.L[i] = copyValue(e)
+		}
+	}
+	return out
+}
+
+// typeOf returns the DynamoDB type descriptor of v: S, N, B, BOOL, NULL, SS,
+// NS, BS, M or L
+func typeOf(v *dynamodb.AttributeValue) string {
+	switch {
+	case v == nil:
+		return ""
+	case v.S != nil:
+		return "S"
+	case v.N != nil:
+		return "N"
+	case v.B != nil:
+		return "B"
+	case v.BOOL != nil:
+		return "BOOL"
+	case v.NULL != nil:
+		return "NULL"
+	case v.SS != nil:
+		return "SS"
+	case v.NS != nil:
+		return "NS"
+	case v.BS != nil:
+		return "BS"
+	case v.M != nil:
+		return "M"
+	case v.L != nil:
+		return "L"
+	}
+	return ""
+}
+
+func parseNumber(s string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return nil, validationError("invalid number %q", s)
+	}
+	return r, nil
+}
+
+// formatNumber renders r the way DynamoDB returns numbers: no exponent and no
+// trailing zeros
+func formatNumber(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	s := strings.TrimRight(r.FloatString(38), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// compareValues orders two scalar values of the same type; ok is false if
+// they can't be ordered
+func compareValues(a, b *dynamodb.AttributeValue) (int, bool) {
+	if typeOf(a) != typeOf(b) {
+		return 0, false
+	}
+	switch typeOf(a) {
+	case "S":
+		return strings.Compare(*a.S, *b.S), true
+	case "N":
+		x, err := parseNumber(*a.N)
+		if err != nil {
+			return 0, false
+		}
+		y, err := parseNumber(*b.N)
+		if err != nil {
+			return 0, false
+		}
+		return x.Cmp(y), true
+	case "B":
+		return bytes.Compare(a.B, b.B), true
+	}
+	return 0, false
+}
+
+// equalValues compares two values of any type, ignoring the order of set
+// members
+func equalValues(a, b *dynamodb.AttributeValue) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if typeOf(a) != typeOf(b) {
+		return false
+	}
+	switch typeOf(a) {
+	case "S", "N", "B":
+		c, _ := compareValues(a, b)
+		return c == 0
+	case "BOOL":
+		return *a.BOOL == *b.BOOL
+	case "NULL":
+		return *a.NULL == *b.NULL
+	case "SS":
+		return equalSets(aws.StringValueSlice(a.SS), aws.StringValueSlice(b.SS))
+	case "NS":
+		return equalSets(normalizeNumbers(a.NS), normalizeNumbers(b.NS))
+	case "BS":
+		return equalSets(bytesToStrings(a.BS), bytesToStrings(b.BS))
+	case "M":
+		if len(a.M) != len(b.M) {
+			return false
+		}
+		for k, v := range a.M {
+			if !equalValues(v, b.M[k]) {
+				return false
+			}
+		}
+		return true
+	case "L":
+		if len(a.L) != len(b.L) {
+			return false
+		}
+		for i := range a.L {
+			if !equalValues(a.L[i], b.L[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func equalSets(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeNumbers(ns []*string) []string {
+	out := make([]string, 0, len(ns))
+	for _, n := range ns {
+		if r, err := parseNumber(aws.StringValue(n)); err == nil {
+			out = append(out, formatNumber(r))
+		} else {
+			out = append(out, aws.StringValue(n))
+		}
+	}
+	return out
+}
+
+func bytesToStrings(bs [][]byte) []string {
+	out := make([]string, len(bs))
+	for i, b := range bs {
+		out[i] = string(b)
+	}
+	return out
+}
+
+// keyString encodes the values of names in v so equal keys encode equally
+func keyString(v item, names ...string) string {
+	var sb strings.Builder
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		a := v[name]
+		switch typeOf(a) {
+		case "N":
+			if r, err := parseNumber(*a.N); err == nil {
+				fmt.Fprintf(&sb, "N%q|", formatNumber(r))
+				continue
+			}
+			fmt.Fprintf(&sb, "N%q|", *a.N)
+		case "S":
+			fmt.Fprintf(&sb, "S%q|", *a.S)
+		case "B":
+			fmt.Fprintf(&sb, "B%x|", a.B)
+		default:
+			sb.WriteString("-|")
+		}
+	}
+	return sb.String()
+}
+
+// valueSize approximates the size DynamoDB's size() function reports
+func valueSize(v *dynamodb.AttributeValue) (int, bool) {
+	switch typeOf(v) {
+	case "S":
+		return len(*v.S), true
+	case "B":
+		return len(v.B), true
+	case "SS":
+		return len(v.SS), true
+	case "NS":
+		return len(v.NS), true
+	case "BS":
+		return len(v.BS), true
+	case "M":
+		return len(v.M), true
+	case "L":
+		return len(v.L), true
+	}
+	return 0, false
+}
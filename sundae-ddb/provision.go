@@ -0,0 +1,292 @@
+package sundaeddb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/savaki/ddb"
+)
+
+// TableDefinition is how a registered table should look in an environment
+type TableDefinition struct {
+	Component string                     `json:"component"`
+	Create    *dynamodb.CreateTableInput `json:"create"`
+	// TTL is the time to live attribute, if the table has one
+	TTL string `json:"ttl,omitempty"`
+}
+
+func (d TableDefinition) TableName() string {
+	return aws.StringValue(d.Create.TableName)
+}
+
+// captureCreateTable records the CreateTable request savaki/ddb makes, so
+// definitions follow the ddb tags exactly as the DAOs interpret them
+type captureCreateTable struct {
+	dynamodbiface.DynamoDBAPI
+	input *dynamodb.CreateTableInput
+}
+
+func (c *captureCreateTable) CreateTableWithContext(_ aws.Context, input *dynamodb.CreateTableInput, _ ...request.Option) (*dynamodb.CreateTableOutput, error) {
+	c.input = input
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+// DefineTable derives the CreateTable request for tableName from the ddb tags
+// of model: the hash and range keys, and any gsi_hash, gsi_range and
+// lsi_range indexes. Tables are on demand.
+func DefineTable(tableName string, model interface{}) (*dynamodb.CreateTableInput, error) {
+	api := &captureCreateTable{}
+	table, err := ddb.New(api).Table(tableName, model)
+	if err != nil {
+		return nil, fmt.Errorf("unable to define table %v: %w", tableName, err)
+	}
+	if err := table.CreateTableIfNotExists(context.Background(), ddb.WithBillingMode(dynamodb.BillingModePayPerRequest)); err != nil {
+		return nil, fmt.Errorf("unable to define table %v: %w", tableName, err)
+	}
+	return api.input, nil
+}
+
+// Definitions returns the definition, in env, of every table registered with
+// sundaecli.RegisterTable and sundaecli.WithTableModel. Given components, it
+// returns only their tables, optional ones included; otherwise optional tables
+// are left out.
+func Definitions(env string, components ...string) ([]TableDefinition, error) {
+	wanted := map[string]bool{}
+	for _, component := range components {
+		wanted[component] = true
+	}
+	var defs []TableDefinition
+	for _, t := range sundaecli.Tables() {
+		if t.Model == nil {
+			continue
+		}
+		if len(wanted) > 0 {
+			if !wanted[t.Component] {
+				continue
+			}
+		} else if t.Optional {
+			continue
+		}
+		create, err := DefineTable(t.TableName(env), t.Model)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", t.Component, err)
+		}
+		defs = append(defs, TableDefinition{Component: t.Component, Create: create, TTL: t.TTL})
+	}
+	return defs, nil
+}
+
+// ProvisionTimeout bounds how long ProvisionTable waits for a new table to
+// become active
+const ProvisionTimeout = 2 * time.Minute
+
+// ProvisionTable creates the table if it doesn't exist, waits for it to
+// become active and enables its time to live. created reports whether the
+// table was new; an existing table is left as it is, so compare it with
+// DiffTable.
+func ProvisionTable(ctx context.Context, api dynamodbiface.DynamoDBAPI, def TableDefinition) (created bool, err error) {
+	_, err = api.CreateTableWithContext(ctx, def.Create)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != dynamodb.ErrCodeResourceInUseException {
+			return false, fmt.Errorf("unable to create table %v: %w", def.TableName(), err)
+		}
+	} else {
+		created = true
+	}
+
+	if err := waitForActive(ctx, api, def.TableName()); err != nil {
+		return created, err
+	}
+
+	if def.TTL != "" {
+		ttl, err := describeTTL(ctx, api, def.TableName())
+		if err != nil {
+			return created, err
+		}
+		if ttl == "" {
+			_, err := api.UpdateTimeToLiveWithContext(ctx, &dynamodb.UpdateTimeToLiveInput{
+				TableName: def.Create.TableName,
+				TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+					AttributeName: aws.String(def.TTL),
+					Enabled:       aws.Bool(true),
+				},
+			})
+			if err != nil {
+				return created, fmt.Errorf("unable to enable ttl on table %v: %w", def.TableName(), err)
+			}
+		}
+	}
+	return created, nil
+}
+
+func waitForActive(ctx context.Context, api dynamodbiface.DynamoDBAPI, tableName string) error {
+	ctx, cancel := context.WithTimeout(ctx, ProvisionTimeout)
+	defer cancel()
+
+	for {
+		out, err := api.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+		if err != nil {
+			return fmt.Errorf("unable to describe table %v: %w", tableName, err)
+		}
+		if aws.StringValue(out.Table.TableStatus) == dynamodb.TableStatusActive {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("table %v is not active: %w", tableName, ctx.Err())
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// describeTTL returns the attribute time to live is enabled on, or "" if it's
+// disabled
+func describeTTL(ctx context.Context, api dynamodbiface.DynamoDBAPI, tableName string) (string, error) {
+	out, err := api.DescribeTimeToLiveWithContext(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return "", fmt.Errorf("unable to describe ttl of table %v: %w", tableName, err)
+	}
+	desc := out.TimeToLiveDescription
+	if desc == nil {
+		return "", nil
+	}
+	switch aws.StringValue(desc.TimeToLiveStatus) {
+	case dynamodb.TimeToLiveStatusEnabled, dynamodb.TimeToLiveStatusEnabling:
+		return aws.StringValue(desc.AttributeName), nil
+	}
+	return "", nil
+}
+
+// DiffTable compares the table in DynamoDB with def, and describes each way
+// it differs: missing tables, mismatched keys, and missing, extra or mismatched
+// indexes and ttl. Billing and throughput aren't compared. A table that
+// matches has no differences.
+func DiffTable(ctx context.Context, api dynamodbiface.DynamoDBAPI, def TableDefinition) ([]string, error) {
+	out, err := api.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: def.Create.TableName})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+			return []string{"table does not exist"}, nil
+		}
+		return nil, fmt.Errorf("unable to describe table %v: %w", def.TableName(), err)
+	}
+	table := out.Table
+
+	var (
+		diffs     []string
+		wantTypes = attributeTypes(def.Create.AttributeDefinitions)
+		gotTypes  = attributeTypes(table.AttributeDefinitions)
+	)
+	diffKeys := func(what string, want, got []*dynamodb.KeySchemaElement) {
+		if w, g := describeKeys(want, wantTypes), describeKeys(got, gotTypes); w != g {
+			diffs = append(diffs, fmt.Sprintf("%v: want %v, got %v", what, w, g))
+		}
+	}
+	diffProjection := func(what string, want, got *dynamodb.Projection) {
+		if w, g := describeProjection(want), describeProjection(got); w != g {
+			diffs = append(diffs, fmt.Sprintf("%v projection: want %v, got %v", what, w, g))
+		}
+	}
+
+	diffKeys("key", def.Create.KeySchema, table.KeySchema)
+
+	gotGlobals := map[string]*dynamodb.GlobalSecondaryIndexDescription{}
+	for _, gsi := range table.GlobalSecondaryIndexes {
+		gotGlobals[aws.StringValue(gsi.IndexName)] = gsi
+	}
+	for _, want := range def.Create.GlobalSecondaryIndexes {
+		name := aws.StringValue(want.IndexName)
+		got, ok := gotGlobals[name]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("global index %v: missing", name))
+			continue
+		}
+		delete(gotGlobals, name)
+		diffKeys("global index "+name+" key", want.KeySchema, got.KeySchema)
+		diffProjection("global index "+name, want.Projection, got.Projection)
+	}
+	for _, name := range sortedKeys(gotGlobals) {
+		diffs = append(diffs, fmt.Sprintf("global index %v: not defined by the model", name))
+	}
+
+	gotLocals := map[string]*dynamodb.LocalSecondaryIndexDescription{}
+	for _, lsi := range table.LocalSecondaryIndexes {
+		gotLocals[aws.StringValue(lsi.IndexName)] = lsi
+	}
+	for _, want := range def.Create.LocalSecondaryIndexes {
+		name := aws.StringValue(want.IndexName)
+		got, ok := gotLocals[name]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("local index %v: missing", name))
+			continue
+		}
+		delete(gotLocals, name)
+		diffKeys("local index "+name+" key", want.KeySchema, got.KeySchema)
+		diffProjection("local index "+name, want.Projection, got.Projection)
+	}
+	for _, name := range sortedKeys(gotLocals) {
+		diffs = append(diffs, fmt.Sprintf("local index %v: not defined by the model", name))
+	}
+
+	ttl, err := describeTTL(ctx, api, def.TableName())
+	if err != nil {
+		return nil, err
+	}
+	if ttl != def.TTL {
+		diffs = append(diffs, fmt.Sprintf("ttl: want %q, got %q", def.TTL, ttl))
+	}
+	return diffs, nil
+}
+
+func attributeTypes(defs []*dynamodb.AttributeDefinition) map[string]string {
+	types := map[string]string{}
+	for _, d := range defs {
+		types[aws.StringValue(d.AttributeName)] = aws.StringValue(d.AttributeType)
+	}
+	return types
+}
+
+// describeKeys renders a key schema as e.g. "pk (S) hash, sk (N) range"
+func describeKeys(keys []*dynamodb.KeySchemaElement, types map[string]string) string {
+	var s string
+	for _, k := range keys {
+		if s != "" {
+			s += ", "
+		}
+		name := aws.StringValue(k.AttributeName)
+		s += fmt.Sprintf("%v (%v) %v", name, types[name], map[string]string{
+			dynamodb.KeyTypeHash:  "hash",
+			dynamodb.KeyTypeRange: "range",
+		}[aws.StringValue(k.KeyType)])
+	}
+	return s
+}
+
+func describeProjection(p *dynamodb.Projection) string {
+	if p == nil {
+		return dynamodb.ProjectionTypeAll
+	}
+	s := aws.StringValue(p.ProjectionType)
+	if attrs := aws.StringValueSlice(p.NonKeyAttributes); len(attrs) > 0 {
+		sort.Strings(attrs)
+		s += fmt.Sprintf(" %v", attrs)
+	}
+	return s
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
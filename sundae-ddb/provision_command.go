@@ -0,0 +1,152 @@
+package sundaeddb
+
+import (
+	"encoding/json"
+	"fmt"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	sundaetrace "github.com/SundaeSwap-finance/sundae-go-utils/sundae-trace"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/urfave/cli/v2"
+)
+
+// TablesCommand builds the ddb-tables command, for sundaecli.CommandApp,
+// which provisions the tables registered with sundaecli.WithTableModel:
+//
+//	ddb-tables show [env]     print each CreateTable request, as JSON
+//	ddb-tables create [env]   create missing tables and enable their ttl
+//	ddb-tables diff [env]     print how each table differs from its model
+//	ddb-tables verify [env]   fail if any table is missing or differs
+//
+// env defaults to --env, and --component limits the tables to some
+// components. Optional tables, such as checkpoints or cron locks, are only
+// included when their component is named. If api is nil, one is built from the default AWS session.
+func TablesCommand(api dynamodbiface.DynamoDBAPI) *cli.Command {
+	componentFlag := &cli.StringSliceFlag{Name: "component", Usage: "only the tables of this component, e.g. cursordao (repeatable)"}
+	definitions := func(c *cli.Context) ([]TableDefinition, error) {
+		env := sundaecli.CommonOpts.Env
+		if c.Args().Present() {
+			env = c.Args().First()
+		}
+		if env == "" {
+			return nil, fmt.Errorf("an environment is required: pass --%v or an argument", sundaecli.EnvFlag.Name)
+		}
+		components := c.StringSlice("component")
+		defs, err := Definitions(env, components...)
+		if err != nil {
+			return nil, err
+		}
+		if len(components) > 0 && len(defs) == 0 {
+			return nil, fmt.Errorf("no tables registered for components %v", components)
+		}
+		return defs, nil
+	}
+	client := func() dynamodbiface.DynamoDBAPI {
+		if api != nil {
+			return api
+		}
+		return dynamodb.New(sundaetrace.Session(aws.NewConfig()))
+	}
+	// diff reports the differences of every table, and whether there were any
+	diff := func(c *cli.Context, verbose bool) (bool, error) {
+		defs, err := definitions(c)
+		if err != nil {
+			return false, err
+		}
+		api := client()
+		var differ bool
+		for _, def := range defs {
+			diffs, err := DiffTable(c.Context, api, def)
+			if err != nil {
+				return false, err
+			}
+			if len(diffs) == 0 {
+				fmt.Fprintf(c.App.Writer, "%v\t%v\tok\n", def.Component, def.TableName())
+				continue
+			}
+			differ = true
+			fmt.Fprintf(c.App.Writer, "%v\t%v\tdiffers\n", def.Component, def.TableName())
+			if verbose {
+				for _, d := range diffs {
+					fmt.Fprintf(c.App.Writer, "\t%v\n", d)
+				}
+			}
+		}
+		return differ, nil
+	}
+
+	return &cli.Command{
+		Name:  "ddb-tables",
+		Usage: "create and check the DynamoDB tables this service uses",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "show",
+				Usage:     "print the CreateTable request for each table, as JSON",
+				ArgsUsage: "[env]",
+				Flags:     []cli.Flag{componentFlag},
+				Action: func(c *cli.Context) error {
+					defs, err := definitions(c)
+					if err != nil {
+						return err
+					}
+					encoder := json.NewEncoder(c.App.Writer)
+					encoder.SetIndent("", "  ")
+					return encoder.Encode(defs)
+				},
+			},
+			{
+				Name:      "create",
+				Usage:     "create missing tables and enable their ttl",
+				ArgsUsage: "[env]",
+				Flags:     []cli.Flag{componentFlag},
+				Action: func(c *cli.Context) error {
+					defs, err := definitions(c)
+					if err != nil {
+						return err
+					}
+					api := client()
+					for _, def := range defs {
+						created, err := ProvisionTable(c.Context, api, def)
+						if err != nil {
+							return err
+						}
+						status := "exists"
+						if created {
+							status = "created"
+						}
+						fmt.Fprintf(c.App.Writer, "%v\t%v\t%v\n", def.Component, def.TableName(), status)
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "diff",
+				Usage:     "print how each table differs from its model",
+				ArgsUsage: "[env]",
+				Flags:     []cli.Flag{componentFlag},
+				Action: func(c *cli.Context) error {
+					_, err := diff(c, true)
+					return err
+				},
+			},
+			{
+				Name:      "verify",
+				Usage:     "fail if any table is missing or differs from its model",
+				ArgsUsage: "[env]",
+				Flags:     []cli.Flag{componentFlag},
+				Action: func(c *cli.Context) error {
+					differ, err := diff(c, false)
+					if err != nil {
+						return err
+					}
+					if differ {
+						return fmt.Errorf("tables differ from their models; run ddb-tables diff for details")
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,143 @@
+package sundaeddb
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ddb/memddb"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/tj/assert"
+	"github.com/urfave/cli/v2"
+)
+
+type provisionRecord struct {
+	ID      string `dynamodbav:"pk" ddb:"hash"`
+	Version int64  `dynamodbav:"sk" ddb:"range"`
+	Topic   string `dynamodbav:"topic" ddb:"gsi_hash:TopicIndex"`
+	TTL     int64  `dynamodbav:"ttl"`
+}
+
+func init() {
+	sundaecli.RegisterTable("provisiondao", func(env string) string { return env + "-test--provision" },
+		sundaecli.WithTableModel(provisionRecord{}), sundaecli.WithTableTTL("ttl"))
+}
+
+func findDefinition(t *testing.T, env, component string) TableDefinition {
+	t.Helper()
+	defs, err := Definitions(env)
+	assert.Nil(t, err)
+	for _, def := range defs {
+		if def.Component == component {
+			return def
+		}
+	}
+	t.Fatalf("no definition for %v", component)
+	return TableDefinition{}
+}
+
+func TestDefinitions(t *testing.T) {
+	def := findDefinition(t, "dev", "provisiondao")
+	assert.Equal(t, "dev-test--provision", def.TableName())
+	assert.Equal(t, "ttl", def.TTL)
+	assert.Equal(t, dynamodb.BillingModePayPerRequest, aws.StringValue(def.Create.BillingMode))
+	assert.Equal(t, "pk (S) hash, sk (N) range", describeKeys(def.Create.KeySchema, attributeTypes(def.Create.AttributeDefinitions)))
+	assert.Len(t, def.Create.GlobalSecondaryIndexes, 1)
+	assert.Equal(t, "TopicIndex", aws.StringValue(def.Create.GlobalSecondaryIndexes[0].IndexName))
+
+	// Tables registered without a model aren't provisioned, and optional ones
+	// only when asked for
+	sundaecli.RegisterTable("namedao", func(env string) string { return env + "-name" })
+	defs, err := Definitions("dev")
+	assert.Nil(t, err)
+	for _, def := range defs {
+		assert.NotEqual(t, "namedao", def.Component)
+		assert.NotEqual(t, "checkpoints", def.Component)
+	}
+	defs, err = Definitions("dev", "checkpoints")
+	assert.Nil(t, err)
+	assert.Len(t, defs, 1)
+	assert.Equal(t, CheckpointTableName("dev"), defs[0].TableName())
+}
+
+func TestProvision(t *testing.T) {
+	var (
+		ctx = context.Background()
+		api = memddb.New()
+		def = findDefinition(t, "dev", "provisiondao")
+	)
+
+	diffs, err := DiffTable(ctx, api, def)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"table does not exist"}, diffs)
+
+	created, err := ProvisionTable(ctx, api, def)
+	assert.Nil(t, err)
+	assert.True(t, created)
+
+	diffs, err = DiffTable(ctx, api, def)
+	assert.Nil(t, err)
+	assert.Len(t, diffs, 0)
+
+	created, err = ProvisionTable(ctx, api, def)
+	assert.Nil(t, err)
+	assert.False(t, created)
+
+	t.Run("differs", func(t *testing.T) {
+		// The same table with a string range key, no index and no ttl
+		_, err := api.CreateTableWithContext(ctx, &dynamodb.CreateTableInput{
+			TableName: aws.String("other"),
+			AttributeDefinitions: []*dynamodb.AttributeDefinition{
+				{AttributeName: aws.String("pk"), AttributeType: aws.String("S")},
+				{AttributeName: aws.String("sk"), AttributeType: aws.String("S")},
+			},
+			KeySchema: []*dynamodb.KeySchemaElement{
+				{AttributeName: aws.String("pk"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+				{AttributeName: aws.String("sk"), KeyType: aws.String(dynamodb.KeyTypeRange)},
+			},
+		})
+		assert.Nil(t, err)
+
+		create, err := DefineTable("other", provisionRecord{})
+		assert.Nil(t, err)
+		other := TableDefinition{Component: def.Component, Create: create, TTL: def.TTL}
+		diffs, err := DiffTable(ctx, api, other)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"key: want pk (S) hash, sk (N) range, got pk (S) hash, sk (S) range",
+			"global index TopicIndex: missing",
+			`ttl: want "ttl", got ""`,
+		}, diffs)
+	})
+}
+
+func TestTablesCommand(t *testing.T) {
+	api := memddb.New()
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		app := &cli.App{Writer: &out, Commands: []*cli.Command{TablesCommand(api)}}
+		err := app.Run(append([]string{"svc", "ddb-tables"}, args...))
+		return out.String(), err
+	}
+
+	_, err := run("verify", "--component", "provisiondao", "dev")
+	assert.NotNil(t, err)
+
+	out, err := run("create", "--component", "provisiondao", "dev")
+	assert.Nil(t, err)
+	assert.Equal(t, "provisiondao\tdev-test--provision\tcreated\n", out)
+
+	out, err = run("verify", "--component", "provisiondao", "dev")
+	assert.Nil(t, err)
+	assert.Equal(t, "provisiondao\tdev-test--provision\tok\n", out)
+
+	out, err = run("show", "--component", "provisiondao", "dev")
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(out, `"IndexName": "TopicIndex"`))
+
+	_, err = run("show", "--component", "nope", "dev")
+	assert.NotNil(t, err)
+}
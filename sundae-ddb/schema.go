@@ -0,0 +1,155 @@
+package sundaeddb
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// DefaultSchemaVersionAttribute holds the version of the schema a record was
+// written with
+const DefaultSchemaVersionAttribute = "schema_version"
+
+// UpgradeFunc rewrites an item written with one version of a schema into the
+// next version, in place
+type UpgradeFunc func(item map[string]*dynamodb.AttributeValue) error
+
+// Schema decodes records of T written by any version of their writer. Each
+// change to the stored shape that tags can't absorb bumps the version, with
+// an UpgradeFunc from the version before; Decode applies every upgrade from
+// the record's version on, then Unmarshal. Records without a version are
+// version 0.
+type Schema[T any] struct {
+	attribute string
+	version   int
+	upgrades  map[int]UpgradeFunc
+}
+
+type schemaOptions struct {
+	attribute string
+	upgrades  map[int]UpgradeFunc
+}
+
+type SchemaOption func(*schemaOptions)
+
+// WithSchemaVersionAttribute sets the attribute holding the schema version;
+// DefaultSchemaVersionAttribute if unset
+func WithSchemaVersionAttribute(name string) SchemaOption {
+	return func(o *schemaOptions) {
+		o.attribute = name
+	}
+}
+
+// WithUpgrade registers fn to upgrade records from version from to from+1
+func WithUpgrade(from int, fn UpgradeFunc) SchemaOption {
+	return func(o *schemaOptions) {
+		o.upgrades[from] = fn
+	}
+}
+
+// NewSchema builds the schema of T at version, which must have an upgrade
+// from each earlier version
+func NewSchema[T any](version int, opts ...SchemaOption) (*Schema[T], error) {
+	o := schemaOptions{
+		attribute: DefaultSchemaVersionAttribute,
+		upgrades:  map[int]UpgradeFunc{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	for from := range o.upgrades {
+		if from < 0 || from >= version {
+			return nil, fmt.Errorf("upgrade from version %v is outside schema version %v", from, version)
+		}
+	}
+	for from := 0; from < version; from++ {
+		if o.upgrades[from] == nil {
+			return nil, fmt.Errorf("no upgrade from version %v to %v", from, from+1)
+		}
+	}
+	return &Schema[T]{attribute: o.attribute, version: version, upgrades: o.upgrades}, nil
+}
+
+// MustSchema is NewSchema, panicking on error
+func MustSchema[T any](version int, opts ...SchemaOption) *Schema[T] {
+	s, err := NewSchema[T](version, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Version is the current version of the schema
+func (s *Schema[T]) Version() int {
+	return s.version
+}
+
+// Upgrade brings a copy of item up to the current version. Items from a
+// newer version than the schema are an error, rather than decoded partially.
+func (s *Schema[T]) Upgrade(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+	version := 0
+	if av, ok := item[s.attribute]; ok && av.N != nil {
+		v, err := strconv.Atoi(aws.StringValue(av.N))
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema version %v: %w", aws.StringValue(av.N), err)
+		}
+		version = v
+	}
+	if version > s.version {
+		return nil, fmt.Errorf("record has schema version %v, newer than %v", version, s.version)
+	}
+
+	upgraded := make(map[string]*dynamodb.AttributeValue, len(item))
+	for k, v := range item {
+		upgraded[k] = v
+	}
+	for ; version < s.version; version++ {
+		if err := s.upgrades[version](upgraded); err != nil {
+			return nil, fmt.Errorf("unable to upgrade record from schema version %v: %w", version, err)
+		}
+	}
+	if s.version > 0 {
+		upgraded[s.attribute] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(s.version))}
+	}
+	return upgraded, nil
+}
+
+// Decode upgrades item and unmarshals it with UnmarshalMap
+func (s *Schema[T]) Decode(item map[string]*dynamodb.AttributeValue) (T, error) {
+	var v T
+	upgraded, err := s.Upgrade(item)
+	if err != nil {
+		return v, err
+	}
+	if err := UnmarshalMap(upgraded, &v); err != nil {
+		return v, fmt.Errorf("unable to unmarshal item: %w", err)
+	}
+	return v, nil
+}
+
+// Encode marshals v, stamped with the current version
+func (s *Schema[T]) Encode(v T) (map[string]*dynamodb.AttributeValue, error) {
+	item, err := dynamodbattribute.MarshalMap(v)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal item: %w", err)
+	}
+	if s.version > 0 {
+		item[s.attribute] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(s.version))}
+	}
+	return item, nil
+}
+
+// RenameAttribute is an UpgradeFunc that moves an attribute from one name to
+// another
+func RenameAttribute(from, to string) UpgradeFunc {
+	return func(item map[string]*dynamodb.AttributeValue) error {
+		if v, ok := item[from]; ok {
+			item[to] = v
+			delete(item, from)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,469 @@
+package sundaeddb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams/dynamodbstreamsiface"
+	"github.com/rs/zerolog"
+	"github.com/savaki/ddb"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	DefaultShardRefreshInterval = time.Minute
+	DefaultIdleBackoff          = time.Second
+	// maxIdleBackoff caps the backoff on a shard that keeps returning nothing
+	maxIdleBackoff = 10 * time.Second
+)
+
+// StartPosition is where a StreamReader starts reading a shard it has no
+// checkpoint for
+type StartPosition struct {
+	// Type is one of the dynamodbstreams.ShardIteratorType values other than
+	// AFTER_SEQUENCE_NUMBER, which is reserved for resuming from checkpoints
+	Type string
+	// SequenceNumbers holds the starting record of each shard, by shard id,
+	// when Type is AT_SEQUENCE_NUMBER. Shards not listed start at TRIM_HORIZON.
+	SequenceNumbers map[string]string
+}
+
+var (
+	TrimHorizon = StartPosition{Type: dynamodbstreams.ShardIteratorTypeTrimHorizon}
+	// Latest skips everything written before the reader starts: shards that
+	// are already closed are ignored, and open ones start at their tip
+	Latest = StartPosition{Type: dynamodbstreams.ShardIteratorTypeLatest}
+)
+
+func AtSequence(sequenceNumbers map[string]string) StartPosition {
+	return StartPosition{Type: dynamodbstreams.ShardIteratorTypeAtSequenceNumber, SequenceNumbers: sequenceNumbers}
+}
+
+// ParseStartPosition parses trim-horizon, latest or at-sequence; sequences are
+// shardId=sequenceNumber pairs for at-sequence
+func ParseStartPosition(s string, sequences []string) (StartPosition, error) {
+	switch strings.ToLower(strings.ReplaceAll(s, "_", "-")) {
+	case "", "trim-horizon":
+		return TrimHorizon, nil
+	case "latest":
+		return Latest, nil
+	case "at-sequence", "at-sequence-number":
+		sequenceNumbers := map[string]string{}
+		for _, pair := range sequences {
+			shard, sequence, ok := strings.Cut(pair, "=")
+			if !ok {
+				return StartPosition{}, fmt.Errorf("invalid start sequence %q: expected shardId=sequenceNumber", pair)
+			}
+			sequenceNumbers[shard] = sequence
+		}
+		if len(sequenceNumbers) == 0 {
+			return StartPosition{}, fmt.Errorf("at-sequence requires at least one start sequence")
+		}
+		return AtSequence(sequenceNumbers), nil
+	}
+	return StartPosition{}, fmt.Errorf("unknown start position %q: expected trim-horizon, latest or at-sequence", s)
+}
+
+// RecordHandler handles one stream record
+type RecordHandler func(ctx context.Context, record ddb.Record) error
+
+// StreamReader reads the stream of a table outside Lambda. Each shard is read
+// by its own goroutine, only once its parent shard has been read to the end,
+// so records for an item stay in order across shard splits. New shards are
+// discovered periodically, and progress is saved to a CheckpointStore after
+// every batch so a restart resumes where the previous run stopped.
+type StreamReader struct {
+	streams     dynamodbstreamsiface.DynamoDBStreamsAPI
+	tableName   string
+	handle      RecordHandler
+	checkpoints CheckpointStore
+	start       StartPosition
+	refresh     time.Duration
+	idleBackoff time.Duration
+	logger      zerolog.Logger
+
+	mu        sync.Mutex
+	streamArn string
+	shards    map[string]*shardState
+	wake      chan struct{}
+}
+
+type shardState struct {
+	shard    *dynamodbstreams.Shard
+	initial  bool // open or closed when the reader first listed the stream
+	started  bool
+	finished bool
+}
+
+type StreamReaderOption func(*StreamReader)
+
+// WithCheckpointStore saves progress to store; without one, every run starts
+// from the StartPosition
+func WithCheckpointStore(store CheckpointStore) StreamReaderOption {
+	return func(r *StreamReader) { r.checkpoints = store }
+}
+
+// WithStartPosition sets where shards without a checkpoint start; TrimHorizon
+// by default
+func WithStartPosition(start StartPosition) StreamReaderOption {
+	return func(r *StreamReader) { r.start = start }
+}
+
+// WithShardRefreshInterval sets how often the stream is listed for new shards
+func WithShardRefreshInterval(interval time.Duration) StreamReaderOption {
+	return func(r *StreamReader) {
+		if interval > 0 {
+			r.refresh = interval
+		}
+	}
+}
+
+// WithIdleBackoff sets how long a shard waits after an empty read; the wait
+// doubles on each consecutive empty read, up to 10s
+func WithIdleBackoff(backoff time.Duration) StreamReaderOption {
+	return func(r *StreamReader) {
+		if backoff > 0 {
+			r.idleBackoff = backoff
+		}
+	}
+}
+
+func WithStreamLogger(logger zerolog.Logger) StreamReaderOption {
+	return func(r *StreamReader) { r.logger = logger }
+}
+
+func NewStreamReader(streams dynamodbstreamsiface.DynamoDBStreamsAPI, tableName string, handle RecordHandler, opts ...StreamReaderOption) *StreamReader {
+	r := &StreamReader{
+		streams:     streams,
+		tableName:   tableName,
+		handle:      handle,
+		start:       TrimHorizon,
+		refresh:     DefaultShardRefreshInterval,
+		idleBackoff: DefaultIdleBackoff,
+		logger:      zerolog.Nop(),
+		shards:      map[string]*shardState{},
+		wake:        make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run reads the stream until ctx is cancelled or a record fails. Records
+// already fetched when ctx is cancelled are still handled, within the
+// lifecycle's drain deadline.
+func (r *StreamReader) Run(ctx context.Context) error {
+	ss, err := r.streams.ListStreamsWithContext(ctx, &dynamodbstreams.ListStreamsInput{
+		TableName: aws.String(r.tableName),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list streams for table %v: %w", r.tableName, err)
+	}
+	if len(ss.Streams) != 1 {
+		return fmt.Errorf("too few or too many streams (%v) for table %v", len(ss.Streams), r.tableName)
+	}
+	r.streamArn = aws.StringValue(ss.Streams[0].StreamArn)
+
+	// Records are handled under a drain context, so a shutdown lets the
+	// current batch finish; fetching stops on shutdown or the first failure
+	drain, cancelDrain := sundaecli.Drain(ctx)
+	defer cancelDrain()
+	group, groupCtx := errgroup.WithContext(drain)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(groupCtx, cancel)()
+
+	if err := r.discover(ctx, true); err != nil {
+		return err
+	}
+	r.logger.Info().Str("tableName", r.tableName).Int("shardCount", len(r.shards)).Msg("responding to stream events")
+
+	ticker := time.NewTicker(r.refresh)
+	defer ticker.Stop()
+	for {
+		r.schedule(ctx, groupCtx, group)
+
+		select {
+		case <-ctx.Done():
+			return group.Wait()
+		case <-r.wake:
+		case <-ticker.C:
+			if err := r.discover(ctx, false); err != nil && ctx.Err() == nil {
+				r.logger.Warn().Err(err).Msg("unable to refresh shards")
+			}
+		}
+	}
+}
+
+// discover lists every shard of the stream, adding any not yet known, and
+// forgets finished shards that have aged out of the stream
+func (r *StreamReader) discover(ctx context.Context, initial bool) error {
+	var lastShard *string
+	listed := map[string]bool{}
+	for {
+		out, err := r.streams.DescribeStreamWithContext(ctx, &dynamodbstreams.DescribeStreamInput{
+			StreamArn:             aws.String(r.streamArn),
+			ExclusiveStartShardId: lastShard,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to describe stream %v: %w", r.streamArn, err)
+		}
+
+		r.mu.Lock()
+		for _, shard := range out.StreamDescription.Shards {
+			id := aws.StringValue(shard.ShardId)
+			listed[id] = true
+			if _, ok := r.shards[id]; !ok {
+				r.shards[id] = &shardState{shard: shard, initial: initial}
+				if !initial {
+					r.logger.Info().Str("shard", id).Str("parent", aws.StringValue(shard.ParentShardId)).Msg("discovered shard")
+				}
+			}
+		}
+		r.mu.Unlock()
+
+		if out.StreamDescription.LastEvaluatedShardId == nil {
+			r.prune(listed)
+			return nil
+		}
+		lastShard = out.StreamDescription.LastEvaluatedShardId
+	}
+}
+
+// prune drops finished shards that are no longer listed. Their children are
+// then treated as having no parent, which lets them start just the same.
+func (r *StreamReader) prune(listed map[string]bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, state := range r.shards {
+		if state.finished && !listed[id] {
+			delete(r.shards, id)
+		}
+	}
+}
+
+// schedule starts a reader for every shard whose parent is done, or no longer
+// in the stream
+func (r *StreamReader) schedule(ctx, handleCtx context.Context, group *errgroup.Group) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, state := range r.shards {
+		if state.started || state.finished {
+			continue
+		}
+		if parent, ok := r.shards[aws.StringValue(state.shard.ParentShardId)]; ok && !parent.finished {
+			continue
+		}
+		state.started = true
+		id, state := id, state
+		group.Go(func() error {
+			closed, err := r.readShard(ctx, handleCtx, state)
+			if err != nil {
+				return fmt.Errorf("error reading shard %v: %w", id, err)
+			}
+			if closed {
+				r.mu.Lock()
+				state.finished = true
+				r.mu.Unlock()
+				select {
+				case r.wake <- struct{}{}:
+				default:
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// iterator returns the iterator to start reading a shard at, or nil if the
+// shard should be skipped
+func (r *StreamReader) iterator(ctx context.Context, state *shardState) (*string, error) {
+	shardID := aws.StringValue(state.shard.ShardId)
+	input := &dynamodbstreams.GetShardIteratorInput{
+		StreamArn: aws.String(r.streamArn),
+		ShardId:   state.shard.ShardId,
+	}
+
+	var checkpoint Checkpoint
+	var ok bool
+	if r.checkpoints != nil {
+		var err error
+		if checkpoint, ok, err = r.checkpoints.LoadCheckpoint(ctx, r.streamArn, shardID); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case ok && checkpoint.Finished:
+		return nil, nil
+	case ok && checkpoint.SequenceNumber != "":
+		input.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeAfterSequenceNumber)
+		input.SequenceNumber = aws.String(checkpoint.SequenceNumber)
+	case !state.initial:
+		// Shards created while running hold records newer than where we
+		// started, whatever the start position
+		input.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon)
+	case r.start.Type == dynamodbstreams.ShardIteratorTypeLatest:
+		if state.shard.SequenceNumberRange != nil && state.shard.SequenceNumberRange.EndingSequenceNumber != nil {
+			return nil, nil
+		}
+		input.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeLatest)
+	case r.start.Type == dynamodbstreams.ShardIteratorTypeAtSequenceNumber && r.start.SequenceNumbers[shardID] != "":
+		input.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeAtSequenceNumber)
+		input.SequenceNumber = aws.String(r.start.SequenceNumbers[shardID])
+	default:
+		input.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon)
+	}
+
+	it, err := r.streams.GetShardIteratorWithContext(ctx, input)
+	if isAWSError(err, dynamodbstreams.ErrCodeTrimmedDataAccessException) && input.SequenceNumber != nil {
+		// The checkpoint is older than the stream's retention
+		r.logger.Warn().Str("shard", shardID).Str("sequence", *input.SequenceNumber).Msg("checkpoint has been trimmed from the stream; reading from the oldest record")
+		input.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon)
+		input.SequenceNumber = nil
+		it, err = r.streams.GetShardIteratorWithContext(ctx, input)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get shard iterator: %w", err)
+	}
+	return it.ShardIterator, nil
+}
+
+// readShard reads a shard until it closes, returning true, or until ctx is
+// cancelled. Records are handled under handleCtx.
+func (r *StreamReader) readShard(ctx, handleCtx context.Context, state *shardState) (bool, error) {
+	shardID := aws.StringValue(state.shard.ShardId)
+	logger := r.logger.With().Str("shard", shardID).Logger()
+
+	iterator, err := r.iterator(ctx, state)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, nil
+		}
+		return false, err
+	}
+	if iterator == nil {
+		logger.Debug().Msg("skipping shard")
+		return true, nil
+	}
+
+	var lastSequence string
+	backoff := r.idleBackoff
+	for iterator != nil {
+		if ctx.Err() != nil {
+			return false, nil
+		}
+		out, err := r.streams.GetRecordsWithContext(ctx, &dynamodbstreams.GetRecordsInput{
+			ShardIterator: iterator,
+		})
+		if isAWSError(err, dynamodbstreams.ErrCodeExpiredIteratorException) {
+			logger.Debug().Msg("shard iterator expired; renewing")
+			if iterator, err = r.renewIterator(ctx, state, lastSequence); err != nil {
+				if ctx.Err() != nil {
+					return false, nil
+				}
+				return false, err
+			}
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, nil
+			}
+			return false, fmt.Errorf("unable to get records: %w", err)
+		}
+
+		for _, record := range out.Records {
+			// Reserialize to the ddb event type, as it's nicer to work with
+			raw, err := json.Marshal(record)
+			if err != nil {
+				return false, fmt.Errorf("unable to marshal record: %w", err)
+			}
+			var ddbr ddb.Record
+			if err := json.Unmarshal(raw, &ddbr); err != nil {
+				return false, fmt.Errorf("unable to unmarshal record: %w", err)
+			}
+			if err := r.handle(handleCtx, ddbr); err != nil {
+				return false, fmt.Errorf("error processing record %v: %w", ddbr.EventID, err)
+			}
+			lastSequence = aws.StringValue(record.Dynamodb.SequenceNumber)
+		}
+		if len(out.Records) > 0 {
+			if err := r.checkpoint(handleCtx, shardID, lastSequence, false); err != nil {
+				return false, err
+			}
+		}
+
+		iterator = out.NextShardIterator
+		if iterator == nil {
+			break
+		}
+		if len(out.Records) > 0 {
+			backoff = r.idleBackoff
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxIdleBackoff {
+			backoff = maxIdleBackoff
+		}
+	}
+
+	logger.Info().Msg("shard closed")
+	if err := r.checkpoint(handleCtx, shardID, lastSequence, true); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *StreamReader) renewIterator(ctx context.Context, state *shardState, lastSequence string) (*string, error) {
+	if lastSequence == "" {
+		return r.iterator(ctx, state)
+	}
+	it, err := r.streams.GetShardIteratorWithContext(ctx, &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         aws.String(r.streamArn),
+		ShardId:           state.shard.ShardId,
+		ShardIteratorType: aws.String(dynamodbstreams.ShardIteratorTypeAfterSequenceNumber),
+		SequenceNumber:    aws.String(lastSequence),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to renew shard iterator: %w", err)
+	}
+	return it.ShardIterator, nil
+}
+
+func (r *StreamReader) checkpoint(ctx context.Context, shardID, sequence string, finished bool) error {
+	if r.checkpoints == nil {
+		return nil
+	}
+	err := r.checkpoints.SaveCheckpoint(ctx, Checkpoint{
+		StreamArn:      r.streamArn,
+		ShardID:        shardID,
+		SequenceNumber: sequence,
+		Finished:       finished,
+		UpdatedAt:      time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to checkpoint shard %v: %w", shardID, err)
+	}
+	return nil
+}
+
+func isAWSError(err error, code string) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == code
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package sundaeddb
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams/dynamodbstreamsiface"
+	"github.com/savaki/ddb"
+	"github.com/tj/assert"
+)
+
+type fakeShard struct {
+	parent  string
+	records []string // sequence numbers
+	closed  bool
+}
+
+// fakeStreams serves shards two records at a time; iterators are shard:index
+type fakeStreams struct {
+	dynamodbstreamsiface.DynamoDBStreamsAPI
+
+	mu     sync.Mutex
+	order  []string
+	shards map[string]*fakeShard
+}
+
+func (f *fakeStreams) ListStreamsWithContext(aws.Context, *dynamodbstreams.ListStreamsInput, ...request.Option) (*dynamodbstreams.ListStreamsOutput, error) {
+	return &dynamodbstreams.ListStreamsOutput{Streams: []*dynamodbstreams.Stream{{StreamArn: aws.String("arn:stream")}}}, nil
+}
+
+func (f *fakeStreams) DescribeStreamWithContext(aws.Context, *dynamodbstreams.DescribeStreamInput, ...request.Option) (*dynamodbstreams.DescribeStreamOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var shards []*dynamodbstreams.Shard
+	for _, id := range f.order {
+		s := f.shards[id]
+		shard := &dynamodbstreams.Shard{ShardId: aws.String(id), SequenceNumberRange: &dynamodbstreams.SequenceNumberRange{}}
+		if s.parent != "" {
+			shard.ParentShardId = aws.String(s.parent)
+		}
+		if s.closed {
+			shard.SequenceNumberRange.EndingSequenceNumber = aws.String("end")
+		}
+		shards = append(shards, shard)
+	}
+	return &dynamodbstreams.DescribeStreamOutput{StreamDescription: &dynamodbstreams.StreamDescription{Shards: shards}}, nil
+}
+
+func (f *fakeStreams) GetShardIteratorWithContext(_ aws.Context, input *dynamodbstreams.GetShardIteratorInput, _ ...request.Option) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	shard := f.shards[*input.ShardId]
+	index := 0
+	switch *input.ShardIteratorType {
+	case dynamodbstreams.ShardIteratorTypeLatest:
+		index = len(shard.records)
+	case dynamodbstreams.ShardIteratorTypeAfterSequenceNumber, dynamodbstreams.ShardIteratorTypeAtSequenceNumber:
+		for i, seq := range shard.records {
+			if seq == *input.SequenceNumber {
+				index = i
+				if *input.ShardIteratorType == dynamodbstreams.ShardIteratorTypeAfterSequenceNumber {
+					index++
+				}
+			}
+		}
+	}
+	return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: aws.String(fmt.Sprintf("%v:%v", *input.ShardId, index))}, nil
+}
+
+func (f *fakeStreams) GetRecordsWithContext(_ aws.Context, input *dynamodbstreams.GetRecordsInput, _ ...request.Option) (*dynamodbstreams.GetRecordsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id, i, _ := strings.Cut(*input.ShardIterator, ":")
+	index, _ := strconv.Atoi(i)
+	shard := f.shards[id]
+
+	out := &dynamodbstreams.GetRecordsOutput{}
+	end := index + 2
+	if end > len(shard.records) {
+		end = len(shard.records)
+	}
+	for _, seq := range shard.records[index:end] {
+		out.Records = append(out.Records, &dynamodbstreams.Record{
+			EventID:   aws.String(seq),
+			EventName: aws.String("INSERT"),
+			Dynamodb:  &dynamodbstreams.StreamRecord{SequenceNumber: aws.String(seq)},
+		})
+	}
+	if !shard.closed || end < len(shard.records) {
+		out.NextShardIterator = aws.String(fmt.Sprintf("%v:%v", id, end))
+	}
+	return out, nil
+}
+
+func (f *fakeStreams) add(id string, shard *fakeShard) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.order = append(f.order, id)
+	f.shards[id] = shard
+}
+
+func TestStreamReader(t *testing.T) {
+	// the child is listed first, but must still be read after its parent
+	streams := &fakeStreams{shards: map[string]*fakeShard{}}
+	streams.add("child", &fakeShard{parent: "parent", records: []string{"4", "5"}})
+	streams.add("parent", &fakeShard{records: []string{"1", "2", "3"}, closed: true})
+	checkpoints := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoints.json"))
+
+	read := func(until string) []string {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var seen []string
+		reader := NewStreamReader(streams, "table", func(_ context.Context, record ddb.Record) error {
+			seen = append(seen, record.EventID)
+			if record.EventID == until {
+				cancel()
+			}
+			return nil
+		}, WithCheckpointStore(checkpoints), WithIdleBackoff(time.Millisecond), WithShardRefreshInterval(10*time.Millisecond))
+		assert.Nil(t, reader.Run(ctx))
+		return seen
+	}
+
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, read("5"))
+
+	c, ok, err := checkpoints.LoadCheckpoint(context.Background(), "arn:stream", "parent")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.True(t, c.Finished)
+
+	t.Run("resumes from checkpoints and finds new shards", func(t *testing.T) {
+		streams.mu.Lock()
+		streams.shards["child"].records = append(streams.shards["child"].records, "6")
+		streams.shards["child"].closed = true
+		streams.mu.Unlock()
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			streams.add("grandchild", &fakeShard{parent: "child", records: []string{"7"}})
+		}()
+		assert.Equal(t, []string{"6", "7"}, read("7"))
+	})
+
+	t.Run("forgets finished shards that aged out", func(t *testing.T) {
+		streams := &fakeStreams{shards: map[string]*fakeShard{}}
+		streams.add("child", &fakeShard{parent: "parent"})
+		reader := NewStreamReader(streams, "table", nil)
+		reader.streamArn = "arn:stream"
+		reader.shards["parent"] = &shardState{shard: &dynamodbstreams.Shard{ShardId: aws.String("parent")}, started: true, finished: true}
+		reader.shards["reading"] = &shardState{shard: &dynamodbstreams.Shard{ShardId: aws.String("reading")}, started: true}
+
+		assert.Nil(t, reader.discover(context.Background(), false))
+		var ids []string
+		for id := range reader.shards {
+			ids = append(ids, id)
+		}
+		assert.ElementsMatch(t, []string{"child", "reading"}, ids)
+	})
+}
+
+func TestParseStartPosition(t *testing.T) {
+	p, err := ParseStartPosition("LATEST", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, Latest, p)
+
+	p, err = ParseStartPosition("at-sequence", []string{"shard-1=100"})
+	assert.Nil(t, err)
+	assert.Equal(t, AtSequence(map[string]string{"shard-1": "100"}), p)
+
+	_, err = ParseStartPosition("at-sequence", []string{"100"})
+	assert.NotNil(t, err)
+	_, err = ParseStartPosition("earliest", nil)
+	assert.NotNil(t, err)
+}
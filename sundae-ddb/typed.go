@@ -0,0 +1,343 @@
+package sundaeddb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+type TypedInsertCallback[T any] func(ctx context.Context, newValue T) error
+type TypedUpdateCallback[T any] func(ctx context.Context, oldValue, newValue T, diff Diff) error
+type TypedDeleteCallback[T any] func(ctx context.Context, oldValue T) error
+
+// ImagePredicate inspects a raw stream image before it is decoded
+type ImagePredicate func(image map[string]*dynamodb.AttributeValue) bool
+
+// UpdatePredicate inspects the raw old and new images of a MODIFY event
+// before they are decoded
+type UpdatePredicate func(oldImage, newImage map[string]*dynamodb.AttributeValue) bool
+
+type typedFilters struct {
+	insert []ImagePredicate
+	update []UpdatePredicate
+	delete []ImagePredicate
+}
+
+type TypedHandlerOption func(*typedFilters)
+
+// WithInsertFilter skips INSERT events whose new image fails any predicate
+func WithInsertFilter(predicates ...ImagePredicate) TypedHandlerOption {
+	return func(f *typedFilters) { f.insert = append(f.insert, predicates...) }
+}
+
+// WithUpdateFilter skips MODIFY events that fail any predicate
+func WithUpdateFilter(predicates ...UpdatePredicate) TypedHandlerOption {
+	return func(f *typedFilters) { f.update = append(f.update, predicates...) }
+}
+
+// WithDeleteFilter skips REMOVE events whose old image fails any predicate
+func WithDeleteFilter(predicates ...ImagePredicate) TypedHandlerOption {
+	return func(f *typedFilters) { f.delete = append(f.delete, predicates...) }
+}
+
+// NewTypedHandler is NewHandler for a table whose items decode into T. Images
+// are checked against the filters in opts, then unmarshalled with
+// UnmarshalMap; MODIFY events also get the Diff between the two images.
+// A nil callback ignores that event type, as with NewHandler.
+func NewTypedHandler[T any](
+	service sundaecli.Service,
+	onInsert TypedInsertCallback[T],
+	onUpdate TypedUpdateCallback[T],
+	onDelete TypedDeleteCallback[T],
+	opts ...TypedHandlerOption,
+) *Handler {
+	var filters typedFilters
+	for _, opt := range opts {
+		opt(&filters)
+	}
+	fields := fieldNames(reflect.TypeOf((*T)(nil)).Elem())
+
+	var insert InsertCallback
+	if onInsert != nil {
+		insert = func(ctx context.Context, newImage map[string]*dynamodb.AttributeValue) error {
+			if !matchImage(filters.insert, newImage) {
+				return nil
+			}
+			newValue, err := Decode[T](newImage)
+			if err != nil {
+				return err
+			}
+			return onInsert(ctx, newValue)
+		}
+	}
+
+	var update UpdateCallback
+	if onUpdate != nil {
+		update = func(ctx context.Context, oldImage, newImage map[string]*dynamodb.AttributeValue) error {
+			for _, p := range filters.update {
+				if !p(oldImage, newImage) {
+					return nil
+				}
+			}
+			oldValue, err := Decode[T](oldImage)
+			if err != nil {
+				return fmt.Errorf("unable to decode old image: %w", err)
+			}
+			newValue, err := Decode[T](newImage)
+			if err != nil {
+				return fmt.Errorf("unable to decode new image: %w", err)
+			}
+			return onUpdate(ctx, oldValue, newValue, diffImages(fields, oldImage, newImage))
+		}
+	}
+
+	var remove DeleteCallback
+	if onDelete != nil {
+		remove = func(ctx context.Context, oldImage map[string]*dynamodb.AttributeValue) error {
+			if !matchImage(filters.delete, oldImage) {
+				return nil
+			}
+			oldValue, err := Decode[T](oldImage)
+			if err != nil {
+				return err
+			}
+			return onDelete(ctx, oldValue)
+		}
+	}
+
+	return NewHandler(service, insert, update, remove)
+}
+
+// Decode unmarshals a stream image into a T, with UnmarshalMap
+func Decode[T any](item map[string]*dynamodb.AttributeValue) (T, error) {
+	var v T
+	if err := UnmarshalMap(item, &v); err != nil {
+		return v, fmt.Errorf("unable to unmarshal item: %w", err)
+	}
+	return v, nil
+}
+
+func matchImage(predicates []ImagePredicate, image map[string]*dynamodb.AttributeValue) bool {
+	for _, p := range predicates {
+		if !p(image) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasAttribute matches images that contain name
+func HasAttribute(name string) ImagePredicate {
+	return func(image map[string]*dynamodb.AttributeValue) bool {
+		_, ok := image[name]
+		return ok
+	}
+}
+
+// AttributeEquals matches images whose string, number or bool attribute name
+// is one of values, compared in its string form
+func AttributeEquals(name string, values ...string) ImagePredicate {
+	return func(image map[string]*dynamodb.AttributeValue) bool {
+		s, ok := scalarString(image[name])
+		if !ok {
+			return false
+		}
+		for _, v := range values {
+			if s == v {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// AttributeHasPrefix matches images whose string attribute name starts with
+// prefix, such as a sort key with a type prefix
+func AttributeHasPrefix(name, prefix string) ImagePredicate {
+	return func(image map[string]*dynamodb.AttributeValue) bool {
+		av := image[name]
+		return av != nil && av.S != nil && strings.HasPrefix(*av.S, prefix)
+	}
+}
+
+// NewImage applies an ImagePredicate to the new image of a MODIFY event
+func NewImage(p ImagePredicate) UpdatePredicate {
+	return func(_, newImage map[string]*dynamodb.AttributeValue) bool { return p(newImage) }
+}
+
+// OldImage applies an ImagePredicate to the old image of a MODIFY event
+func OldImage(p ImagePredicate) UpdatePredicate {
+	return func(oldImage, _ map[string]*dynamodb.AttributeValue) bool { return p(oldImage) }
+}
+
+// AttributeChanged matches MODIFY events where any of names differs between
+// the old and new images, e.g. to ignore updates that only touch a TTL
+func AttributeChanged(names ...string) UpdatePredicate {
+	return func(oldImage, newImage map[string]*dynamodb.AttributeValue) bool {
+		for _, name := range names {
+			if !attributeEqual(oldImage[name], newImage[name]) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func scalarString(av *dynamodb.AttributeValue) (string, bool) {
+	switch {
+	case av == nil:
+		return "", false
+	case av.S != nil:
+		return *av.S, true
+	case av.N != nil:
+		return *av.N, true
+	case av.BOOL != nil:
+		return fmt.Sprint(*av.BOOL), true
+	}
+	return "", false
+}
+
+// FieldChange is a top level attribute that differs between the old and new
+// images of a MODIFY event. Old is nil for an added attribute and New for a
+// removed one.
+type FieldChange struct {
+	// Field is the name of the struct field the attribute decodes into, or the
+	// attribute name if no field does
+	Field     string
+	Attribute string
+	Old       *dynamodb.AttributeValue
+	New       *dynamodb.AttributeValue
+}
+
+// Diff is the set of changed attributes, sorted by attribute name
+type Diff []FieldChange
+
+// Changed reports whether the field or attribute called name changed
+func (d Diff) Changed(name string) bool {
+	for _, c := range d {
+		if c.Field == name || c.Attribute == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Fields returns the names of the changed fields
+func (d Diff) Fields() []string {
+	names := make([]string, 0, len(d))
+	for _, c := range d {
+		names = append(names, c.Field)
+	}
+	return names
+}
+
+func diffImages(fields map[string]string, oldImage, newImage map[string]*dynamodb.AttributeValue) Diff {
+	var diff Diff
+	add := func(name string) {
+		if attributeEqual(oldImage[name], newImage[name]) {
+			return
+		}
+		field, ok := fields[name]
+		if !ok {
+			field = name
+		}
+		diff = append(diff, FieldChange{Field: field, Attribute: name, Old: oldImage[name], New: newImage[name]})
+	}
+	for name := range oldImage {
+		add(name)
+	}
+	for name := range newImage {
+		if _, ok := oldImage[name]; !ok {
+			add(name)
+		}
+	}
+	sort.Slice(diff, func(i, j int) bool { return diff[i].Attribute < diff[j].Attribute })
+	return diff
+}
+
+// fieldNames maps the attribute names of a struct type, as dynamodbattribute
+// would marshal them, to the Go field names
+func fieldNames(t reflect.Type) map[string]string {
+	names := map[string]string{}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return names
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("dynamodbav")
+		if tag == "" {
+			// dynamodbattribute falls back to json tags
+			tag = f.Tag.Get("json")
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" && f.Anonymous {
+			for attribute, field := range fieldNames(f.Type) {
+				if _, ok := names[attribute]; !ok {
+					names[attribute] = field
+				}
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names[name] = f.Name
+	}
+	return names
+}
+
+// attributeEqual compares attribute values, ignoring the order of set members
+func attributeEqual(a, b *dynamodb.AttributeValue) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if a.SS != nil || b.SS != nil {
+		return equalStringSets(aws.StringValueSlice(a.SS), aws.StringValueSlice(b.SS))
+	}
+	if a.NS != nil || b.NS != nil {
+		return equalStringSets(aws.StringValueSlice(a.NS), aws.StringValueSlice(b.NS))
+	}
+	if a.BS != nil || b.BS != nil {
+		if len(a.BS) != len(b.BS) {
+			return false
+		}
+		as := append([][]byte(nil), a.BS...)
+		bs := append([][]byte(nil), b.BS...)
+		sort.Slice(as, func(i, j int) bool { return bytes.Compare(as[i], as[j]) < 0 })
+		sort.Slice(bs, func(i, j int) bool { return bytes.Compare(bs[i], bs[j]) < 0 })
+		return reflect.DeepEqual(as, bs)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func equalStringSets(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package sundaeddb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/savaki/ddb"
+	"github.com/tj/assert"
+)
+
+type testPool struct {
+	Ident    string   `dynamodbav:"pk"`
+	Kind     string   `dynamodbav:"kind"`
+	Reserves int      `dynamodbav:"reserves"`
+	Tags     []string `dynamodbav:"tags,stringset,omitempty"`
+	TTL      int64    `dynamodbav:"ttl,omitempty"`
+}
+
+func testImage(t *testing.T, p testPool) map[string]*dynamodb.AttributeValue {
+	item, err := dynamodbattribute.MarshalMap(p)
+	assert.Nil(t, err)
+	return item
+}
+
+func TestTypedHandler(t *testing.T) {
+	ctx := context.Background()
+	var inserted []testPool
+	var diffs []Diff
+	var removed []testPool
+
+	h := NewTypedHandler(sundaecli.NewService("test"),
+		func(_ context.Context, p testPool) error { inserted = append(inserted, p); return nil },
+		func(_ context.Context, _, _ testPool, diff Diff) error { diffs = append(diffs, diff); return nil },
+		func(_ context.Context, p testPool) error { removed = append(removed, p); return nil },
+		WithInsertFilter(AttributeEquals("kind", "pool")),
+		WithUpdateFilter(AttributeChanged("reserves", "tags")),
+		WithDeleteFilter(AttributeHasPrefix("pk", "pool#")),
+	)
+
+	a := testPool{Ident: "pool#a", Kind: "pool", Reserves: 10, Tags: []string{"x", "y"}}
+	b := a
+	b.Reserves, b.Tags = 20, []string{"y", "x"}
+	c := b
+	c.TTL = 123
+
+	record := func(name string, old, new *testPool) ddb.Record {
+		r := ddb.Record{EventName: name}
+		if old != nil {
+			r.Change.OldImage = testImage(t, *old)
+		}
+		if new != nil {
+			r.Change.NewImage = testImage(t, *new)
+		}
+		return r
+	}
+	other := testPool{Ident: "order#1", Kind: "order"}
+	event := ddb.Event{Records: []ddb.Record{
+		record("INSERT", nil, &a),
+		record("INSERT", nil, &other),
+		record("MODIFY", &a, &b),
+		record("MODIFY", &b, &c), // only the ttl changed
+		record("REMOVE", &other, nil),
+		record("REMOVE", &c, nil),
+	}}
+	assert.Nil(t, h.HandleEvent(ctx, event))
+
+	assert.Equal(t, []testPool{a}, inserted)
+	assert.Len(t, diffs, 1)
+	assert.Equal(t, []string{"Reserves"}, diffs[0].Fields())
+	assert.True(t, diffs[0].Changed("reserves"))
+	assert.False(t, diffs[0].Changed("Tags"))
+	assert.Equal(t, []testPool{c}, removed)
+
+	t.Run("json tags", func(t *testing.T) {
+		type jsonPool struct {
+			Ident    string `json:"pk"`
+			Reserves int    `json:"reserves,omitempty"`
+			Skipped  string `json:"-"`
+		}
+		oldImage, err := dynamodbattribute.MarshalMap(jsonPool{Ident: "pool#a", Reserves: 10})
+		assert.Nil(t, err)
+		newImage, err := dynamodbattribute.MarshalMap(jsonPool{Ident: "pool#a", Reserves: 20})
+		assert.Nil(t, err)
+		diff := diffImages(fieldNames(reflect.TypeOf(jsonPool{})), oldImage, newImage)
+		assert.Equal(t, []string{"Reserves"}, diff.Fields())
+		assert.True(t, diff.Changed("reserves"))
+	})
+}
@@ -0,0 +1,201 @@
+package sundaedeadletter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/urfave/cli/v2"
+)
+
+// RedriveFunc hands a dead letter back to the handler that gave up on it,
+// e.g. sundaeddb.Handler.Redrive
+type RedriveFunc func(ctx context.Context, letter sundaecli.DeadLetter) error
+
+// Command builds the dead-letters command, for sundaecli.CommandApp, which
+// lists, shows and redrives the records in the sink set by DeadLetterFlag:
+//
+//	dead-letters list [--limit n] [--json]
+//	dead-letters show <id>
+//	dead-letters redrive [--keep] [id...]
+//
+// redrive passes each dead letter, or every one if no ids are given, to
+// redrive, and removes those that succeed unless --keep is set.
+func Command(redrive RedriveFunc) *cli.Command {
+	return CommandWithStore(nil, redrive)
+}
+
+// CommandWithStore is Command reading from store rather than the sink set by
+// DeadLetterFlag
+func CommandWithStore(store Store, redrive RedriveFunc) *cli.Command {
+	open := func() (Store, error) {
+		if store != nil {
+			return store, nil
+		}
+		return Open(DeadLetterOpts.Target)
+	}
+	return &cli.Command{
+		Name:  "dead-letters",
+		Usage: "list, inspect and redrive quarantined records",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "list quarantined records",
+				Flags: []cli.Flag{
+					&cli.IntFlag{Name: "limit", Usage: "the most records to list (0 lists all)", Value: 100},
+					&cli.BoolFlag{Name: "json", Usage: "print as JSON"},
+				},
+				Action: func(c *cli.Context) error {
+					store, err := open()
+					if err != nil {
+						return err
+					}
+					letters, err := store.List(c.Context, c.Int("limit"))
+					if err != nil {
+						return err
+					}
+					if err := release(c.Context, store, letters); err != nil {
+						return err
+					}
+					if c.Bool("json") {
+						list := make([]sundaecli.DeadLetter, 0, len(letters))
+						for _, q := range letters {
+							list = append(list, q.DeadLetter)
+						}
+						return writeJSON(c.App.Writer, list)
+					}
+					for _, q := range letters {
+						fmt.Fprintf(c.App.Writer, "%v\t%v\t%v\t%v\t%v\n", q.ID, q.Source, q.RecordID, q.Attempts, firstLine(q.Error))
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "show",
+				Usage:     "print a quarantined record, as JSON",
+				ArgsUsage: "<id>",
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return fmt.Errorf("expected the id of one dead letter")
+					}
+					store, err := open()
+					if err != nil {
+						return err
+					}
+					letters, err := find(c.Context, store, c.Args().Slice())
+					if err != nil {
+						return err
+					}
+					if err := release(c.Context, store, letters); err != nil {
+						return err
+					}
+					return writeJSON(c.App.Writer, letters[0].DeadLetter)
+				},
+			},
+			{
+				Name:      "redrive",
+				Usage:     "hand quarantined records back to the handler",
+				ArgsUsage: "[id...]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "keep", Usage: "leave records in the sink after redriving them"},
+				},
+				Action: func(c *cli.Context) error {
+					store, err := open()
+					if err != nil {
+						return err
+					}
+					letters, err := find(c.Context, store, c.Args().Slice())
+					if err != nil {
+						return err
+					}
+					return Redrive(c.Context, c.App.Writer, store, letters, redrive, c.Bool("keep"))
+				},
+			},
+		},
+	}
+}
+
+// Redrive passes letters to redrive, removing those that succeed from store
+// unless keep is set. It carries on past failures, and returns an error if any
+// letter failed. Letters left in store are released for other readers.
+func Redrive(ctx context.Context, w io.Writer, store Store, letters []Quarantined, redrive RedriveFunc, keep bool) (err error) {
+	var failed int
+	var left []Quarantined
+	defer func() {
+		if releaseErr := release(ctx, store, left); err == nil {
+			err = releaseErr
+		}
+	}()
+	for i, q := range letters {
+		if err := redrive(ctx, q.DeadLetter); err != nil {
+			fmt.Fprintf(w, "%v\tfailed\t%v\n", q.ID, firstLine(err.Error()))
+			failed++
+			left = append(left, q)
+			continue
+		}
+		if keep {
+			left = append(left, q)
+		} else if err := store.Remove(ctx, q); err != nil {
+			left = append(left, letters[i+1:]...)
+			return err
+		}
+		fmt.Fprintf(w, "%v\tok\n", q.ID)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v dead letters failed to redrive", failed, len(letters))
+	}
+	return nil
+}
+
+// find returns the letters with the given ids, or every letter if there are
+// no ids. The rest are released, so only those found stay hidden from other
+// readers.
+func find(ctx context.Context, store Store, ids []string) ([]Quarantined, error) {
+	letters, err := store.List(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return letters, nil
+	}
+	byID := map[string]Quarantined{}
+	for _, q := range letters {
+		byID[q.ID] = q
+	}
+	found := make([]Quarantined, 0, len(ids))
+	for _, id := range ids {
+		q, ok := byID[id]
+		if !ok {
+			if err := release(ctx, store, letters); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("dead letter %v not found", id)
+		}
+		found = append(found, q)
+		delete(byID, id)
+	}
+	rest := make([]Quarantined, 0, len(byID))
+	for _, q := range letters {
+		if _, ok := byID[q.ID]; ok {
+			rest = append(rest, q)
+		}
+	}
+	if err := release(ctx, store, rest); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,93 @@
+package sundaedeadletter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+)
+
+// DirStore keeps each dead letter as a JSON file in a local directory
+type DirStore struct {
+	dir string
+}
+
+func NewDirStore(dir string) *DirStore {
+	return &DirStore{dir: dir}
+}
+
+// Send writes the letter via a rename, so List never sees a partial file
+func (s *DirStore) Send(_ context.Context, letter sundaecli.DeadLetter) error {
+	if letter.ID == "" {
+		letter.ID = letterID(letter)
+	}
+	data, err := json.MarshalIndent(letter, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal dead letter %v: %w", letter.ID, err)
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("unable to create dead letter directory %v: %w", s.dir, err)
+	}
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("unable to write dead letter %v: %w", letter.ID, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write dead letter %v: %w", letter.ID, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write dead letter %v: %w", letter.ID, err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, letter.ID+".json")); err != nil {
+		return fmt.Errorf("unable to write dead letter %v: %w", letter.ID, err)
+	}
+	return nil
+}
+
+func (s *DirStore) List(_ context.Context, limit int) ([]Quarantined, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read dead letter directory %v: %w", s.dir, err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var letters []Quarantined
+	for _, name := range names {
+		if limit > 0 && len(letters) >= limit {
+			break
+		}
+		path := filepath.Join(s.dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read dead letter %v: %w", path, err)
+		}
+		var letter sundaecli.DeadLetter
+		if err := json.Unmarshal(data, &letter); err != nil {
+			return nil, fmt.Errorf("unable to parse dead letter %v: %w", path, err)
+		}
+		letters = append(letters, Quarantined{DeadLetter: letter, handle: path})
+	}
+	return letters, nil
+}
+
+func (s *DirStore) Remove(_ context.Context, q Quarantined) error {
+	if err := os.Remove(q.handle); err != nil {
+		return fmt.Errorf("unable to remove dead letter %v: %w", q.ID, err)
+	}
+	return nil
+}
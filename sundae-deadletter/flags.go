@@ -0,0 +1,16 @@
+package sundaedeadletter
+
+import (
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/urfave/cli/v2"
+)
+
+var DeadLetterOpts struct {
+	Target string
+}
+
+var DeadLetterFlag = sundaecli.StringFlag("dead-letter", "where to quarantine records that are given up on: an SQS queue url, s3://bucket/prefix, or a local directory; unset discards them", &DeadLetterOpts.Target)
+
+var DeadLetterFlags = []cli.Flag{
+	DeadLetterFlag,
+}
@@ -0,0 +1,104 @@
+package sundaedeadletter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// S3Store keeps each dead letter as a JSON object under a prefix
+type S3Store struct {
+	api    s3iface.S3API
+	bucket string
+	prefix string
+}
+
+func NewS3Store(api s3iface.S3API, bucket, prefix string) *S3Store {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &S3Store{api: api, bucket: bucket, prefix: prefix}
+}
+
+func (s *S3Store) Send(ctx context.Context, letter sundaecli.DeadLetter) error {
+	if letter.ID == "" {
+		letter.ID = letterID(letter)
+	}
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("unable to marshal dead letter %v: %w", letter.ID, err)
+	}
+	_, err = s.api.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.prefix + letter.ID + ".json"),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to put dead letter %v: %w", letter.ID, err)
+	}
+	return nil
+}
+
+// List returns the letters in key order, which is the order they were sent
+func (s *S3Store) List(ctx context.Context, limit int) ([]Quarantined, error) {
+	var keys []string
+	err := s.api.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, object := range page.Contents {
+			if limit > 0 && len(keys) >= limit {
+				return false
+			}
+			if key := aws.StringValue(object.Key); strings.HasSuffix(key, ".json") {
+				keys = append(keys, key)
+			}
+		}
+		return limit <= 0 || len(keys) < limit
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list dead letters in s3://%v/%v: %w", s.bucket, s.prefix, err)
+	}
+
+	letters := make([]Quarantined, 0, len(keys))
+	for _, key := range keys {
+		out, err := s.api.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to get dead letter %v: %w", key, err)
+		}
+		data, err := io.ReadAll(out.Body)
+		out.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read dead letter %v: %w", key, err)
+		}
+		var letter sundaecli.DeadLetter
+		if err := json.Unmarshal(data, &letter); err != nil {
+			return nil, fmt.Errorf("unable to parse dead letter %v: %w", key, err)
+		}
+		letters = append(letters, Quarantined{DeadLetter: letter, handle: key})
+	}
+	return letters, nil
+}
+
+func (s *S3Store) Remove(ctx context.Context, q Quarantined) error {
+	_, err := s.api.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(q.handle),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to remove dead letter %v: %w", q.ID, err)
+	}
+	return nil
+}
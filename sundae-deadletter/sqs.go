@@ -0,0 +1,123 @@
+package sundaedeadletter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/rs/zerolog"
+)
+
+// sqsVisibilityTimeout is how long listed messages stay hidden from other
+// readers; anything not removed or released by then goes back on the queue
+const sqsVisibilityTimeout = 300
+
+// SQSStore keeps each dead letter as a message on an SQS queue. Reading the
+// queue hides the messages read until they are released, so List is best
+// suited to a single operator at a time, and only sees messages that aren't
+// hidden.
+type SQSStore struct {
+	api      sqsiface.SQSAPI
+	queueURL string
+}
+
+func NewSQSStore(api sqsiface.SQSAPI, queueURL string) *SQSStore {
+	return &SQSStore{api: api, queueURL: queueURL}
+}
+
+func (s *SQSStore) Send(ctx context.Context, letter sundaecli.DeadLetter) error {
+	if letter.ID == "" {
+		letter.ID = letterID(letter)
+	}
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("unable to marshal dead letter %v: %w", letter.ID, err)
+	}
+	_, err = s.api.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(s.queueURL),
+		MessageBody: aws.String(string(data)),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to send dead letter %v: %w", letter.ID, err)
+	}
+	return nil
+}
+
+// List receives messages until the queue returns none or limit is reached.
+// The messages it returns stay hidden until they are removed or released;
+// any that can't be parsed are logged, skipped and made visible again.
+func (s *SQSStore) List(ctx context.Context, limit int) ([]Quarantined, error) {
+	var letters, unreadable []Quarantined
+	defer func() {
+		if err := s.Release(ctx, unreadable); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("unable to release unreadable dead letters")
+		}
+	}()
+	for limit <= 0 || len(letters) < limit {
+		max := int64(10)
+		if limit > 0 && int64(limit-len(letters)) < max {
+			max = int64(limit - len(letters))
+		}
+		out, err := s.api.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(s.queueURL),
+			MaxNumberOfMessages: aws.Int64(max),
+			VisibilityTimeout:   aws.Int64(sqsVisibilityTimeout),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to receive dead letters from %v: %w", s.queueURL, err)
+		}
+		if len(out.Messages) == 0 {
+			break
+		}
+		for _, message := range out.Messages {
+			var letter sundaecli.DeadLetter
+			if err := json.Unmarshal([]byte(aws.StringValue(message.Body)), &letter); err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Str("message", aws.StringValue(message.MessageId)).Msg("skipping unreadable dead letter")
+				unreadable = append(unreadable, Quarantined{DeadLetter: sundaecli.DeadLetter{ID: aws.StringValue(message.MessageId)}, handle: aws.StringValue(message.ReceiptHandle)})
+				continue
+			}
+			letters = append(letters, Quarantined{DeadLetter: letter, handle: aws.StringValue(message.ReceiptHandle)})
+		}
+	}
+	return letters, nil
+}
+
+func (s *SQSStore) Remove(ctx context.Context, q Quarantined) error {
+	_, err := s.api.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(s.queueURL),
+		ReceiptHandle: aws.String(q.handle),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to remove dead letter %v: %w", q.ID, err)
+	}
+	return nil
+}
+
+// Release makes letters visible on the queue again straight away, rather than
+// once the visibility timeout expires
+func (s *SQSStore) Release(ctx context.Context, letters []Quarantined) error {
+	for start := 0; start < len(letters); start += 10 {
+		end := min(start+10, len(letters))
+		input := &sqs.ChangeMessageVisibilityBatchInput{QueueUrl: aws.String(s.queueURL)}
+		for i, q := range letters[start:end] {
+			input.Entries = append(input.Entries, &sqs.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                aws.String(strconv.Itoa(i)),
+				ReceiptHandle:     aws.String(q.handle),
+				VisibilityTimeout: aws.Int64(0),
+			})
+		}
+		out, err := s.api.ChangeMessageVisibilityBatchWithContext(ctx, input)
+		if err != nil {
+			return fmt.Errorf("unable to release dead letters on %v: %w", s.queueURL, err)
+		}
+		if len(out.Failed) > 0 {
+			return fmt.Errorf("unable to release %v dead letters on %v: %v", len(out.Failed), s.queueURL, aws.StringValue(out.Failed[0].Message))
+		}
+	}
+	return nil
+}
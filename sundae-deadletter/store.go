@@ -0,0 +1,96 @@
+// Package sundaedeadletter quarantines the records a stream consumer gives up
+// on, in an SQS queue, under an S3 prefix or in a local directory, and
+// provides a command to list, inspect and redrive them.
+package sundaedeadletter
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	sundaetrace "github.com/SundaeSwap-finance/sundae-go-utils/sundae-trace"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Quarantined is a dead letter as read back from a Store
+type Quarantined struct {
+	sundaecli.DeadLetter
+
+	// handle is what the store needs to remove it: a path, key or receipt
+	// handle
+	handle string
+}
+
+// Store is a dead letter sink that can also be read back
+type Store interface {
+	sundaecli.DeadLetterSink
+	// List returns up to limit dead letters, oldest first where the store
+	// allows it; limit <= 0 returns them all
+	List(ctx context.Context, limit int) ([]Quarantined, error)
+	// Remove deletes a dead letter returned by List
+	Remove(ctx context.Context, q Quarantined) error
+}
+
+// Releaser is implemented by stores whose List hides the letters it returns
+// from other readers, like SQSStore
+type Releaser interface {
+	// Release makes letters returned by List, but not removed, visible again
+	Release(ctx context.Context, letters []Quarantined) error
+}
+
+// release hands letters back to store, if it hid them when listing
+func release(ctx context.Context, store Store, letters []Quarantined) error {
+	if r, ok := store.(Releaser); ok && len(letters) > 0 {
+		return r.Release(ctx, letters)
+	}
+	return nil
+}
+
+// Open returns the Store for target, as accepted by DeadLetterFlag
+func Open(target string) (Store, error) {
+	switch {
+	case target == "":
+		return nil, fmt.Errorf("no dead letter sink configured; set --%v", DeadLetterFlag.Name)
+	case strings.HasPrefix(target, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(target, "s3://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("invalid dead letter sink %v: missing bucket", target)
+		}
+		api := s3.New(sundaetrace.Session(aws.NewConfig()))
+		return NewS3Store(api, bucket, prefix), nil
+	case strings.HasPrefix(target, "https://sqs."):
+		api := sqs.New(sundaetrace.Session(aws.NewConfig()))
+		return NewSQSStore(api, target), nil
+	default:
+		return NewDirStore(target), nil
+	}
+}
+
+// Setup opens the Store selected by DeadLetterOpts and makes it the sink in
+// sundaecli.DefaultBatchPolicy. It returns nil if no sink is configured.
+func Setup() (Store, error) {
+	if DeadLetterOpts.Target == "" {
+		return nil, nil
+	}
+	store, err := Open(DeadLetterOpts.Target)
+	if err != nil {
+		return nil, err
+	}
+	sundaecli.SetDeadLetterSink(store)
+	return store, nil
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// letterID names a dead letter so they sort by the time they were given up on
+func letterID(letter sundaecli.DeadLetter) string {
+	id := letter.Time.UTC().Format("20060102T150405.000000000Z")
+	if letter.RecordID != "" {
+		id += "-" + unsafeChars.ReplaceAllString(letter.RecordID, "_")
+	}
+	return id
+}
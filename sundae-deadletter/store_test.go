@@ -0,0 +1,194 @@
+package sundaedeadletter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/rs/zerolog"
+	"github.com/tj/assert"
+)
+
+func TestDirStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewDirStore(t.TempDir())
+
+	letters, err := store.List(ctx, 0)
+	assert.Nil(t, err)
+	assert.Len(t, letters, 0)
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, id := range []string{"200", "100", "300"} {
+		err := store.Send(ctx, sundaecli.DeadLetter{
+			Source:   "sundae-ddb",
+			RecordID: id,
+			Error:    "bad record",
+			Time:     start.Add(time.Duration(i) * time.Second),
+			Record:   json.RawMessage(`{"id":"` + id + `"}`),
+		})
+		assert.Nil(t, err)
+	}
+
+	letters, err = store.List(ctx, 2)
+	assert.Nil(t, err)
+	assert.Len(t, letters, 2)
+	assert.Equal(t, "20240102T030405.000000000Z-200", letters[0].ID)
+	assert.Equal(t, "100", letters[1].RecordID)
+	assert.JSONEq(t, `{"id":"200"}`, string(letters[0].Record))
+
+	assert.Nil(t, store.Remove(ctx, letters[0]))
+	letters, err = store.List(ctx, 0)
+	assert.Nil(t, err)
+	assert.Len(t, letters, 2)
+}
+
+func TestRedrive(t *testing.T) {
+	ctx := context.Background()
+	store := NewDirStore(t.TempDir())
+	for _, id := range []string{"1", "2", "3"} {
+		assert.Nil(t, store.Send(ctx, sundaecli.DeadLetter{RecordID: id, Time: time.Now(), Record: json.RawMessage(id)}))
+	}
+
+	var redriven []string
+	redrive := func(_ context.Context, letter sundaecli.DeadLetter) error {
+		if letter.RecordID == "2" {
+			return errors.New("still bad")
+		}
+		redriven = append(redriven, string(letter.Record))
+		return nil
+	}
+
+	letters, err := find(ctx, store, nil)
+	assert.Nil(t, err)
+	var out bytes.Buffer
+	err = Redrive(ctx, &out, store, letters, redrive, false)
+	assert.EqualError(t, err, "1 of 3 dead letters failed to redrive")
+	assert.Equal(t, []string{"1", "3"}, redriven)
+
+	// Only the failed one is left
+	letters, err = store.List(ctx, 0)
+	assert.Nil(t, err)
+	assert.Len(t, letters, 1)
+	assert.Equal(t, "2", letters[0].RecordID)
+
+	_, err = find(ctx, store, []string{"missing"})
+	assert.NotNil(t, err)
+}
+
+func TestSetup(t *testing.T) {
+	defer sundaecli.SetDeadLetterSink(nil)
+	DeadLetterOpts.Target = t.TempDir()
+	defer func() { DeadLetterOpts.Target = "" }()
+
+	store, err := Setup()
+	assert.Nil(t, err)
+
+	policy := sundaecli.DefaultBatchPolicy("sundae-ddb")
+	assert.NotNil(t, policy.DeadLetter)
+	policy.Service = sundaecli.Service{Name: "test", Version: "1.2.3"}
+	p := sundaecli.NewBatchProcessor(policy, zerolog.Nop(), nil)
+	result := sundaecli.ProcessRecords(context.Background(), p, []string{"1"}, func(id string) string { return id }, func(context.Context, string) error {
+		return errors.New("bad record")
+	})
+	assert.Nil(t, result.Err)
+
+	letters, err := store.List(context.Background(), 0)
+	assert.Nil(t, err)
+	assert.Len(t, letters, 1)
+	assert.Equal(t, "test", letters[0].Service)
+	assert.Equal(t, "1.2.3", letters[0].Version)
+}
+
+func TestSQSStore(t *testing.T) {
+	ctx := context.Background()
+	queue := &fakeQueue{}
+	store := NewSQSStore(queue, "https://sqs.us-east-1.amazonaws.com/1/dead-letters")
+	for _, id := range []string{"1", "2", "3"} {
+		assert.Nil(t, store.Send(ctx, sundaecli.DeadLetter{RecordID: id, Time: time.Now(), Record: json.RawMessage(id)}))
+	}
+	queue.messages = append(queue.messages, &fakeMessage{body: "not json"})
+
+	// Unreadable messages are skipped, and go back on the queue
+	letters, err := store.List(ctx, 0)
+	assert.Nil(t, err)
+	assert.Len(t, letters, 3)
+	assert.Equal(t, 1, queue.visible())
+
+	// Only the letters selected stay hidden
+	assert.Nil(t, store.Release(ctx, letters))
+	found, err := find(ctx, store, []string{letters[1].ID})
+	assert.Nil(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, "2", found[0].RecordID)
+	assert.Equal(t, 3, queue.visible())
+
+	// Letters that fail to redrive are released too
+	var out bytes.Buffer
+	err = Redrive(ctx, &out, store, found, func(context.Context, sundaecli.DeadLetter) error { return errors.New("still bad") }, false)
+	assert.NotNil(t, err)
+	assert.Equal(t, 4, queue.visible())
+
+	_, err = find(ctx, store, []string{"missing"})
+	assert.NotNil(t, err)
+	assert.Equal(t, 4, queue.visible())
+}
+
+type fakeMessage struct {
+	body   string
+	hidden bool
+}
+
+// fakeQueue is an SQS queue whose received messages stay hidden until they
+// are released
+type fakeQueue struct {
+	sqsiface.SQSAPI
+	messages []*fakeMessage
+}
+
+func (q *fakeQueue) visible() int {
+	var n int
+	for _, m := range q.messages {
+		if !m.hidden {
+			n++
+		}
+	}
+	return n
+}
+
+func (q *fakeQueue) SendMessageWithContext(_ aws.Context, input *sqs.SendMessageInput, _ ...request.Option) (*sqs.SendMessageOutput, error) {
+	q.messages = append(q.messages, &fakeMessage{body: aws.StringValue(input.MessageBody)})
+	return &sqs.SendMessageOutput{}, nil
+}
+
+func (q *fakeQueue) ReceiveMessageWithContext(_ aws.Context, input *sqs.ReceiveMessageInput, _ ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	out := &sqs.ReceiveMessageOutput{}
+	for i, m := range q.messages {
+		if m.hidden || int64(len(out.Messages)) == aws.Int64Value(input.MaxNumberOfMessages) {
+			continue
+		}
+		m.hidden = true
+		out.Messages = append(out.Messages, &sqs.Message{
+			MessageId:     aws.String(strconv.Itoa(i)),
+			ReceiptHandle: aws.String(strconv.Itoa(i)),
+			Body:          aws.String(m.body),
+		})
+	}
+	return out, nil
+}
+
+func (q *fakeQueue) ChangeMessageVisibilityBatchWithContext(_ aws.Context, input *sqs.ChangeMessageVisibilityBatchInput, _ ...request.Option) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	for _, entry := range input.Entries {
+		i, _ := strconv.Atoi(aws.StringValue(entry.ReceiptHandle))
+		q.messages[i].hidden = aws.Int64Value(entry.VisibilityTimeout) > 0
+	}
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
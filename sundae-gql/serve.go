@@ -1,17 +1,18 @@
 package sundaegql
 
 import (
+	"context"
 	"fmt"
-	"net/http"
 
 	"github.com/SundaeSwap-finance/sundae-go-utils/graphiql"
 	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	sundaetrace "github.com/SundaeSwap-finance/sundae-go-utils/sundae-trace"
 
-	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/graph-gophers/graphql-go"
 	"github.com/graph-gophers/graphql-go/relay"
+	graphqlotel "github.com/graph-gophers/graphql-go/trace/otel"
 	"github.com/rs/zerolog"
 	"github.com/savaki/apigateway"
 )
@@ -54,6 +55,7 @@ func GraphQLRelay(resolver Resolver) (*relay.Handler, error) {
 	opts := []graphql.SchemaOpt{
 		graphql.MaxDepth(15),
 		graphql.UseFieldResolvers(),
+		graphql.Tracer(&graphqlotel.Tracer{Tracer: sundaetrace.Tracer()}),
 	}
 	if !AllowIntrospection() {
 		opts = append(opts, graphql.DisableIntrospection())
@@ -71,6 +73,7 @@ func GraphQLRelay(resolver Resolver) (*relay.Handler, error) {
 func DefaultRouter(logger zerolog.Logger) chi.Router {
 	router := chi.NewRouter()
 	router.Use(
+		sundaetrace.Middleware,
 		middleware.Logger,
 		WithCORS(),
 		WithLogger(logger),
@@ -81,18 +84,16 @@ func DefaultRouter(logger zerolog.Logger) chi.Router {
 
 // Start listening / serving a graphql server, or as a Lambda function
 func Serve(router chi.Router, config *BaseConfig) error {
-	if sundaecli.CommonOpts.Console {
-		config.Logger.Info().Str("env", sundaecli.CommonOpts.Env).Int("port", sundaecli.CommonOpts.Port).Msgf("starting %v", config.Service.Name)
+	config.Logger.Info().Str("env", sundaecli.CommonOpts.Env).Str("runtime", string(sundaecli.DetectRuntime())).Msgf("starting %v", config.Service.Name)
+	handler := apigateway.Wrap(router, sundaecli.CommonOpts.Env, config.Service.Subpath)
+	return sundaecli.Run(context.Background(), handler, func(ctx context.Context) error {
+		config.Logger.Info().Int("port", sundaecli.CommonOpts.Port).Msg("starting http server")
 		addr := fmt.Sprintf(":%v", sundaecli.CommonOpts.Port)
 		if config.Service.Subpath != "" {
 			newRouter := chi.NewRouter()
 			newRouter.Mount(fmt.Sprintf("/%v", config.Service.Subpath), router)
 			router = newRouter
 		}
-		return http.ListenAndServe(addr, router)
-	}
-
-	config.Logger.Info().Str("env", sundaecli.CommonOpts.Env).Msgf("starting %v", config.Service.Name)
-	lambda.Start(apigateway.Wrap(router, sundaecli.CommonOpts.Env, config.Service.Subpath))
-	return nil
+		return sundaecli.ListenAndServe(ctx, addr, router)
+	})
 }
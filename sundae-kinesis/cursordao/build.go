@@ -1,9 +1,14 @@
 package cursordao
 
 import (
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
+func init() {
+	sundaecli.RegisterTable("cursordao", TableName, sundaecli.WithTableModel(Record{}), sundaecli.WithTableTTL("ttl"))
+}
+
 // Build protocol dao pointing to local db
 func Build(api dynamodbiface.DynamoDBAPI, env string) *DAO {
 	return New(api, TableName(env))
@@ -1,47 +1,14 @@
-//go:build integration
-// +build integration
-
 package cursordao
 
 import (
 	"context"
-	"fmt"
 	"sync/atomic"
 	"testing"
-	"time"
 
 	"github.com/SundaeSwap-finance/ogmigo/v6/ouroboros/chainsync"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/savaki/ddb"
 	"github.com/tj/assert"
 )
 
-func withTable(t *testing.T, callback func(ctx context.Context, dao *DAO)) {
-	var (
-		s = session.Must(session.NewSession(aws.NewConfig().
-			WithCredentials(credentials.NewStaticCredentials("blah", "blah", "")).
-			WithEndpoint("http://localhost:8000").
-			WithRegion("us-west-2")))
-		api       = dynamodb.New(s)
-		client    = ddb.New(api)
-		tableName = fmt.Sprintf("table-%v", time.Now().UnixNano())
-		table     = client.MustTable(tableName, Record{})
-		dao       = New(api, tableName)
-	)
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	err := table.CreateTableIfNotExists(ctx)
-	assert.Nil(t, err)
-	defer table.DeleteTableIfExists(ctx)
-
-	callback(ctx, dao)
-}
-
 func TestDAO(t *testing.T) {
 	withTable(t, func(ctx context.Context, dao *DAO) {
 		var (
@@ -0,0 +1,32 @@
+//go:build !integration
+// +build !integration
+
+package cursordao
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ddb/memddb"
+	"github.com/savaki/ddb"
+	"github.com/tj/assert"
+)
+
+// withTable runs callback against an in-memory table; build with -tags
+// integration to use DynamoDB Local instead
+func withTable(t *testing.T, callback func(ctx context.Context, dao *DAO)) {
+	var (
+		api       = memddb.New()
+		tableName = "cursors"
+		table     = ddb.New(api).MustTable(tableName, Record{})
+		dao       = New(api, tableName)
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := table.CreateTableIfNotExists(ctx)
+	assert.Nil(t, err)
+
+	callback(ctx, dao)
+}
@@ -14,6 +14,7 @@ var KinesisOpts struct {
 	StreamName  string
 	Replay      bool
 	ReplayFrom  cli.Timestamp
+	MaxLag      time.Duration
 }
 
 var OgmiosFlag = sundaecli.StringFlag("ogmios", "The ogmios endpoint to connect to", &KinesisOpts.Ogmios, "http://localhost:8000")
@@ -22,6 +23,8 @@ var PointFlag = sundaecli.StringFlag("point", "one or more points to try to star
 var StreamNameFlag = sundaecli.StringFlag("stream-name", "The stream name to read records from", &KinesisOpts.StreamName)
 var ReplayFlag = sundaecli.BoolFlag("replay", "Whether to replay from the beginning, or start from the next message", &KinesisOpts.Replay)
 
+var MaxLagFlag = sundaecli.DurationFlag("max-lag", "report not-ready on /readyz when the consumer falls further behind the stream than this (0 disables)", &KinesisOpts.MaxLag, 0)
+
 var ReplayFromFlag = cli.TimestampFlag{
 	Name:        "replay-from",
 	Usage:       "Kinesis ingestion timestamp to replay from",
@@ -38,4 +41,5 @@ var KinesisFlags = []cli.Flag{
 	StreamNameFlag,
 	ReplayFlag,
 	&ReplayFromFlag,
+	MaxLagFlag,
 }
@@ -9,12 +9,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"os"
-	"os/signal"
 	"regexp"
 	"strconv"
 	"strings"
-	"syscall"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/SundaeSwap-finance/ogmigo/v6"
 	ogmigolog "github.com/SundaeSwap-finance/ogmigo/v6/logger/zerolog"
@@ -23,14 +23,15 @@ import (
 	"github.com/SundaeSwap-finance/sundae-go-utils/cardano"
 	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
 	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-kinesis/cursordao"
+	sundaetrace "github.com/SundaeSwap-finance/sundae-go-utils/sundae-trace"
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	consumer "github.com/harlow/kinesis-consumer"
 	"github.com/rs/zerolog"
 	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const usage = "sundae-kinesis"
@@ -46,11 +47,18 @@ type Handler struct {
 	cursor      *cursordao.DAO
 	cursorUsage string
 
+	// millisBehind is how far behind the tip of the stream the last record
+	// read in console mode was
+	millisBehind atomic.Int64
+
 	handleMessage HandleMessageCallback
 
 	rollForwardBlock RollForwardBlockCallback
 	rollForwardTx    RollForwardTxCallback
 	rollBackward     RollBackwardCallback
+
+	batchOnce sync.Once
+	batch     *sundaecli.BatchProcessor
 }
 
 func NewGenericHandler(
@@ -70,7 +78,7 @@ func NewHandler(
 	rollForwardTx RollForwardTxCallback,
 	rollBackward RollBackwardCallback,
 ) *Handler {
-	session := session.Must(session.NewSession(aws.NewConfig()))
+	session := sundaetrace.Session(aws.NewConfig())
 	api := dynamodb.New(session)
 	return &Handler{
 		Service:          service,
@@ -103,26 +111,100 @@ func (h *Handler) SetCursorUsage(usage string) {
 	h.cursorUsage = usage
 }
 
+// SetBatchPolicy overrides the BatchPolicy set by sundaecli.BatchFlags; it
+// must be called before the first event is handled. Dead letters are stamped
+// with the handler's service unless the policy names another.
+func (h *Handler) SetBatchPolicy(policy sundaecli.BatchPolicy) {
+	if policy.Service.Name == "" {
+		policy.Service = h.Service
+	}
+	h.batchOnce.Do(func() {
+		h.batch = sundaecli.NewBatchProcessor(policy, h.Logger, nil)
+	})
+}
+
+func (h *Handler) batchProcessor() *sundaecli.BatchProcessor {
+	h.SetBatchPolicy(sundaecli.DefaultBatchPolicy("sundae-kinesis"))
+	return h.batch
+}
+
 func (h *Handler) Start(ctx *cli.Context) error {
-	if !sundaecli.CommonOpts.Console {
-		lambda.Start(h.HandleKinesisEvent)
+	metrics, err := sundaecli.BuildMetrics(h.Service)
+	if err != nil {
+		return err
+	}
+	if _, err := sundaecli.BuildFeatures(h.Service, metrics); err != nil {
+		return err
 	}
+	// The policy may have been set before the metrics were built
+	h.batchProcessor().SetMetrics(metrics)
 
-	if ctx.IsSet(OgmiosFlag.Name) {
-		return h.replayWithOgmios()
-	} else {
-		return h.handleRealtime()
+	var handler interface{} = h.HandleKinesisEvent
+	if h.batchProcessor().Policy().ReportItemFailures {
+		handler = h.HandleKinesisEventWithResponse
 	}
+	return sundaecli.Run(ctx.Context, handler, func(runCtx context.Context) error {
+		admin, err := sundaecli.StartAdmin(h.Service, metrics)
+		if err != nil {
+			return err
+		}
+		defer admin.Close()
+		if h.cursor != nil {
+			session := sundaetrace.Session(aws.NewConfig())
+			admin.AddReadinessCheck("cursor", sundaecli.DynamoDBCheck(dynamodb.New(session), cursordao.TableName(sundaecli.CommonOpts.Env)))
+		}
+		if KinesisOpts.MaxLag > 0 && !ctx.IsSet(OgmiosFlag.Name) {
+			admin.AddReadinessCheck("lag", sundaecli.LagCheck(h.Lag, KinesisOpts.MaxLag))
+		}
+
+		if ctx.IsSet(OgmiosFlag.Name) {
+			return h.replayWithOgmios(runCtx)
+		}
+		return h.handleRealtime(runCtx)
+	})
+}
+
+// Lag reports how far behind the tip of the stream the consumer was as of the
+// last record it read in console mode
+func (h *Handler) Lag() time.Duration {
+	return time.Duration(h.millisBehind.Load()) * time.Millisecond
 }
 
 func (h *Handler) HandleKinesisEvent(ctx context.Context, event events.KinesisEvent) (err error) {
+	return h.handleBatch(ctx, event).Err
+}
+
+// HandleKinesisEventWithResponse is HandleKinesisEvent for event source
+// mappings with ReportBatchItemFailures enabled: rather than failing, it names
+// the first record that failed, so Lambda retries from there instead of from
+// the start of the batch
+func (h *Handler) HandleKinesisEventWithResponse(ctx context.Context, event events.KinesisEvent) (events.KinesisEventResponse, error) {
+	var response events.KinesisEventResponse
+	if result := h.handleBatch(ctx, event); result.Err != nil {
+		response.BatchItemFailures = append(response.BatchItemFailures, events.KinesisBatchItemFailure{
+			ItemIdentifier: result.FailedID,
+		})
+	}
+	return response, nil
+}
+
+func (h *Handler) handleBatch(ctx context.Context, event events.KinesisEvent) sundaecli.BatchResult {
 	ctx = h.Logger.WithContext(ctx)
-	for _, r := range event.Records {
-		if err := h.handleSingleEvent(ctx, r); err != nil {
-			return err
-		}
+	return sundaecli.ProcessRecords(ctx, h.batchProcessor(), event.Records, kinesisRecordID, h.handleSingleEvent)
+}
+
+func kinesisRecordID(r events.KinesisEventRecord) string {
+	return r.Kinesis.SequenceNumber
+}
+
+// Redrive hands a record quarantined by this handler back to it, e.g. from
+// the sundaedeadletter command
+func (h *Handler) Redrive(ctx context.Context, letter sundaecli.DeadLetter) error {
+	var record events.KinesisEventRecord
+	if err := json.Unmarshal(letter.Record, &record); err != nil {
+		return fmt.Errorf("unable to decode dead letter %v: %w", letter.ID, err)
 	}
-	return nil
+	return h.handleSingleEvent(h.Logger.WithContext(ctx), record)
 }
 
 type KinesisSequenceNumberKeyType string
@@ -131,6 +213,15 @@ var KinesisSequenceNumberKey = KinesisSequenceNumberKeyType("kinesisSequenceNumb
 
 func (h *Handler) handleSingleEvent(ctx context.Context, r events.KinesisEventRecord) (err error) {
 	ctx = context.WithValue(ctx, KinesisSequenceNumberKey, r.Kinesis.SequenceNumber)
+	ctx, span := sundaetrace.Start(ctx, "kinesis.Record",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "aws_kinesis"),
+			attribute.String("messaging.kinesis.sequence_number", r.Kinesis.SequenceNumber),
+			attribute.String("messaging.kinesis.partition_key", r.Kinesis.PartitionKey),
+		),
+	)
+	defer func() { sundaetrace.End(span, err) }()
 
 	// Sometimes we just want full access, but still do the fancy ogmios / kinesis thing
 	if h.handleMessage != nil {
@@ -162,6 +253,13 @@ func (h *Handler) handleSingleEvent(ctx context.Context, r events.KinesisEventRe
 }
 
 func (h *Handler) onRollForward(ctx context.Context, block *chainsync.Block) (err error) {
+	ctx, span := sundaetrace.Start(ctx, "chainsync.RollForward", trace.WithAttributes(
+		attribute.Int64("cardano.slot", int64(block.Slot)),
+		attribute.String("cardano.block_hash", block.ID),
+		attribute.Int("cardano.tx_count", len(block.Transactions)),
+	))
+	defer func() { sundaetrace.End(span, err) }()
+
 	slotTime, err := cardano.SlotToDateTimeEnv(block.Slot, "")
 	if err != nil {
 		return fmt.Errorf("failed to convert slot to datetime: %w", err)
@@ -192,6 +290,12 @@ func (h *Handler) onRollForward(ctx context.Context, block *chainsync.Block) (er
 }
 
 func (h *Handler) onRollBackward(ctx context.Context, ps *chainsync.PointStruct) (err error) {
+	ctx, span := sundaetrace.Start(ctx, "chainsync.RollBackward", trace.WithAttributes(
+		attribute.Int64("cardano.slot", int64(ps.Slot)),
+		attribute.String("cardano.block_hash", ps.ID),
+	))
+	defer func() { sundaetrace.End(span, err) }()
+
 	h.Logger.Info().Uint64("slot", ps.Slot).Str("block", ps.ID).Msg("rolling backward")
 	if sundaecli.CommonOpts.Dry || KinesisOpts.PatchReplay {
 		if h.rollBackward != nil {
@@ -260,7 +364,10 @@ func parsePoints(pp ...string) ([]chainsync.Point, error) {
 
 	return points, nil
 }
-func (h *Handler) handleRealtime() error {
+
+// handleRealtime reads from the stream until ctx is cancelled; a record being
+// handled at that point is allowed to finish within the drain deadline
+func (h *Handler) handleRealtime(ctx context.Context) error {
 	streamName := KinesisOpts.StreamName
 	if streamName == "" {
 		streamName = fmt.Sprintf("%v-sundae-sync--tx", sundaecli.CommonOpts.Env)
@@ -281,19 +388,33 @@ func (h *Handler) handleRealtime() error {
 		return err
 	}
 
-	ctx := h.Logger.WithContext(context.Background())
+	ctx = h.Logger.WithContext(ctx)
+	drain, cancel := sundaecli.Drain(ctx)
+	defer cancel()
 	callback := func(record *consumer.Record) error {
+		if record.MillisBehindLatest != nil {
+			h.millisBehind.Store(*record.MillisBehindLatest)
+		}
 		er := events.KinesisEventRecord{
-			Kinesis: events.KinesisRecord{Data: record.Data},
+			Kinesis: events.KinesisRecord{
+				Data:           record.Data,
+				SequenceNumber: aws.StringValue(record.SequenceNumber),
+				PartitionKey:   aws.StringValue(record.PartitionKey),
+			},
 		}
-		return h.handleSingleEvent(ctx, er)
+		return sundaecli.ProcessRecords(drain, h.batchProcessor(), []events.KinesisEventRecord{er}, kinesisRecordID, h.handleSingleEvent).Err
 	}
 	fmt.Println("Listening...")
 	return c.Scan(ctx, callback)
 }
 
-func (h *Handler) replayWithOgmios() error {
-	ctx := h.Logger.WithContext(context.Background())
+// replayWithOgmios follows the chain from Ogmios until ctx is cancelled; the
+// block being handled at that point, and its cursor, are allowed to finish
+// within the drain deadline
+func (h *Handler) replayWithOgmios(ctx context.Context) error {
+	done := ctx.Done()
+	ctx, cancel := sundaecli.Drain(h.Logger.WithContext(ctx))
+	defer cancel()
 	ogmigoClient := ogmigo.New(
 		ogmigo.WithPipeline(50),
 		ogmigo.WithInterval(1000),
@@ -338,8 +459,6 @@ func (h *Handler) replayWithOgmios() error {
 		return err
 	}
 	defer chainSync.Close()
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
 	select {
 	case ogmigoErr := <-chainSync.Err():
@@ -348,9 +467,8 @@ func (h *Handler) replayWithOgmios() error {
 		h.Logger.Info().Msg("chainsync done")
 	case <-ctx.Done():
 		h.Logger.Info().Msg("context done")
-	case <-stop:
-		h.Logger.Info().Msg("caught SIGINT")
-		return nil
+	case <-done:
+		h.Logger.Info().Msg("shutting down chainsync")
 	}
 
 	return nil
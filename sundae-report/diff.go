@@ -0,0 +1,157 @@
+package sundaereport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The operations of a Change, as in a JSON Patch
+const (
+	ChangeAdd     = "add"
+	ChangeRemove  = "remove"
+	ChangeReplace = "replace"
+)
+
+// Change is one difference between two versions of a JSON report
+type Change struct {
+	Op string `json:"op"`
+	// Path is the JSON Pointer of the value that changed, e.g. /pools/0/price
+	Path string          `json:"path"`
+	Old  json.RawMessage `json:"old,omitempty"`
+	New  json.RawMessage `json:"new,omitempty"`
+}
+
+func (c Change) String() string {
+	path := c.Path
+	if path == "" {
+		path = "(root)"
+	}
+	switch c.Op {
+	case ChangeAdd:
+		return fmt.Sprintf("+ %v: %s", path, c.New)
+	case ChangeRemove:
+		return fmt.Sprintf("- %v: %s", path, c.Old)
+	default:
+		return fmt.Sprintf("~ %v: %s -> %s", path, c.Old, c.New)
+	}
+}
+
+// Diff compares two versions of a JSON report, or NDJSON decoded as an array,
+// value by value. Object members are compared by name, and array elements by
+// index; changes are in the order of their paths.
+func Diff(from, to []byte) ([]Change, error) {
+	a, err := decodeDiffable(from)
+	if err != nil {
+		return nil, fmt.Errorf("unable to diff old version: %w", err)
+	}
+	b, err := decodeDiffable(to)
+	if err != nil {
+		return nil, fmt.Errorf("unable to diff new version: %w", err)
+	}
+	var changes []Change
+	diffValues("", a, b, &changes)
+	return changes, nil
+}
+
+// decodeDiffable decodes data keeping numbers as written, so large integers
+// such as lovelace amounts compare exactly
+func decodeDiffable(data []byte) (interface{}, error) {
+	var values []interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	for dec.More() {
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	if len(values) == 1 {
+		return values[0], nil
+	}
+	return values, nil
+}
+
+func diffValues(path string, a, b interface{}, changes *[]Change) {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		if b, ok := b.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(a)+len(b))
+			for k := range a {
+				keys = append(keys, k)
+			}
+			for k := range b {
+				if _, ok := a[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				diffMember(path+"/"+escapePointer(k), a, b, k, changes)
+			}
+			return
+		}
+	case []interface{}:
+		if b, ok := b.([]interface{}); ok {
+			for i := 0; i < len(a) || i < len(b); i++ {
+				p := path + "/" + strconv.Itoa(i)
+				switch {
+				case i >= len(b):
+					*changes = append(*changes, Change{Op: ChangeRemove, Path: p, Old: rawJSON(a[i])})
+				case i >= len(a):
+					*changes = append(*changes, Change{Op: ChangeAdd, Path: p, New: rawJSON(b[i])})
+				default:
+					diffValues(p, a[i], b[i], changes)
+				}
+			}
+			return
+		}
+	}
+	if !equalValues(a, b) {
+		*changes = append(*changes, Change{Op: ChangeReplace, Path: path, Old: rawJSON(a), New: rawJSON(b)})
+	}
+}
+
+func diffMember(path string, a, b map[string]interface{}, k string, changes *[]Change) {
+	av, inA := a[k]
+	bv, inB := b[k]
+	switch {
+	case !inB:
+		*changes = append(*changes, Change{Op: ChangeRemove, Path: path, Old: rawJSON(av)})
+	case !inA:
+		*changes = append(*changes, Change{Op: ChangeAdd, Path: path, New: rawJSON(bv)})
+	default:
+		diffValues(path, av, bv, changes)
+	}
+}
+
+// equalValues compares leaf values, numbers by value, so 1.10 equals 1.1
+func equalValues(a, b interface{}) bool {
+	an, aok := a.(json.Number)
+	bn, bok := b.(json.Number)
+	if aok && bok {
+		ar, aok := new(big.Rat).SetString(an.String())
+		br, bok := new(big.Rat).SetString(bn.String())
+		if aok && bok {
+			return ar.Cmp(br) == 0
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// escapePointer escapes a member name for a JSON Pointer
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+// rawJSON encodes a value decoded by decodeDiffable, which can't fail
+func rawJSON(v interface{}) json.RawMessage {
+	data, _ := json.Marshal(v)
+	return data
+}
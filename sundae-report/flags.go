@@ -6,18 +6,27 @@ import (
 )
 
 var ReportOpts struct {
-	Bucket string
+	Bucket      string
+	Storage     string
+	Format      string
+	Compression string
 
 	OutFile   string
 	GetLatest bool
 }
 
 var BucketFlag = sundaecli.StringFlag("bucket", "The bucket to write the report to", &ReportOpts.Bucket)
+var StorageFlag = sundaecli.StringFlag("report-storage", "Where reports are kept: s3, in --bucket, or file:<dir>, a local directory laid out like the bucket", &ReportOpts.Storage, "s3")
+var FormatFlag = sundaecli.StringFlag("report-format", "The format to write the report in: json, ndjson, csv or parquet; defaults to the handler's", &ReportOpts.Format)
+var CompressionFlag = sundaecli.StringFlag("report-compression", "How to compress the report: gzip, zstd or none; defaults to the handler's", &ReportOpts.Compression)
 var OutFileFlag = sundaecli.StringFlag("out-file", "The file to write the report to, when running in dry mode", &ReportOpts.OutFile)
 var GetLatestFlag = sundaecli.BoolFlag("get-latest", "Get the latest report from the bucket instead of generating a new one", &ReportOpts.GetLatest)
 
 var ReportFlags = []cli.Flag{
 	BucketFlag,
+	StorageFlag,
+	FormatFlag,
+	CompressionFlag,
 	OutFileFlag,
 	GetLatestFlag,
 }
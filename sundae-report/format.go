@@ -0,0 +1,517 @@
+package sundaereport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Format is the encoding of a report
+type Format string
+
+const (
+	// FormatJSON writes the report as a single JSON value; rows become an array
+	FormatJSON Format = "json"
+	// FormatNDJSON writes one JSON row per line
+	FormatNDJSON Format = "ndjson"
+	// FormatCSV writes a header row, then one line per row
+	FormatCSV Format = "csv"
+	// FormatParquet writes a Parquet file, with the schema of the first row
+	FormatParquet Format = "parquet"
+)
+
+// ParseFormat parses the --report-format flag
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatJSON, FormatNDJSON, FormatCSV, FormatParquet:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown report format %q: expected json, ndjson, csv or parquet", s)
+}
+
+// ContentType is the Content-Type of a report in format f
+func (f Format) ContentType() string {
+	switch f {
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatCSV:
+		return "text/csv"
+	case FormatParquet:
+		return "application/vnd.apache.parquet"
+	default:
+		return "application/json"
+	}
+}
+
+// Ext is the file extension of a report in format f compressed with c.
+// Parquet compresses its pages itself, so compression doesn't change it.
+func (f Format) Ext(c Compression) string {
+	ext := "." + string(f)
+	if f == "" {
+		ext = ".json"
+	}
+	if f == FormatParquet {
+		return ext
+	}
+	return ext + c.Ext()
+}
+
+// Compression is how a report is compressed
+type Compression string
+
+const (
+	CompressionNone Compression = ""
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+// ParseCompression parses the --report-compression flag
+func ParseCompression(s string) (Compression, error) {
+	switch c := Compression(strings.ToLower(s)); c {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return c, nil
+	case "none":
+		return CompressionNone, nil
+	}
+	return "", fmt.Errorf("unknown report compression %q: expected gzip, zstd or none", s)
+}
+
+// ContentEncoding is the Content-Encoding of a report in format f compressed
+// with c, or "" if it has none
+func (c Compression) ContentEncoding(f Format) string {
+	if f == FormatParquet {
+		return ""
+	}
+	return string(c)
+}
+
+// Ext is the file extension c adds
+func (c Compression) Ext() string {
+	switch c {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// ReportWriter receives the rows of a report as they are generated, so a
+// report needn't fit in memory. Rows are structs, pointers to structs or, for
+// every format but Parquet, maps with string keys.
+type ReportWriter interface {
+	Write(row interface{}) error
+}
+
+// rowEncoder is a ReportWriter that must be closed to finish the report
+type rowEncoder interface {
+	ReportWriter
+	Close() error
+}
+
+// newEncoder returns an encoder writing rows to w in format f, compressed with c
+func newEncoder(w io.Writer, f Format, c Compression) (rowEncoder, error) {
+	if f == FormatParquet {
+		return newParquetEncoder(w, c), nil
+	}
+
+	cw, err := newCompressor(w, c)
+	if err != nil {
+		return nil, err
+	}
+	var enc rowEncoder
+	switch f {
+	case FormatNDJSON:
+		enc = &ndjsonEncoder{enc: json.NewEncoder(cw)}
+	case FormatCSV:
+		enc = &csvEncoder{w: csv.NewWriter(cw)}
+	default:
+		enc = &jsonEncoder{w: cw}
+	}
+	return &compressedEncoder{rowEncoder: enc, compressor: cw}, nil
+}
+
+// newCompressor returns a writer compressing to w with c; closing it flushes
+// the compressor, but doesn't close w
+func newCompressor(w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create zstd writer: %w", err)
+		}
+		return zw, nil
+	default:
+		return nopCloser{w}, nil
+	}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// compressedEncoder closes the compressor after the encoder
+type compressedEncoder struct {
+	rowEncoder
+	compressor io.Closer
+}
+
+func (e *compressedEncoder) Close() error {
+	if err := e.rowEncoder.Close(); err != nil {
+		return err
+	}
+	return e.compressor.Close()
+}
+
+// jsonEncoder writes rows as the elements of a JSON array
+type jsonEncoder struct {
+	w    io.Writer
+	rows int
+}
+
+func (e *jsonEncoder) Write(row interface{}) error {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return fmt.Errorf("unable to encode report row: %w", err)
+	}
+	sep := ",\n"
+	if e.rows == 0 {
+		sep = "[\n"
+	}
+	e.rows++
+	if _, err := io.WriteString(e.w, sep); err != nil {
+		return err
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonEncoder) Close() error {
+	end := "\n]\n"
+	if e.rows == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) Write(row interface{}) error {
+	if err := e.enc.Encode(row); err != nil {
+		return fmt.Errorf("unable to encode report row: %w", err)
+	}
+	return nil
+}
+
+func (e *ndjsonEncoder) Close() error {
+	return nil
+}
+
+// csvEncoder writes the columns of the first row as the header. The columns
+// of a struct are its exported fields, named by their csv or json tag; the
+// columns of a map are its sorted keys.
+type csvEncoder struct {
+	w       *csv.Writer
+	columns []csvColumn
+	keys    []string
+	record  []string
+}
+
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+func (e *csvEncoder) Write(row interface{}) error {
+	rv := reflect.ValueOf(row)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+
+	if e.record == nil {
+		var header []string
+		switch {
+		case rv.Kind() == reflect.Struct:
+			e.columns = csvColumns(rv.Type(), nil)
+			for _, c := range e.columns {
+				header = append(header, c.name)
+			}
+		case rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String:
+			for _, k := range rv.MapKeys() {
+				e.keys = append(e.keys, k.String())
+			}
+			sort.Strings(e.keys)
+			header = e.keys
+		default:
+			return fmt.Errorf("unable to write %T as a csv row: expected a struct or a map with string keys", row)
+		}
+		e.record = make([]string, len(header))
+		if err := e.w.Write(header); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case e.columns != nil && rv.Kind() == reflect.Struct:
+		for i, c := range e.columns {
+			fv, ok := fieldByIndex(rv, c.index)
+			e.record[i] = ""
+			if ok {
+				e.record[i] = csvValue(fv)
+			}
+		}
+	case e.keys != nil && rv.Kind() == reflect.Map:
+		for i, k := range e.keys {
+			e.record[i] = ""
+			if fv := rv.MapIndex(reflect.ValueOf(k).Convert(rv.Type().Key())); fv.IsValid() {
+				e.record[i] = csvValue(fv)
+			}
+		}
+	default:
+		return fmt.Errorf("unable to write %T as a csv row: rows must all be the same kind", row)
+	}
+	return e.w.Write(e.record)
+}
+
+func (e *csvEncoder) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func csvColumns(rt reflect.Type, index []int) []csvColumn {
+	var columns []csvColumn
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		fieldIndex := append(append([]int(nil), index...), i)
+		name, tagged := csvName(field)
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && !tagged {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				columns = append(columns, csvColumns(ft, fieldIndex)...)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		columns = append(columns, csvColumn{name: name, index: fieldIndex})
+	}
+	return columns
+}
+
+func csvName(field reflect.StructField) (string, bool) {
+	for _, key := range []string{"csv", "json"} {
+		if tag, ok := field.Tag.Lookup(key); ok {
+			if name, _, _ := strings.Cut(tag, ","); name != "" {
+				return name, true
+			}
+		}
+	}
+	return field.Name, false
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex, reporting false for a field
+// inside a nil embedded pointer
+func fieldByIndex(rv reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				return reflect.Value{}, false
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, true
+}
+
+func csvValue(v reflect.Value) string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	switch x := v.Interface().(type) {
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return x.String()
+	case []byte:
+		return string(x)
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits())
+	}
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return fmt.Sprint(v.Interface())
+	}
+	return string(data)
+}
+
+// parquetEncoder writes a Parquet file, flushing a row group every
+// parquetRowGroupSize rows so only one row group is held in memory
+type parquetEncoder struct {
+	w *parquet.Writer
+}
+
+const parquetRowGroupSize = 64 * 1024
+
+func newParquetEncoder(w io.Writer, c Compression) *parquetEncoder {
+	opts := []parquet.WriterOption{parquet.MaxRowsPerRowGroup(parquetRowGroupSize)}
+	switch c {
+	case CompressionGzip:
+		opts = append(opts, parquet.Compression(&parquet.Gzip))
+	case CompressionZstd:
+		opts = append(opts, parquet.Compression(&parquet.Zstd))
+	}
+	return &parquetEncoder{w: parquet.NewWriter(w, opts...)}
+}
+
+func (e *parquetEncoder) Write(row interface{}) error {
+	rt := reflect.TypeOf(row)
+	if rt != nil && rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	if rt == nil || rt.Kind() != reflect.Struct {
+		return fmt.Errorf("unable to write %T as a parquet row: expected a struct", row)
+	}
+	if err := e.w.Write(row); err != nil {
+		return fmt.Errorf("unable to write parquet row: %w", err)
+	}
+	return nil
+}
+
+func (e *parquetEncoder) Close() error {
+	return e.w.Close()
+}
+
+// writeValue writes a report returned whole by a GenerateCallback. As JSON,
+// it's written as is; in the other formats it must be a slice, each element
+// of which is a row.
+func writeValue(w io.Writer, value interface{}, f Format, c Compression) error {
+	if f == FormatJSON || f == "" {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to marshal report: %w", err)
+		}
+		cw, err := newCompressor(w, c)
+		if err != nil {
+			return err
+		}
+		if _, err := cw.Write(data); err != nil {
+			return err
+		}
+		return cw.Close()
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return fmt.Errorf("unable to write %T as a %v report: expected a slice of rows", value, f)
+	}
+	enc, err := newEncoder(w, f, c)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < rv.Len(); i++ {
+		if err := enc.Write(rv.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
+
+// decompress undoes the compression implied by the extension of key
+func decompress(key string, data []byte) ([]byte, error) {
+	switch {
+	case strings.HasSuffix(key, CompressionGzip.Ext()):
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("unable to decompress %v: %w", key, err)
+		}
+		defer r.Close()
+		out, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decompress %v: %w", key, err)
+		}
+		return out, nil
+	case strings.HasSuffix(key, CompressionZstd.Ext()):
+		r, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decompress %v: %w", key, err)
+		}
+		defer r.Close()
+		out, err := r.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decompress %v: %w", key, err)
+		}
+		return out, nil
+	}
+	return data, nil
+}
+
+// decodeReport decodes the report stored at key into obj; NDJSON rows are
+// decoded as a JSON array
+func decodeReport(key string, data []byte, obj any) error {
+	key = strings.TrimSuffix(strings.TrimSuffix(key, CompressionGzip.Ext()), CompressionZstd.Ext())
+	switch {
+	case strings.HasSuffix(key, FormatNDJSON.Ext(CompressionNone)):
+		var rows []json.RawMessage
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for dec.More() {
+			var row json.RawMessage
+			if err := dec.Decode(&row); err != nil {
+				return fmt.Errorf("failed to unmarshal report %v: %w", key, err)
+			}
+			rows = append(rows, row)
+		}
+		array, err := json.Marshal(rows)
+		if err != nil {
+			return err
+		}
+		data = array
+	case strings.HasSuffix(key, FormatCSV.Ext(CompressionNone)), strings.HasSuffix(key, FormatParquet.Ext(CompressionNone)):
+		return fmt.Errorf("unable to unmarshal report %v: only json and ndjson reports can be decoded", key)
+	}
+	if err := json.Unmarshal(data, obj); err != nil {
+		return fmt.Errorf("failed to unmarshal latest report: %w", err)
+	}
+	return nil
+}
@@ -5,18 +5,20 @@ package sundaereport
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path"
-	"sort"
 	"time"
 
 	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
-	"github.com/aws/aws-lambda-go/lambda"
+	sundaetrace "github.com/SundaeSwap-finance/sundae-go-utils/sundae-trace"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/rs/zerolog"
@@ -24,162 +26,317 @@ import (
 
 type GenerateCallback func(ctx context.Context) (interface{}, error)
 
+// RowsCallback generates a report by writing its rows to w, which encodes
+// and uploads them as they are written
+type RowsCallback func(ctx context.Context, w ReportWriter) error
+
 type Handler struct {
 	service sundaecli.Service
 	logger  zerolog.Logger
 	s3      s3iface.S3API
+	storage Storage
 
-	reportName string
+	reportName  string
+	format      Format
+	compression Compression
+	retention   *RetentionPolicy
+	schema      int
 
 	generate GenerateCallback
+	rows     RowsCallback
+}
+
+type HandlerOption func(*Handler)
+
+// WithFormat sets the format reports are written in, FormatJSON by default
+func WithFormat(format Format) HandlerOption {
+	return func(h *Handler) {
+		h.format = format
+	}
+}
+
+// WithCompression sets how reports are compressed, CompressionNone by default
+func WithCompression(compression Compression) HandlerOption {
+	return func(h *Handler) {
+		h.compression = compression
+	}
+}
+
+// WithStorage keeps reports in storage, rather than the one set by
+// --report-storage
+func WithStorage(storage Storage) HandlerOption {
+	return func(h *Handler) {
+		h.storage = storage
+	}
+}
+
+// WithRetention deletes the versions policy doesn't keep after each report
+// is saved
+func WithRetention(policy RetentionPolicy) HandlerOption {
+	return func(h *Handler) {
+		h.retention = &policy
+	}
+}
+
+// WithSchemaVersion sets the schema version recorded in the manifest, to be
+// bumped when the layout of the report changes
+func WithSchemaVersion(version int) HandlerOption {
+	return func(h *Handler) {
+		h.schema = version
+	}
 }
 
 func ReportKey(serviceName, reportName string, timestamp time.Time) string {
-	return fmt.Sprintf("%v/%v/%v/%v/%v", serviceName, reportName, timestamp.Format("2006-01-02"), timestamp.Format("15"), timestamp.Format("2006-01-02-15:04:05.json"))
+	return ReportKeyFor(serviceName, reportName, timestamp, FormatJSON, CompressionNone)
+}
+
+// ReportKeyFor is the key of a report in format, compressed with compression
+func ReportKeyFor(serviceName, reportName string, timestamp time.Time, format Format, compression Compression) string {
+	return fmt.Sprintf("%v/%v/%v/%v/%v%v", serviceName, reportName, timestamp.Format("2006-01-02"), timestamp.Format("15"), timestamp.Format("2006-01-02-15:04:05"), format.Ext(compression))
 }
 
 func NewHandler(
 	service sundaecli.Service,
 	reportName string,
 	generate GenerateCallback,
+	opts ...HandlerOption,
+) *Handler {
+	h := newHandler(service, reportName, opts)
+	h.generate = generate
+	return h
+}
+
+// NewStreamingHandler returns a Handler for reports too large to hold in
+// memory: rows are encoded, compressed and uploaded as rows writes them
+func NewStreamingHandler(
+	service sundaecli.Service,
+	reportName string,
+	rows RowsCallback,
+	opts ...HandlerOption,
 ) *Handler {
-	session := session.Must(session.NewSession(aws.NewConfig()))
-	return &Handler{
+	h := newHandler(service, reportName, opts)
+	h.rows = rows
+	return h
+}
+
+func newHandler(service sundaecli.Service, reportName string, opts []HandlerOption) *Handler {
+	session := sundaetrace.Session(aws.NewConfig())
+	h := &Handler{
 		service:    service,
 		logger:     sundaecli.Logger(service),
 		s3:         s3.New(session),
 		reportName: reportName,
-		generate:   generate,
+		format:     FormatJSON,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// configure applies --report-format and --report-compression
+func (h *Handler) configure() error {
+	if ReportOpts.Format != "" {
+		format, err := ParseFormat(ReportOpts.Format)
+		if err != nil {
+			return err
+		}
+		h.format = format
+	}
+	if ReportOpts.Compression != "" {
+		compression, err := ParseCompression(ReportOpts.Compression)
+		if err != nil {
+			return err
+		}
+		h.compression = compression
 	}
+	return nil
 }
 
 func (h *Handler) Generate(ctx context.Context, _ json.RawMessage) error {
 	h.logger.Info().Msg("generating report")
-	report, err := h.generate(ctx)
-	if err != nil {
-		h.logger.Warn().Err(err).Msg("failed to generate report")
-		return err
-	}
-	reportBytes, err := json.Marshal(report)
-	if err != nil {
-		h.logger.Warn().Err(err).Msg("failed to marshal report")
-		return err
+
+	// A report returned whole is generated before anything is written, as it
+	// always has been
+	var report interface{}
+	if h.generate != nil {
+		var err error
+		report, err = h.generate(ctx)
+		if err != nil {
+			h.logger.Warn().Err(err).Msg("failed to generate report")
+			return err
+		}
 	}
 
 	now := time.Now().UTC()
-	var filename string
 	if sundaecli.CommonOpts.Dry {
 		if ReportOpts.OutFile == "" {
-			enc := json.NewEncoder(os.Stdout)
-			enc.SetIndent("", "  ")
-			if err := enc.Encode(report); err != nil {
-				return err
-			}
-		} else {
-			filename = fmt.Sprintf("%v-%v.json", h.reportName, now.Format("2006-01-02-15:04:05"))
-			if err := os.MkdirAll(path.Dir(filename), 0755); err != nil {
-				return err
-			}
-			h.logger.Info().Str("bucket", ReportOpts.Bucket).Str("filename", filename).Int("size", len(reportBytes)).Msg("dry run, saving report locally")
-			if err := os.WriteFile(filename, reportBytes, 0644); err != nil {
-				return err
+			if h.generate != nil && h.format == FormatJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(report)
 			}
+			return h.write(ctx, os.Stdout, report, CompressionNone)
 		}
-	} else {
-		filename := ReportKey(h.service.Name, h.reportName, now)
-		h.logger.Info().Str("bucket", ReportOpts.Bucket).Str("filename", filename).Int("size", len(reportBytes)).Msg("saving report to s3")
-		_, err = h.s3.PutObject(&s3.PutObjectInput{
-			Bucket: aws.String(ReportOpts.Bucket),
-			Body:   bytes.NewReader(reportBytes),
-			Key:    aws.String(filename),
-		})
+		filename := fmt.Sprintf("%v-%v%v", h.reportName, now.Format("2006-01-02-15:04:05"), h.format.Ext(h.compression))
+		if err := os.MkdirAll(path.Dir(filename), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(filename)
 		if err != nil {
 			return err
 		}
+		defer f.Close()
+		out := newCountingWriter(f)
+		if err := h.write(ctx, out, report, h.compression); err != nil {
+			h.logger.Warn().Err(err).Msg("failed to write report")
+			return err
+		}
+		h.logger.Info().Str("bucket", ReportOpts.Bucket).Str("filename", filename).Int64("size", out.n).Msg("dry run, saved report locally")
+		return f.Close()
 	}
 
-	return nil
-}
+	storage, err := h.store()
+	if err != nil {
+		return err
+	}
+	filename := ReportKeyFor(h.service.Name, h.reportName, now, h.format, h.compression)
+	h.logger.Info().Str("filename", filename).Msg("saving report")
+	out, err := h.upload(ctx, storage, filename, report)
+	if err != nil {
+		h.logger.Warn().Err(err).Str("filename", filename).Msg("failed to save report")
+		return err
+	}
+	h.logger.Info().Str("filename", filename).Int64("size", out.n).Msg("saved report")
 
-func GetRawAsOf(ctx context.Context, s3Api s3iface.S3API, bucket, servicename, reportName string, timestamp time.Time) ([]byte, string, error) {
-	count := 0
-	currentTimestamp := timestamp
-	for {
-		prefix := fmt.Sprintf("%v/%v/%v/%v", servicename, reportName, currentTimestamp.Format("2006-01-02"), currentTimestamp.Format("15"))
-		listInput := s3.ListObjectsV2Input{
-			Bucket:  aws.String(bucket),
-			MaxKeys: aws.Int64(1000),
-			Prefix:  aws.String(prefix),
-		}
-		listOutput, err := s3Api.ListObjectsV2WithContext(ctx, &listInput)
+	manifest := Manifest{
+		Key:           filename,
+		Size:          out.n,
+		Checksum:      hex.EncodeToString(out.sum.Sum(nil)),
+		Generated:     now,
+		Format:        h.format,
+		Compression:   h.compression,
+		SchemaVersion: h.schema,
+	}
+	if err := putManifest(ctx, storage, h.service.Name, h.reportName, manifest); err != nil {
+		h.logger.Warn().Err(err).Str("filename", filename).Msg("saved report, but failed to update the manifest")
+		return err
+	}
+
+	if h.retention != nil {
+		expired, err := ApplyRetention(ctx, storage, h.service.Name, h.reportName, *h.retention, now)
 		if err != nil {
-			return nil, "", fmt.Errorf("failed to read most recent exchange-feed: failed to list objects: %w", err)
+			// the report is saved; expired versions are deleted next time
+			h.logger.Warn().Err(err).Msg("failed to apply retention policy")
+			return nil
 		}
+		h.logger.Info().Int("expired", len(expired)).Msg("applied retention policy")
+	}
+	return nil
+}
 
-		if len(listOutput.Contents) == 0 {
-			currentTimestamp = currentTimestamp.Add(-time.Hour)
-			if count > 24 {
-				return nil, "", fmt.Errorf("failed to find latest report in the last 24 hours. final timestamp: %v", currentTimestamp)
-			}
-			count += 1
-			continue
-		}
+// write encodes the report to w: report, if it was returned whole, or else
+// the rows the RowsCallback writes
+func (h *Handler) write(ctx context.Context, w io.Writer, report interface{}, compression Compression) error {
+	if h.generate != nil {
+		return writeValue(w, report, h.format, compression)
+	}
+	enc, err := newEncoder(w, h.format, compression)
+	if err != nil {
+		return err
+	}
+	if err := h.rows(ctx, enc); err != nil {
+		return fmt.Errorf("failed to generate report: %w", err)
+	}
+	return enc.Close()
+}
 
-		// Grab the last
-		sort.Slice(listOutput.Contents, func(i, j int) bool {
-			return aws.StringValue(listOutput.Contents[i].Key) > aws.StringValue(listOutput.Contents[j].Key)
-		})
+// store returns the storage set by WithStorage, or else by --report-storage
+func (h *Handler) store() (Storage, error) {
+	if h.storage != nil {
+		return h.storage, nil
+	}
+	return NewStorage(h.s3, ReportOpts.Storage, ReportOpts.Bucket)
+}
 
-		var firstKey *string
-		for _, obj := range listOutput.Contents {
-			if aws.StringValue(obj.Key) > ReportKey(servicename, reportName, timestamp) {
-				continue
-			}
-			firstKey = obj.Key
-			break
-		}
-		if firstKey == nil {
-			return nil, "", fmt.Errorf("pnly found reports after timestamp %v", timestamp)
-		}
+// upload streams the report to storage through a pipe, so only the part
+// being uploaded is held in memory. If generation fails, the upload is
+// aborted and no object is written.
+func (h *Handler) upload(ctx context.Context, storage Storage, key string, report interface{}) (*countingWriter, error) {
+	pr, pw := io.Pipe()
+	out := newCountingWriter(pw)
+	done := make(chan error, 1)
+	go func() {
+		err := h.write(ctx, out, report, h.compression)
+		pw.CloseWithError(err)
+		done <- err
+	}()
 
-		input := s3.GetObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    firstKey,
-		}
-		output, err := s3Api.GetObjectWithContext(ctx, &input)
-		if err != nil {
-			return nil, "", fmt.Errorf("failed to read most recent file in %v: failed to get object, %v: %w", prefix, aws.StringValue(firstKey), err)
-		}
-		bytes, err := io.ReadAll(output.Body)
-		if err != nil {
-			return nil, "", fmt.Errorf("failed to read most recent file in %v: failed to read s3 response, %v: %w", prefix, aws.StringValue(firstKey), err)
+	meta := ObjectMeta{
+		ContentType:     h.format.ContentType(),
+		ContentEncoding: h.compression.ContentEncoding(h.format),
+	}
+	if err := storage.Put(ctx, key, pr, meta); err != nil {
+		// unblock the writer; if it failed first, its error is the cause
+		pr.CloseWithError(err)
+		if writeErr := <-done; writeErr != nil && !errors.Is(writeErr, err) {
+			return nil, writeErr
 		}
-		return bytes, aws.StringValue(firstKey), nil
+		return nil, err
 	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
+// countingWriter counts and hashes what's written through it
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	sum hash.Hash
+}
+
+func newCountingWriter(w io.Writer) *countingWriter {
+	return &countingWriter{w: w, sum: sha256.New()}
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.sum.Write(p[:n])
+	return n, err
+}
+
+// GetRawAsOf is ReadAsOf from an S3 bucket
+func GetRawAsOf(ctx context.Context, s3Api s3iface.S3API, bucket, servicename, reportName string, timestamp time.Time) ([]byte, string, error) {
+	return ReadAsOf(ctx, NewS3Storage(s3Api, bucket), servicename, reportName, timestamp)
+}
+
+// GetLatest is DecodeLatest from an S3 bucket
 func GetLatest(ctx context.Context, s3Api s3iface.S3API, bucket, serviceName, reportName string, obj any) (string, error) {
-	now := time.Now().UTC()
-	bytes, filename, err := GetRawAsOf(ctx, s3Api, bucket, serviceName, reportName, now)
-	if err != nil {
-		return "", err
-	}
-	if err := json.Unmarshal(bytes, obj); err != nil {
-		return "", fmt.Errorf("failed to unmarshal latest report: %w", err)
-	}
-	return filename, nil
+	return DecodeLatest(ctx, NewS3Storage(s3Api, bucket), serviceName, reportName, obj)
 }
 
 func (h *Handler) Start() error {
+	if err := h.configure(); err != nil {
+		return err
+	}
 	if ReportOpts.GetLatest {
-		reportBytes, filename, err := GetRawAsOf(context.Background(), h.s3, ReportOpts.Bucket, h.service.Name, h.reportName, time.Now().UTC())
+		storage, err := h.store()
+		if err != nil {
+			return err
+		}
+		reportBytes, filename, err := ReadLatest(context.Background(), storage, h.service.Name, h.reportName)
 		if err != nil {
 			return err
 		}
 		if ReportOpts.OutFile == "" {
 			var prettyBytes bytes.Buffer
 			if err := json.Indent(&prettyBytes, reportBytes, "", "  "); err != nil {
-				return err
+				// not a JSON report
+				prettyBytes.Reset()
+				prettyBytes.Write(reportBytes)
 			}
 			os.Stdout.Write(prettyBytes.Bytes())
 		} else {
@@ -193,12 +350,7 @@ func (h *Handler) Start() error {
 		return nil
 	}
 
-	switch {
-	case sundaecli.CommonOpts.Console:
-		return h.Generate(context.Background(), nil)
-
-	default:
-		lambda.Start(h.Generate)
-	}
-	return nil
+	return sundaecli.Run(context.Background(), h.Generate, func(ctx context.Context) error {
+		return h.Generate(ctx, nil)
+	})
 }
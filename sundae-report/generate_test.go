@@ -0,0 +1,210 @@
+package sundaereport
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/parquet-go/parquet-go"
+	"github.com/tj/assert"
+)
+
+type order struct {
+	ID      string    `json:"id" parquet:"id"`
+	Amount  int64     `json:"amount" parquet:"amount"`
+	Pair    *string   `json:"pair,omitempty" parquet:"pair,optional"`
+	Created time.Time `json:"created" parquet:"created"`
+	secret  string
+}
+
+var created = time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+
+func orders() []order {
+	return []order{
+		{ID: "a", Amount: 10, Pair: aws.String("ADA/SUNDAE"), Created: created},
+		{ID: "b", Amount: -5, Created: created.Add(time.Minute), secret: "x"},
+	}
+}
+
+func encode(t *testing.T, f Format, c Compression, rows interface{}) []byte {
+	var buf bytes.Buffer
+	assert.Nil(t, writeValue(&buf, rows, f, c))
+	data, err := decompress(ReportKeyFor("svc", "orders", created, f, c), buf.Bytes())
+	assert.Nil(t, err)
+	return data
+}
+
+func TestFormats(t *testing.T) {
+	var buf bytes.Buffer
+	enc, err := newEncoder(&buf, FormatJSON, CompressionNone)
+	assert.Nil(t, err)
+	for _, o := range orders() {
+		assert.Nil(t, enc.Write(o))
+	}
+	assert.Nil(t, enc.Close())
+	assert.Equal(t, "[\n"+
+		`{"id":"a","amount":10,"pair":"ADA/SUNDAE","created":"2026-10-14T10:00:00Z"},`+"\n"+
+		`{"id":"b","amount":-5,"created":"2026-10-14T10:01:00Z"}`+"\n]\n", buf.String())
+
+	ndjson := `{"id":"a","amount":10,"pair":"ADA/SUNDAE","created":"2026-10-14T10:00:00Z"}` + "\n" +
+		`{"id":"b","amount":-5,"created":"2026-10-14T10:01:00Z"}` + "\n"
+	assert.Equal(t, ndjson, string(encode(t, FormatNDJSON, CompressionGzip, orders())))
+	assert.Equal(t, ndjson, string(encode(t, FormatNDJSON, CompressionZstd, orders())))
+
+	var decoded []order
+	assert.Nil(t, decodeReport("svc/orders/x.ndjson.gz", []byte(ndjson), &decoded))
+	assert.Equal(t, "ADA/SUNDAE", *decoded[0].Pair)
+	assert.Len(t, decoded, 2)
+
+	assert.Equal(t, "id,amount,pair,created\n"+
+		"a,10,ADA/SUNDAE,2026-10-14T10:00:00Z\n"+
+		"b,-5,,2026-10-14T10:01:00Z\n",
+		string(encode(t, FormatCSV, CompressionNone, orders())))
+	assert.Equal(t, "amount,id\n10,a\n,b\n", string(encode(t, FormatCSV, CompressionNone, []map[string]interface{}{
+		{"id": "a", "amount": 10},
+		{"id": "b"},
+	})))
+
+	data := encode(t, FormatParquet, CompressionZstd, orders())
+	rows, err := parquet.Read[order](bytes.NewReader(data), int64(len(data)))
+	assert.Nil(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, "b", rows[1].ID)
+	assert.Equal(t, int64(-5), rows[1].Amount)
+
+	// A report returned whole is written as is in JSON, and must be rows otherwise
+	assert.Equal(t, `{"hello":"world"}`, string(encode(t, FormatJSON, CompressionGzip, map[string]string{"hello": "world"})))
+	assert.NotNil(t, writeValue(io.Discard, map[string]string{"hello": "world"}, FormatCSV, CompressionNone))
+	assert.NotNil(t, writeValue(io.Discard, []string{"a"}, FormatParquet, CompressionNone))
+
+	assert.Equal(t, "svc/orders/2026-10-14/10/2026-10-14-10:00:00.json", ReportKey("svc", "orders", created))
+	assert.Equal(t, "svc/orders/2026-10-14/10/2026-10-14-10:00:00.csv.zst", ReportKeyFor("svc", "orders", created, FormatCSV, CompressionZstd))
+	assert.Equal(t, "svc/orders/2026-10-14/10/2026-10-14-10:00:00.parquet", ReportKeyFor("svc", "orders", created, FormatParquet, CompressionGzip))
+	_, err = ParseFormat("xml")
+	assert.EqualError(t, err, `unknown report format "xml": expected json, ndjson, csv or parquet`)
+}
+
+// s3Server records the objects put to it
+type s3Server struct {
+	headers map[string]http.Header
+	objects map[string][]byte
+}
+
+func (s *s3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+	case http.MethodGet:
+		body, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `<Error><Code>NoSuchKey</Code></Error>`)
+			return
+		}
+		w.Write(body)
+		return
+	default:
+		http.Error(w, "unsupported", http.StatusNotImplemented)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.headers[r.URL.Path] = r.Header
+	s.objects[r.URL.Path] = body
+}
+
+func TestGenerate(t *testing.T) {
+	server := &s3Server{headers: map[string]http.Header{}, objects: map[string][]byte{}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	s3Api := s3.New(session.Must(session.NewSession(&aws.Config{
+		Endpoint:         aws.String(ts.URL),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		S3ForcePathStyle: aws.Bool(true),
+	})))
+	defer func(bucket string) { ReportOpts.Bucket = bucket }(ReportOpts.Bucket)
+	ReportOpts.Bucket = "reports"
+
+	service := sundaecli.NewService("svc")
+	h := NewStreamingHandler(service, "orders", func(ctx context.Context, w ReportWriter) error {
+		for i := 0; i < 1000; i++ {
+			if err := w.Write(order{ID: fmt.Sprint(i), Amount: int64(i), Created: created}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, WithFormat(FormatNDJSON), WithCompression(CompressionGzip))
+	h.s3 = s3Api
+	assert.Nil(t, h.Generate(context.Background(), nil))
+
+	// The report, then the manifest pointing to it, are written
+	assert.Len(t, server.objects, 2)
+	var manifest Manifest
+	assert.Nil(t, json.Unmarshal(server.objects["/reports/"+ManifestKey("svc", "orders")], &manifest))
+	assert.Equal(t, FormatNDJSON, manifest.Format)
+	for key, body := range server.objects {
+		if strings.HasSuffix(key, "latest.json") {
+			continue
+		}
+		assert.Equal(t, "/reports/"+manifest.Key, key)
+		assert.Equal(t, int64(len(body)), manifest.Size)
+		sum := sha256.Sum256(body)
+		assert.Equal(t, hex.EncodeToString(sum[:]), manifest.Checksum)
+		assert.True(t, strings.HasSuffix(key, ".ndjson.gz"))
+		assert.Equal(t, "application/x-ndjson", server.headers[key].Get("Content-Type"))
+		assert.Equal(t, "gzip", server.headers[key].Get("Content-Encoding"))
+
+		data, err := decompress(key, body)
+		assert.Nil(t, err)
+		var decoded []order
+		assert.Nil(t, decodeReport(key, data, &decoded))
+		assert.Len(t, decoded, 1000)
+		assert.Equal(t, "999", decoded[999].ID)
+	}
+
+	// A failed report isn't uploaded
+	server.objects = map[string][]byte{}
+	h.rows = func(ctx context.Context, w ReportWriter) error {
+		if err := w.Write(order{ID: "a"}); err != nil {
+			return err
+		}
+		return fmt.Errorf("boom")
+	}
+	assert.EqualError(t, h.Generate(context.Background(), nil), "failed to generate report: boom")
+	assert.Empty(t, server.objects)
+
+	// Reports returned whole are still supported
+	h = NewHandler(service, "orders", func(ctx context.Context) (interface{}, error) {
+		return orders(), nil
+	}, WithFormat(FormatCSV))
+	h.s3 = s3Api
+	assert.Nil(t, h.Generate(context.Background(), nil))
+	assert.Len(t, server.objects, 2)
+	for key, body := range server.objects {
+		if strings.HasSuffix(key, "latest.json") {
+			continue
+		}
+		assert.True(t, strings.HasSuffix(key, ".csv"))
+		assert.Equal(t, "text/csv", server.headers[key].Get("Content-Type"))
+		assert.Equal(t, "", server.headers[key].Get("Content-Encoding"))
+		assert.True(t, strings.HasPrefix(string(body), "id,amount,pair,created\n"))
+	}
+}
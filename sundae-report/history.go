@@ -0,0 +1,217 @@
+package sundaereport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reportTimeLayout is the layout of the time in the file name of a report
+const reportTimeLayout = "2006-01-02-15:04:05"
+
+// Version is one generated report
+type Version struct {
+	Key  string    `json:"key"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// ReportTime is the time the report at key was generated, or false if key
+// isn't a report
+func ReportTime(key string) (time.Time, bool) {
+	name := path.Base(key)
+	if len(name) <= len(reportTimeLayout) || name[len(reportTimeLayout)] != '.' {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(reportTimeLayout, name[:len(reportTimeLayout)])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func reportPrefix(serviceName, reportName string) string {
+	return fmt.Sprintf("%v/%v/", serviceName, reportName)
+}
+
+func hourPrefix(serviceName, reportName string, timestamp time.Time) string {
+	return fmt.Sprintf("%v%v/%v/", reportPrefix(serviceName, reportName), timestamp.Format("2006-01-02"), timestamp.Format("15"))
+}
+
+// asOfBound sorts after every key of a report generated at or before timestamp,
+// whatever its extension, and before those generated after it
+func asOfBound(serviceName, reportName string, timestamp time.Time) string {
+	return hourPrefix(serviceName, reportName, timestamp) + timestamp.Format(reportTimeLayout) + "/"
+}
+
+// ListVersions lists the versions of a report generated between from and to,
+// inclusive, oldest first
+func ListVersions(ctx context.Context, storage Storage, serviceName, reportName string, from, to time.Time) ([]Version, error) {
+	from, to = from.UTC(), to.UTC()
+	bound := asOfBound(serviceName, reportName, to)
+	var versions []Version
+	// keys sort by the time they were generated, so start at from's hour
+	err := storage.List(ctx, reportPrefix(serviceName, reportName), hourPrefix(serviceName, reportName, from), func(obj Object) bool {
+		if obj.Key > bound {
+			return false
+		}
+		if t, ok := ReportTime(obj.Key); ok && !t.Before(from) {
+			versions = append(versions, Version{Key: obj.Key, Time: t, Size: obj.Size})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions of %v: %w", reportName, err)
+	}
+	return versions, nil
+}
+
+// KeyAsOf returns the key of the version of a report in effect at timestamp:
+// the last one generated at or before it, however long before
+func KeyAsOf(ctx context.Context, storage Storage, serviceName, reportName string, timestamp time.Time) (string, error) {
+	timestamp = timestamp.UTC()
+	bound := asOfBound(serviceName, reportName, timestamp)
+
+	// Reports are usually generated every hour, so look in timestamp's hour
+	// before listing the days there are reports for
+	key, err := lastKeyBefore(ctx, storage, hourPrefix(serviceName, reportName, timestamp), bound)
+	if err != nil || key != "" {
+		return key, err
+	}
+
+	days, err := storage.Dirs(ctx, reportPrefix(serviceName, reportName))
+	if err != nil {
+		return "", fmt.Errorf("failed to list days of %v: %w", reportName, err)
+	}
+	for i := len(days) - 1; i >= 0; i-- {
+		if days[i] > bound {
+			continue
+		}
+		key, err := lastKeyBefore(ctx, storage, days[i], bound)
+		if err != nil || key != "" {
+			return key, err
+		}
+	}
+	return "", fmt.Errorf("no %v report at or before %v", reportName, timestamp.Format(time.RFC3339))
+}
+
+// lastKeyBefore returns the last report key under prefix before bound, or ""
+func lastKeyBefore(ctx context.Context, storage Storage, prefix, bound string) (string, error) {
+	var last string
+	err := storage.List(ctx, prefix, "", func(obj Object) bool {
+		if obj.Key > bound {
+			return false
+		}
+		if _, ok := ReportTime(obj.Key); ok {
+			last = obj.Key
+		}
+		return true
+	})
+	return last, err
+}
+
+// Read reads the report at key, decompressed
+func Read(ctx context.Context, storage Storage, key string) ([]byte, error) {
+	body, err := storage.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get report %v: %w", key, err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report %v: %w", key, err)
+	}
+	return decompress(key, data)
+}
+
+// ReadAsOf reads the version of a report in effect at timestamp,
+// decompressed, and returns it with its key
+func ReadAsOf(ctx context.Context, storage Storage, serviceName, reportName string, timestamp time.Time) ([]byte, string, error) {
+	key, err := KeyAsOf(ctx, storage, serviceName, reportName, timestamp)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := Read(ctx, storage, key)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, key, nil
+}
+
+// RetentionPolicy decides which versions of a report to keep: the last one
+// of each hour younger than Hourly, and the last one of each day younger than
+// Daily. Older versions, and the others, are deleted; the latest version is
+// always kept.
+type RetentionPolicy struct {
+	Hourly time.Duration
+	Daily  time.Duration
+}
+
+// DefaultRetention keeps hourly versions for a week, and daily ones for a year
+var DefaultRetention = RetentionPolicy{
+	Hourly: 7 * 24 * time.Hour,
+	Daily:  365 * 24 * time.Hour,
+}
+
+// Expired returns the versions p doesn't keep at now
+func (p RetentionPolicy) Expired(versions []Version, now time.Time) []Version {
+	sorted := append([]Version(nil), versions...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key > sorted[j].Key })
+
+	var expired []Version
+	kept := map[string]bool{}
+	for i, v := range sorted {
+		age := now.Sub(v.Time)
+		var period string
+		switch {
+		case age < p.Hourly:
+			period = "hour " + v.Time.Truncate(time.Hour).Format(time.RFC3339)
+		case age < p.Daily:
+			period = "day " + v.Time.Format("2006-01-02")
+		}
+		if i > 0 && (period == "" || kept[period]) {
+			expired = append(expired, v)
+			continue
+		}
+		kept[period] = true
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].Key < expired[j].Key })
+	return expired
+}
+
+// ApplyRetention deletes the versions of a report policy doesn't keep at now,
+// and returns them
+func ApplyRetention(ctx context.Context, storage Storage, serviceName, reportName string, policy RetentionPolicy, now time.Time) ([]Version, error) {
+	versions, err := ListVersions(ctx, storage, serviceName, reportName, time.Time{}, now)
+	if err != nil {
+		return nil, err
+	}
+	expired := policy.Expired(versions, now)
+	keys := make([]string, 0, len(expired))
+	for _, v := range expired {
+		keys = append(keys, v.Key)
+	}
+	if err := storage.Delete(ctx, keys); err != nil {
+		return nil, fmt.Errorf("failed to delete expired versions of %v: %w", reportName, err)
+	}
+	return expired, nil
+}
+
+// parseVersion parses a version given on the command line: a report key, an
+// RFC 3339 time, or a duration before now such as 24h
+func parseVersion(s string, now time.Time) (key string, t time.Time, err error) {
+	if _, ok := ReportTime(s); ok && strings.Contains(s, "/") {
+		return s, time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return "", t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return "", now.Add(-d), nil
+	}
+	return "", time.Time{}, fmt.Errorf("invalid version %q: expected a report key, an RFC 3339 time or a duration such as 24h", s)
+}
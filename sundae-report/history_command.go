@@ -0,0 +1,195 @@
+package sundaereport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/urfave/cli/v2"
+)
+
+// HistoryCommand builds the report-history command, for sundaecli.CommandApp,
+// which browses the versions of h's report in its storage:
+//
+//	report-history list [--from version] [--to version] [--json]
+//	report-history get <version>
+//	report-history diff <from> [<to>] [--json]
+//	report-history retain [--hourly d] [--daily d]
+//
+// A version is a report key, an RFC 3339 time, or a duration before now, so
+// "diff 24h" is what changed since yesterday. retain deletes the versions the
+// policy doesn't keep, or with --dry only lists them.
+func HistoryCommand(h *Handler) *cli.Command {
+	return &cli.Command{
+		Name:  "report-history",
+		Usage: "list, read, diff and expire the versions of the report",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "list the versions generated in a time range",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "from", Usage: "the first version to list", Value: "24h"},
+					&cli.StringFlag{Name: "to", Usage: "the last version to list (default: now)"},
+					&cli.BoolFlag{Name: "json", Usage: "print as JSON"},
+				},
+				Action: func(c *cli.Context) error {
+					now := time.Now().UTC()
+					from, err := versionTime(c.String("from"), now)
+					if err != nil {
+						return err
+					}
+					to := now
+					if c.String("to") != "" {
+						if to, err = versionTime(c.String("to"), now); err != nil {
+							return err
+						}
+					}
+					storage, err := h.store()
+					if err != nil {
+						return err
+					}
+					versions, err := ListVersions(c.Context, storage, h.service.Name, h.reportName, from, to)
+					if err != nil {
+						return err
+					}
+					if c.Bool("json") {
+						return writeJSON(c.App.Writer, versions)
+					}
+					for _, v := range versions {
+						fmt.Fprintf(c.App.Writer, "%v\t%v\t%v\n", v.Time.Format(time.RFC3339), v.Size, v.Key)
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "get",
+				Usage:     "print the version in effect at a time",
+				ArgsUsage: "<version>",
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return fmt.Errorf("expected one version")
+					}
+					data, _, err := h.getVersion(c.Context, c.Args().First(), time.Now().UTC())
+					if err != nil {
+						return err
+					}
+					var pretty bytes.Buffer
+					if err := json.Indent(&pretty, data, "", "  "); err != nil {
+						// not a JSON report
+						_, err := c.App.Writer.Write(data)
+						return err
+					}
+					pretty.WriteByte('\n')
+					_, err = pretty.WriteTo(c.App.Writer)
+					return err
+				},
+			},
+			{
+				Name:      "diff",
+				Usage:     "print what changed between two versions",
+				ArgsUsage: "<from> [<to>]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "json", Usage: "print as JSON"},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() < 1 || c.NArg() > 2 {
+						return fmt.Errorf("expected one or two versions")
+					}
+					now := time.Now().UTC()
+					from, fromKey, err := h.getVersion(c.Context, c.Args().Get(0), now)
+					if err != nil {
+						return err
+					}
+					version := now.Format(time.RFC3339)
+					if c.NArg() == 2 {
+						version = c.Args().Get(1)
+					}
+					to, toKey, err := h.getVersion(c.Context, version, now)
+					if err != nil {
+						return err
+					}
+					changes, err := Diff(from, to)
+					if err != nil {
+						return err
+					}
+					if c.Bool("json") {
+						return writeJSON(c.App.Writer, changes)
+					}
+					fmt.Fprintf(c.App.Writer, "--- %v\n+++ %v\n", fromKey, toKey)
+					for _, change := range changes {
+						fmt.Fprintln(c.App.Writer, change)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "retain",
+				Usage: "delete the versions the retention policy doesn't keep",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{Name: "hourly", Usage: "how long to keep a version of each hour", Value: DefaultRetention.Hourly},
+					&cli.DurationFlag{Name: "daily", Usage: "how long to keep a version of each day", Value: DefaultRetention.Daily},
+				},
+				Action: func(c *cli.Context) error {
+					storage, err := h.store()
+					if err != nil {
+						return err
+					}
+					policy := RetentionPolicy{Hourly: c.Duration("hourly"), Daily: c.Duration("daily")}
+					now := time.Now().UTC()
+					var expired []Version
+					if sundaecli.CommonOpts.Dry {
+						var versions []Version
+						versions, err = ListVersions(c.Context, storage, h.service.Name, h.reportName, time.Time{}, now)
+						expired = policy.Expired(versions, now)
+					} else {
+						expired, err = ApplyRetention(c.Context, storage, h.service.Name, h.reportName, policy, now)
+					}
+					if err != nil {
+						return err
+					}
+					for _, v := range expired {
+						fmt.Fprintf(c.App.Writer, "%v\texpired\n", v.Key)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// versionTime resolves a version given on the command line to a time
+func versionTime(s string, now time.Time) (time.Time, error) {
+	key, t, err := parseVersion(s, now)
+	if err != nil || key == "" {
+		return t, err
+	}
+	t, _ = ReportTime(key)
+	return t, nil
+}
+
+// getVersion reads a version given on the command line, and returns its key
+func (h *Handler) getVersion(ctx context.Context, s string, now time.Time) ([]byte, string, error) {
+	key, t, err := parseVersion(s, now)
+	if err != nil {
+		return nil, "", err
+	}
+	storage, err := h.store()
+	if err != nil {
+		return nil, "", err
+	}
+	if key == "" {
+		return ReadAsOf(ctx, storage, h.service.Name, h.reportName, t)
+	}
+	data, err := Read(ctx, storage, key)
+	return data, key, err
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,222 @@
+package sundaereport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/tj/assert"
+	"github.com/urfave/cli/v2"
+)
+
+// memS3 is a bucket in memory, listing two keys a page
+type memS3 struct {
+	s3iface.S3API
+	objects map[string][]byte
+}
+
+func (m *memS3) ListObjectsV2WithContext(_ aws.Context, input *s3.ListObjectsV2Input, _ ...request.Option) (*s3.ListObjectsV2Output, error) {
+	var keys []string
+	for key := range m.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	output := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(false)}
+	after := aws.StringValue(input.StartAfter)
+	if input.ContinuationToken != nil {
+		after = aws.StringValue(input.ContinuationToken)
+	}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		prefix := aws.StringValue(input.Prefix)
+		if key <= after || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if len(output.Contents)+len(output.CommonPrefixes) == 2 {
+			output.IsTruncated = aws.Bool(true)
+			break
+		}
+		if d := aws.StringValue(input.Delimiter); d != "" {
+			if i := strings.Index(key[len(prefix):], d); i >= 0 {
+				common := key[:len(prefix)+i+1]
+				if !seen[common] {
+					seen[common] = true
+					output.CommonPrefixes = append(output.CommonPrefixes, &s3.CommonPrefix{Prefix: aws.String(common)})
+				}
+				output.NextContinuationToken = aws.String(common + "\xff")
+				continue
+			}
+		}
+		output.Contents = append(output.Contents, &s3.Object{Key: aws.String(key), Size: aws.Int64(int64(len(m.objects[key])))})
+		output.NextContinuationToken = aws.String(key)
+	}
+	return output, nil
+}
+
+func (m *memS3) GetObjectWithContext(_ aws.Context, input *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	data, ok := m.objects[aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "no such key", nil)
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (m *memS3) DeleteObjectsWithContext(_ aws.Context, input *s3.DeleteObjectsInput, _ ...request.Option) (*s3.DeleteObjectsOutput, error) {
+	for _, obj := range input.Delete.Objects {
+		delete(m.objects, aws.StringValue(obj.Key))
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	api := &memS3{objects: map[string][]byte{
+		"svc/prices/latest.json": []byte(`{}`),
+	}}
+	storage := NewS3Storage(api, "bucket")
+	put := func(t time.Time, report string) string {
+		key := ReportKey("svc", "prices", t)
+		api.objects[key] = []byte(report)
+		return key
+	}
+	now := time.Date(2026, 10, 14, 10, 30, 0, 0, time.UTC)
+	old := put(now.Add(-40*24*time.Hour), `{"ada":0.2}`)
+	yesterday := put(now.Add(-24*time.Hour), `{"ada":0.3,"pairs":["ADA/SUNDAE"]}`)
+	put(now.Add(-23*time.Hour-30*time.Minute), `{"ada":0.31,"pairs":["ADA/SUNDAE"]}`)
+	put(now.Add(-23*time.Hour-20*time.Minute), `{"ada":0.32,"pairs":["ADA/SUNDAE"]}`)
+	latest := put(now.Add(-5*time.Hour), `{"ada":0.35,"pairs":["ADA/SUNDAE","ADA/USDM"],"sundae":1.00}`)
+
+	// The version in effect is found however long before it was generated
+	data, key, err := GetRawAsOf(ctx, api, "bucket", "svc", "prices", now)
+	assert.Nil(t, err)
+	assert.Equal(t, latest, key)
+	assert.Equal(t, api.objects[latest], data)
+	key, err = KeyAsOf(ctx, storage, "svc", "prices", now.Add(-30*24*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, old, key)
+	key, err = KeyAsOf(ctx, storage, "svc", "prices", now.Add(-24*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, yesterday, key)
+	_, err = KeyAsOf(ctx, storage, "svc", "prices", now.Add(-50*24*time.Hour))
+	assert.EqualError(t, err, "no prices report at or before 2026-08-25T10:30:00Z")
+
+	versions, err := ListVersions(ctx, storage, "svc", "prices", now.Add(-24*time.Hour), now.Add(-23*time.Hour-30*time.Minute))
+	assert.Nil(t, err)
+	assert.Len(t, versions, 2)
+	assert.Equal(t, yesterday, versions[0].Key)
+	assert.Equal(t, now.Add(-24*time.Hour), versions[0].Time)
+
+	changes, err := Diff(api.objects[yesterday], api.objects[latest])
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"~ /ada: 0.3 -> 0.35",
+		"+ /pairs/1: \"ADA/USDM\"",
+		"+ /sundae: 1.00",
+	}, changeStrings(changes))
+	changes, err = Diff([]byte(`{"a/b":1.10,"n":[1,{"x":true}]}`), []byte(`{"a/b":1.1,"n":[1]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{`- /n/1: {"x":true}`}, changeStrings(changes))
+
+	// Each hour keeps its last version for 12 hours, then each day for 30 days
+	policy := RetentionPolicy{Hourly: 12 * time.Hour, Daily: 30 * 24 * time.Hour}
+	expired, err := ApplyRetention(ctx, storage, "svc", "prices", policy, now)
+	assert.Nil(t, err)
+	assert.Len(t, expired, 3)
+	assert.Equal(t, old, expired[0].Key)
+	assert.Equal(t, yesterday, expired[1].Key)
+	assert.Len(t, api.objects, 3)
+	assert.Empty(t, policy.Expired([]Version{{Key: old, Time: now.Add(-40 * 24 * time.Hour)}}, now), "the latest version is kept")
+}
+
+func changeStrings(changes []Change) []string {
+	var s []string
+	for _, c := range changes {
+		s = append(s, c.String())
+	}
+	return s
+}
+
+func TestHistoryCommand(t *testing.T) {
+	storage := NewMemoryStorage()
+	now := time.Now().UTC()
+	put(t, storage, ReportKey("svc", "prices", now.Add(-26*time.Hour)), `{"ada":0.3}`)
+	put(t, storage, ReportKeyFor("svc", "prices", now.Add(-time.Hour), FormatNDJSON, CompressionNone), `{"ada":0.35}`+"\n"+`{"ada":0.4}`+"\n")
+
+	h := NewHandler(sundaecli.NewService("svc"), "prices", nil, WithStorage(storage))
+	var out bytes.Buffer
+	app := &cli.App{Writer: &out, Commands: []*cli.Command{HistoryCommand(h)}}
+
+	assert.Nil(t, app.Run([]string{"app", "report-history", "diff", "24h"}))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Equal(t, `~ (root): {"ada":0.3} -> [{"ada":0.35},{"ada":0.4}]`, lines[2])
+
+	out.Reset()
+	assert.Nil(t, app.Run([]string{"app", "report-history", "list", "--from", "48h"}))
+	assert.Len(t, strings.Split(strings.TrimSpace(out.String()), "\n"), 2)
+
+	assert.NotNil(t, app.Run([]string{"app", "report-history", "get", "yesterday"}))
+
+	defer func() { sundaecli.CommonOpts.Dry = false }()
+	sundaecli.CommonOpts.Dry = true
+	out.Reset()
+	assert.Nil(t, app.Run([]string{"app", "report-history", "retain", "--hourly", "2h", "--daily", "24h"}))
+	assert.Contains(t, out.String(), "expired")
+	assert.Len(t, storage.Keys(), 2)
+}
+
+func put(t *testing.T, storage Storage, key, report string) {
+	assert.Nil(t, storage.Put(context.Background(), key, strings.NewReader(report), ObjectMeta{}))
+}
+
+func TestManifest(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	now := time.Now().UTC()
+	listed := ReportKey("svc", "prices", now.Add(-time.Hour))
+	put(t, storage, listed, `{"ada":0.3}`)
+
+	// Without a manifest, the latest version is found by listing
+	_, err := GetManifest(ctx, storage, "svc", "prices")
+	assert.True(t, errors.Is(err, ErrNoManifest))
+	var report map[string]float64
+	key, err := DecodeLatest(ctx, storage, "svc", "prices", &report)
+	assert.Nil(t, err)
+	assert.Equal(t, listed, key)
+
+	// With one, the version it points to is read
+	pointed := ReportKeyFor("svc", "prices", now.Add(-30*time.Minute), FormatJSON, CompressionGzip)
+	var gzipped bytes.Buffer
+	assert.Nil(t, writeValue(&gzipped, map[string]float64{"ada": 0.35}, FormatJSON, CompressionGzip))
+	put(t, storage, pointed, gzipped.String())
+	m := Manifest{Key: pointed, Generated: now.Add(-30 * time.Minute), Format: FormatJSON, Compression: CompressionGzip, SchemaVersion: 2}
+	assert.Nil(t, putManifest(ctx, storage, "svc", "prices", m))
+	key, err = DecodeLatest(ctx, storage, "svc", "prices", &report)
+	assert.Nil(t, err)
+	assert.Equal(t, pointed, key)
+	assert.Equal(t, 0.35, report["ada"])
+
+	// An overlapping run that finishes late doesn't move the manifest back
+	assert.Nil(t, putManifest(ctx, storage, "svc", "prices", Manifest{Key: listed, Generated: now.Add(-time.Hour)}))
+	got, err := GetManifest(ctx, storage, "svc", "prices")
+	assert.Nil(t, err)
+	assert.Equal(t, m, got)
+
+	// A manifest pointing to a missing version falls back to listing
+	assert.Nil(t, storage.Delete(ctx, []string{pointed}))
+	key, err = DecodeLatest(ctx, storage, "svc", "prices", &report)
+	assert.Nil(t, err)
+	assert.Equal(t, listed, key)
+}
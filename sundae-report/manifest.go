@@ -0,0 +1,109 @@
+package sundaereport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrNoManifest is returned, wrapped, for a report without a manifest, e.g.
+// one last generated before manifests were written
+var ErrNoManifest = errors.New("report has no manifest")
+
+// Manifest points to the latest version of a report, so it can be read
+// without listing the bucket
+type Manifest struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	// Checksum is the hex SHA-256 of the object, as stored
+	Checksum    string      `json:"checksum"`
+	Generated   time.Time   `json:"generated"`
+	Format      Format      `json:"format"`
+	Compression Compression `json:"compression,omitempty"`
+	// SchemaVersion is the version of the report's layout, set with
+	// WithSchemaVersion, so consumers can tell a report they can't read
+	SchemaVersion int `json:"schemaVersion"`
+}
+
+// ManifestKey is the key of the manifest of a report
+func ManifestKey(serviceName, reportName string) string {
+	return reportPrefix(serviceName, reportName) + "latest.json"
+}
+
+// GetManifest reads the manifest of a report, or returns an error wrapping
+// ErrNoManifest if it has none
+func GetManifest(ctx context.Context, storage Storage, serviceName, reportName string) (Manifest, error) {
+	key := ManifestKey(serviceName, reportName)
+	body, err := storage.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return Manifest{}, fmt.Errorf("%v: %w", key, ErrNoManifest)
+	}
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to get manifest %v: %w", key, err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to read manifest %v: %w", key, err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Manifest{}, fmt.Errorf("failed to unmarshal manifest %v: %w", key, err)
+	}
+	return m, nil
+}
+
+// putManifest points the manifest of a report at m. Put replaces the
+// manifest whole, so readers see either the old version or m; a manifest
+// already pointing at a newer version, from a run that overlapped this one,
+// is left as is.
+func putManifest(ctx context.Context, storage Storage, serviceName, reportName string, m Manifest) error {
+	current, err := GetManifest(ctx, storage, serviceName, reportName)
+	if err == nil && current.Generated.After(m.Generated) {
+		return nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	key := ManifestKey(serviceName, reportName)
+	err = storage.Put(ctx, key, bytes.NewReader(data), ObjectMeta{
+		ContentType:  "application/json",
+		CacheControl: "no-cache",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put manifest %v: %w", key, err)
+	}
+	return nil
+}
+
+// ReadLatest reads the latest version of a report, decompressed, and returns
+// it with its key. The manifest is read first; reports without one are found
+// by listing.
+func ReadLatest(ctx context.Context, storage Storage, serviceName, reportName string) ([]byte, string, error) {
+	m, err := GetManifest(ctx, storage, serviceName, reportName)
+	if err == nil {
+		data, err := Read(ctx, storage, m.Key)
+		if err == nil {
+			return data, m.Key, nil
+		}
+	}
+	return ReadAsOf(ctx, storage, serviceName, reportName, time.Now().UTC())
+}
+
+// DecodeLatest decodes the latest version of a report into obj, and returns
+// its key
+func DecodeLatest(ctx context.Context, storage Storage, serviceName, reportName string, obj any) (string, error) {
+	data, key, err := ReadLatest(ctx, storage, serviceName, reportName)
+	if err != nil {
+		return "", err
+	}
+	if err := decodeReport(key, data, obj); err != nil {
+		return "", err
+	}
+	return key, nil
+}
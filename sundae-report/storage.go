@@ -0,0 +1,380 @@
+package sundaereport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// ErrNotFound is returned, wrapped, for an object a Storage doesn't have
+var ErrNotFound = errors.New("report object not found")
+
+// Object is an object in a Storage
+type Object struct {
+	Key  string
+	Size int64
+}
+
+// ObjectMeta is how an object is served
+type ObjectMeta struct {
+	ContentType     string
+	ContentEncoding string
+	CacheControl    string
+}
+
+// Storage keeps the objects of reports, by key: the versions laid out by
+// ReportKey, and their manifests
+type Storage interface {
+	// Put writes body to key, replacing any object there. If reading body
+	// fails, nothing is written.
+	Put(ctx context.Context, key string, body io.Reader, meta ObjectMeta) error
+	// Get opens the object at key, or returns an error wrapping ErrNotFound
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// List calls fn with the objects whose keys start with prefix and sort
+	// after startAfter, in key order, until fn returns false
+	List(ctx context.Context, prefix, startAfter string, fn func(Object) bool) error
+	// Dirs returns the sorted prefixes, each ending in /, of the keys under
+	// prefix that have a / after it
+	Dirs(ctx context.Context, prefix string) ([]string, error)
+	// Delete deletes the objects at keys, if there are any
+	Delete(ctx context.Context, keys []string) error
+}
+
+// NewStorage builds the storage in spec, the --report-storage flag:
+//
+//	s3          the bucket, through s3Api
+//	file:<dir>  a local directory, laid out like the bucket
+func NewStorage(s3Api s3iface.S3API, spec, bucket string) (Storage, error) {
+	switch {
+	case spec == "" || spec == "s3":
+		if bucket == "" {
+			return nil, fmt.Errorf("no bucket to keep reports in: set --bucket")
+		}
+		return NewS3Storage(s3Api, bucket), nil
+	case strings.HasPrefix(spec, "file:"):
+		return FileStorage(strings.TrimPrefix(spec, "file:")), nil
+	}
+	return nil, fmt.Errorf("unknown report storage %q: expected s3 or file:<dir>", spec)
+}
+
+// S3Storage keeps reports in an S3 bucket
+type S3Storage struct {
+	api    s3iface.S3API
+	bucket string
+}
+
+func NewS3Storage(api s3iface.S3API, bucket string) *S3Storage {
+	return &S3Storage{api: api, bucket: bucket}
+}
+
+// Put uploads body in parts, so only the part being uploaded is held in memory
+func (s *S3Storage) Put(ctx context.Context, key string, body io.Reader, meta ObjectMeta) error {
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	}
+	if meta.ContentType != "" {
+		input.ContentType = aws.String(meta.ContentType)
+	}
+	if meta.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(meta.ContentEncoding)
+	}
+	if meta.CacheControl != "" {
+		input.CacheControl = aws.String(meta.CacheControl)
+	}
+	if _, err := s3manager.NewUploaderWithClient(s.api).UploadWithContext(ctx, input); err != nil {
+		return fmt.Errorf("failed to upload %v: %w", key, err)
+	}
+	return nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	output, err := s.api.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, fmt.Errorf("%v: %w", key, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get object %v: %w", key, err)
+	}
+	return output.Body, nil
+}
+
+func (s *S3Storage) List(ctx context.Context, prefix, startAfter string, fn func(Object) bool) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}
+	if startAfter != "" {
+		input.StartAfter = aws.String(startAfter)
+	}
+	for {
+		output, err := s.api.ListObjectsV2WithContext(ctx, input)
+		if err != nil {
+			return fmt.Errorf("failed to list objects in %v: %w", prefix, err)
+		}
+		for _, obj := range output.Contents {
+			if !fn(Object{Key: aws.StringValue(obj.Key), Size: aws.Int64Value(obj.Size)}) {
+				return nil
+			}
+		}
+		if !aws.BoolValue(output.IsTruncated) {
+			return nil
+		}
+		input.ContinuationToken = output.NextContinuationToken
+	}
+}
+
+func (s *S3Storage) Dirs(ctx context.Context, prefix string) ([]string, error) {
+	var dirs []string
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}
+	for {
+		output, err := s.api.ListObjectsV2WithContext(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list prefixes in %v: %w", prefix, err)
+		}
+		for _, p := range output.CommonPrefixes {
+			dirs = append(dirs, aws.StringValue(p.Prefix))
+		}
+		if !aws.BoolValue(output.IsTruncated) {
+			return dirs, nil
+		}
+		input.ContinuationToken = output.NextContinuationToken
+	}
+}
+
+func (s *S3Storage) Delete(ctx context.Context, keys []string) error {
+	const batchSize = 1000 // the most DeleteObjects takes
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		var objects []*s3.ObjectIdentifier
+		for _, key := range keys[start:end] {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+		output, err := s.api.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete objects: %w", err)
+		}
+		if len(output.Errors) > 0 {
+			e := output.Errors[0]
+			return fmt.Errorf("failed to delete %v objects, e.g. %v: %v", len(output.Errors), aws.StringValue(e.Key), aws.StringValue(e.Message))
+		}
+	}
+	return nil
+}
+
+// FileStorage keeps reports in a local directory, each at the path of its
+// key, so the reports of a bucket can be synced to it and read offline. The
+// ObjectMeta isn't kept.
+type FileStorage string
+
+func (s FileStorage) path(key string) string {
+	return filepath.Join(string(s), filepath.FromSlash(key))
+}
+
+// Put writes body to a temporary file, renamed into place once complete, so
+// readers never see part of an object
+func (s FileStorage) Put(_ context.Context, key string, body io.Reader, _ ObjectMeta) error {
+	filename := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(filename), ".tmp-"+filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %v: %w", key, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+func (s FileStorage) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%v: %w", key, ErrNotFound)
+	}
+	return f, err
+}
+
+func (s FileStorage) List(_ context.Context, prefix, startAfter string, fn func(Object) bool) error {
+	// walk from the deepest directory every key with prefix is in
+	dir := prefix[:strings.LastIndex(prefix, "/")+1]
+	var objects []Object
+	err := filepath.WalkDir(s.path(dir), func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return err
+		}
+		rel, err := filepath.Rel(string(s), p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) || key <= startAfter {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Key: key, Size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list objects in %v: %w", prefix, err)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	for _, obj := range objects {
+		if !fn(obj) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s FileStorage) Dirs(_ context.Context, prefix string) ([]string, error) {
+	dir := prefix[:strings.LastIndex(prefix, "/")+1]
+	entries, err := os.ReadDir(s.path(dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list prefixes in %v: %w", prefix, err)
+	}
+	var dirs []string
+	for _, e := range entries {
+		if name := dir + e.Name() + "/"; e.IsDir() && strings.HasPrefix(name, prefix) {
+			dirs = append(dirs, name)
+		}
+	}
+	return dirs, nil
+}
+
+func (s FileStorage) Delete(_ context.Context, keys []string) error {
+	for _, key := range keys {
+		if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// MemoryStorage keeps reports in memory, for tests
+type MemoryStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{objects: map[string][]byte{}}
+}
+
+func (s *MemoryStorage) Put(_ context.Context, key string, body io.Reader, _ ObjectMeta) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("failed to write %v: %w", key, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return nil
+}
+
+func (s *MemoryStorage) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%v: %w", key, ErrNotFound)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *MemoryStorage) List(_ context.Context, prefix, startAfter string, fn func(Object) bool) error {
+	for _, key := range s.Keys() {
+		if !strings.HasPrefix(key, prefix) || key <= startAfter {
+			continue
+		}
+		s.mu.Lock()
+		obj := Object{Key: key, Size: int64(len(s.objects[key]))}
+		s.mu.Unlock()
+		if !fn(obj) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) Dirs(_ context.Context, prefix string) ([]string, error) {
+	var dirs []string
+	for _, key := range s.Keys() {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		i := strings.Index(key[len(prefix):], "/")
+		if i < 0 {
+			continue
+		}
+		if dir := key[:len(prefix)+i+1]; len(dirs) == 0 || dirs[len(dirs)-1] != dir {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs, nil
+}
+
+func (s *MemoryStorage) Delete(_ context.Context, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		delete(s.objects, key)
+	}
+	return nil
+}
+
+// Keys returns the keys of every object, sorted
+func (s *MemoryStorage) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,106 @@
+package sundaereport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/tj/assert"
+)
+
+func TestStorage(t *testing.T) {
+	for name, storage := range map[string]Storage{
+		"memory": NewMemoryStorage(),
+		"file":   FileStorage(t.TempDir()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, key := range []string{"svc/a/2026-10-14/10/x.json", "svc/a/2026-10-14/11/y.json", "svc/a/2026-10-15/00/z.json", "svc/a/latest.json", "svc/b/2026-10-14/10/x.json"} {
+				put(t, storage, key, key)
+			}
+
+			body, err := storage.Get(ctx, "svc/a/latest.json")
+			assert.Nil(t, err)
+			data, err := io.ReadAll(body)
+			assert.Nil(t, err)
+			assert.Nil(t, body.Close())
+			assert.Equal(t, "svc/a/latest.json", string(data))
+			_, err = storage.Get(ctx, "svc/a/none.json")
+			assert.True(t, errors.Is(err, ErrNotFound))
+
+			var keys []string
+			assert.Nil(t, storage.List(ctx, "svc/a/", "svc/a/2026-10-14/10/", func(obj Object) bool {
+				keys = append(keys, obj.Key)
+				assert.Equal(t, int64(len(obj.Key)), obj.Size)
+				return len(keys) < 2
+			}))
+			assert.Equal(t, []string{"svc/a/2026-10-14/10/x.json", "svc/a/2026-10-14/11/y.json"}, keys)
+			assert.Nil(t, storage.List(ctx, "svc/c/", "", func(Object) bool {
+				t.Fatal("svc/c/ is empty")
+				return false
+			}))
+
+			dirs, err := storage.Dirs(ctx, "svc/a/")
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"svc/a/2026-10-14/", "svc/a/2026-10-15/"}, dirs)
+
+			// A failed write leaves nothing behind
+			err = storage.Put(ctx, "svc/a/failed.json", io.MultiReader(strings.NewReader("part"), failingReader{}), ObjectMeta{})
+			assert.NotNil(t, err)
+			_, err = storage.Get(ctx, "svc/a/failed.json")
+			assert.True(t, errors.Is(err, ErrNotFound))
+
+			assert.Nil(t, storage.Delete(ctx, []string{"svc/a/latest.json", "svc/a/none.json"}))
+			_, err = storage.Get(ctx, "svc/a/latest.json")
+			assert.True(t, errors.Is(err, ErrNotFound))
+		})
+	}
+
+	_, err := NewStorage(nil, "s3", "")
+	assert.EqualError(t, err, "no bucket to keep reports in: set --bucket")
+	_, err = NewStorage(nil, "gcs", "reports")
+	assert.EqualError(t, err, `unknown report storage "gcs": expected s3 or file:<dir>`)
+	storage, err := NewStorage(nil, "file:/tmp/reports", "")
+	assert.Nil(t, err)
+	assert.Equal(t, FileStorage("/tmp/reports"), storage)
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, fmt.Errorf("boom")
+}
+
+func TestGenerateOffline(t *testing.T) {
+	ctx := context.Background()
+	storage := FileStorage(t.TempDir())
+	type price struct {
+		Asset string  `json:"asset"`
+		Price float64 `json:"price"`
+	}
+	h := NewHandler(sundaecli.NewService("svc"), "prices", func(ctx context.Context) (interface{}, error) {
+		return []price{{Asset: "ADA", Price: 0.35}}, nil
+	}, WithStorage(storage), WithCompression(CompressionZstd), WithSchemaVersion(3))
+	assert.Nil(t, h.Generate(ctx, nil))
+
+	// What's generated offline reads back as it would from S3
+	var prices []price
+	key, err := DecodeLatest(ctx, storage, "svc", "prices", &prices)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(key, ".json.zst"))
+	assert.Equal(t, []price{{Asset: "ADA", Price: 0.35}}, prices)
+
+	m, err := GetManifest(ctx, storage, "svc", "prices")
+	assert.Nil(t, err)
+	assert.Equal(t, key, m.Key)
+	assert.Equal(t, 3, m.SchemaVersion)
+
+	_, asOf, err := ReadAsOf(ctx, storage, "svc", "prices", time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, key, asOf)
+}
@@ -2,12 +2,13 @@
 package sundaerest
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
 
 	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
-	"github.com/aws/aws-lambda-go/lambda"
+	sundaetrace "github.com/SundaeSwap-finance/sundae-go-utils/sundae-trace"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
@@ -17,6 +18,7 @@ import (
 
 func Middlewares(service sundaecli.Service, routes chi.Router) chi.Router {
 	routes.Use(
+		sundaetrace.Middleware,
 		withEmbedPolicyHeaders,
 		withCORS(),
 		withLogger(sundaecli.Logger(service)),
@@ -28,14 +30,11 @@ func Middlewares(service sundaecli.Service, routes chi.Router) chi.Router {
 func Webserver(service sundaecli.Service, routes chi.Router) error {
 	logger := sundaecli.Logger(service)
 
-	if sundaecli.CommonOpts.Console {
+	return sundaecli.Run(context.Background(), apigateway.Wrap(routes, sundaecli.CommonOpts.Env), func(ctx context.Context) error {
 		logger.Info().Int("port", sundaecli.CommonOpts.Port).Msg("starting http server")
 		addr := fmt.Sprintf(":%v", sundaecli.CommonOpts.Port)
-		return http.ListenAndServe(addr, routes)
-	}
-
-	lambda.Start(apigateway.Wrap(routes, sundaecli.CommonOpts.Env))
-	return nil
+		return sundaecli.ListenAndServe(ctx, addr, routes)
+	})
 }
 
 func CacheControl(handler http.HandlerFunc, maxAge int) http.HandlerFunc {
@@ -0,0 +1,222 @@
+package sundaesecret
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// The version stages Secrets Manager moves through a rotation
+const (
+	StageCurrent  = "AWSCURRENT"
+	StagePending  = "AWSPENDING"
+	StagePrevious = "AWSPREVIOUS"
+)
+
+// ErrNotFound is returned, wrapped, for a secret or stage a backend doesn't have
+var ErrNotFound = errors.New("secret not found")
+
+// Secret is one version of a secret
+type Secret struct {
+	Name  string
+	Value []byte
+	// VersionID identifies the version, where the backend has versions
+	VersionID string
+	// Stages are the version stages the version is in, e.g. AWSCURRENT
+	Stages []string
+}
+
+// Backend fetches secrets from where they are kept
+type Backend interface {
+	// Fetch returns the version of secret name in stage, StageCurrent if
+	// empty, or an error wrapping ErrNotFound
+	Fetch(ctx context.Context, name, stage string) (Secret, error)
+}
+
+// SecretsManagerBackend fetches secrets from AWS Secrets Manager
+type SecretsManagerBackend struct {
+	api secretsmanageriface.SecretsManagerAPI
+}
+
+func NewSecretsManagerBackend(api secretsmanageriface.SecretsManagerAPI) *SecretsManagerBackend {
+	return &SecretsManagerBackend{api: api}
+}
+
+func (b *SecretsManagerBackend) Fetch(ctx context.Context, name, stage string) (Secret, error) {
+	if stage == "" {
+		stage = StageCurrent
+	}
+	out, err := b.api.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(name),
+		VersionStage: aws.String(stage),
+	})
+	if err != nil {
+		if isAWSCode(err, secretsmanager.ErrCodeResourceNotFoundException) {
+			return Secret{}, fmt.Errorf("%v %v: %w", name, stage, ErrNotFound)
+		}
+		return Secret{}, fmt.Errorf("unable to fetch secret %v: %w", name, err)
+	}
+	value := out.SecretBinary
+	if len(value) == 0 {
+		value = []byte(aws.StringValue(out.SecretString))
+	}
+	return Secret{
+		Name:      name,
+		Value:     value,
+		VersionID: aws.StringValue(out.VersionId),
+		Stages:    aws.StringValueSlice(out.VersionStages),
+	}, nil
+}
+
+// ParameterStoreBackend fetches SecureString and String parameters from SSM
+// Parameter Store. A stage other than StageCurrent is read as a parameter
+// label, so rotations can label the versions they move through.
+type ParameterStoreBackend struct {
+	api ssmiface.SSMAPI
+}
+
+func NewParameterStoreBackend(api ssmiface.SSMAPI) *ParameterStoreBackend {
+	return &ParameterStoreBackend{api: api}
+}
+
+func (b *ParameterStoreBackend) Fetch(ctx context.Context, name, stage string) (Secret, error) {
+	selector := name
+	if stage != "" && stage != StageCurrent {
+		selector += ":" + stage
+	}
+	out, err := b.api.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(selector),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		if isAWSCode(err, ssm.ErrCodeParameterNotFound) || isAWSCode(err, ssm.ErrCodeParameterVersionNotFound) {
+			return Secret{}, fmt.Errorf("%v: %w", selector, ErrNotFound)
+		}
+		return Secret{}, fmt.Errorf("unable to fetch parameter %v: %w", selector, err)
+	}
+	stages := []string{StageCurrent}
+	if stage != "" && stage != StageCurrent {
+		stages = []string{stage}
+	}
+	return Secret{
+		Name:      name,
+		Value:     []byte(aws.StringValue(out.Parameter.Value)),
+		VersionID: strconv.FormatInt(aws.Int64Value(out.Parameter.Version), 10),
+		Stages:    stages,
+	}, nil
+}
+
+// EnvBackend reads secrets from environment variables, named by upper casing
+// the secret name and replacing everything but letters and digits with _,
+// after Prefix: prod/db is PROD_DB. It only has the current stage.
+type EnvBackend struct {
+	Prefix string
+}
+
+// EnvName is the environment variable holding secret name
+func (b EnvBackend) EnvName(name string) string {
+	return b.Prefix + strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+}
+
+func (b EnvBackend) Fetch(_ context.Context, name, stage string) (Secret, error) {
+	key := b.EnvName(name)
+	value, ok := os.LookupEnv(key)
+	if !ok || (stage != "" && stage != StageCurrent) {
+		return Secret{}, fmt.Errorf("%v (%v): %w", name, key, ErrNotFound)
+	}
+	return Secret{Name: name, Value: []byte(value), Stages: []string{StageCurrent}}, nil
+}
+
+// FileBackend reads secrets from a local JSON file, an object keyed by secret
+// name. A string value is the secret itself; any other value is the secret's
+// JSON. It's read on every fetch, so edits are picked up as the cache expires.
+// It only has the current stage.
+type FileBackend string
+
+func (b FileBackend) Fetch(_ context.Context, name, stage string) (Secret, error) {
+	data, err := os.ReadFile(string(b))
+	if err != nil {
+		return Secret{}, fmt.Errorf("unable to read secrets file %v: %w", string(b), err)
+	}
+	var secrets map[string]json.RawMessage
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return Secret{}, fmt.Errorf("unable to decode secrets file %v: %w", string(b), err)
+	}
+	raw, ok := secrets[name]
+	if !ok || (stage != "" && stage != StageCurrent) {
+		return Secret{}, fmt.Errorf("%v: %w", name, ErrNotFound)
+	}
+	value := []byte(raw)
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		value = []byte(s)
+	}
+	return Secret{Name: name, Value: value, Stages: []string{StageCurrent}}, nil
+}
+
+// Chain fetches each secret from the first backend that has it
+type Chain []Backend
+
+func (c Chain) Fetch(ctx context.Context, name, stage string) (Secret, error) {
+	for _, b := range c {
+		secret, err := b.Fetch(ctx, name, stage)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		return secret, err
+	}
+	return Secret{}, fmt.Errorf("%v: %w", name, ErrNotFound)
+}
+
+// NewBackend builds the backends in spec, a comma separated list, in the
+// order they are tried, of
+//
+//	secretsmanager  AWS Secrets Manager
+//	ssm             SSM Parameter Store
+//	env             environment variables
+//	file:<path>     a local JSON file
+func NewBackend(s *session.Session, spec string) (Backend, error) {
+	var chain Chain
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "secretsmanager":
+			chain = append(chain, NewSecretsManagerBackend(secretsmanager.New(s)))
+		case name == "ssm":
+			chain = append(chain, NewParameterStoreBackend(ssm.New(s)))
+		case name == "env":
+			chain = append(chain, EnvBackend{})
+		case strings.HasPrefix(name, "file:"):
+			chain = append(chain, FileBackend(strings.TrimPrefix(name, "file:")))
+		default:
+			return nil, fmt.Errorf("unknown secret backend %q: expected secretsmanager, ssm, env or file:<path>", name)
+		}
+	}
+	if len(chain) == 1 {
+		return chain[0], nil
+	}
+	return chain, nil
+}
+
+func isAWSCode(err error, code string) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == code
+}
@@ -0,0 +1,21 @@
+package sundaesecret
+
+import (
+	"time"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/urfave/cli/v2"
+)
+
+var SecretOpts struct {
+	Backend string
+	TTL     time.Duration
+}
+
+var BackendFlag = sundaecli.StringFlag("secret-backend", "where to fetch secrets, in order: secretsmanager, ssm, env or file:<path>, comma separated", &SecretOpts.Backend, "secretsmanager")
+var TTLFlag = sundaecli.DurationFlag("secret-ttl", "how long to cache a secret before fetching it again", &SecretOpts.TTL, DefaultTTL)
+
+var SecretFlags = []cli.Flag{
+	BackendFlag,
+	TTLFlag,
+}
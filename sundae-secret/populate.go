@@ -0,0 +1,139 @@
+package sundaesecret
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Populate sets the fields of the struct v points to from their secret tags:
+//
+//	Password string `secret:"prod/db#password"`    the password of the JSON secret prod/db
+//	APIKey   string `secret:"prod/api-key"`        the whole secret
+//	Token    string `secret:"prod/token,optional"` left as is if there's no such secret
+//
+// string and []byte fields take the value as is; other fields decode it from
+// JSON, or from the JSON inside a string, so a port kept as "5432" fills an
+// int. Struct fields without a tag are populated in turn. Each secret is
+// fetched once, through the cache.
+func (p *Provider) Populate(ctx context.Context, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("unable to populate %T: expected a pointer to a struct", v)
+	}
+	return p.populate(ctx, rv.Elem())
+}
+
+func (p *Provider) populate(ctx context.Context, rv reflect.Value) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fv := rv.Field(i)
+		tag, ok := field.Tag.Lookup("secret")
+		if !ok {
+			switch {
+			case fv.Kind() == reflect.Struct:
+				if err := p.populate(ctx, fv); err != nil {
+					return err
+				}
+			case fv.Kind() == reflect.Ptr && !fv.IsNil() && fv.Elem().Kind() == reflect.Struct:
+				if err := p.populate(ctx, fv.Elem()); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		ref, optional, err := parseSecretTag(tag)
+		if err != nil {
+			return fmt.Errorf("%v.%v: %w", rt, field.Name, err)
+		}
+		name, key, _ := strings.Cut(ref, "#")
+		secret, err := p.Get(ctx, name)
+		if err != nil {
+			if optional && errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return fmt.Errorf("unable to populate %v.%v: %w", rt, field.Name, err)
+		}
+		value := json.RawMessage(secret.Value)
+		quoted := false
+		if key != "" {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(secret.Value, &fields); err != nil {
+				return fmt.Errorf("unable to populate %v.%v: secret %v is not a JSON object", rt, field.Name, name)
+			}
+			raw, ok := fields[key]
+			if !ok {
+				if optional {
+					continue
+				}
+				return fmt.Errorf("unable to populate %v.%v: secret %v has no %v: %w", rt, field.Name, name, key, ErrNotFound)
+			}
+			value, quoted = raw, true
+		}
+		if err := setField(fv, value, quoted); err != nil {
+			return fmt.Errorf("unable to populate %v.%v from %v: %w", rt, field.Name, ref, err)
+		}
+	}
+	return nil
+}
+
+func parseSecretTag(tag string) (ref string, optional bool, err error) {
+	parts := strings.Split(tag, ",")
+	ref = parts[0]
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return "", false, fmt.Errorf("invalid secret tag %q", tag)
+	}
+	for _, opt := range parts[1:] {
+		switch opt {
+		case "optional":
+			optional = true
+		default:
+			return "", false, fmt.Errorf("unknown option %q in secret tag %q", opt, tag)
+		}
+	}
+	return ref, optional, nil
+}
+
+// setField sets fv from value: raw bytes, or, if quoted, the JSON of one
+// field of a secret
+func setField(fv reflect.Value, value json.RawMessage, quoted bool) error {
+	var s string
+	isString := quoted && json.Unmarshal(value, &s) == nil
+	if !quoted {
+		s, isString = string(value), true
+	}
+
+	switch {
+	case fv.Kind() == reflect.String:
+		if !isString {
+			s = string(value)
+		}
+		fv.SetString(s)
+		return nil
+	case fv.Type() == reflect.TypeOf([]byte(nil)):
+		if !isString {
+			s = string(value)
+		}
+		fv.SetBytes([]byte(s))
+		return nil
+	}
+
+	target := reflect.New(fv.Type())
+	err := json.Unmarshal(value, target.Interface())
+	if err != nil && isString {
+		err = json.Unmarshal([]byte(s), target.Interface())
+	}
+	if err != nil {
+		return err
+	}
+	fv.Set(target.Elem())
+	return nil
+}
@@ -0,0 +1,205 @@
+package sundaesecret
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultTTL is how long a secret is cached before it's fetched again
+const DefaultTTL = 5 * time.Minute
+
+// ChangeFunc is called with the new version of a secret that changed
+type ChangeFunc func(secret Secret)
+
+// Provider caches the secrets of a Backend. A cached secret is fetched again
+// once its TTL has passed; if that fails, the cached version is used until a
+// fetch succeeds, so a Secrets Manager outage doesn't take the service down.
+type Provider struct {
+	backend Backend
+	ttl     time.Duration
+	logger  zerolog.Logger
+	now     func() time.Time
+
+	mu        sync.Mutex
+	entries   map[entryKey]*entry
+	callbacks map[string][]ChangeFunc
+}
+
+type entryKey struct {
+	name  string
+	stage string
+}
+
+type entry struct {
+	secret  Secret
+	fetched time.Time
+	// fetching serialises the fetches of the entry
+	fetching sync.Mutex
+}
+
+type ProviderOption func(*Provider)
+
+// WithTTL sets how long secrets are cached
+func WithTTL(d time.Duration) ProviderOption {
+	return func(p *Provider) {
+		p.ttl = d
+	}
+}
+
+// WithLogger reports fetches that fail while a cached version is in use
+func WithLogger(logger zerolog.Logger) ProviderOption {
+	return func(p *Provider) {
+		p.logger = logger
+	}
+}
+
+func withClock(now func() time.Time) ProviderOption {
+	return func(p *Provider) {
+		p.now = now
+	}
+}
+
+func NewProvider(backend Backend, opts ...ProviderOption) *Provider {
+	p := &Provider{
+		backend:   backend,
+		ttl:       DefaultTTL,
+		logger:    zerolog.Nop(),
+		now:       time.Now,
+		entries:   map[entryKey]*entry{},
+		callbacks: map[string][]ChangeFunc{},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Get returns the current version of secret name
+func (p *Provider) Get(ctx context.Context, name string) (Secret, error) {
+	return p.GetStage(ctx, name, StageCurrent)
+}
+
+// GetStage returns the version of secret name in stage
+func (p *Provider) GetStage(ctx context.Context, name, stage string) (Secret, error) {
+	return p.get(ctx, name, stage, false)
+}
+
+// Refresh fetches secret name again, ignoring the cache; call it when the
+// secret is rejected, in case it was rotated
+func (p *Provider) Refresh(ctx context.Context, name string) (Secret, error) {
+	return p.get(ctx, name, StageCurrent, true)
+}
+
+// OnChange calls fn whenever a fetch of secret name finds a new current
+// version. fn is called by the goroutine making the fetch.
+func (p *Provider) OnChange(name string, fn ChangeFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.callbacks[name] = append(p.callbacks[name], fn)
+}
+
+func (p *Provider) get(ctx context.Context, name, stage string, force bool) (Secret, error) {
+	if stage == "" {
+		stage = StageCurrent
+	}
+	key := entryKey{name: name, stage: stage}
+	p.mu.Lock()
+	e, ok := p.entries[key]
+	if !ok {
+		e = &entry{}
+		p.entries[key] = e
+	}
+	p.mu.Unlock()
+
+	e.fetching.Lock()
+	defer e.fetching.Unlock()
+
+	cached := !e.fetched.IsZero()
+	if cached && !force && p.now().Sub(e.fetched) < p.ttl {
+		return e.secret, nil
+	}
+	secret, err := p.backend.Fetch(ctx, name, stage)
+	if err != nil {
+		if cached && !force && !errors.Is(err, ErrNotFound) {
+			p.logger.Warn().Err(err).Str("secret", name).Msg("unable to refresh secret; using the cached version")
+			return e.secret, nil
+		}
+		return Secret{}, err
+	}
+
+	changed := cached && !sameVersion(e.secret, secret)
+	e.secret, e.fetched = secret, p.now()
+	if changed && stage == StageCurrent {
+		p.mu.Lock()
+		callbacks := append([]ChangeFunc(nil), p.callbacks[name]...)
+		p.mu.Unlock()
+		for _, fn := range callbacks {
+			fn(secret)
+		}
+	}
+	return secret, nil
+}
+
+func sameVersion(a, b Secret) bool {
+	if a.VersionID != "" || b.VersionID != "" {
+		return a.VersionID == b.VersionID
+	}
+	return bytes.Equal(a.Value, b.Value)
+}
+
+// Candidates returns the distinct versions of secret name a client may have
+// to try during a rotation, most likely first: the current version, then the
+// pending one, then the previous one
+func (p *Provider) Candidates(ctx context.Context, name string) ([]Secret, error) {
+	var candidates []Secret
+	seen := map[string]bool{}
+	for _, stage := range []string{StageCurrent, StagePending, StagePrevious} {
+		secret, err := p.GetStage(ctx, name, stage)
+		if errors.Is(err, ErrNotFound) && stage != StageCurrent {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		id := secret.VersionID
+		if id == "" {
+			id = string(secret.Value)
+		}
+		if !seen[id] {
+			seen[id] = true
+			candidates = append(candidates, secret)
+		}
+	}
+	return candidates, nil
+}
+
+// Decode decodes the current version of secret name into v: a *string or
+// *[]byte takes it as is, anything else is decoded from JSON
+func (p *Provider) Decode(ctx context.Context, name string, v interface{}) error {
+	secret, err := p.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	return decodeValue(name, secret.Value, v)
+}
+
+func decodeValue(name string, data []byte, v interface{}) error {
+	switch value := v.(type) {
+	case *string:
+		*value = string(data)
+	case *[]byte:
+		*value = append([]byte(nil), data...)
+	default:
+		if err := json.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("unable to decode secret %v: %w", name, err)
+		}
+	}
+	return nil
+}
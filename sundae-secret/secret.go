@@ -3,22 +3,56 @@
 package sundaesecret
 
 import (
+	"context"
 	"fmt"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/secretsmanager"
-	"github.com/savaki/secrets"
 )
 
+var providers struct {
+	sync.Mutex
+	bySession map[*session.Session]*Provider
+}
+
+// SecretProvider returns the Provider configured by SecretFlags, Secrets
+// Manager by default, shared by every caller with the same session so each
+// secret is fetched once per TTL rather than once per call
+func SecretProvider(s *session.Session) (*Provider, error) {
+	providers.Lock()
+	defer providers.Unlock()
+	if p, ok := providers.bySession[s]; ok {
+		return p, nil
+	}
+
+	spec := SecretOpts.Backend
+	if spec == "" {
+		spec = "secretsmanager"
+	}
+	backend, err := NewBackend(s, spec)
+	if err != nil {
+		return nil, err
+	}
+	ttl := SecretOpts.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	p := NewProvider(backend, WithTTL(ttl))
+	if providers.bySession == nil {
+		providers.bySession = map[*session.Session]*Provider{}
+	}
+	providers.bySession[s] = p
+	return p, nil
+}
+
 func LoadSecret(s *session.Session, secretName string, data interface{}) error {
-	api := secrets.WithSecretsManager(secretsmanager.New(s))
-	manager, err := secrets.NewManager(api)
+	p, err := SecretProvider(s)
 	if err != nil {
 		return fmt.Errorf("failed to initialize secrets: %w", err)
 	}
 
-	if err := manager.Decode(secretName, &data); err != nil {
-		return fmt.Errorf("failed to load secret %v: %v", secretName, err)
+	if err := p.Decode(context.Background(), secretName, data); err != nil {
+		return fmt.Errorf("failed to load secret %v: %w", secretName, err)
 	}
 	return nil
 }
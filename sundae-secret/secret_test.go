@@ -0,0 +1,138 @@
+package sundaesecret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/tj/assert"
+)
+
+// fakeSecretsManager holds one secret's versions, by stage
+type fakeSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	stages map[string]string
+	calls  int
+	err    error
+}
+
+func (f *fakeSecretsManager) GetSecretValueWithContext(_ aws.Context, input *secretsmanager.GetSecretValueInput, _ ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	value, ok := f.stages[aws.StringValue(input.VersionStage)]
+	if !ok || aws.StringValue(input.SecretId) != "prod/db" {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "not found", nil)
+	}
+	return &secretsmanager.GetSecretValueOutput{
+		SecretString:  aws.String(value),
+		VersionId:     aws.String(fmt.Sprintf("v-%v", len(value))),
+		VersionStages: aws.StringSlice([]string{aws.StringValue(input.VersionStage)}),
+	}, nil
+}
+
+type dbConfig struct {
+	User     string `secret:"prod/db#user"`
+	Password []byte `secret:"prod/db#password"`
+	Port     int    `secret:"prod/db#port"`
+}
+
+type appConfig struct {
+	DB     dbConfig
+	Raw    string            `secret:"prod/db"`
+	Fields map[string]string `secret:"prod/db"`
+	Token  string            `secret:"prod/token,optional"`
+	Region string            `secret:"prod/db#region,optional"`
+}
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+	api := &fakeSecretsManager{stages: map[string]string{
+		StageCurrent: `{"user":"app","password":"hunter2","port":"5432"}`,
+	}}
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	p := NewProvider(NewSecretsManagerBackend(api), WithTTL(time.Minute), withClock(func() time.Time { return now }))
+
+	var changes []string
+	p.OnChange("prod/db", func(secret Secret) { changes = append(changes, string(secret.Value)) })
+
+	var config appConfig
+	assert.Nil(t, p.Populate(ctx, &config))
+	assert.Equal(t, dbConfig{User: "app", Password: []byte("hunter2"), Port: 5432}, config.DB)
+	assert.Equal(t, api.stages[StageCurrent], config.Raw)
+	assert.Equal(t, "5432", config.Fields["port"])
+	assert.Equal(t, "", config.Token)
+	assert.Equal(t, 2, api.calls, "prod/db is fetched once, and the optional prod/token once")
+
+	// The cached version is used until the TTL passes, and through failures
+	// after it
+	api.stages[StageCurrent] = `{"user":"app","password":"rotated!"}`
+	_, err := p.Get(ctx, "prod/db")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, api.calls)
+	now = now.Add(2 * time.Minute)
+	api.err = fmt.Errorf("throttled")
+	secret, err := p.Get(ctx, "prod/db")
+	assert.Nil(t, err)
+	assert.Contains(t, string(secret.Value), "hunter2")
+	assert.Empty(t, changes)
+
+	api.err = nil
+	secret, err = p.Refresh(ctx, "prod/db")
+	assert.Nil(t, err)
+	assert.Contains(t, string(secret.Value), "rotated!")
+	assert.Equal(t, []string{api.stages[StageCurrent]}, changes)
+
+	// During a rotation, the pending and previous versions are candidates too
+	api.stages[StagePending] = `{"user":"app","password":"next"}`
+	candidates, err := p.Candidates(ctx, "prod/db")
+	assert.Nil(t, err)
+	assert.Len(t, candidates, 2)
+	assert.Equal(t, []string{StagePending}, candidates[1].Stages)
+
+	var s string
+	assert.Nil(t, p.Decode(ctx, "prod/db", &s))
+	assert.Equal(t, api.stages[StageCurrent], s)
+
+	var missing struct {
+		Password string `secret:"prod/other#password"`
+	}
+	assert.True(t, errors.Is(p.Populate(ctx, &missing), ErrNotFound))
+	assert.NotNil(t, p.Populate(ctx, &struct {
+		X string `secret:"#x"`
+	}{}))
+}
+
+func TestBackends(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"prod/api-key":"abc","prod/db":{"user":"app"}}`), 0o600))
+	t.Setenv("TEST_PROD_API_KEY", "from-env")
+
+	backend := Chain{EnvBackend{Prefix: "TEST_"}, FileBackend(path)}
+	secret, err := backend.Fetch(ctx, "prod/api-key", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "from-env", string(secret.Value))
+
+	secret, err = backend.Fetch(ctx, "prod/db", "")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"user":"app"}`, string(secret.Value))
+
+	_, err = backend.Fetch(ctx, "prod/db", StagePrevious)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = backend.Fetch(ctx, "prod/none", "")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	_, err = NewBackend(nil, "env,vault")
+	assert.EqualError(t, err, `unknown secret backend "vault": expected secretsmanager, ssm, env or file:<path>`)
+}
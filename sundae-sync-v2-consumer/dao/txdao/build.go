@@ -8,6 +8,10 @@ import (
 	"github.com/rs/zerolog"
 )
 
+func init() {
+	sundaecli.RegisterTable("txdao", TableName, sundaecli.WithTableModel(Tx{}))
+}
+
 func Build(api dynamodbiface.DynamoDBAPI) *DAO {
 	return New(api, TableName(sundaecli.CommonOpts.Env), zerolog.New(os.Stdout), sundaecli.CommonOpts.Dry)
 }
@@ -0,0 +1,66 @@
+package txdao
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ddb/memddb"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/rs/zerolog"
+	"github.com/savaki/ddb"
+	"github.com/tj/assert"
+)
+
+func TestDAO(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		api       = memddb.New()
+		tableName = TableName("test")
+		dao       = New(api, tableName, zerolog.Nop(), false)
+	)
+	err := ddb.New(api).MustTable(tableName, Tx{}).CreateTableIfNotExists(ctx)
+	assert.Nil(t, err)
+
+	put := func(hash string, successful bool) {
+		_, err := api.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(tableName),
+			Item: map[string]*dynamodb.AttributeValue{
+				"pk":             {S: aws.String("tx:" + hash)},
+				"sk":             {S: aws.String("tx")},
+				"successful":     {BOOL: aws.Bool(successful)},
+				"utxos":          {L: []*dynamodb.AttributeValue{{M: assetItem("policy_id", "output_coin", "policy", "token", "42")}}},
+				"collateral_out": {M: map[string]*dynamodb.AttributeValue{"address": {S: aws.String("collateral")}, "coin": {N: aws.String("5")}}},
+			},
+		})
+		assert.Nil(t, err)
+	}
+	put("ok", true)
+	put("failed", false)
+
+	utxo, err := dao.GetOutput(ctx, "ok", 0)
+	assert.Nil(t, err)
+	assertUTxO(t, utxo, "policy", "token", "42")
+
+	_, err = dao.GetOutput(ctx, "ok", 1)
+	assert.EqualError(t, err, "index too high")
+
+	// Failed transactions only produce their collateral output
+	utxo, err = dao.GetOutput(ctx, "failed", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "collateral", utxo.Address)
+	_, err = dao.GetOutput(ctx, "failed", 0)
+	assert.EqualError(t, err, "index too low")
+
+	// Lookups are cached
+	_, err = api.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key:       map[string]*dynamodb.AttributeValue{"pk": {S: aws.String("tx:ok")}, "sk": {S: aws.String("tx")}},
+	})
+	assert.Nil(t, err)
+	_, err = dao.Get(ctx, "ok")
+	assert.Nil(t, err)
+
+	_, err = dao.Get(ctx, "missing")
+	assert.True(t, ddb.IsItemNotFoundError(err))
+}
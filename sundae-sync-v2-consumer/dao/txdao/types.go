@@ -6,6 +6,7 @@ import (
 
 	"github.com/SundaeSwap-finance/ogmigo/v6/ouroboros/chainsync/num"
 	"github.com/SundaeSwap-finance/ogmigo/v6/ouroboros/shared"
+	sundaeddb "github.com/SundaeSwap-finance/sundae-go-utils/sundae-ddb"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 )
@@ -16,10 +17,9 @@ type Datum struct {
 	Payload      dynamodb.AttributeValue
 }
 
-// CoinValue handles DynamoDB values that might be stored as:
-//   - String (S): plain decimal string
-//   - Number (N): DynamoDB number
-//   - Map (M): serde_dynamo's num serialization {"int": {"S": "..."}}
+// CoinValue is a quantity, which writers have stored as a string (S), a
+// number (N) or serde_dynamo's num map ({"int": {"S": "..."}}). It decodes
+// each shape itself, so it needn't be reached through sundaeddb.Unmarshal.
 type CoinValue struct {
 	Value string
 }
@@ -28,94 +28,58 @@ func (c *CoinValue) UnmarshalDynamoDBAttributeValue(item *dynamodb.AttributeValu
 	if item == nil {
 		return nil
 	}
-	if item.S != nil {
-		c.Value = aws.StringValue(item.S)
-		return nil
-	}
-	if item.N != nil {
-		c.Value = aws.StringValue(item.N)
-		return nil
-	}
 	if item.M != nil {
 		// serde_dynamo serializes Rust numeric types as {"int": {"S": "..."}} or {"int": {"N": "..."}}
-		if intVal, ok := item.M["int"]; ok {
-			if intVal.S != nil {
-				c.Value = aws.StringValue(intVal.S)
-				return nil
-			}
-			if intVal.N != nil {
-				c.Value = aws.StringValue(intVal.N)
-				return nil
-			}
+		item = item.M["int"]
+		if item == nil {
+			return nil
 		}
 	}
+	if item.S != nil {
+		c.Value = aws.StringValue(item.S)
+	} else if item.N != nil {
+		c.Value = aws.StringValue(item.N)
+	}
 	return nil
 }
 
+// Asset and Policy accept both the current Rust writer format (serde default,
+// snake_case: "output_coin", "policy_id") and the legacy camelCase format
+// ("outputCoin", "policyId") that coexists in older records on long-lived
+// environments like mainnet. Without the aliases, mainnet's pre-rename records
+// unmarshal as empty OutputCoin and panic in Value(). Both decode with
+// sundaeddb.Unmarshal, so the aliases apply however they're reached, plain
+// dynamodbattribute included.
 type Asset struct {
-	Name       string    // base64 encoded token name
-	OutputCoin CoinValue // quantity; primary key "output_coin", legacy key "outputCoin"
+	Name       string    `dynamodbav:"name"`                                                 // base64 encoded token name
+	OutputCoin CoinValue `dynamodbav:"output_coin" ddbalias:"outputCoin" ddbformat:"number"` // quantity
 }
 
-// UnmarshalDynamoDBAttributeValue accepts both the current Rust writer format
-// (serde default, snake_case: "output_coin") and the legacy camelCase format
-// ("outputCoin") that coexists in older records on long-lived environments
-// like mainnet. Without this shim, mainnet's pre-rename records unmarshal as
-// empty OutputCoin and panic in Value().
+// asset is Asset without its unmarshaller
+type asset Asset
+
 func (a *Asset) UnmarshalDynamoDBAttributeValue(item *dynamodb.AttributeValue) error {
-	if item == nil || item.M == nil {
-		return nil
-	}
-	if n, ok := item.M["name"]; ok && n.S != nil {
-		a.Name = *n.S
-	}
-	key := "output_coin"
-	if _, ok := item.M[key]; !ok {
-		key = "outputCoin"
-	}
-	if v, ok := item.M[key]; ok {
-		if err := a.OutputCoin.UnmarshalDynamoDBAttributeValue(v); err != nil {
-			return err
-		}
-	}
-	return nil
+	return sundaeddb.Unmarshal(item, (*asset)(a))
 }
 
 type Policy struct {
-	PolicyID string  // base64 encoded; primary key "policy_id", legacy key "policyId"
-	Assets   []Asset
+	PolicyID string  `dynamodbav:"policy_id" ddbalias:"policyId"` // base64 encoded
+	Assets   []Asset `dynamodbav:"assets"`
 }
 
-// UnmarshalDynamoDBAttributeValue accepts both "policy_id" (current snake_case)
-// and "policyId" (legacy camelCase). See Asset.UnmarshalDynamoDBAttributeValue
-// for the motivation.
+// policy is Policy without its unmarshaller
+type policy Policy
+
 func (p *Policy) UnmarshalDynamoDBAttributeValue(item *dynamodb.AttributeValue) error {
-	if item == nil || item.M == nil {
-		return nil
-	}
-	key := "policy_id"
-	if _, ok := item.M[key]; !ok {
-		key = "policyId"
-	}
-	if v, ok := item.M[key]; ok && v.S != nil {
-		p.PolicyID = *v.S
-	}
-	if assets, ok := item.M["assets"]; ok && assets.L != nil {
-		p.Assets = make([]Asset, 0, len(assets.L))
-		for _, el := range assets.L {
-			var a Asset
-			if err := a.UnmarshalDynamoDBAttributeValue(el); err != nil {
-				return err
-			}
-			p.Assets = append(p.Assets, a)
-		}
-	}
-	return nil
+	return sundaeddb.Unmarshal(item, (*policy)(p))
 }
 
-// DatumField handles two DynamoDB formats for the datum field:
+// DatumField holds the datum, which writers have stored in two formats:
 //   - Legacy: a plain base64 string of the CBOR bytes
 //   - Current: a Map with "originalCbor" (base64), "hash", and "payload" keys
+//
+// It decodes both itself, so it needn't be reached through
+// sundaeddb.Unmarshal.
 type DatumField struct {
 	B64 string // base64-encoded datum CBOR, populated from either format
 }
@@ -132,16 +96,25 @@ func (d *DatumField) UnmarshalDynamoDBAttributeValue(item *dynamodb.AttributeVal
 		if oc, ok := item.M["originalCbor"]; ok && oc.S != nil {
 			d.B64 = *oc.S
 		}
-		return nil
 	}
 	return nil
 }
 
 type UTxO struct {
-	Address string     `dynamodbav:"address"`         // base64 encoded
-	Coin    string     `dynamodbav:"coin"`            // lovelace
+	Address string     `dynamodbav:"address"` // base64 encoded
+	Coin    string     `dynamodbav:"coin"`    // lovelace
 	Assets  []Policy   `dynamodbav:"assets"`
-	Datum   DatumField `dynamodbav:"datum,omitempty"`
+	Datum   DatumField `dynamodbav:"datum,omitempty" ddbformat:"unwrap=originalCbor"`
+}
+
+// utxo is UTxO without its unmarshaller
+type utxo UTxO
+
+// UnmarshalDynamoDBAttributeValue decodes with sundaeddb.Unmarshal, so the
+// ddbalias and ddbformat tags of everything in a UTxO apply however it's
+// reached, e.g. through Tx
+func (u *UTxO) UnmarshalDynamoDBAttributeValue(item *dynamodb.AttributeValue) error {
+	return sundaeddb.Unmarshal(item, (*utxo)(u))
 }
 
 // DatumCBOR returns the decoded datum CBOR bytes, or nil if not present.
@@ -42,7 +42,7 @@ func assetItem(policyKey, coinKey, policyID, name, coin string) map[string]*dyna
 				policyKey: {S: aws.String(policyID)},
 				"assets": {L: []*dynamodb.AttributeValue{
 					{M: map[string]*dynamodb.AttributeValue{
-						"name": {S: aws.String(name)},
+						"name":  {S: aws.String(name)},
 						coinKey: {S: aws.String(coin)},
 					}},
 				}},
@@ -69,3 +69,64 @@ func assertUTxO(t *testing.T, utxo UTxO, wantPolicy, wantName, wantCoin string)
 		t.Errorf("OutputCoin = %q, want %q", utxo.Assets[0].Assets[0].OutputCoin.Value, wantCoin)
 	}
 }
+
+// TestUnmarshal_CoinShapes covers quantities stored as a number and as
+// serde_dynamo's num map, alongside the plain string above.
+func TestUnmarshal_CoinShapes(t *testing.T) {
+	for name, coin := range map[string]*dynamodb.AttributeValue{
+		"number":     {N: aws.String("42")},
+		"serde int":  {M: map[string]*dynamodb.AttributeValue{"int": {S: aws.String("42")}}},
+		"serde intN": {M: map[string]*dynamodb.AttributeValue{"int": {N: aws.String("42")}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			item := assetItem("policy_id", "output_coin", "policy-bytes", "token-bytes", "")
+			item["assets"].L[0].M["assets"].L[0].M["output_coin"] = coin
+			assertUTxO(t, decodeUTxO(t, item), "policy-bytes", "token-bytes", "42")
+		})
+	}
+}
+
+// TestUnmarshal_Datum covers the legacy bare base64 datum and the current map
+// holding it as originalCbor.
+func TestUnmarshal_Datum(t *testing.T) {
+	for name, datum := range map[string]*dynamodb.AttributeValue{
+		"legacy": {S: aws.String("2Hmf")},
+		"current": {M: map[string]*dynamodb.AttributeValue{
+			"hash":         {S: aws.String("aGFzaA==")},
+			"originalCbor": {S: aws.String("2Hmf")},
+		}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			item := assetItem("policy_id", "output_coin", "policy-bytes", "token-bytes", "42")
+			item["datum"] = datum
+			utxo := decodeUTxO(t, item)
+			if utxo.Datum.B64 != "2Hmf" {
+				t.Errorf("Datum = %q, want %q", utxo.Datum.B64, "2Hmf")
+			}
+		})
+	}
+}
+
+// TestUnmarshal_Standalone decodes the exported types on their own, with
+// plain dynamodbattribute, rather than through a UTxO.
+func TestUnmarshal_Standalone(t *testing.T) {
+	for _, keys := range [][2]string{{"policy_id", "output_coin"}, {"policyId", "outputCoin"}} {
+		item := assetItem(keys[0], keys[1], "policy-bytes", "token-bytes", "")
+		item["assets"].L[0].M["assets"].L[0].M[keys[1]] = &dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{"int": {N: aws.String("42")}}}
+
+		var policy Policy
+		if err := dynamodbattribute.Unmarshal(item["assets"].L[0], &policy); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		assertUTxO(t, UTxO{Assets: []Policy{policy}}, "policy-bytes", "token-bytes", "42")
+	}
+
+	var coin CoinValue
+	if err := dynamodbattribute.Unmarshal(&dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{"int": {S: aws.String("7")}}}, &coin); err != nil || coin.Value != "7" {
+		t.Errorf("CoinValue = %q (%v), want %q", coin.Value, err, "7")
+	}
+	var datum DatumField
+	if err := dynamodbattribute.Unmarshal(&dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{"originalCbor": {S: aws.String("2Hmf")}}}, &datum); err != nil || datum.B64 != "2Hmf" {
+		t.Errorf("Datum = %q (%v), want %q", datum.B64, err, "2Hmf")
+	}
+}
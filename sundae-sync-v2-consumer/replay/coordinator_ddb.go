@@ -24,6 +24,7 @@ import (
 	"sync"
 	"time"
 
+	sundaeddb "github.com/SundaeSwap-finance/sundae-go-utils/sundae-ddb"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
@@ -399,7 +400,8 @@ func (c *DDBCoordinator) GlobalWatermark(ctx context.Context) (uint64, error) {
 	}
 
 	// Walk chunks in order until we find the first not-done. Cheap to start
-	// with BatchGetItem in groups of 100.
+	// with BatchGetItem in groups of 100; unprocessed keys are retried, so a
+	// throttled read can't look like a missing chunk.
 	const batchSize = 100
 	var watermark uint64
 	for base := uint64(0); base < total; base += batchSize {
@@ -413,17 +415,13 @@ func (c *DDBCoordinator) GlobalWatermark(ctx context.Context) (uint64, error) {
 				"pk": {S: aws.String(strconv.FormatUint(i, 10))},
 			})
 		}
-		out, err := c.api.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{
-			RequestItems: map[string]*dynamodb.KeysAndAttributes{
-				c.claimsTable: {Keys: keys},
-			},
-		})
+		items, err := sundaeddb.BatchGetItems(ctx, c.api, c.claimsTable, keys)
 		if err != nil {
 			return watermark, fmt.Errorf("watermark batch get: %w", err)
 		}
 		// Index by chunk id so we can iterate in order.
-		got := make(map[uint64]map[string]*dynamodb.AttributeValue, len(out.Responses[c.claimsTable]))
-		for _, item := range out.Responses[c.claimsTable] {
+		got := make(map[uint64]map[string]*dynamodb.AttributeValue, len(items))
+		for _, item := range items {
 			if pk := item["pk"]; pk != nil && pk.S != nil {
 				idx, _ := strconv.ParseUint(*pk.S, 10, 64)
 				got[idx] = item
@@ -504,15 +502,11 @@ func (c *DDBCoordinator) lookupChunkIdx(ctx context.Context, start uint64) (uint
 				"pk": {S: aws.String(strconv.FormatUint(i, 10))},
 			})
 		}
-		out, err := c.api.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{
-			RequestItems: map[string]*dynamodb.KeysAndAttributes{
-				c.claimsTable: {Keys: keys},
-			},
-		})
+		items, err := sundaeddb.BatchGetItems(ctx, c.api, c.claimsTable, keys)
 		if err != nil {
 			return 0, fmt.Errorf("lookup chunk idx scan: %w", err)
 		}
-		for _, item := range out.Responses[c.claimsTable] {
+		for _, item := range items {
 			startVal := item["start"]
 			if startVal == nil || startVal.N == nil {
 				continue
@@ -0,0 +1,142 @@
+package replay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ddb/memddb"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/rs/zerolog"
+)
+
+// setupDDBCoordinators seeds an in-memory claims table with three chunks,
+// [100, 200), [200, 300) and [300, 350), and returns two workers sharing it.
+func setupDDBCoordinators(t *testing.T) (*memddb.DB, *DDBCoordinator, *DDBCoordinator) {
+	t.Helper()
+	api := memddb.New()
+	cfg := SetupConfig{Prefix: "test", StartHeight: 100, EndHeight: 350, ChunkSize: 100}
+	if err := SetupDDB(context.Background(), api, cfg); err != nil {
+		t.Fatalf("SetupDDB: %v", err)
+	}
+	a := NewDDBCoordinator(api, "test", "a", time.Minute, zerolog.Nop())
+	b := NewDDBCoordinator(api, "test", "b", time.Minute, zerolog.Nop())
+	return api, a, b
+}
+
+func claim(t *testing.T, c *DDBCoordinator, wantStart, wantEnd uint64) {
+	t.Helper()
+	start, end, ok, err := c.ClaimChunk(context.Background())
+	if err != nil || !ok {
+		t.Fatalf("ClaimChunk: ok=%v err=%v", ok, err)
+	}
+	if start != wantStart || end != wantEnd {
+		t.Fatalf("got chunk [%d, %d), want [%d, %d)", start, end, wantStart, wantEnd)
+	}
+}
+
+func watermark(t *testing.T, c *DDBCoordinator, want uint64) {
+	t.Helper()
+	got, err := c.GlobalWatermark(context.Background())
+	if err != nil {
+		t.Fatalf("GlobalWatermark: %v", err)
+	}
+	if got != want {
+		t.Errorf("GlobalWatermark = %d, want %d", got, want)
+	}
+}
+
+// TestSetupDDB_Idempotent verifies SetupDDB can be re-run with the same
+// range, and refuses a different chunking of an existing table.
+func TestSetupDDB_Idempotent(t *testing.T) {
+	api, _, _ := setupDDBCoordinators(t)
+	ctx := context.Background()
+
+	if err := SetupDDB(ctx, api, SetupConfig{Prefix: "test", StartHeight: 100, EndHeight: 350, ChunkSize: 100}); err != nil {
+		t.Fatalf("re-run SetupDDB: %v", err)
+	}
+	if err := SetupDDB(ctx, api, SetupConfig{Prefix: "test", StartHeight: 100, EndHeight: 350, ChunkSize: 50}); err == nil {
+		t.Errorf("SetupDDB with a different chunk size should fail")
+	}
+
+	if err := TeardownDDB(ctx, api, "test"); err != nil {
+		t.Fatalf("TeardownDDB: %v", err)
+	}
+	if err := TeardownDDB(ctx, api, "test"); err != nil {
+		t.Errorf("TeardownDDB should ignore missing tables: %v", err)
+	}
+}
+
+// TestDDBCoordinator_Claims verifies workers get distinct fresh chunks, that
+// the watermark only advances over a contiguous done prefix, and that an
+// expired lease is rescued by another worker.
+func TestDDBCoordinator_Claims(t *testing.T) {
+	api, a, b := setupDDBCoordinators(t)
+	ctx := context.Background()
+
+	claim(t, a, 100, 200)
+	claim(t, b, 200, 300)
+	claim(t, a, 300, 350)
+	if _, _, ok, err := b.ClaimChunk(ctx); err != nil || ok {
+		t.Fatalf("ClaimChunk with every chunk leased: ok=%v err=%v (want false, nil)", ok, err)
+	}
+
+	if err := b.Heartbeat(ctx, 200, 300); err != nil {
+		t.Errorf("Heartbeat by holder: %v", err)
+	}
+	if err := a.Heartbeat(ctx, 200, 300); err == nil {
+		t.Errorf("Heartbeat by another worker should fail")
+	}
+
+	watermark(t, a, 0)
+	if err := a.CompleteChunk(ctx, 300, 350); err != nil {
+		t.Fatalf("CompleteChunk: %v", err)
+	}
+	watermark(t, a, 0)
+	if err := a.CompleteChunk(ctx, 100, 200); err != nil {
+		t.Fatalf("CompleteChunk: %v", err)
+	}
+	watermark(t, a, 199)
+
+	// Worker b dies; once its lease lapses, a picks the chunk up
+	_, err := api.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String("test-claims"),
+		Key:                       map[string]*dynamodb.AttributeValue{"pk": {S: aws.String("1")}},
+		UpdateExpression:          aws.String("SET leased_until = :past"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":past": {N: aws.String("1")}},
+	})
+	if err != nil {
+		t.Fatalf("expire lease: %v", err)
+	}
+	claim(t, a, 200, 300)
+	if err := a.CompleteChunk(ctx, 200, 300); err != nil {
+		t.Fatalf("CompleteChunk: %v", err)
+	}
+	watermark(t, b, 349)
+
+	// Throttled reads are retried rather than ending the done prefix early
+	api.DeferBatchItems(2)
+	watermark(t, b, 349)
+
+	if _, _, ok, err := a.ClaimChunk(ctx); err != nil || ok {
+		t.Errorf("ClaimChunk after completion: ok=%v err=%v (want false, nil)", ok, err)
+	}
+}
+
+// TestDDBCoordinator_Txs verifies a tx published by one worker is visible to
+// another.
+func TestDDBCoordinator_Txs(t *testing.T) {
+	_, a, b := setupDDBCoordinators(t)
+	ctx := context.Background()
+
+	if err := a.PublishTx(ctx, "abc", 150); err != nil {
+		t.Fatalf("PublishTx: %v", err)
+	}
+	if h, ok, err := b.FindTx(ctx, "abc"); err != nil || !ok || h != 150 {
+		t.Errorf("FindTx = %d, %v, %v (want 150, true, nil)", h, ok, err)
+	}
+	if _, ok, err := b.FindTx(ctx, "def"); err != nil || ok {
+		t.Errorf("FindTx of unknown tx: ok=%v err=%v (want false, nil)", ok, err)
+	}
+}
@@ -31,6 +31,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	sundaeddb "github.com/SundaeSwap-finance/sundae-go-utils/sundae-ddb"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
@@ -53,6 +54,13 @@ type Config struct {
 	Workers     int    // number of parallel workers (default 64)
 }
 
+// heightRow is a height row as stored in the lookup table.
+type heightRow struct {
+	PK       string `dynamodbav:"pk"` // "height:{height}"
+	Hash     string `dynamodbav:"hash"`
+	Location string `dynamodbav:"location"`
+}
+
 // heightRecord is a row from the lookup table.
 type heightRecord struct {
 	Height   uint64
@@ -245,6 +253,8 @@ func (r *Replayer) processChunk(ctx context.Context, start, end uint64) (uint64,
 
 // produceHeights queries the lookup table for consecutive heights using
 // BatchGetItem (up to 100 per request) and sends them to the work channel.
+// Unprocessed keys and throttling are retried; a height is never skipped
+// because DynamoDB was busy.
 //
 // chunkStart and chunkEnd bound the range. chunkEnd=0 means open-ended
 // (stop after maxConsecutiveMisses heights in a row not found, i.e. chain tip).
@@ -279,35 +289,15 @@ func (r *Replayer) produceHeights(ctx context.Context, work chan<- heightRecord,
 			})
 		}
 
-		found := make(map[uint64]heightRecord)
-		unprocessed := map[string]*dynamodb.KeysAndAttributes{
-			r.config.LookupTable: {Keys: keys},
+		rows, err := sundaeddb.BatchGet[heightRow](ctx, r.api, r.config.LookupTable, keys)
+		if err != nil {
+			return fmt.Errorf("BatchGetItem heights %d-%d: %w", height, batchEnd-1, err)
 		}
-		for len(unprocessed) > 0 {
-			kna, ok := unprocessed[r.config.LookupTable]
-			if !ok || len(kna.Keys) == 0 {
-				break
-			}
-			out, err := r.api.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{
-				RequestItems: unprocessed,
-			})
-			if err != nil {
-				return fmt.Errorf("BatchGetItem heights %d-%d: %w", height, batchEnd-1, err)
-			}
-			for _, item := range out.Responses[r.config.LookupTable] {
-				rec := heightRecord{}
-				if v := item["pk"]; v != nil && v.S != nil {
-					fmt.Sscanf(*v.S, "height:%d", &rec.Height)
-				}
-				if v := item["hash"]; v != nil && v.S != nil {
-					rec.Hash = *v.S
-				}
-				if v := item["location"]; v != nil && v.S != nil {
-					rec.Location = *v.S
-				}
-				found[rec.Height] = rec
-			}
-			unprocessed = out.UnprocessedKeys
+		found := make(map[uint64]heightRecord, len(rows))
+		for _, row := range rows {
+			rec := heightRecord{Hash: row.Hash, Location: row.Location}
+			fmt.Sscanf(row.PK, "height:%d", &rec.Height)
+			found[rec.Height] = rec
 		}
 
 		for h := height; h < batchEnd; h++ {
@@ -7,8 +7,11 @@ import (
 	"fmt"
 	"slices"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 
+	sundaetrace "github.com/SundaeSwap-finance/sundae-go-utils/sundae-trace"
 	"github.com/blinklabs-io/gouroboros/ledger"
 	"github.com/blinklabs-io/gouroboros/ledger/common"
 	"github.com/rs/zerolog"
@@ -30,7 +33,11 @@ type Syncer struct {
 	Downloader Downloader
 	Events     chan Message
 	Group      *errgroup.Group
-	ctx        context.Context // errgroup context; cancelled when sync goroutine exits with error
+	// ContinueOnError keeps the sync goroutine running after a message fails,
+	// reporting the error only on that message's Finished channel, so the
+	// caller can decide whether to retry or skip it
+	ContinueOnError bool
+	ctx             context.Context // errgroup context; cancelled when sync goroutine exits with error
 }
 
 type Block struct {
@@ -51,6 +58,7 @@ func (h *Syncer) SpawnSyncFunc(group *errgroup.Group, ctx context.Context, undoF
 	h.ctx = ctx
 	group.Go(func() (err error) {
 		// For every event we receive
+	events:
 		for event := range h.Events {
 			defer func() {
 				if panicCause := recover(); panicCause != nil {
@@ -59,6 +67,12 @@ func (h *Syncer) SpawnSyncFunc(group *errgroup.Group, ctx context.Context, undoF
 					event.Finished <- err
 				}
 			}()
+			eventCtx, span := sundaetrace.Start(ctx, "syncv2.Message", trace.WithAttributes(
+				attribute.String("cardano.block_hash", hex.EncodeToString(event.Advance.Hash)),
+				attribute.String("cardano.block_index", event.Advance.Index.String()),
+				attribute.Int("syncv2.undo_count", len(event.Undo)),
+			))
+
 			// First apply each undo
 			for _, undo := range event.Undo {
 				// Wait for the contents of the block
@@ -68,7 +82,11 @@ func (h *Syncer) SpawnSyncFunc(group *errgroup.Group, ctx context.Context, undoF
 				block, err := ledger.NewBlockFromCbor(blockType, contents[2:], skipBodyHashCfg)
 				if err != nil {
 					h.Logger.Warn().Str("blockHash", hex.EncodeToString(undo.Hash)).Err(err).Msg("Error decoding block for undo")
+					sundaetrace.End(span, err)
 					event.Finished <- err
+					if h.ContinueOnError {
+						continue events
+					}
 					return err
 				}
 
@@ -77,9 +95,13 @@ func (h *Syncer) SpawnSyncFunc(group *errgroup.Group, ctx context.Context, undoF
 				slices.Reverse(txs)
 				for _, tx := range txs {
 					// And invoke the undo logic
-					if err := undoFunc(ctx, tx, block.SlotNumber()); err != nil {
+					if err := undoFunc(eventCtx, tx, block.SlotNumber()); err != nil {
 						h.Logger.Warn().Str("blockHash", hex.EncodeToString(undo.Hash)).Err(err).Msg("Error executing undo logic for transaction")
+						sundaetrace.End(span, err)
 						event.Finished <- err
+						if h.ContinueOnError {
+							continue events
+						}
 						return err
 					}
 				}
@@ -92,17 +114,30 @@ func (h *Syncer) SpawnSyncFunc(group *errgroup.Group, ctx context.Context, undoF
 			block, err := ledger.NewBlockFromCbor(blockType, contents[2:], skipBodyHashCfg)
 			if err != nil {
 				h.Logger.Warn().Str("blockHash", hex.EncodeToString(event.Advance.Hash)).Err(err).Msg("Error decoding block for advance")
+				sundaetrace.End(span, err)
 				event.Finished <- err
+				if h.ContinueOnError {
+					continue events
+				}
 				return err
 			}
+			span.SetAttributes(
+				attribute.Int64("cardano.slot", int64(block.SlotNumber())),
+				attribute.Int("cardano.tx_count", len(block.Transactions())),
+			)
 			// And apply each transaction in order
 			for index, tx := range block.Transactions() {
-				if err := advanceFunc(ctx, tx, block.SlotNumber(), index); err != nil {
+				if err := advanceFunc(eventCtx, tx, block.SlotNumber(), index); err != nil {
 					h.Logger.Warn().Str("blockHash", hex.EncodeToString(event.Advance.Hash)).Err(err).Msg("Error executing advance logic for transaction")
+					sundaetrace.End(span, err)
 					event.Finished <- err
+					if h.ContinueOnError {
+						continue events
+					}
 					return err
 				}
 			}
+			span.End()
 			event.Finished <- nil
 		}
 		return nil
@@ -8,13 +8,14 @@ package syncV2Consumer
 import (
 	"context"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
@@ -25,6 +26,7 @@ import (
 
 	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
 	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-sync-v2-consumer/dao/txdao"
+	sundaetrace "github.com/SundaeSwap-finance/sundae-go-utils/sundae-trace"
 	"github.com/urfave/cli/v2"
 )
 
@@ -33,11 +35,13 @@ var SyncV2ConsumerOpts struct {
 	Stream      string
 	Account     string
 	Timestamp   cli.Timestamp
+	MaxLag      time.Duration
 }
 
 var TransactionFlag = sundaecli.StringFlag("transaction", "Replay just one transaction", &SyncV2ConsumerOpts.Transaction)
 var StreamFlag = sundaecli.StringFlag("kinesis-stream", "The stream name or arn to connect to", &SyncV2ConsumerOpts.Stream)
 var AccountFlag = sundaecli.StringFlag("aws-account", "The AWS Account number, for interpolating S3 buckets", &SyncV2ConsumerOpts.Account)
+var MaxLagFlag = sundaecli.DurationFlag("max-lag", "report not-ready on /readyz when the consumer falls further behind the stream than this (0 disables)", &SyncV2ConsumerOpts.MaxLag, 0)
 var TsFlag = sundaecli.TimestampFlag("kinesis-timestamp", "2006-01-02 15:04:05", "The timestamp to start syncing from", &SyncV2ConsumerOpts.Timestamp)
 
 var CommonFlags = []cli.Flag{
@@ -45,6 +49,7 @@ var CommonFlags = []cli.Flag{
 	StreamFlag,
 	AccountFlag,
 	TsFlag,
+	MaxLagFlag,
 }
 
 type SyncV2Consumer struct {
@@ -57,7 +62,7 @@ type SyncV2Consumer struct {
 
 func New(advance AdvanceFunc, undo UndoFunc, logger *zerolog.Logger) SyncV2Consumer {
 	var (
-		s   = session.Must(session.NewSession(aws.NewConfig()))
+		s   = sundaetrace.Session(aws.NewConfig())
 		s3  = s3.New(s)
 		db  = dynamodb.New(s)
 		txs = txdao.Build(db)
@@ -80,21 +85,37 @@ func New(advance AdvanceFunc, undo UndoFunc, logger *zerolog.Logger) SyncV2Consu
 }
 
 func (h *SyncV2Consumer) Start(c *cli.Context) error {
-	if !sundaecli.CommonOpts.Console {
+	switch sundaecli.DetectRuntime() {
+	case sundaecli.LambdaRuntime:
 		h.Logger.Info().Msg("Starting lambda handler")
 		return h.StartLambda(c)
-	} else if SyncV2ConsumerOpts.Stream != "" {
-		h.Logger.Info().Msg("Starting kinesis handler")
-		return h.StartKinesis(c)
-	} else if SyncV2ConsumerOpts.Transaction != "" {
-		h.Logger.Info().Msg("Replaying specific transaction")
-		return h.RunOne(c)
-	} else {
-		return fmt.Errorf("Must run as a lambda, or specify --steam or --utxorpc-url")
+	case sundaecli.ReplayRuntime:
+		h.Logger.Info().Msg("Replaying fixture events through lambda handler")
+		return h.StartLambda(c)
 	}
+
+	return sundaecli.DefaultLifecycle().Run(c.Context, func(ctx context.Context) error {
+		if SyncV2ConsumerOpts.Stream != "" {
+			h.Logger.Info().Msg("Starting kinesis handler")
+			return h.startKinesis(ctx, c.App)
+		} else if SyncV2ConsumerOpts.Transaction != "" {
+			h.Logger.Info().Msg("Replaying specific transaction")
+			return h.runOne(ctx)
+		} else {
+			return fmt.Errorf("Must run as a lambda, or specify --steam or --utxorpc-url")
+		}
+	})
 }
 
 func (h *SyncV2Consumer) StartLambda(c *cli.Context) error {
+	service := sundaecli.Service{Name: c.App.Name, Version: c.App.Version}
+	metrics, err := sundaecli.BuildMetrics(service)
+	if err != nil {
+		return err
+	}
+	if _, err := sundaecli.BuildFeatures(service, metrics); err != nil {
+		return err
+	}
 
 	eventStream := make(chan Message)
 	group, ctx := errgroup.WithContext(c.Context)
@@ -105,24 +126,49 @@ func (h *SyncV2Consumer) StartLambda(c *cli.Context) error {
 		Env:     sundaecli.CommonOpts.Env,
 		Account: SyncV2ConsumerOpts.Account,
 	}
+	policy := sundaecli.DefaultBatchPolicy(batchSource)
+	policy.Service = service
+	batch := sundaecli.NewBatchProcessor(policy, h.Logger, metrics)
 	syncer := Syncer{
 		Logger:     h.Logger,
 		Downloader: &downloader,
 		Events:     eventStream,
 		Group:      group,
+		// With partial batch responses or skipping, a failed message must
+		// not stop later invocations from being synced
+		ContinueOnError: batch.Policy().ReportItemFailures || batch.Policy().Quarantines(),
 	}
 
 	syncer.SpawnSyncFunc(group, ctx, h.Undo, h.Advance)
 
-	lambda.Start(func(_ context.Context, event events.KinesisEvent) error {
-		for _, r := range event.Records {
-			if err := <-syncer.HandleOne(r.Kinesis.Data); err != nil {
-				return err
+	handleBatch := func(ctx context.Context, event events.KinesisEvent) sundaecli.BatchResult {
+		return sundaecli.ProcessRecords(ctx, batch, event.Records, kinesisRecordID,
+			func(_ context.Context, r events.KinesisEventRecord) error { return <-syncer.HandleOne(r.Kinesis.Data) },
+		)
+	}
+	var handler interface{} = func(ctx context.Context, event events.KinesisEvent) error {
+		return handleBatch(ctx, event).Err
+	}
+	if batch.Policy().ReportItemFailures {
+		handler = func(ctx context.Context, event events.KinesisEvent) (events.KinesisEventResponse, error) {
+			var response events.KinesisEventResponse
+			if result := handleBatch(ctx, event); result.Err != nil {
+				response.BatchItemFailures = append(response.BatchItemFailures, events.KinesisBatchItemFailure{
+					ItemIdentifier: result.FailedID,
+				})
 			}
+			return response, nil
 		}
-		return nil
-	})
+	}
 
+	// In Lambda this never returns; when replaying fixtures it returns once
+	// every event has been handled, and closing the stream lets the sync
+	// goroutine finish
+	err = sundaecli.Run(ctx, handler, nil)
+	close(eventStream)
+	if err != nil {
+		return err
+	}
 	if err := group.Wait(); err != nil {
 		return fmt.Errorf("failure processing events: %w", err)
 	}
@@ -131,6 +177,12 @@ func (h *SyncV2Consumer) StartLambda(c *cli.Context) error {
 }
 
 func (h *SyncV2Consumer) StartKinesis(c *cli.Context) error {
+	return h.startKinesis(c.Context, c.App)
+}
+
+// startKinesis reads from the stream until ctx is cancelled. Messages already
+// read by then are still synced, within the lifecycle's drain deadline.
+func (h *SyncV2Consumer) startKinesis(ctx context.Context, app *cli.App) error {
 	var options []consumer.Option
 	ts := SyncV2ConsumerOpts.Timestamp.Value()
 	if ts == nil {
@@ -145,8 +197,31 @@ func (h *SyncV2Consumer) StartKinesis(c *cli.Context) error {
 		return err
 	}
 
-	events := make(chan Message)
-	group, ctx := errgroup.WithContext(c.Context)
+	service := sundaecli.Service{Name: app.Name, Version: app.Version}
+	metrics, err := sundaecli.BuildMetrics(service)
+	if err != nil {
+		return err
+	}
+	if _, err := sundaecli.BuildFeatures(service, metrics); err != nil {
+		return err
+	}
+	admin, err := sundaecli.StartAdmin(service, metrics)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	session := sundaetrace.Session(aws.NewConfig())
+	admin.AddReadinessCheck("lookup", sundaecli.DynamoDBCheck(dynamodb.New(session), txdao.TableName(sundaecli.CommonOpts.Env)))
+	var millisBehind atomic.Int64
+	if SyncV2ConsumerOpts.MaxLag > 0 {
+		lag := func() time.Duration { return time.Duration(millisBehind.Load()) * time.Millisecond }
+		admin.AddReadinessCheck("lag", sundaecli.LagCheck(lag, SyncV2ConsumerOpts.MaxLag))
+	}
+
+	messages := make(chan Message)
+	drain, cancel := sundaecli.Drain(ctx)
+	defer cancel()
+	group, groupCtx := errgroup.WithContext(drain)
 
 	downloader := S3Downloader{
 		Logger:  h.Logger,
@@ -154,21 +229,45 @@ func (h *SyncV2Consumer) StartKinesis(c *cli.Context) error {
 		Env:     sundaecli.CommonOpts.Env,
 		Account: SyncV2ConsumerOpts.Account,
 	}
+	policy := sundaecli.DefaultBatchPolicy(batchSource)
+	policy.Service = service
+	batch := sundaecli.NewBatchProcessor(policy, h.Logger, metrics)
 	syncer := Syncer{
 		Logger:     h.Logger,
 		Downloader: &downloader,
-		Events:     events,
+		Events:     messages,
 		Group:      group,
+		// A quarantined message must not stop the ones after it
+		ContinueOnError: batch.Policy().Quarantines(),
 	}
 
-	syncer.SpawnSyncFunc(group, ctx, h.Undo, h.Advance)
+	syncer.SpawnSyncFunc(group, groupCtx, h.Undo, h.Advance)
+
+	// Stop reading on shutdown, or as soon as the sync goroutine fails
+	scanCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	defer context.AfterFunc(groupCtx, stop)()
 
-	err = k.Scan(ctx, func(r *consumer.Record) error {
-		return <-syncer.HandleOne(r.Data)
+	err = k.Scan(scanCtx, func(r *consumer.Record) error {
+		if r.MillisBehindLatest != nil {
+			millisBehind.Store(*r.MillisBehindLatest)
+		}
+		record := events.KinesisEventRecord{
+			Kinesis: events.KinesisRecord{
+				Data:           r.Data,
+				SequenceNumber: aws.StringValue(r.SequenceNumber),
+				PartitionKey:   aws.StringValue(r.PartitionKey),
+			},
+		}
+		return sundaecli.ProcessRecords(scanCtx, batch, []events.KinesisEventRecord{record}, kinesisRecordID,
+			func(_ context.Context, r events.KinesisEventRecord) error { return <-syncer.HandleOne(r.Kinesis.Data) },
+		).Err
 	})
 	if err != nil {
 		return fmt.Errorf("failure reading from kinesis: %w", err)
 	}
+	// Every callback has returned, so nothing else will be sent
+	close(messages)
 	if err := group.Wait(); err != nil {
 		return fmt.Errorf("failure processing events: %w", err)
 	}
@@ -176,8 +275,53 @@ func (h *SyncV2Consumer) StartKinesis(c *cli.Context) error {
 	return nil
 }
 
+// batchSource names this consumer in dead letters
+const batchSource = "sundae-sync-v2"
+
+func kinesisRecordID(r events.KinesisEventRecord) string {
+	return r.Kinesis.SequenceNumber
+}
+
+// Redrive syncs a message quarantined by this consumer again, e.g. from the
+// sundaedeadletter command
+func (h *SyncV2Consumer) Redrive(ctx context.Context, letter sundaecli.DeadLetter) error {
+	var record events.KinesisEventRecord
+	if err := json.Unmarshal(letter.Record, &record); err != nil {
+		return fmt.Errorf("unable to decode dead letter %v: %w", letter.ID, err)
+	}
+
+	messages := make(chan Message)
+	group, groupCtx := errgroup.WithContext(ctx)
+	downloader := S3Downloader{
+		Logger:  h.Logger,
+		S3:      h.S3,
+		Env:     sundaecli.CommonOpts.Env,
+		Account: SyncV2ConsumerOpts.Account,
+	}
+	syncer := Syncer{
+		Logger:     h.Logger,
+		Downloader: &downloader,
+		Events:     messages,
+		Group:      group,
+	}
+	syncer.SpawnSyncFunc(group, groupCtx, h.Undo, h.Advance)
+
+	err := <-syncer.HandleOne(record.Kinesis.Data)
+	close(messages)
+	if groupErr := group.Wait(); err == nil && groupErr != nil {
+		err = groupErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to redrive dead letter %v: %w", letter.ID, err)
+	}
+	return nil
+}
+
 func (h *SyncV2Consumer) RunOne(c *cli.Context) error {
-	ctx := c.Context
+	return h.runOne(c.Context)
+}
+
+func (h *SyncV2Consumer) runOne(ctx context.Context) error {
 	downloader := S3Downloader{
 		Logger:  h.Logger,
 		S3:      h.S3,
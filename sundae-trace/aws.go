@@ -0,0 +1,92 @@
+package sundaetrace
+
+import (
+	"reflect"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func init() {
+	// Trace the clients sundaecli creates, as it can't import this package
+	sundaecli.InstrumentAWS(Instrument)
+}
+
+// Session creates an AWS session whose clients are instrumented; see
+// Instrument
+func Session(cfgs ...*aws.Config) *session.Session {
+	s := session.Must(session.NewSession(cfgs...))
+	Instrument(&s.Handlers)
+	return s
+}
+
+// Instrument wraps every request made through handlers in a client span named
+// after the service and operation (e.g. DynamoDB.Query), tagged with the table
+// or bucket involved. Pass a session's handlers to instrument every client
+// created from it afterwards, or a client's own handlers to instrument just
+// that client:
+//
+//	api := dynamodb.New(sess)
+//	sundaetrace.Instrument(&api.Handlers)
+func Instrument(handlers *request.Handlers) {
+	handlers.Validate.PushFrontNamed(request.NamedHandler{Name: "sundaetrace.StartSpan", Fn: startAWSSpan})
+	handlers.Complete.PushBackNamed(request.NamedHandler{Name: "sundaetrace.EndSpan", Fn: endAWSSpan})
+}
+
+func startAWSSpan(r *request.Request) {
+	attrs := []attribute.KeyValue{
+		semconv.RPCSystemKey.String("aws-api"),
+		semconv.RPCService(r.ClientInfo.ServiceID),
+		semconv.RPCMethod(r.Operation.Name),
+		attribute.String("aws.region", aws.StringValue(r.Config.Region)),
+	}
+	if table := stringField(r.Params, "TableName"); table != "" {
+		attrs = append(attrs, semconv.AWSDynamoDBTableNames(table))
+	}
+	if bucket := stringField(r.Params, "Bucket"); bucket != "" {
+		attrs = append(attrs, attribute.String("aws.s3.bucket", bucket))
+	}
+	if stream := stringField(r.Params, "StreamName"); stream != "" {
+		attrs = append(attrs, attribute.String("aws.kinesis.stream_name", stream))
+	}
+	ctx, _ := Start(r.Context(), r.ClientInfo.ServiceID+"."+r.Operation.Name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	r.SetContext(ctx)
+}
+
+func endAWSSpan(r *request.Request) {
+	span := trace.SpanFromContext(r.Context())
+	if r.HTTPResponse != nil {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(r.HTTPResponse.StatusCode))
+	}
+	if r.RequestID != "" {
+		span.SetAttributes(semconv.AWSRequestID(r.RequestID))
+	}
+	if r.RetryCount > 0 {
+		span.SetAttributes(attribute.Int("aws.retry_count", r.RetryCount))
+	}
+	End(span, r.Error)
+}
+
+// stringField reads a *string field by name from an SDK input struct
+func stringField(params interface{}, name string) string {
+	v := reflect.ValueOf(params)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	f := v.FieldByName(name)
+	if !f.IsValid() || f.Kind() != reflect.Ptr || f.IsNil() || f.Elem().Kind() != reflect.String {
+		return ""
+	}
+	return f.Elem().String()
+}
@@ -0,0 +1,28 @@
+package sundaetrace
+
+import (
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/urfave/cli/v2"
+)
+
+var TraceOpts struct {
+	Exporter    string
+	Endpoint    string
+	SampleRatio float64
+}
+
+var ExporterFlag = sundaecli.StringFlag("trace", "where to export traces: none, otlp, stdout or memory", &TraceOpts.Exporter, "none")
+var EndpointFlag = sundaecli.StringFlag("trace-endpoint", "the OTLP/HTTP endpoint to export traces to (defaults to OTEL_EXPORTER_OTLP_ENDPOINT, or localhost:4318)", &TraceOpts.Endpoint)
+var SampleRatioFlag = &cli.Float64Flag{
+	Name:        "trace-sample-ratio",
+	Usage:       "the fraction of new traces to sample; traces started upstream follow the caller's decision",
+	Value:       1,
+	EnvVars:     []string{"TRACE_SAMPLE_RATIO"},
+	Destination: &TraceOpts.SampleRatio,
+}
+
+var TraceFlags = []cli.Flag{
+	ExporterFlag,
+	EndpointFlag,
+	SampleRatioFlag,
+}
@@ -0,0 +1,152 @@
+// Package sundaetrace provides OpenTelemetry tracing for services built on
+// sundae-go-utils.
+//
+// This package includes tracer provider setup with OTLP, stdout and in-memory
+// exporters, span helpers, trace context propagation through message
+// payloads, and instrumentation for HTTP routers and AWS SDK clients.
+package sundaetrace
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName identifies spans created by this module
+const InstrumentationName = "github.com/SundaeSwap-finance/sundae-go-utils"
+
+var memory struct {
+	sync.Mutex
+	exporter *tracetest.InMemoryExporter
+}
+
+// Setup installs a global tracer provider for service, exporting to the
+// backend selected by TraceOpts. With no exporter configured it does nothing,
+// leaving the default no-op provider in place, and returns nil.
+//
+// The provider is registered with sundaecli.RegisterFlusher, so buffered spans
+// are exported when a Lambda shuts down or a console process exits.
+func Setup(ctx context.Context, service sundaecli.Service) (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	switch TraceOpts.Exporter {
+	case "", "none":
+		return nil, nil
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if TraceOpts.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(TraceOpts.Endpoint))
+		}
+		e, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create otlp exporter: %w", err)
+		}
+		exporter = e
+	case "stdout":
+		e, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, fmt.Errorf("unable to create stdout exporter: %w", err)
+		}
+		exporter = e
+	case "memory":
+		exporter = MemoryExporter()
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q: expected none, otlp, stdout or memory", TraceOpts.Exporter)
+	}
+
+	provider := NewProvider(service, exporter, sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(TraceOpts.SampleRatio))))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	sundaecli.RegisterFlusher(flusher{provider})
+	return provider, nil
+}
+
+// NewProvider builds a tracer provider that batches spans to exporter, with
+// the service name, version and environment as resource attributes. It isn't
+// installed globally; tests typically pair it with tracetest.NewInMemoryExporter.
+func NewProvider(service sundaecli.Service, exporter sdktrace.SpanExporter, opts ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	res := resource.NewSchemaless(
+		semconv.ServiceName(service.Name),
+		semconv.ServiceVersion(service.Version),
+		semconv.DeploymentEnvironment(sundaecli.CommonOpts.Env),
+	)
+	opts = append([]sdktrace.TracerProviderOption{
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	}, opts...)
+	return sdktrace.NewTracerProvider(opts...)
+}
+
+// MemoryExporter is the exporter used by --trace=memory, for inspecting spans
+// from within a local process
+func MemoryExporter() *tracetest.InMemoryExporter {
+	memory.Lock()
+	defer memory.Unlock()
+	if memory.exporter == nil {
+		memory.exporter = tracetest.NewInMemoryExporter()
+	}
+	return memory.exporter
+}
+
+type flusher struct {
+	provider *sdktrace.TracerProvider
+}
+
+func (f flusher) Flush(ctx context.Context) error {
+	return f.provider.ForceFlush(ctx)
+}
+
+// Tracer returns the tracer used for every span this module creates
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+// Start starts a span named name as a child of any span in ctx
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, opts...)
+}
+
+// End records err, if any, on span and ends it. It is intended to be deferred
+// from functions with a named error return:
+//
+//	ctx, span := sundaetrace.Start(ctx, "DoThing")
+//	defer func() { sundaetrace.End(span, err) }()
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject returns the trace context of ctx as a map, for embedding in a message
+// payload; it is nil if ctx carries no trace
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// Extract returns ctx with the trace context from a map built by Inject, so
+// spans started from it continue the publisher's trace
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(carrier))
+}
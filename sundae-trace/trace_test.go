@@ -0,0 +1,113 @@
+package sundaetrace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/go-chi/chi/v5"
+	"github.com/tj/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func record(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+	return exporter
+}
+
+func TestPropagation(t *testing.T) {
+	exporter := record(t)
+
+	assert.Nil(t, Inject(context.Background()))
+
+	ctx, parent := Start(context.Background(), "publish")
+	carrier := Inject(ctx)
+	parent.End()
+	assert.Contains(t, carrier, "traceparent")
+
+	_, child := Start(Extract(context.Background(), carrier), "dispatch")
+	End(child, errors.New("boom"))
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, spans[0].SpanContext.TraceID(), spans[1].SpanContext.TraceID())
+	assert.Equal(t, spans[0].SpanContext.SpanID(), spans[1].Parent.SpanID())
+	assert.Equal(t, codes.Error, spans[1].Status.Code)
+}
+
+func TestMiddleware(t *testing.T) {
+	exporter := record(t)
+
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	router.Get("/pools/{id}", func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, trace.SpanFromContext(r.Context()).SpanContext().IsValid())
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/pools/abc", nil))
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "GET /pools/{id}", spans[0].Name)
+	assert.Equal(t, trace.SpanKindServer, spans[0].SpanKind)
+}
+
+func TestInstrument(t *testing.T) {
+	// sundaecli's sessions are instrumented too, once this package is linked
+	for name, newSession := range map[string]func(...*aws.Config) *session.Session{
+		"sundaetrace": Session,
+		"sundaecli":   sundaecli.AWSSession,
+	} {
+		t.Run(name, func(t *testing.T) {
+			exporter := record(t)
+
+			api := dynamodb.New(newSession(&aws.Config{
+				Region:      aws.String("us-east-2"),
+				Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+				MaxRetries:  aws.Int(0),
+			}))
+			// Fail at send instead of touching the network
+			api.Handlers.Send.Clear()
+			api.Handlers.Send.PushBack(func(r *request.Request) {
+				r.Error = errors.New("offline")
+			})
+
+			_, err := api.GetItemWithContext(context.Background(), &dynamodb.GetItemInput{
+				TableName: aws.String("mainnet-sundae-sync--cursor"),
+				Key:       map[string]*dynamodb.AttributeValue{"block": {N: aws.String("1")}},
+			})
+			assert.Error(t, err)
+
+			spans := exporter.GetSpans()
+			assert.Len(t, spans, 1)
+			assert.Equal(t, "DynamoDB.GetItem", spans[0].Name)
+			assert.Equal(t, trace.SpanKindClient, spans[0].SpanKind)
+			assert.Equal(t, codes.Error, spans[0].Status.Code)
+			var tables []string
+			for _, attr := range spans[0].Attributes {
+				if attr.Key == "aws.dynamodb.table_names" {
+					tables = attr.Value.AsStringSlice()
+				}
+			}
+			assert.Equal(t, []string{"mainnet-sundae-sync--cursor"}, tables)
+		})
+	}
+}
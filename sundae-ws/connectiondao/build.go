@@ -1,6 +1,13 @@
 package connectiondao
 
-import "github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+import (
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func init() {
+	sundaecli.RegisterTable("connectiondao", TableName, sundaecli.WithTableModel(Connection{}), sundaecli.WithTableTTL("ttl"))
+}
 
 // Build creates a new connections DAO using the standard table name for the
 // given environment.
@@ -0,0 +1,32 @@
+package connectiondao
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ddb/memddb"
+	"github.com/savaki/ddb"
+	"github.com/tj/assert"
+)
+
+func TestDAO(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		api       = memddb.New()
+		tableName = TableName("test")
+		dao       = New(api, tableName)
+	)
+	err := ddb.New(api).MustTable(tableName, Connection{}).CreateTableIfNotExists(ctx)
+	assert.Nil(t, err)
+
+	want := Connection{ConnectionID: "c1", Endpoint: "https://example.com", ConnectedAt: 123, TTL: 456}
+	assert.Nil(t, dao.Put(ctx, want))
+
+	got, err := dao.Get(ctx, "c1")
+	assert.Nil(t, err)
+	assert.Equal(t, want, *got)
+
+	assert.Nil(t, dao.Delete(ctx, "c1"))
+	_, err = dao.Get(ctx, "c1")
+	assert.EqualError(t, err, "connection c1 not found")
+}
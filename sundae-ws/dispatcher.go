@@ -7,15 +7,17 @@ import (
 	"fmt"
 	"sync"
 
+	sundaetrace "github.com/SundaeSwap-finance/sundae-go-utils/sundae-trace"
 	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ws/connectiondao"
 	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ws/subscriptiondao"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
 	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi/apigatewaymanagementapiiface"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ws/publish"
@@ -50,12 +52,23 @@ func (d *Dispatcher) HandleKinesisEvent(ctx context.Context, event events.Kinesi
 	return firstErr
 }
 
-func (d *Dispatcher) processRecord(ctx context.Context, record events.KinesisEventRecord) error {
+func (d *Dispatcher) processRecord(ctx context.Context, record events.KinesisEventRecord) (err error) {
 	var envelope publish.Envelope
 	if err := json.Unmarshal(record.Kinesis.Data, &envelope); err != nil {
 		return fmt.Errorf("unmarshalling kinesis record: %w", err)
 	}
 
+	// Continue the publisher's trace, if it sent one
+	ctx, span := sundaetrace.Start(sundaetrace.Extract(ctx, envelope.TraceContext), "dispatch "+envelope.Topic,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "aws_kinesis"),
+			attribute.String("messaging.message.id", envelope.MessageID),
+			attribute.String("sundae.ws.topic", envelope.Topic),
+		),
+	)
+	defer func() { sundaetrace.End(span, err) }()
+
 	if envelope.Topic == "" {
 		d.Logger.Warn().Msg("kinesis record has empty topic, skipping")
 		return nil
@@ -66,6 +79,7 @@ func (d *Dispatcher) processRecord(ctx context.Context, record events.KinesisEve
 		return fmt.Errorf("querying subscriptions for topic %v: %w", envelope.Topic, err)
 	}
 
+	span.SetAttributes(attribute.Int("sundae.ws.subscribers", len(subs)))
 	if len(subs) == 0 {
 		return nil
 	}
@@ -164,7 +178,7 @@ func (d *Dispatcher) getManagementClient(endpoint string) apigatewaymanagementap
 		d.mgmtClients = make(map[string]apigatewaymanagementapiiface.ApiGatewayManagementApiAPI)
 	}
 
-	sess := session.Must(session.NewSession(aws.NewConfig().WithEndpoint(endpoint)))
+	sess := sundaetrace.Session(aws.NewConfig().WithEndpoint(endpoint))
 	client := apigatewaymanagementapi.New(sess)
 	d.mgmtClients[endpoint] = client
 	return client
@@ -8,12 +8,12 @@ import (
 	"sync"
 	"time"
 
+	sundaetrace "github.com/SundaeSwap-finance/sundae-go-utils/sundae-trace"
 	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ws/connectiondao"
 	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ws/latestdao"
 	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ws/subscriptiondao"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
 	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi/apigatewaymanagementapiiface"
 	"github.com/rs/zerolog"
@@ -305,7 +305,7 @@ func (h *Handler) getManagementClient(endpoint string) apigatewaymanagementapiif
 		h.mgmtClients = make(map[string]apigatewaymanagementapiiface.ApiGatewayManagementApiAPI)
 	}
 
-	sess := session.Must(session.NewSession(aws.NewConfig().WithEndpoint(endpoint)))
+	sess := sundaetrace.Session(aws.NewConfig().WithEndpoint(endpoint))
 	client := apigatewaymanagementapi.New(sess)
 	h.mgmtClients[endpoint] = client
 	return client
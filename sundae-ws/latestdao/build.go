@@ -1,6 +1,13 @@
 package latestdao
 
-import "github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+import (
+	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func init() {
+	sundaecli.RegisterTable("latestdao", TableName, sundaecli.WithTableModel(Latest{}), sundaecli.WithTableTTL("ttl"))
+}
 
 // Build creates a new latest-payload DAO using the standard table name for the
 // given environment.
@@ -0,0 +1,32 @@
+package latestdao
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ddb/memddb"
+	"github.com/savaki/ddb"
+	"github.com/tj/assert"
+)
+
+func TestDAO(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		api       = memddb.New()
+		tableName = TableName("test")
+		dao       = New(api, tableName)
+	)
+	err := ddb.New(api).MustTable(tableName, Latest{}).CreateTableIfNotExists(ctx)
+	assert.Nil(t, err)
+
+	got, err := dao.Get(ctx, "pools")
+	assert.Nil(t, err)
+	assert.Nil(t, got)
+
+	assert.Nil(t, dao.Put(ctx, Latest{Topic: "pools", Payload: `{"a":1}`, MessageID: "m1"}))
+	assert.Nil(t, dao.Put(ctx, Latest{Topic: "pools", Payload: `{"a":2}`, MessageID: "m2"}))
+
+	got, err = dao.Get(ctx, "pools")
+	assert.Nil(t, err)
+	assert.Equal(t, Latest{Topic: "pools", Payload: `{"a":2}`, MessageID: "m2"}, *got)
+}
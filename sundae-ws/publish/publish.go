@@ -6,20 +6,25 @@ import (
 	"fmt"
 	"time"
 
+	sundaetrace "github.com/SundaeSwap-finance/sundae-go-utils/sundae-trace"
 	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ws/latestdao"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kinesis"
 	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Envelope is the message format published to the WebSocket events stream.
 // MessageID is a caller-provided idempotency key (e.g., transaction hash,
 // slot+pool ID) that is passed through to WebSocket clients for deduplication.
+// TraceContext carries the publisher's trace (see sundaetrace.Inject) so the
+// dispatcher's spans join it; it is not forwarded to clients.
 type Envelope struct {
-	Topic     string          `json:"topic"`
-	MessageID string          `json:"messageId"`
-	Payload   json.RawMessage `json:"payload"`
+	Topic        string            `json:"topic"`
+	MessageID    string            `json:"messageId"`
+	Payload      json.RawMessage   `json:"payload"`
+	TraceContext map[string]string `json:"traceContext,omitempty"`
 }
 
 // Publisher publishes events to the WebSocket Kinesis stream.
@@ -48,7 +53,7 @@ func New(client kinesisiface.KinesisAPI, streamName string) *Publisher {
 // Build creates a new Publisher using the standard stream name for the given
 // environment.
 func Build(env string) *Publisher {
-	sess := session.Must(session.NewSession(aws.NewConfig()))
+	sess := sundaetrace.Session(aws.NewConfig())
 	client := kinesis.New(sess)
 	return New(client, StreamName(env))
 }
@@ -63,16 +68,28 @@ func StreamName(env string) string {
 // transaction hash, slot number + pool ID) so that retries produce the same ID.
 // The topic is used as the Kinesis partition key to preserve ordering within a
 // topic.
-func (p *Publisher) Send(ctx context.Context, topic string, messageID string, payload interface{}) error {
+func (p *Publisher) Send(ctx context.Context, topic string, messageID string, payload interface{}) (err error) {
+	ctx, span := sundaetrace.Start(ctx, "publish "+topic,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "aws_kinesis"),
+			attribute.String("messaging.destination.name", p.streamName),
+			attribute.String("messaging.message.id", messageID),
+			attribute.String("sundae.ws.topic", topic),
+		),
+	)
+	defer func() { sundaetrace.End(span, err) }()
+
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("marshalling payload: %w", err)
 	}
 
 	envelope := Envelope{
-		Topic:     topic,
-		MessageID: messageID,
-		Payload:   payloadBytes,
+		Topic:        topic,
+		MessageID:    messageID,
+		Payload:      payloadBytes,
+		TraceContext: sundaetrace.Inject(ctx),
 	}
 
 	data, err := json.Marshal(envelope)
@@ -3,8 +3,8 @@ package subscriptiondao
 import (
 	"context"
 	"fmt"
-	"time"
 
+	sundaeddb "github.com/SundaeSwap-finance/sundae-go-utils/sundae-ddb"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
@@ -69,56 +69,19 @@ func (d *DAO) DeleteByConnection(ctx context.Context, connectionID string) error
 		return err
 	}
 
-	// Batch delete in chunks of 25 (DynamoDB limit)
-	const batchSize = 25
-	for i := 0; i < len(subs); i += batchSize {
-		end := i + batchSize
-		if end > len(subs) {
-			end = len(subs)
+	w := sundaeddb.NewBatchWriter(d.api, d.tableName)
+	for _, sub := range subs {
+		key, err := dynamodbattribute.MarshalMap(map[string]string{"pk": sub.SubscriptionID})
+		if err != nil {
+			return fmt.Errorf("failed to marshal key for subscription %v: %w", sub.SubscriptionID, err)
 		}
-		chunk := subs[i:end]
-
-		writeRequests := make([]*dynamodb.WriteRequest, len(chunk))
-		for j, sub := range chunk {
-			key, err := dynamodbattribute.MarshalMap(map[string]string{"pk": sub.SubscriptionID})
-			if err != nil {
-				return fmt.Errorf("failed to marshal key for subscription %v: %w", sub.SubscriptionID, err)
-			}
-			writeRequests[j] = &dynamodb.WriteRequest{
-				DeleteRequest: &dynamodb.DeleteRequest{Key: key},
-			}
-		}
-
-		unprocessed := map[string][]*dynamodb.WriteRequest{
-			d.tableName: writeRequests,
-		}
-
-		const maxRetries = 5
-		for attempt := 0; attempt < maxRetries; attempt++ {
-			output, err := d.api.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
-				RequestItems: unprocessed,
-			})
-			if err != nil {
-				return fmt.Errorf("failed to batch delete subscriptions for connection %v: %w", connectionID, err)
-			}
-			if len(output.UnprocessedItems) == 0 {
-				break
-			}
-			unprocessed = output.UnprocessedItems
-			if attempt < maxRetries-1 {
-				backoff := time.Duration(1<<attempt) * 100 * time.Millisecond
-				timer := time.NewTimer(backoff)
-				select {
-				case <-ctx.Done():
-					timer.Stop()
-					return fmt.Errorf("context cancelled during retry for connection %v: %w", connectionID, ctx.Err())
-				case <-timer.C:
-				}
-			} else {
-				return fmt.Errorf("failed to delete all subscriptions for connection %v: %d items unprocessed after %d retries", connectionID, len(unprocessed[d.tableName]), maxRetries)
-			}
+		if err := w.Delete(ctx, key); err != nil {
+			return fmt.Errorf("failed to batch delete subscriptions for connection %v: %w", connectionID, err)
 		}
 	}
+	if err := w.Flush(ctx); err != nil {
+		return fmt.Errorf("failed to batch delete subscriptions for connection %v: %w", connectionID, err)
+	}
 
 	return nil
 }
@@ -0,0 +1,83 @@
+package subscriptiondao
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/SundaeSwap-finance/sundae-go-utils/sundae-ddb/memddb"
+	"github.com/savaki/ddb"
+	"github.com/tj/assert"
+)
+
+func withDAO(t *testing.T, callback func(ctx context.Context, api *memddb.DB, dao *DAO)) {
+	var (
+		ctx       = context.Background()
+		api       = memddb.New()
+		tableName = TableName("test")
+	)
+	err := ddb.New(api).MustTable(tableName, Subscription{}).CreateTableIfNotExists(ctx)
+	assert.Nil(t, err)
+
+	callback(ctx, api, New(api, tableName))
+}
+
+func subscriptionIDs(subs []Subscription) []string {
+	var ids []string
+	for _, sub := range subs {
+		ids = append(ids, sub.SubscriptionID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestDAO(t *testing.T) {
+	withDAO(t, func(ctx context.Context, api *memddb.DB, dao *DAO) {
+		for _, sub := range []Subscription{
+			{SubscriptionID: "c1#1", ConnectionID: "c1", Topic: "pools"},
+			{SubscriptionID: "c1#2", ConnectionID: "c1", Topic: "orders"},
+			{SubscriptionID: "c2#1", ConnectionID: "c2", Topic: "pools"},
+		} {
+			assert.Nil(t, dao.Put(ctx, sub))
+		}
+
+		subs, err := dao.QueryByTopic(ctx, "pools")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"c1#1", "c2#1"}, subscriptionIDs(subs))
+
+		subs, err = dao.QueryByConnection(ctx, "c1")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"c1#1", "c1#2"}, subscriptionIDs(subs))
+
+		n, err := dao.Count(ctx, "pools")
+		assert.Nil(t, err)
+		assert.EqualValues(t, 2, n)
+
+		assert.Nil(t, dao.Delete(ctx, "c2#1"))
+		n, err = dao.Count(ctx, "pools")
+		assert.Nil(t, err)
+		assert.EqualValues(t, 1, n)
+	})
+}
+
+func TestDeleteByConnection(t *testing.T) {
+	withDAO(t, func(ctx context.Context, api *memddb.DB, dao *DAO) {
+		// More than one batch, with some items left unprocessed
+		for i := 0; i < 30; i++ {
+			assert.Nil(t, dao.Put(ctx, Subscription{SubscriptionID: fmt.Sprintf("c1#%v", i), ConnectionID: "c1", Topic: "pools"}))
+		}
+		assert.Nil(t, dao.Put(ctx, Subscription{SubscriptionID: "c2#1", ConnectionID: "c2", Topic: "pools"}))
+		api.DeferBatchItems(3)
+
+		assert.Nil(t, dao.DeleteByConnection(ctx, "c1"))
+
+		subs, err := dao.QueryByConnection(ctx, "c1")
+		assert.Nil(t, err)
+		assert.Len(t, subs, 0)
+
+		subs, err = dao.QueryByTopic(ctx, "pools")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"c2#1"}, subscriptionIDs(subs))
+	})
+}
@@ -8,7 +8,6 @@ import (
 
 	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
 	sundaeddb "github.com/SundaeSwap-finance/sundae-go-utils/sundae-ddb"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/urfave/cli/v2"
 )
 
@@ -29,47 +28,32 @@ func main() {
 	}
 }
 
-func onInsert(ctx context.Context, newValue map[string]*dynamodb.AttributeValue) error {
-	var obj struct {
-		PK   string `dynamodbav:"pk"`
-		Slot uint64 `dynamodbav:"slot"`
-	}
-	if err := sundaeddb.ParseItem(newValue, &obj); err != nil {
-		return err
-	}
+type Object struct {
+	PK   string `dynamodbav:"pk"`
+	Slot uint64 `dynamodbav:"slot"`
+	TTL  int64  `dynamodbav:"ttl,omitempty"`
+}
 
+func onInsert(ctx context.Context, obj Object) error {
 	fmt.Printf("object %v inserted (slot %v)\n", obj.PK, obj.Slot)
 	return nil
 }
 
-func onUpdate(ctx context.Context, oldValue, newValue map[string]*dynamodb.AttributeValue) error {
-	var obj struct {
-		PK   string `dynamodbav:"pk"`
-		Slot uint64 `dynamodbav:"slot"`
-	}
-	if err := sundaeddb.ParseItem(newValue, &obj); err != nil {
-		return err
-	}
-
-	fmt.Printf("object %v updated (slot %v)\n", obj.PK, obj.Slot)
+func onUpdate(ctx context.Context, oldObj, newObj Object, diff sundaeddb.Diff) error {
+	fmt.Printf("object %v updated (slot %v, changed %v)\n", newObj.PK, newObj.Slot, diff.Fields())
 	return nil
 }
 
-func onDelete(ctx context.Context, oldValue map[string]*dynamodb.AttributeValue) error {
-	var obj struct {
-		PK   string `dynamodbav:"pk"`
-		Slot uint64 `dynamodbav:"slot"`
-	}
-	if err := sundaeddb.ParseItem(oldValue, &obj); err != nil {
-		return err
-	}
-
+func onDelete(ctx context.Context, obj Object) error {
 	fmt.Printf("object %v deleted (slot %v)\n", obj.PK, obj.Slot)
 	return nil
 }
 
 func action(_ *cli.Context) error {
-	handler := sundaeddb.NewHandler(service, onInsert, onUpdate, onDelete)
+	handler := sundaeddb.NewTypedHandler(service, onInsert, onUpdate, onDelete,
+		// ignore updates that only refresh the ttl
+		sundaeddb.WithUpdateFilter(sundaeddb.AttributeChanged("slot")),
+	)
 
 	return handler.Start()
 }
@@ -8,6 +8,7 @@ import (
 
 	sundaecli "github.com/SundaeSwap-finance/sundae-go-utils/sundae-cli"
 	sundaegql "github.com/SundaeSwap-finance/sundae-go-utils/sundae-gql"
+	sundaetrace "github.com/SundaeSwap-finance/sundae-go-utils/sundae-trace"
 	"github.com/urfave/cli/v2"
 )
 
@@ -18,8 +19,8 @@ func main() {
 		service,
 		action,
 		append(
-			sundaecli.CommonFlags,
-			sundaecli.PortFlag(5001),
+			append(sundaecli.CommonFlags, sundaecli.PortFlag(5001)),
+			sundaetrace.TraceFlags...,
 		)...,
 	)
 	err := app.Run(os.Args)
@@ -29,6 +30,9 @@ func main() {
 }
 
 func action(ctx *cli.Context) error {
+	if _, err := sundaetrace.Setup(ctx.Context, service); err != nil {
+		return err
+	}
 	return sundaegql.Webserver(&Resolver{})
 }
 